@@ -14,6 +14,7 @@ import (
 	"github.com/aimerfeng/AgentLink/internal/logging"
 	"github.com/aimerfeng/AgentLink/internal/monitoring"
 	"github.com/aimerfeng/AgentLink/internal/server"
+	"github.com/aimerfeng/AgentLink/internal/tracing"
 	"github.com/rs/zerolog/log"
 )
 
@@ -40,6 +41,12 @@ func main() {
 	}
 	defer db.Close()
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(&cfg.Tracing, "agentlink-api")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up tracing")
+	}
+
 	// Initialize Prometheus metrics
 	monitoring.Init()
 	log.Info().Msg("Prometheus metrics initialized")
@@ -52,6 +59,10 @@ func main() {
 	// Create and start server
 	srv := server.NewAPIServer(cfg, db.Pool)
 
+	// Start background workers (webhooks, alerts, rollups, exports, partitions)
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	srv.StartBackground(bgCtx)
+
 	httpServer := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
 		Handler:      srv.Router(),
@@ -86,6 +97,10 @@ func main() {
 	if err := httpServer.Shutdown(ctx); err != nil {
 		log.Error().Err(err).Msg("Server forced to shutdown")
 	}
+	stopBackground()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to flush traces")
+	}
 
 	log.Info().Msg("Server exited gracefully")
 }
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/partition"
 	"github.com/golang-migrate/migrate/v4"
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
@@ -25,7 +29,7 @@ func main() {
 		databaseURL   string
 	)
 
-	flag.StringVar(&command, "command", "up", "Migration command: up, down, force, version, drop")
+	flag.StringVar(&command, "command", "up", "Migration command: up, down, force, version, drop, partitions")
 	flag.IntVar(&steps, "steps", 0, "Number of migrations to run (0 = all)")
 	flag.StringVar(&migrationsDir, "dir", "migrations", "Path to migrations directory")
 	flag.StringVar(&databaseURL, "database", "", "Database URL (overrides DATABASE_URL env)")
@@ -39,6 +43,14 @@ func main() {
 		log.Fatal().Msg("DATABASE_URL environment variable or -database flag is required")
 	}
 
+	// Partition maintenance runs against the migrated schema
+	if command == "partitions" {
+		if err := runPartitions(databaseURL); err != nil {
+			log.Fatal().Err(err).Msg("Partition maintenance failed")
+		}
+		return
+	}
+
 	// Get absolute path to migrations directory
 	absPath, err := filepath.Abs(migrationsDir)
 	if err != nil {
@@ -116,3 +128,36 @@ func runDown(m *migrate.Migrate, steps int) error {
 	}
 	return m.Down()
 }
+
+// runPartitions creates upcoming call_logs partitions and archives expired
+// ones once, as the API process does periodically, using the partition
+// settings from the environment
+func runPartitions(databaseURL string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	db, err := pgxpool.New(ctx, databaseURL)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := partition.NewService(db, &cfg.Partitions).Maintain(ctx)
+	if err != nil {
+		return err
+	}
+	if report.Skipped {
+		log.Warn().Msg("Partitions are being maintained by another process")
+		return nil
+	}
+
+	log.Info().
+		Strs("created", report.Created).
+		Int64("moved", report.Moved).
+		Strs("archived", report.Archived).
+		Msg("Partition maintenance completed")
+	return nil
+}
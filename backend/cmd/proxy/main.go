@@ -13,6 +13,7 @@ import (
 	"github.com/aimerfeng/AgentLink/internal/logging"
 	"github.com/aimerfeng/AgentLink/internal/monitoring"
 	"github.com/aimerfeng/AgentLink/internal/server"
+	"github.com/aimerfeng/AgentLink/internal/tracing"
 	"github.com/rs/zerolog/log"
 )
 
@@ -31,6 +32,12 @@ func main() {
 		Str("env", cfg.Server.Env).
 		Msg("Starting AgentLink Proxy Gateway")
 
+	// Initialize tracing
+	shutdownTracing, err := tracing.Setup(&cfg.Tracing, "agentlink-proxy")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up tracing")
+	}
+
 	// Initialize Prometheus metrics
 	monitoring.Init()
 	log.Info().Msg("Prometheus metrics initialized")
@@ -38,6 +45,10 @@ func main() {
 	// Create and start proxy server
 	srv := server.NewProxyServer(cfg)
 
+	// Start background workers (cache invalidation, batched key usage writes)
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	srv.StartBackground(bgCtx)
+
 	httpServer := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Proxy.Port),
 		Handler:      srv.Router(),
@@ -72,6 +83,10 @@ func main() {
 	if err := httpServer.Shutdown(ctx); err != nil {
 		log.Error().Err(err).Msg("Proxy server forced to shutdown")
 	}
+	stopBackground()
+	if err := shutdownTracing(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to flush traces")
+	}
 
 	log.Info().Msg("Proxy server exited gracefully")
 }
@@ -0,0 +1,426 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// Admin errors
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrInvalidUserType   = errors.New("user_type must be creator, developer or admin")
+	ErrInvalidStatus     = errors.New("status must be active, suspended or banned")
+	ErrReasonRequired    = errors.New("a reason is required to suspend or ban an account")
+	ErrCannotChangeAdmin = errors.New("admin accounts cannot be suspended or banned")
+)
+
+// detailLimit is how many recent payments, status changes and agents the
+// user detail view includes
+const detailLimit = 20
+
+// Service handles admin user management and platform statistics
+type Service struct {
+	db *pgxpool.Pool
+}
+
+// NewService creates a new admin service
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{
+		db: db,
+	}
+}
+
+// UserFilter selects the users to list. Query matches an exact user ID or
+// part of an email address or creator display name.
+type UserFilter struct {
+	Query    string
+	UserType models.UserType
+	Status   models.AccountStatus
+	Page     int
+	PageSize int
+}
+
+// UserSummary is one row of the admin user list
+type UserSummary struct {
+	ID            uuid.UUID            `json:"id"`
+	Email         string               `json:"email"`
+	UserType      models.UserType      `json:"user_type"`
+	DisplayName   *string              `json:"display_name,omitempty"` // Creators only
+	Status        models.AccountStatus `json:"status"`
+	StatusReason  *string              `json:"status_reason,omitempty"`
+	EmailVerified bool                 `json:"email_verified"`
+	CreatedAt     time.Time            `json:"created_at"`
+}
+
+// ListUsersResponse represents a page of users
+type ListUsersResponse struct {
+	Users      []UserSummary `json:"users"`
+	Total      int64         `json:"total"`
+	Page       int           `json:"page"`
+	PageSize   int           `json:"page_size"`
+	TotalPages int           `json:"total_pages"`
+}
+
+// UserKey is an API key in the user detail view
+type UserKey struct {
+	ID         uuid.UUID  `json:"id"`
+	KeyPrefix  string     `json:"key_prefix"`
+	Name       *string    `json:"name,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// UserAgent is an agent in the user detail view
+type UserAgent struct {
+	ID           uuid.UUID          `json:"id"`
+	Name         string             `json:"name"`
+	Status       models.AgentStatus `json:"status"`
+	TotalCalls   int64              `json:"total_calls"`
+	TotalRevenue decimal.Decimal    `json:"total_revenue"`
+	CreatedAt    time.Time          `json:"created_at"`
+}
+
+// StatusChange is one change of a user's account status
+type StatusChange struct {
+	Status    models.AccountStatus `json:"status"`
+	Reason    *string              `json:"reason,omitempty"`
+	ChangedBy *uuid.UUID           `json:"changed_by,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+// UserDetail is a user with their quota, API keys, recent payments, agents
+// and status history
+type UserDetail struct {
+	UserSummary
+	WalletAddress   *string          `json:"wallet_address,omitempty"`
+	StatusChangedAt *time.Time       `json:"status_changed_at,omitempty"`
+	Quota           *models.Quota    `json:"quota,omitempty"`
+	APIKeys         []UserKey        `json:"api_keys"`
+	Payments        []models.Payment `json:"recent_payments"`
+	Agents          []UserAgent      `json:"agents"`
+	StatusHistory   []StatusChange   `json:"status_history"`
+}
+
+// ListUsers returns a page of users matching the filter, newest first
+func (s *Service) ListUsers(ctx context.Context, f *UserFilter) (*ListUsersResponse, error) {
+	if f.UserType != "" && f.UserType != models.UserTypeCreator &&
+		f.UserType != models.UserTypeDeveloper && f.UserType != models.UserTypeAdmin {
+		return nil, ErrInvalidUserType
+	}
+	if f.Status != "" && !f.Status.IsValid() {
+		return nil, ErrInvalidStatus
+	}
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	if f.PageSize < 1 || f.PageSize > 100 {
+		f.PageSize = 20
+	}
+
+	var userType, status, pattern *string
+	var id *uuid.UUID
+	if f.UserType != "" {
+		v := string(f.UserType)
+		userType = &v
+	}
+	if f.Status != "" {
+		v := string(f.Status)
+		status = &v
+	}
+	if q := strings.TrimSpace(f.Query); q != "" {
+		if parsed, err := uuid.Parse(q); err == nil {
+			id = &parsed
+		} else {
+			v := "%" + escapeLike(q) + "%"
+			pattern = &v
+		}
+	}
+	where := `
+		FROM users u
+		LEFT JOIN creator_profiles p ON p.user_id = u.id
+		WHERE ($1::text IS NULL OR u.user_type = $1)
+		  AND ($2::text IS NULL OR u.status = $2)
+		  AND ($3::uuid IS NULL OR u.id = $3)
+		  AND ($4::text IS NULL OR u.email ILIKE $4 OR p.display_name ILIKE $4)`
+	args := []any{userType, status, id, pattern}
+
+	var total int64
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(*)`+where, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count users: %w", err)
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT u.id, u.email, u.user_type, p.display_name, u.status, u.status_reason,
+			u.email_verified, u.created_at`+where+`
+		ORDER BY u.created_at DESC, u.id
+		LIMIT $5 OFFSET $6
+	`, append(args, f.PageSize, (f.Page-1)*f.PageSize)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []UserSummary{}
+	for rows.Next() {
+		var u UserSummary
+		err := rows.Scan(&u.ID, &u.Email, &u.UserType, &u.DisplayName, &u.Status, &u.StatusReason,
+			&u.EmailVerified, &u.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return &ListUsersResponse{
+		Users:      users,
+		Total:      total,
+		Page:       f.Page,
+		PageSize:   f.PageSize,
+		TotalPages: int((total + int64(f.PageSize) - 1) / int64(f.PageSize)),
+	}, nil
+}
+
+// GetUser returns a user's detail view.
+// Returns ErrUserNotFound if the user does not exist.
+func (s *Service) GetUser(ctx context.Context, userID uuid.UUID) (*UserDetail, error) {
+	var d UserDetail
+	err := s.db.QueryRow(ctx, `
+		SELECT u.id, u.email, u.user_type, p.display_name, u.status, u.status_reason,
+			u.email_verified, u.created_at, u.wallet_address, u.status_changed_at
+		FROM users u
+		LEFT JOIN creator_profiles p ON p.user_id = u.id
+		WHERE u.id = $1
+	`, userID).Scan(
+		&d.ID, &d.Email, &d.UserType, &d.DisplayName, &d.Status, &d.StatusReason,
+		&d.EmailVerified, &d.CreatedAt, &d.WalletAddress, &d.StatusChangedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	var quota models.Quota
+	err = s.db.QueryRow(ctx, `
+		SELECT user_id, total_quota, used_quota, free_quota, updated_at FROM quotas WHERE user_id = $1
+	`, userID).Scan(&quota.UserID, &quota.TotalQuota, &quota.UsedQuota, &quota.FreeQuota, &quota.UpdatedAt)
+	switch {
+	case err == nil:
+		d.Quota = &quota
+	case !errors.Is(err, pgx.ErrNoRows):
+		return nil, fmt.Errorf("failed to get quota: %w", err)
+	}
+
+	if d.APIKeys, err = s.userKeys(ctx, userID); err != nil {
+		return nil, err
+	}
+	if d.Payments, err = s.userPayments(ctx, userID); err != nil {
+		return nil, err
+	}
+	if d.Agents, err = s.userAgents(ctx, userID); err != nil {
+		return nil, err
+	}
+	if d.StatusHistory, err = s.statusHistory(ctx, userID); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// userKeys returns all of a user's API keys, newest first
+func (s *Service) userKeys(ctx context.Context, userID uuid.UUID) ([]UserKey, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, key_prefix, name, last_used_at, expires_at, created_at, revoked_at
+		FROM api_keys WHERE user_id = $1
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []UserKey{}
+	for rows.Next() {
+		var k UserKey
+		if err := rows.Scan(&k.ID, &k.KeyPrefix, &k.Name, &k.LastUsedAt, &k.ExpiresAt, &k.CreatedAt, &k.RevokedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// userPayments returns a user's most recent payments
+func (s *Service) userPayments(ctx context.Context, userID uuid.UUID) ([]models.Payment, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, user_id, amount_usd, quota_purchased, payment_method, payment_id, status,
+			failure_reason, created_at, completed_at, failed_at
+		FROM payments WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, detailLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %w", err)
+	}
+	defer rows.Close()
+
+	payments := []models.Payment{}
+	for rows.Next() {
+		var p models.Payment
+		err := rows.Scan(&p.ID, &p.UserID, &p.AmountUSD, &p.QuotaPurchased, &p.PaymentMethod, &p.PaymentID, &p.Status,
+			&p.FailureReason, &p.CreatedAt, &p.CompletedAt, &p.FailedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan payment: %w", err)
+		}
+		payments = append(payments, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list payments: %w", err)
+	}
+	return payments, nil
+}
+
+// userAgents returns a creator's most recently created agents
+func (s *Service) userAgents(ctx context.Context, userID uuid.UUID) ([]UserAgent, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, name, status, total_calls, total_revenue, created_at
+		FROM agents WHERE creator_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, detailLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agents: %w", err)
+	}
+	defer rows.Close()
+
+	agents := []UserAgent{}
+	for rows.Next() {
+		var a UserAgent
+		if err := rows.Scan(&a.ID, &a.Name, &a.Status, &a.TotalCalls, &a.TotalRevenue, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan agent: %w", err)
+		}
+		agents = append(agents, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list agents: %w", err)
+	}
+	return agents, nil
+}
+
+// statusHistory returns a user's most recent account status changes
+func (s *Service) statusHistory(ctx context.Context, userID uuid.UUID) ([]StatusChange, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT status, reason, changed_by, created_at
+		FROM user_status_changes WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, detailLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list status changes: %w", err)
+	}
+	defer rows.Close()
+
+	changes := []StatusChange{}
+	for rows.Next() {
+		var c StatusChange
+		if err := rows.Scan(&c.Status, &c.Reason, &c.ChangedBy, &c.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan status change: %w", err)
+		}
+		changes = append(changes, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list status changes: %w", err)
+	}
+	return changes, nil
+}
+
+// UpdateUserStatus sets a user's account status and records the change.
+// Suspending or banning requires a reason; admin accounts cannot be
+// suspended or banned. The change takes effect on the user's next request:
+// JWTs are checked against the status and proxies drop the user's cached
+// API keys.
+func (s *Service) UpdateUserStatus(ctx context.Context, adminID, userID uuid.UUID, status models.AccountStatus, reason string) (*UserSummary, error) {
+	if !status.IsValid() {
+		return nil, ErrInvalidStatus
+	}
+	reason = strings.TrimSpace(reason)
+	if status != models.AccountStatusActive && reason == "" {
+		return nil, ErrReasonRequired
+	}
+	var reasonPtr *string
+	if reason != "" {
+		reasonPtr = &reason
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var userType models.UserType
+	err = tx.QueryRow(ctx, `SELECT user_type FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&userType)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if userType == models.UserTypeAdmin && status != models.AccountStatusActive {
+		return nil, ErrCannotChangeAdmin
+	}
+
+	var u UserSummary
+	err = tx.QueryRow(ctx, `
+		WITH updated AS (
+			UPDATE users
+			SET status = $2, status_reason = $3, status_changed_at = NOW(), updated_at = NOW()
+			WHERE id = $1
+			RETURNING id, email, user_type, status, status_reason, email_verified, created_at
+		)
+		SELECT updated.id, updated.email, updated.user_type, p.display_name, updated.status,
+			updated.status_reason, updated.email_verified, updated.created_at
+		FROM updated
+		LEFT JOIN creator_profiles p ON p.user_id = updated.id
+	`, userID, status, reasonPtr).Scan(
+		&u.ID, &u.Email, &u.UserType, &u.DisplayName, &u.Status, &u.StatusReason,
+		&u.EmailVerified, &u.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user status: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_status_changes (user_id, status, reason, changed_by) VALUES ($1, $2, $3, $4)
+	`, userID, status, reasonPtr, adminID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record status change: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return &u, nil
+}
+
+// escapeLike escapes the LIKE wildcards in a search term
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,163 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/shopspring/decimal"
+)
+
+// Stats query errors
+var (
+	ErrInvalidPreset = errors.New("range must be 24h, 7d, 30d or 90d")
+	ErrInvalidRange  = errors.New("from must be before to")
+	ErrRangeTooLarge = errors.New("date range cannot exceed 366 days")
+)
+
+// rangePresets are the named periods ending now that stats can be asked for
+var rangePresets = map[string]time.Duration{
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+	"30d": 30 * 24 * time.Hour,
+	"90d": 90 * 24 * time.Hour,
+}
+
+// defaultPreset is the period reported when no range is given
+const defaultPreset = "7d"
+
+// maxStatsRange bounds the period of a custom range
+const maxStatsRange = 366 * 24 * time.Hour
+
+// StatsQuery selects the period to report on: a named range ending now, or
+// From and To. The period is widened to whole hours, in UTC.
+type StatsQuery struct {
+	Range string
+	From  time.Time
+	To    time.Time
+}
+
+// Stats summarizes platform activity over a period
+type Stats struct {
+	From             time.Time       `json:"from"`
+	To               time.Time       `json:"to"`
+	GMV              decimal.Decimal `json:"gmv_usd"`         // Completed quota purchases
+	SettlementFees   decimal.Decimal `json:"settlement_fees"` // Taken from completed creator settlements
+	WithdrawalFees   decimal.Decimal `json:"withdrawal_fees"` // Taken from completed creator withdrawals
+	PlatformFees     decimal.Decimal `json:"platform_fees"`
+	ActiveDevelopers int64           `json:"active_developers"` // Users who made a call
+	ActiveCreators   int64           `json:"active_creators"`   // Creators whose agents were called
+	NewDevelopers    int64           `json:"new_developers"`
+	NewCreators      int64           `json:"new_creators"`
+	Calls            int64           `json:"calls"`
+	FailedCalls      int64           `json:"failed_calls"`
+	ErrorRate        float64         `json:"error_rate"` // Failed calls over calls, 0 without calls
+}
+
+// Stats returns platform statistics for the queried period. Calls are read
+// from the hourly usage rollups, plus call logs not yet rolled up.
+func (s *Service) Stats(ctx context.Context, q *StatsQuery) (*Stats, error) {
+	if err := normalizeStats(q, time.Now()); err != nil {
+		return nil, err
+	}
+	stats := &Stats{From: q.From, To: q.To}
+
+	var watermark *time.Time
+	err := s.db.QueryRow(ctx, `SELECT rolled_up_to FROM usage_rollup_state`).Scan(&watermark)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get rollup state: %w", err)
+	}
+
+	err = s.db.QueryRow(ctx, `
+		WITH src AS (
+			SELECT user_id, agent_id, status, calls
+			FROM call_usage_hourly
+			WHERE bucket_start >= $1
+			  AND $3::timestamptz IS NOT NULL AND bucket_start < LEAST($2, $3::timestamptz)
+			UNION ALL
+			SELECT user_id, agent_id, COALESCE(status, 'unknown'), COUNT(*)
+			FROM call_logs
+			WHERE created_at >= GREATEST($1, $3::timestamptz) AND created_at < $2
+			GROUP BY 1, 2, 3
+		)
+		SELECT COALESCE(SUM(calls), 0)::BIGINT,
+			COALESCE(SUM(calls) FILTER (WHERE status <> 'success'), 0)::BIGINT,
+			COUNT(DISTINCT user_id),
+			(SELECT COUNT(DISTINCT a.creator_id) FROM agents a WHERE a.id IN (SELECT agent_id FROM src))
+		FROM src
+	`, q.From, q.To, watermark).Scan(&stats.Calls, &stats.FailedCalls, &stats.ActiveDevelopers, &stats.ActiveCreators)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query call stats: %w", err)
+	}
+	stats.ErrorRate = errorRate(stats.Calls, stats.FailedCalls)
+
+	err = s.db.QueryRow(ctx, `
+		SELECT
+			(SELECT COALESCE(SUM(amount_usd), 0) FROM payments
+			 WHERE status = 'completed' AND completed_at >= $1 AND completed_at < $2),
+			(SELECT COALESCE(SUM(platform_fee), 0) FROM settlements
+			 WHERE status = 'completed' AND settled_at >= $1 AND settled_at < $2),
+			(SELECT COALESCE(SUM(platform_fee), 0) FROM withdrawals
+			 WHERE status = 'completed' AND completed_at >= $1 AND completed_at < $2)
+	`, q.From, q.To).Scan(&stats.GMV, &stats.SettlementFees, &stats.WithdrawalFees)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revenue stats: %w", err)
+	}
+	stats.PlatformFees = stats.SettlementFees.Add(stats.WithdrawalFees)
+
+	err = s.db.QueryRow(ctx, `
+		SELECT COUNT(*) FILTER (WHERE user_type = 'developer'),
+			COUNT(*) FILTER (WHERE user_type = 'creator')
+		FROM users
+		WHERE created_at >= $1 AND created_at < $2
+	`, q.From, q.To).Scan(&stats.NewDevelopers, &stats.NewCreators)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query signup stats: %w", err)
+	}
+
+	return stats, nil
+}
+
+// normalizeStats validates a stats query, applies the default range and
+// widens the period to whole hours
+func normalizeStats(q *StatsQuery, now time.Time) error {
+	if q.Range == "" && q.From.IsZero() && q.To.IsZero() {
+		q.Range = defaultPreset
+	}
+	if q.Range != "" {
+		d, ok := rangePresets[q.Range]
+		if !ok {
+			return ErrInvalidPreset
+		}
+		q.To = now
+		q.From = now.Add(-d)
+	}
+	if q.To.IsZero() {
+		q.To = now
+	}
+	if q.From.IsZero() {
+		q.From = q.To.Add(-rangePresets[defaultPreset])
+	}
+
+	q.From, q.To = q.From.UTC().Truncate(time.Hour), q.To.UTC()
+	if aligned := q.To.Truncate(time.Hour); !aligned.Equal(q.To) {
+		q.To = aligned.Add(time.Hour)
+	}
+	if !q.From.Before(q.To) {
+		return ErrInvalidRange
+	}
+	if q.To.Sub(q.From) > maxStatsRange {
+		return ErrRangeTooLarge
+	}
+	return nil
+}
+
+// errorRate returns failed over calls, or 0 without calls
+func errorRate(calls, failed int64) float64 {
+	if calls == 0 {
+		return 0
+	}
+	return float64(failed) / float64(calls)
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/aimerfeng/AgentLink/internal/config"
 	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/aimerfeng/AgentLink/internal/monitoring"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -22,21 +23,32 @@ import (
 
 // Service errors
 var (
-	ErrAgentNotFound     = errors.New("agent not found")
-	ErrAgentNotOwned     = errors.New("agent not owned by user")
-	ErrInvalidPrice      = errors.New("invalid price: must be between $0.001 and $100")
-	ErrInvalidConfig     = errors.New("invalid agent configuration")
-	ErrEncryptionFailed  = errors.New("encryption failed")
-	ErrDecryptionFailed  = errors.New("decryption failed")
-	ErrAgentDraft        = errors.New("agent is in draft status")
-	ErrAgentNotDraft     = errors.New("agent is not in draft status")
-	ErrAgentAlreadyActive = errors.New("agent is already active")
+	ErrAgentNotFound       = errors.New("agent not found")
+	ErrAgentNotOwned       = errors.New("agent not owned by user")
+	ErrInvalidPrice        = errors.New("invalid price: must be between $0.001 and $100")
+	ErrInvalidConfig       = errors.New("invalid agent configuration")
+	ErrEncryptionFailed    = errors.New("encryption failed")
+	ErrDecryptionFailed    = errors.New("decryption failed")
+	ErrAgentDraft          = errors.New("agent is in draft status")
+	ErrAgentNotDraft       = errors.New("agent is not in draft status")
+	ErrAgentAlreadyActive  = errors.New("agent is already active")
+	ErrInvalidPricingModel = errors.New("invalid pricing model: must be per_call, per_token or hybrid")
+	ErrInvalidTokenPrice   = errors.New("invalid token price: must be between $0 and $10 per 1K tokens, and non-zero for token pricing")
+	ErrInvalidAgentLimits  = errors.New("invalid agent limits")
 )
 
 // Price validation constants
 var (
 	MinPricePerCall = decimal.NewFromFloat(0.001) // $0.001 minimum
 	MaxPricePerCall = decimal.NewFromFloat(100.0) // $100 maximum
+
+	MaxPricePer1KTokens = decimal.NewFromFloat(10.0) // $10 per 1K tokens maximum
+)
+
+// Agent limit bounds
+const (
+	MaxAgentRateLimitRPM = 100000
+	MaxAgentConcurrency  = 10000
 )
 
 // Service handles agent operations
@@ -73,43 +85,55 @@ func NewService(db *pgxpool.Pool, encCfg *config.EncryptionConfig) (*Service, er
 
 // CreateAgentRequest represents a request to create an agent
 type CreateAgentRequest struct {
-	Name         string                `json:"name" binding:"required,min=1,max=100"`
-	Description  *string               `json:"description,omitempty"`
-	Category     *string               `json:"category,omitempty"`
-	Config       models.AgentConfig    `json:"config" binding:"required"`
-	PricePerCall decimal.Decimal       `json:"price_per_call" binding:"required"`
+	Name                   string              `json:"name" binding:"required,min=1,max=100"`
+	Description            *string             `json:"description,omitempty"`
+	Category               *string             `json:"category,omitempty"`
+	Config                 models.AgentConfig  `json:"config" binding:"required"`
+	PricingModel           models.PricingModel `json:"pricing_model,omitempty"` // Defaults to per_call
+	PricePerCall           decimal.Decimal     `json:"price_per_call"`          // Flat price (per_call) or base fee (hybrid)
+	PricePer1KInputTokens  decimal.Decimal     `json:"price_per_1k_input_tokens"`
+	PricePer1KOutputTokens decimal.Decimal     `json:"price_per_1k_output_tokens"`
+	Limits                 *models.AgentLimits `json:"limits,omitempty"`
 }
 
 // UpdateAgentRequest represents a request to update an agent
 type UpdateAgentRequest struct {
-	Name         *string               `json:"name,omitempty"`
-	Description  *string               `json:"description,omitempty"`
-	Category     *string               `json:"category,omitempty"`
-	Config       *models.AgentConfig   `json:"config,omitempty"`
-	PricePerCall *decimal.Decimal      `json:"price_per_call,omitempty"`
+	Name                   *string              `json:"name,omitempty"`
+	Description            *string              `json:"description,omitempty"`
+	Category               *string              `json:"category,omitempty"`
+	Config                 *models.AgentConfig  `json:"config,omitempty"`
+	PricingModel           *models.PricingModel `json:"pricing_model,omitempty"`
+	PricePerCall           *decimal.Decimal     `json:"price_per_call,omitempty"`
+	PricePer1KInputTokens  *decimal.Decimal     `json:"price_per_1k_input_tokens,omitempty"`
+	PricePer1KOutputTokens *decimal.Decimal     `json:"price_per_1k_output_tokens,omitempty"`
+	Limits                 *models.AgentLimits  `json:"limits,omitempty"` // Replaces all limits; omitted fields are cleared
 }
 
 // AgentResponse represents an agent response (with decrypted config for owner)
 type AgentResponse struct {
-	ID            uuid.UUID           `json:"id"`
-	CreatorID     uuid.UUID           `json:"creator_id"`
-	Name          string              `json:"name"`
-	Description   *string             `json:"description,omitempty"`
-	Category      *string             `json:"category,omitempty"`
-	Status        models.AgentStatus  `json:"status"`
-	Config        *models.AgentConfig `json:"config,omitempty"` // Only included for owner
-	PricePerCall  decimal.Decimal     `json:"price_per_call"`
-	TotalCalls    int64               `json:"total_calls"`
-	TotalRevenue  decimal.Decimal     `json:"total_revenue"`
-	AverageRating decimal.Decimal     `json:"average_rating"`
-	ReviewCount   int                 `json:"review_count"`
-	TokenID       *int64              `json:"token_id,omitempty"`
-	TokenTxHash   *string             `json:"token_tx_hash,omitempty"`
-	TrialEnabled  bool                `json:"trial_enabled"` // D5.4: Whether trial is enabled
-	Version       int                 `json:"version"`
-	CreatedAt     time.Time           `json:"created_at"`
-	UpdatedAt     time.Time           `json:"updated_at"`
-	PublishedAt   *time.Time          `json:"published_at,omitempty"`
+	ID                     uuid.UUID           `json:"id"`
+	CreatorID              uuid.UUID           `json:"creator_id"`
+	Name                   string              `json:"name"`
+	Description            *string             `json:"description,omitempty"`
+	Category               *string             `json:"category,omitempty"`
+	Status                 models.AgentStatus  `json:"status"`
+	Config                 *models.AgentConfig `json:"config,omitempty"` // Only included for owner
+	PricingModel           models.PricingModel `json:"pricing_model"`
+	PricePerCall           decimal.Decimal     `json:"price_per_call"`
+	PricePer1KInputTokens  decimal.Decimal     `json:"price_per_1k_input_tokens"`
+	PricePer1KOutputTokens decimal.Decimal     `json:"price_per_1k_output_tokens"`
+	TotalCalls             int64               `json:"total_calls"`
+	TotalRevenue           decimal.Decimal     `json:"total_revenue"`
+	AverageRating          decimal.Decimal     `json:"average_rating"`
+	ReviewCount            int                 `json:"review_count"`
+	TokenID                *int64              `json:"token_id,omitempty"`
+	TokenTxHash            *string             `json:"token_tx_hash,omitempty"`
+	TrialEnabled           bool                `json:"trial_enabled"` // D5.4: Whether trial is enabled
+	Limits                 models.AgentLimits  `json:"limits"`
+	Version                int                 `json:"version"`
+	CreatedAt              time.Time           `json:"created_at"`
+	UpdatedAt              time.Time           `json:"updated_at"`
+	PublishedAt            *time.Time          `json:"published_at,omitempty"`
 }
 
 // ListAgentsResponse represents a paginated list of agents
@@ -129,6 +153,33 @@ func ValidatePrice(price decimal.Decimal) error {
 	return nil
 }
 
+// ValidatePricing validates a pricing model together with its prices.
+// per_call requires a valid price_per_call, per_token requires at least one
+// non-zero token price, and hybrid requires both a valid base fee and token prices.
+func ValidatePricing(model models.PricingModel, pricePerCall, pricePer1KInput, pricePer1KOutput decimal.Decimal) error {
+	switch model {
+	case models.PricingModelPerCall:
+		return ValidatePrice(pricePerCall)
+	case models.PricingModelPerToken, models.PricingModelHybrid:
+		if model == models.PricingModelHybrid {
+			if err := ValidatePrice(pricePerCall); err != nil {
+				return err
+			}
+		}
+		for _, p := range []decimal.Decimal{pricePer1KInput, pricePer1KOutput} {
+			if p.IsNegative() || p.GreaterThan(MaxPricePer1KTokens) {
+				return ErrInvalidTokenPrice
+			}
+		}
+		if pricePer1KInput.IsZero() && pricePer1KOutput.IsZero() {
+			return ErrInvalidTokenPrice
+		}
+		return nil
+	default:
+		return ErrInvalidPricingModel
+	}
+}
+
 // ValidateConfig validates the agent configuration
 func ValidateConfig(cfg *models.AgentConfig) error {
 	if cfg.SystemPrompt == "" {
@@ -152,6 +203,25 @@ func ValidateConfig(cfg *models.AgentConfig) error {
 	return nil
 }
 
+// ValidateLimits validates creator-defined agent limits
+func ValidateLimits(limits *models.AgentLimits) error {
+	if limits == nil {
+		return nil
+	}
+	for name, rpm := range map[string]*int{"rate_limit_rpm": limits.RateLimitRPM, "per_caller_rpm": limits.PerCallerRPM} {
+		if rpm != nil && (*rpm < 1 || *rpm > MaxAgentRateLimitRPM) {
+			return fmt.Errorf("%w: %s must be between 1 and %d", ErrInvalidAgentLimits, name, MaxAgentRateLimitRPM)
+		}
+	}
+	if limits.RateLimitRPM != nil && limits.PerCallerRPM != nil && *limits.PerCallerRPM > *limits.RateLimitRPM {
+		return fmt.Errorf("%w: per_caller_rpm cannot exceed rate_limit_rpm", ErrInvalidAgentLimits)
+	}
+	if limits.MaxConcurrent != nil && (*limits.MaxConcurrent < 1 || *limits.MaxConcurrent > MaxAgentConcurrency) {
+		return fmt.Errorf("%w: max_concurrent_requests must be between 1 and %d", ErrInvalidAgentLimits, MaxAgentConcurrency)
+	}
+	return nil
+}
+
 
 // Encrypt encrypts data using AES-256-GCM
 func (s *Service) Encrypt(plaintext []byte) (ciphertext, nonce []byte, err error) {
@@ -221,8 +291,12 @@ func (s *Service) decryptConfig(ciphertext, nonce []byte) (*models.AgentConfig,
 
 // Create creates a new agent
 func (s *Service) Create(ctx context.Context, creatorID uuid.UUID, req *CreateAgentRequest) (*AgentResponse, error) {
-	// Validate price
-	if err := ValidatePrice(req.PricePerCall); err != nil {
+	// Validate pricing
+	pricingModel := req.PricingModel
+	if pricingModel == "" {
+		pricingModel = models.PricingModelPerCall
+	}
+	if err := ValidatePricing(pricingModel, req.PricePerCall, req.PricePer1KInputTokens, req.PricePer1KOutputTokens); err != nil {
 		return nil, err
 	}
 
@@ -231,6 +305,14 @@ func (s *Service) Create(ctx context.Context, creatorID uuid.UUID, req *CreateAg
 		return nil, err
 	}
 
+	var limits models.AgentLimits
+	if req.Limits != nil {
+		if err := ValidateLimits(req.Limits); err != nil {
+			return nil, err
+		}
+		limits = *req.Limits
+	}
+
 	// Encrypt config
 	configEncrypted, configIV, err := s.encryptConfig(&req.Config)
 	if err != nil {
@@ -238,30 +320,41 @@ func (s *Service) Create(ctx context.Context, creatorID uuid.UUID, req *CreateAg
 	}
 
 	// Create agent in database
+	provider, modelFamily := modelInfo(&req.Config)
 	var agent models.Agent
 	err = s.db.QueryRow(ctx, `
 		INSERT INTO agents (
 			creator_id, name, description, category, status,
-			config_encrypted, config_iv, price_per_call, trial_enabled, version
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, true, 1)
+			config_encrypted, config_iv, pricing_model, price_per_call,
+			price_per_1k_input_tokens, price_per_1k_output_tokens, trial_enabled,
+			rate_limit_rpm, per_caller_rpm, max_concurrent_requests, version,
+			provider, model_family
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, true, $12, $13, $14, 1, $15, $16)
 		RETURNING id, creator_id, name, description, category, status,
-			config_encrypted, config_iv, price_per_call, total_calls,
+			config_encrypted, config_iv, COALESCE(pricing_model, 'per_call'), price_per_call,
+			COALESCE(price_per_1k_input_tokens, 0), COALESCE(price_per_1k_output_tokens, 0), total_calls,
 			total_revenue, average_rating, review_count, token_id,
-			token_tx_hash, COALESCE(trial_enabled, true), version, created_at, updated_at, published_at
+			token_tx_hash, COALESCE(trial_enabled, true), rate_limit_rpm, per_caller_rpm, max_concurrent_requests,
+			version, created_at, updated_at, published_at
 	`, creatorID, req.Name, req.Description, req.Category, models.AgentStatusDraft,
-		configEncrypted, configIV, req.PricePerCall,
+		configEncrypted, configIV, pricingModel, req.PricePerCall,
+		req.PricePer1KInputTokens, req.PricePer1KOutputTokens,
+		limits.RateLimitRPM, limits.PerCallerRPM, limits.MaxConcurrent, provider, modelFamily,
 	).Scan(
 		&agent.ID, &agent.CreatorID, &agent.Name, &agent.Description,
 		&agent.Category, &agent.Status, &agent.ConfigEncrypted, &agent.ConfigIV,
-		&agent.PricePerCall, &agent.TotalCalls, &agent.TotalRevenue,
+		&agent.PricingModel, &agent.PricePerCall, &agent.PricePer1KInput, &agent.PricePer1KOutput,
+		&agent.TotalCalls, &agent.TotalRevenue,
 		&agent.AverageRating, &agent.ReviewCount, &agent.TokenID,
-		&agent.TokenTxHash, &agent.TrialEnabled, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
+		&agent.TokenTxHash, &agent.TrialEnabled,
+		&agent.Limits.RateLimitRPM, &agent.Limits.PerCallerRPM, &agent.Limits.MaxConcurrent, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
 		&agent.PublishedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create agent: %w", err)
 	}
 
+	monitoring.RecordAgentCreated()
 	return s.toAgentResponse(&agent, &req.Config), nil
 }
 
@@ -270,16 +363,20 @@ func (s *Service) GetByID(ctx context.Context, agentID uuid.UUID) (*models.Agent
 	var agent models.Agent
 	err := s.db.QueryRow(ctx, `
 		SELECT id, creator_id, name, description, category, status,
-			config_encrypted, config_iv, price_per_call, total_calls,
+			config_encrypted, config_iv, COALESCE(pricing_model, 'per_call'), price_per_call,
+			COALESCE(price_per_1k_input_tokens, 0), COALESCE(price_per_1k_output_tokens, 0), total_calls,
 			total_revenue, average_rating, review_count, token_id,
-			token_tx_hash, COALESCE(trial_enabled, true), version, created_at, updated_at, published_at
+			token_tx_hash, COALESCE(trial_enabled, true), rate_limit_rpm, per_caller_rpm, max_concurrent_requests,
+			version, created_at, updated_at, published_at
 		FROM agents WHERE id = $1
 	`, agentID).Scan(
 		&agent.ID, &agent.CreatorID, &agent.Name, &agent.Description,
 		&agent.Category, &agent.Status, &agent.ConfigEncrypted, &agent.ConfigIV,
-		&agent.PricePerCall, &agent.TotalCalls, &agent.TotalRevenue,
+		&agent.PricingModel, &agent.PricePerCall, &agent.PricePer1KInput, &agent.PricePer1KOutput,
+		&agent.TotalCalls, &agent.TotalRevenue,
 		&agent.AverageRating, &agent.ReviewCount, &agent.TokenID,
-		&agent.TokenTxHash, &agent.TrialEnabled, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
+		&agent.TokenTxHash, &agent.TrialEnabled,
+		&agent.Limits.RateLimitRPM, &agent.Limits.PerCallerRPM, &agent.Limits.MaxConcurrent, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
 		&agent.PublishedAt,
 	)
 	if err != nil {
@@ -335,9 +432,11 @@ func (s *Service) List(ctx context.Context, creatorID uuid.UUID, page, pageSize
 	// Get agents
 	rows, err := s.db.Query(ctx, `
 		SELECT id, creator_id, name, description, category, status,
-			config_encrypted, config_iv, price_per_call, total_calls,
+			config_encrypted, config_iv, COALESCE(pricing_model, 'per_call'), price_per_call,
+			COALESCE(price_per_1k_input_tokens, 0), COALESCE(price_per_1k_output_tokens, 0), total_calls,
 			total_revenue, average_rating, review_count, token_id,
-			token_tx_hash, COALESCE(trial_enabled, true), version, created_at, updated_at, published_at
+			token_tx_hash, COALESCE(trial_enabled, true), rate_limit_rpm, per_caller_rpm, max_concurrent_requests,
+			version, created_at, updated_at, published_at
 		FROM agents
 		WHERE creator_id = $1
 		ORDER BY created_at DESC
@@ -354,9 +453,11 @@ func (s *Service) List(ctx context.Context, creatorID uuid.UUID, page, pageSize
 		err := rows.Scan(
 			&agent.ID, &agent.CreatorID, &agent.Name, &agent.Description,
 			&agent.Category, &agent.Status, &agent.ConfigEncrypted, &agent.ConfigIV,
-			&agent.PricePerCall, &agent.TotalCalls, &agent.TotalRevenue,
+			&agent.PricingModel, &agent.PricePerCall, &agent.PricePer1KInput, &agent.PricePer1KOutput,
+			&agent.TotalCalls, &agent.TotalRevenue,
 			&agent.AverageRating, &agent.ReviewCount, &agent.TokenID,
-			&agent.TokenTxHash, &agent.TrialEnabled, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
+			&agent.TokenTxHash, &agent.TrialEnabled,
+			&agent.Limits.RateLimitRPM, &agent.Limits.PerCallerRPM, &agent.Limits.MaxConcurrent, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
 			&agent.PublishedAt,
 		)
 		if err != nil {
@@ -436,11 +537,23 @@ func (s *Service) Update(ctx context.Context, agentID, creatorID uuid.UUID, req
 	if req.Category != nil {
 		agent.Category = req.Category
 	}
-	if req.PricePerCall != nil {
-		if err := ValidatePrice(*req.PricePerCall); err != nil {
+	if req.PricingModel != nil || req.PricePerCall != nil ||
+		req.PricePer1KInputTokens != nil || req.PricePer1KOutputTokens != nil {
+		if req.PricingModel != nil {
+			agent.PricingModel = *req.PricingModel
+		}
+		if req.PricePerCall != nil {
+			agent.PricePerCall = *req.PricePerCall
+		}
+		if req.PricePer1KInputTokens != nil {
+			agent.PricePer1KInput = *req.PricePer1KInputTokens
+		}
+		if req.PricePer1KOutputTokens != nil {
+			agent.PricePer1KOutput = *req.PricePer1KOutputTokens
+		}
+		if err := ValidatePricing(agent.PricingModel, agent.PricePerCall, agent.PricePer1KInput, agent.PricePer1KOutput); err != nil {
 			return nil, err
 		}
-		agent.PricePerCall = *req.PricePerCall
 	}
 	if req.Config != nil {
 		if err := ValidateConfig(req.Config); err != nil {
@@ -448,6 +561,12 @@ func (s *Service) Update(ctx context.Context, agentID, creatorID uuid.UUID, req
 		}
 		currentConfig = req.Config
 	}
+	if req.Limits != nil {
+		if err := ValidateLimits(req.Limits); err != nil {
+			return nil, err
+		}
+		agent.Limits = *req.Limits
+	}
 
 	// Encrypt updated config
 	configEncrypted, configIV, err := s.encryptConfig(currentConfig)
@@ -456,25 +575,35 @@ func (s *Service) Update(ctx context.Context, agentID, creatorID uuid.UUID, req
 	}
 
 	// Update agent
+	provider, modelFamily := modelInfo(currentConfig)
 	newVersion := agent.Version + 1
 	err = tx.QueryRow(ctx, `
 		UPDATE agents SET
 			name = $1, description = $2, category = $3,
-			config_encrypted = $4, config_iv = $5, price_per_call = $6,
-			version = $7, updated_at = NOW()
-		WHERE id = $8
+			config_encrypted = $4, config_iv = $5, pricing_model = $6, price_per_call = $7,
+			price_per_1k_input_tokens = $8, price_per_1k_output_tokens = $9,
+			rate_limit_rpm = $10, per_caller_rpm = $11, max_concurrent_requests = $12,
+			version = $13, provider = $15, model_family = $16, updated_at = NOW()
+		WHERE id = $14
 		RETURNING id, creator_id, name, description, category, status,
-			config_encrypted, config_iv, price_per_call, total_calls,
+			config_encrypted, config_iv, COALESCE(pricing_model, 'per_call'), price_per_call,
+			COALESCE(price_per_1k_input_tokens, 0), COALESCE(price_per_1k_output_tokens, 0), total_calls,
 			total_revenue, average_rating, review_count, token_id,
-			token_tx_hash, COALESCE(trial_enabled, true), version, created_at, updated_at, published_at
+			token_tx_hash, COALESCE(trial_enabled, true), rate_limit_rpm, per_caller_rpm, max_concurrent_requests,
+			version, created_at, updated_at, published_at
 	`, agent.Name, agent.Description, agent.Category,
-		configEncrypted, configIV, agent.PricePerCall, newVersion, agentID,
+		configEncrypted, configIV, agent.PricingModel, agent.PricePerCall,
+		agent.PricePer1KInput, agent.PricePer1KOutput,
+		agent.Limits.RateLimitRPM, agent.Limits.PerCallerRPM, agent.Limits.MaxConcurrent, newVersion, agentID,
+		provider, modelFamily,
 	).Scan(
 		&agent.ID, &agent.CreatorID, &agent.Name, &agent.Description,
 		&agent.Category, &agent.Status, &agent.ConfigEncrypted, &agent.ConfigIV,
-		&agent.PricePerCall, &agent.TotalCalls, &agent.TotalRevenue,
+		&agent.PricingModel, &agent.PricePerCall, &agent.PricePer1KInput, &agent.PricePer1KOutput,
+		&agent.TotalCalls, &agent.TotalRevenue,
 		&agent.AverageRating, &agent.ReviewCount, &agent.TokenID,
-		&agent.TokenTxHash, &agent.TrialEnabled, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
+		&agent.TokenTxHash, &agent.TrialEnabled,
+		&agent.Limits.RateLimitRPM, &agent.Limits.PerCallerRPM, &agent.Limits.MaxConcurrent, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
 		&agent.PublishedAt,
 	)
 	if err != nil {
@@ -514,20 +643,25 @@ func (s *Service) Publish(ctx context.Context, agentID, creatorID uuid.UUID) (*A
 			status = $1, published_at = $2, updated_at = NOW()
 		WHERE id = $3
 		RETURNING id, creator_id, name, description, category, status,
-			config_encrypted, config_iv, price_per_call, total_calls,
+			config_encrypted, config_iv, COALESCE(pricing_model, 'per_call'), price_per_call,
+			COALESCE(price_per_1k_input_tokens, 0), COALESCE(price_per_1k_output_tokens, 0), total_calls,
 			total_revenue, average_rating, review_count, token_id,
-			token_tx_hash, COALESCE(trial_enabled, true), version, created_at, updated_at, published_at
+			token_tx_hash, COALESCE(trial_enabled, true), rate_limit_rpm, per_caller_rpm, max_concurrent_requests,
+			version, created_at, updated_at, published_at
 	`, models.AgentStatusActive, now, agentID).Scan(
 		&agent.ID, &agent.CreatorID, &agent.Name, &agent.Description,
 		&agent.Category, &agent.Status, &agent.ConfigEncrypted, &agent.ConfigIV,
-		&agent.PricePerCall, &agent.TotalCalls, &agent.TotalRevenue,
+		&agent.PricingModel, &agent.PricePerCall, &agent.PricePer1KInput, &agent.PricePer1KOutput,
+		&agent.TotalCalls, &agent.TotalRevenue,
 		&agent.AverageRating, &agent.ReviewCount, &agent.TokenID,
-		&agent.TokenTxHash, &agent.TrialEnabled, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
+		&agent.TokenTxHash, &agent.TrialEnabled,
+		&agent.Limits.RateLimitRPM, &agent.Limits.PerCallerRPM, &agent.Limits.MaxConcurrent, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
 		&agent.PublishedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to publish agent: %w", err)
 	}
+	monitoring.RecordAgentPublished()
 
 	// Decrypt config for response
 	cfg, err := s.decryptConfig(agent.ConfigEncrypted, agent.ConfigIV)
@@ -556,15 +690,19 @@ func (s *Service) Unpublish(ctx context.Context, agentID, creatorID uuid.UUID) (
 			status = $1, updated_at = NOW()
 		WHERE id = $2
 		RETURNING id, creator_id, name, description, category, status,
-			config_encrypted, config_iv, price_per_call, total_calls,
+			config_encrypted, config_iv, COALESCE(pricing_model, 'per_call'), price_per_call,
+			COALESCE(price_per_1k_input_tokens, 0), COALESCE(price_per_1k_output_tokens, 0), total_calls,
 			total_revenue, average_rating, review_count, token_id,
-			token_tx_hash, COALESCE(trial_enabled, true), version, created_at, updated_at, published_at
+			token_tx_hash, COALESCE(trial_enabled, true), rate_limit_rpm, per_caller_rpm, max_concurrent_requests,
+			version, created_at, updated_at, published_at
 	`, models.AgentStatusInactive, agentID).Scan(
 		&agent.ID, &agent.CreatorID, &agent.Name, &agent.Description,
 		&agent.Category, &agent.Status, &agent.ConfigEncrypted, &agent.ConfigIV,
-		&agent.PricePerCall, &agent.TotalCalls, &agent.TotalRevenue,
+		&agent.PricingModel, &agent.PricePerCall, &agent.PricePer1KInput, &agent.PricePer1KOutput,
+		&agent.TotalCalls, &agent.TotalRevenue,
 		&agent.AverageRating, &agent.ReviewCount, &agent.TokenID,
-		&agent.TokenTxHash, &agent.TrialEnabled, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
+		&agent.TokenTxHash, &agent.TrialEnabled,
+		&agent.Limits.RateLimitRPM, &agent.Limits.PerCallerRPM, &agent.Limits.MaxConcurrent, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
 		&agent.PublishedAt,
 	)
 	if err != nil {
@@ -590,6 +728,11 @@ func (s *Service) GetConfig(ctx context.Context, agentID uuid.UUID) (*models.Age
 	return s.decryptConfig(agent.ConfigEncrypted, agent.ConfigIV)
 }
 
+// DecryptConfig decrypts the config of an already loaded agent (internal use)
+func (s *Service) DecryptConfig(agent *models.Agent) (*models.AgentConfig, error) {
+	return s.decryptConfig(agent.ConfigEncrypted, agent.ConfigIV)
+}
+
 // SetTrialEnabled sets whether trial is enabled for an agent
 // D5.4: THE Creator SHALL have option to disable trial for their Agents
 func (s *Service) SetTrialEnabled(ctx context.Context, agentID, creatorID uuid.UUID, enabled bool) (*AgentResponse, error) {
@@ -610,15 +753,19 @@ func (s *Service) SetTrialEnabled(ctx context.Context, agentID, creatorID uuid.U
 			trial_enabled = $1, updated_at = NOW()
 		WHERE id = $2
 		RETURNING id, creator_id, name, description, category, status,
-			config_encrypted, config_iv, price_per_call, total_calls,
+			config_encrypted, config_iv, COALESCE(pricing_model, 'per_call'), price_per_call,
+			COALESCE(price_per_1k_input_tokens, 0), COALESCE(price_per_1k_output_tokens, 0), total_calls,
 			total_revenue, average_rating, review_count, token_id,
-			token_tx_hash, COALESCE(trial_enabled, true), version, created_at, updated_at, published_at
+			token_tx_hash, COALESCE(trial_enabled, true), rate_limit_rpm, per_caller_rpm, max_concurrent_requests,
+			version, created_at, updated_at, published_at
 	`, enabled, agentID).Scan(
 		&agent.ID, &agent.CreatorID, &agent.Name, &agent.Description,
 		&agent.Category, &agent.Status, &agent.ConfigEncrypted, &agent.ConfigIV,
-		&agent.PricePerCall, &agent.TotalCalls, &agent.TotalRevenue,
+		&agent.PricingModel, &agent.PricePerCall, &agent.PricePer1KInput, &agent.PricePer1KOutput,
+		&agent.TotalCalls, &agent.TotalRevenue,
 		&agent.AverageRating, &agent.ReviewCount, &agent.TokenID,
-		&agent.TokenTxHash, &agent.TrialEnabled, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
+		&agent.TokenTxHash, &agent.TrialEnabled,
+		&agent.Limits.RateLimitRPM, &agent.Limits.PerCallerRPM, &agent.Limits.MaxConcurrent, &agent.Version, &agent.CreatedAt, &agent.UpdatedAt,
 		&agent.PublishedAt,
 	)
 	if err != nil {
@@ -637,25 +784,29 @@ func (s *Service) SetTrialEnabled(ctx context.Context, agentID, creatorID uuid.U
 // toAgentResponse converts an Agent model to AgentResponse
 func (s *Service) toAgentResponse(agent *models.Agent, cfg *models.AgentConfig) *AgentResponse {
 	return &AgentResponse{
-		ID:            agent.ID,
-		CreatorID:     agent.CreatorID,
-		Name:          agent.Name,
-		Description:   agent.Description,
-		Category:      agent.Category,
-		Status:        agent.Status,
-		Config:        cfg,
-		PricePerCall:  agent.PricePerCall,
-		TotalCalls:    agent.TotalCalls,
-		TotalRevenue:  agent.TotalRevenue,
-		AverageRating: agent.AverageRating,
-		ReviewCount:   agent.ReviewCount,
-		TokenID:       agent.TokenID,
-		TokenTxHash:   agent.TokenTxHash,
-		TrialEnabled:  agent.TrialEnabled,
-		Version:       agent.Version,
-		CreatedAt:     agent.CreatedAt,
-		UpdatedAt:     agent.UpdatedAt,
-		PublishedAt:   agent.PublishedAt,
+		ID:                     agent.ID,
+		CreatorID:              agent.CreatorID,
+		Name:                   agent.Name,
+		Description:            agent.Description,
+		Category:               agent.Category,
+		Status:                 agent.Status,
+		Config:                 cfg,
+		PricingModel:           agent.PricingModel,
+		PricePerCall:           agent.PricePerCall,
+		PricePer1KInputTokens:  agent.PricePer1KInput,
+		PricePer1KOutputTokens: agent.PricePer1KOutput,
+		TotalCalls:             agent.TotalCalls,
+		TotalRevenue:           agent.TotalRevenue,
+		AverageRating:          agent.AverageRating,
+		ReviewCount:            agent.ReviewCount,
+		TokenID:                agent.TokenID,
+		TokenTxHash:            agent.TokenTxHash,
+		TrialEnabled:           agent.TrialEnabled,
+		Limits:                 agent.Limits,
+		Version:                agent.Version,
+		CreatedAt:              agent.CreatedAt,
+		UpdatedAt:              agent.UpdatedAt,
+		PublishedAt:            agent.PublishedAt,
 	}
 }
 
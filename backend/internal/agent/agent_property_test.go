@@ -2,6 +2,7 @@ package agent_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
@@ -417,6 +418,107 @@ func TestProperty11_PriceValidationIntegration(t *testing.T) {
 }
 
 
+// Property 11b: Token Pricing Calculation
+// *For any* token-priced agent, the call cost SHALL equal input/1000 * input price
+// plus output/1000 * output price, with hybrid agents adding the base per-call fee.
+// Per-call agents SHALL be charged the flat price regardless of token usage.
+func TestProperty11_TokenPricingCalculation(t *testing.T) {
+	thousand := decimal.NewFromInt(1000)
+
+	rapid.Check(t, func(t *rapid.T) {
+		// Token prices in micro-dollars: $0.000001 to $10 per 1K tokens
+		inputPrice := decimal.NewFromInt(rapid.Int64Range(0, 10000000).Draw(t, "inputMicros")).Div(decimal.NewFromInt(1000000))
+		outputPrice := decimal.NewFromInt(rapid.Int64Range(0, 10000000).Draw(t, "outputMicros")).Div(decimal.NewFromInt(1000000))
+		basePrice := generateValidPrice(t)
+		inputTokens := rapid.IntRange(0, 200000).Draw(t, "inputTokens")
+		outputTokens := rapid.IntRange(0, 200000).Draw(t, "outputTokens")
+
+		a := &agentmodels.Agent{
+			PricePerCall:     basePrice,
+			PricePer1KInput:  inputPrice,
+			PricePer1KOutput: outputPrice,
+		}
+		tokenCost := decimal.NewFromInt(int64(inputTokens)).Mul(inputPrice).Div(thousand).
+			Add(decimal.NewFromInt(int64(outputTokens)).Mul(outputPrice).Div(thousand)).
+			Round(6)
+
+		// Property: per_call ignores token usage
+		a.PricingModel = agentmodels.PricingModelPerCall
+		if cost := a.CallCost(inputTokens, outputTokens); !cost.Equal(basePrice) {
+			t.Fatalf("per_call cost should be %s, got %s", basePrice, cost)
+		}
+
+		// Property: per_token charges only for tokens
+		a.PricingModel = agentmodels.PricingModelPerToken
+		if cost := a.CallCost(inputTokens, outputTokens); !cost.Equal(tokenCost) {
+			t.Fatalf("per_token cost should be %s, got %s", tokenCost, cost)
+		}
+
+		// Property: hybrid charges base fee plus tokens
+		a.PricingModel = agentmodels.PricingModelHybrid
+		if cost := a.CallCost(inputTokens, outputTokens); !cost.Equal(basePrice.Add(tokenCost)) {
+			t.Fatalf("hybrid cost should be %s, got %s", basePrice.Add(tokenCost), cost)
+		}
+	})
+
+	t.Run("ValidatePricing", func(t *testing.T) {
+		one := decimal.NewFromFloat(0.01)
+		zero := decimal.Zero
+
+		if err := agent.ValidatePricing(agentmodels.PricingModelPerCall, one, zero, zero); err != nil {
+			t.Fatalf("per_call with valid price should pass, got: %v", err)
+		}
+		if err := agent.ValidatePricing(agentmodels.PricingModelPerToken, zero, one, zero); err != nil {
+			t.Fatalf("per_token with input price should pass, got: %v", err)
+		}
+		if err := agent.ValidatePricing(agentmodels.PricingModelPerToken, zero, zero, zero); err != agent.ErrInvalidTokenPrice {
+			t.Fatalf("per_token without token prices should return ErrInvalidTokenPrice, got: %v", err)
+		}
+		if err := agent.ValidatePricing(agentmodels.PricingModelPerToken, zero, decimal.NewFromFloat(10.01), one); err != agent.ErrInvalidTokenPrice {
+			t.Fatalf("token price above maximum should return ErrInvalidTokenPrice, got: %v", err)
+		}
+		if err := agent.ValidatePricing(agentmodels.PricingModelHybrid, zero, one, one); err != agent.ErrInvalidPrice {
+			t.Fatalf("hybrid without base fee should return ErrInvalidPrice, got: %v", err)
+		}
+		if err := agent.ValidatePricing("per_minute", one, one, one); err != agent.ErrInvalidPricingModel {
+			t.Fatalf("unknown pricing model should return ErrInvalidPricingModel, got: %v", err)
+		}
+	})
+}
+
+
+// TestAgentLimitsValidation tests that creator-defined agent limits are
+// accepted exactly when they are within bounds and consistent
+func TestAgentLimitsValidation(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		rpm := rapid.IntRange(-10, agent.MaxAgentRateLimitRPM+10).Draw(rt, "rpm")
+		perCaller := rapid.IntRange(-10, agent.MaxAgentRateLimitRPM+10).Draw(rt, "perCaller")
+		concurrent := rapid.IntRange(-10, agent.MaxAgentConcurrency+10).Draw(rt, "concurrent")
+		limits := &agentmodels.AgentLimits{RateLimitRPM: &rpm, PerCallerRPM: &perCaller, MaxConcurrent: &concurrent}
+
+		valid := rpm >= 1 && rpm <= agent.MaxAgentRateLimitRPM &&
+			perCaller >= 1 && perCaller <= rpm &&
+			concurrent >= 1 && concurrent <= agent.MaxAgentConcurrency
+		err := agent.ValidateLimits(limits)
+		if valid && err != nil {
+			t.Fatalf("Limits %d/%d/%d should be valid, got: %v", rpm, perCaller, concurrent, err)
+		}
+		if !valid && !errors.Is(err, agent.ErrInvalidAgentLimits) {
+			t.Fatalf("Limits %d/%d/%d should return ErrInvalidAgentLimits, got: %v", rpm, perCaller, concurrent, err)
+		}
+	})
+
+	t.Run("Optional", func(t *testing.T) {
+		if err := agent.ValidateLimits(&agentmodels.AgentLimits{}); err != nil {
+			t.Fatalf("Empty limits should be valid, got: %v", err)
+		}
+		perCaller := 50
+		if err := agent.ValidateLimits(&agentmodels.AgentLimits{PerCallerRPM: &perCaller}); err != nil {
+			t.Fatalf("Per-caller limit without a global limit should be valid, got: %v", err)
+		}
+	})
+}
+
 // Property 19: Encryption Round-Trip
 // *For any* valid agent configuration, encrypting then decrypting SHALL produce the original configuration.
 // **Validates: Requirements 10.1**
@@ -1292,3 +1394,32 @@ func TestProperty9_VersionOwnershipValidation(t *testing.T) {
 		}
 	})
 }
+
+// TestProperty_ModelFamilyIgnoresSnapshots tests that snapshot suffixes are
+// removed from model names and that the family of a family is itself
+func TestProperty_ModelFamilyIgnoresSnapshots(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		base := rapid.StringMatching(`[a-z][a-z0-9.]{0,8}(-[a-z][a-z0-9.]{0,6}){0,3}`).Draw(t, "base")
+		suffix := rapid.SampledFrom([]string{"", "-20240620", "-2024-08-06", "-0613", "-latest", "-preview", "@20240620"}).Draw(t, "suffix")
+
+		family := agent.ModelFamily(base + suffix)
+		if family != agent.ModelFamily(base) {
+			t.Fatalf("PROPERTY VIOLATION: %q has family %q, but %q has %q", base+suffix, family, base, agent.ModelFamily(base))
+		}
+		if agent.ModelFamily(family) != family {
+			t.Fatalf("PROPERTY VIOLATION: family %q is not its own family", family)
+		}
+	})
+
+	for model, want := range map[string]string{
+		"gpt-4o-2024-08-06":          "gpt-4o",
+		"claude-3-5-sonnet-20240620": "claude-3-5-sonnet",
+		"GPT-4-0613":                 "gpt-4",
+		"gemini-1.5-pro-latest":      "gemini-1.5-pro",
+		"llama-3-70b":                "llama-3-70b",
+	} {
+		if got := agent.ModelFamily(model); got != want {
+			t.Errorf("ModelFamily(%q) = %q, want %q", model, got, want)
+		}
+	}
+}
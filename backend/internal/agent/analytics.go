@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Analytics period bounds in days
+const (
+	DefaultAnalyticsDays = 7
+	MaxAnalyticsDays     = 90
+)
+
+// AnalyticsResponse summarizes calls to an agent over a period, for its creator
+type AnalyticsResponse struct {
+	AgentID          uuid.UUID        `json:"agent_id"`
+	From             time.Time        `json:"from"`
+	To               time.Time        `json:"to"`
+	TotalCalls       int64            `json:"total_calls"`
+	SuccessfulCalls  int64            `json:"successful_calls"`
+	FailedCalls      int64            `json:"failed_calls"`
+	AverageLatencyMs int              `json:"average_latency_ms"`
+	InputTokens      int64            `json:"input_tokens"`
+	OutputTokens     int64            `json:"output_tokens"`
+	Revenue          decimal.Decimal  `json:"revenue"`
+	Rejections       map[string]int64 `json:"rejections"` // Requests rejected by the agent's limits, by reason
+	Daily            []DailyAnalytics `json:"daily"`
+}
+
+// DailyAnalytics is one UTC day of an agent's analytics
+type DailyAnalytics struct {
+	Date       string           `json:"date"` // YYYY-MM-DD
+	Calls      int64            `json:"calls"`
+	Errors     int64            `json:"errors"`
+	Rejections map[string]int64 `json:"rejections"`
+}
+
+// GetAnalytics returns call and limit rejection statistics for an agent over
+// the last days (UTC), for its owner only
+func (s *Service) GetAnalytics(ctx context.Context, agentID, creatorID uuid.UUID, days int) (*AnalyticsResponse, error) {
+	if days < 1 || days > MaxAnalyticsDays {
+		days = DefaultAnalyticsDays
+	}
+
+	agent, err := s.GetByID(ctx, agentID)
+	if err != nil {
+		return nil, err
+	}
+	if agent.CreatorID != creatorID {
+		return nil, ErrAgentNotOwned
+	}
+
+	to := time.Now().UTC()
+	from := to.Truncate(24*time.Hour).AddDate(0, 0, -(days - 1))
+	resp := &AnalyticsResponse{
+		AgentID:    agentID,
+		From:       from,
+		To:         to,
+		Rejections: make(map[string]int64),
+	}
+
+	// One entry per day so gaps show as zeros
+	daily := make(map[string]*DailyAnalytics, days)
+	for d := 0; d < days; d++ {
+		date := from.AddDate(0, 0, d).Format("2006-01-02")
+		resp.Daily = append(resp.Daily, DailyAnalytics{Date: date, Rejections: make(map[string]int64)})
+	}
+	for i := range resp.Daily {
+		daily[resp.Daily[i].Date] = &resp.Daily[i]
+	}
+
+	var avgLatency float64
+	err = s.db.QueryRow(ctx, `
+		SELECT COUNT(*),
+			COUNT(*) FILTER (WHERE status = 'success'),
+			COALESCE(AVG(latency_ms), 0),
+			COALESCE(SUM(input_tokens), 0),
+			COALESCE(SUM(output_tokens), 0),
+			COALESCE(SUM(cost_usd) FILTER (WHERE status = 'success'), 0)
+		FROM call_logs
+		WHERE agent_id = $1 AND created_at >= $2
+	`, agentID, from).Scan(
+		&resp.TotalCalls, &resp.SuccessfulCalls, &avgLatency,
+		&resp.InputTokens, &resp.OutputTokens, &resp.Revenue,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get call statistics: %w", err)
+	}
+	resp.FailedCalls = resp.TotalCalls - resp.SuccessfulCalls
+	resp.AverageLatencyMs = int(avgLatency)
+
+	rows, err := s.db.Query(ctx, `
+		SELECT to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day,
+			COUNT(*), COUNT(*) FILTER (WHERE status <> 'success')
+		FROM call_logs
+		WHERE agent_id = $1 AND created_at >= $2
+		GROUP BY day
+	`, agentID, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get daily call statistics: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var date string
+		var calls, errs int64
+		if err := rows.Scan(&date, &calls, &errs); err != nil {
+			return nil, fmt.Errorf("failed to scan daily call statistics: %w", err)
+		}
+		if day, ok := daily[date]; ok {
+			day.Calls, day.Errors = calls, errs
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate daily call statistics: %w", err)
+	}
+
+	rows, err = s.db.Query(ctx, `
+		SELECT to_char(bucket_start AT TIME ZONE 'UTC', 'YYYY-MM-DD') AS day, reason, SUM(count)
+		FROM agent_limit_rejections
+		WHERE agent_id = $1 AND bucket_start >= $2
+		GROUP BY day, reason
+	`, agentID, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get limit rejections: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var date, reason string
+		var count int64
+		if err := rows.Scan(&date, &reason, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan limit rejections: %w", err)
+		}
+		resp.Rejections[reason] += count
+		if day, ok := daily[date]; ok {
+			day.Rejections[reason] += count
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate limit rejections: %w", err)
+	}
+
+	return resp, nil
+}
+
+// RecordLimitRejection counts a request rejected by the agent's limits in an hourly bucket
+func (s *Service) RecordLimitRejection(ctx context.Context, agentID uuid.UUID, reason string) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO agent_limit_rejections (agent_id, bucket_start, reason, count)
+		VALUES ($1, date_trunc('hour', NOW()), $2, 1)
+		ON CONFLICT (agent_id, bucket_start, reason)
+		DO UPDATE SET count = agent_limit_rejections.count + 1
+	`, agentID, reason)
+	if err != nil {
+		return fmt.Errorf("failed to record agent limit rejection: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// modelSuffix matches a snapshot, date or release-channel suffix of a model name,
+// such as -20240620, -2024-08-06, -0613, -latest or @20240620
+var modelSuffix = regexp.MustCompile(`(@[^@]*|-(\d{4}-\d{2}-\d{2}|\d{8}|\d{4}|latest|preview))$`)
+
+// ModelFamily returns a model name without its snapshot suffixes, so that
+// agents on different snapshots of a model can be found together
+func ModelFamily(model string) string {
+	family := strings.ToLower(strings.TrimSpace(model))
+	for {
+		trimmed := modelSuffix.ReplaceAllString(family, "")
+		if trimmed == family || trimmed == "" {
+			return family
+		}
+		family = trimmed
+	}
+}
+
+// modelInfo returns the searchable provider and model family of a config
+func modelInfo(cfg *models.AgentConfig) (provider, family string) {
+	return strings.ToLower(strings.TrimSpace(cfg.Provider)), ModelFamily(cfg.Model)
+}
+
+// BackfillModelInfo sets the provider and model family of agents saved
+// before they were stored outside the encrypted config. Agents whose config
+// cannot be decrypted are skipped.
+func (s *Service) BackfillModelInfo(ctx context.Context) (int, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, config_encrypted, config_iv FROM agents WHERE provider IS NULL
+	`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list agents: %w", err)
+	}
+
+	type pending struct {
+		id       uuid.UUID
+		provider string
+		family   string
+	}
+	var agents []pending
+	for rows.Next() {
+		var id uuid.UUID
+		var ciphertext, nonce []byte
+		if err := rows.Scan(&id, &ciphertext, &nonce); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan agent: %w", err)
+		}
+		cfg, err := s.decryptConfig(ciphertext, nonce)
+		if err != nil {
+			log.Warn().Err(err).Str("agent_id", id.String()).Msg("Skipping agent with undecryptable config")
+			continue
+		}
+		provider, family := modelInfo(cfg)
+		agents = append(agents, pending{id: id, provider: provider, family: family})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to list agents: %w", err)
+	}
+
+	updated := 0
+	for _, a := range agents {
+		tag, err := s.db.Exec(ctx, `
+			UPDATE agents SET provider = $2, model_family = $3 WHERE id = $1 AND provider IS NULL
+		`, a.id, a.provider, a.family)
+		if err != nil {
+			return updated, fmt.Errorf("failed to update agent: %w", err)
+		}
+		updated += int(tag.RowsAffected())
+	}
+	return updated, nil
+}
@@ -0,0 +1,395 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/email"
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/aimerfeng/AgentLink/internal/webhook"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// MaxThresholdsPerUser is how many alert thresholds a user can configure
+const MaxThresholdsPerUser = 10
+
+// emailBatchSize is how many pending alert emails are sent per batch
+const emailBatchSize = 50
+
+// Service errors
+var (
+	ErrThresholdNotFound    = errors.New("quota alert threshold not found")
+	ErrInvalidThreshold     = errors.New("threshold value must be positive, and at most 100 for percentages")
+	ErrInvalidThresholdType = errors.New("threshold type must be absolute or percentage")
+	ErrTooManyThresholds    = errors.New("quota alert threshold limit reached")
+)
+
+// Querier is implemented by both the connection pool and a transaction, so
+// alerts can be evaluated in the same transaction as the balance change
+type Querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Service manages quota alert thresholds and emails fired alerts
+type Service struct {
+	db     *pgxpool.Pool
+	config *config.QuotaConfig
+	mailer *email.Mailer
+}
+
+// NewService creates a new quota alert service
+func NewService(db *pgxpool.Pool, cfg *config.QuotaConfig, mailer *email.Mailer) *Service {
+	return &Service{
+		db:     db,
+		config: cfg,
+		mailer: mailer,
+	}
+}
+
+// CreateThresholdRequest represents a request to add a quota alert threshold
+type CreateThresholdRequest struct {
+	ThresholdType models.QuotaAlertThresholdType `json:"threshold_type" binding:"required"`
+	Value         int64                          `json:"value" binding:"required,min=1"`
+	NotifyEmail   *bool                          `json:"notify_email"` // Defaults to true
+}
+
+// balanceCTEs computes a user's balance and their last purchase, for $1
+const balanceCTEs = `
+	WITH b AS (
+		SELECT total_quota + free_quota - used_quota AS balance FROM quotas WHERE user_id = $1
+	), lp AS (
+		SELECT quota_purchased FROM payments
+		WHERE user_id = $1 AND status = 'completed'
+		ORDER BY completed_at DESC NULLS LAST
+		LIMIT 1
+	)`
+
+// thresholdQuota is a threshold in quota units. Percentage thresholds are NULL,
+// and so never fire, until the user has made a purchase.
+const thresholdQuota = `CASE WHEN t.threshold_type = 'absolute' THEN t.value
+	ELSE lp.quota_purchased * t.value / 100 END`
+
+// ListThresholds returns a user's alert thresholds, oldest first
+func (s *Service) ListThresholds(ctx context.Context, userID uuid.UUID) ([]models.QuotaAlertThreshold, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT id, user_id, threshold_type, value, notify_email, fired_at, created_at
+		FROM quota_alert_thresholds
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list thresholds: %w", err)
+	}
+	defer rows.Close()
+
+	thresholds := []models.QuotaAlertThreshold{}
+	for rows.Next() {
+		var t models.QuotaAlertThreshold
+		if err := rows.Scan(&t.ID, &t.UserID, &t.ThresholdType, &t.Value, &t.NotifyEmail, &t.FiredAt, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan threshold: %w", err)
+		}
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, rows.Err()
+}
+
+// CreateThreshold adds an armed alert threshold. A threshold the balance is
+// already below fires on the next call that uses quota.
+func (s *Service) CreateThreshold(ctx context.Context, userID uuid.UUID, req *CreateThresholdRequest) (*models.QuotaAlertThreshold, error) {
+	switch req.ThresholdType {
+	case models.QuotaAlertAbsolute:
+	case models.QuotaAlertPercentage:
+		if req.Value > 100 {
+			return nil, ErrInvalidThreshold
+		}
+	default:
+		return nil, ErrInvalidThresholdType
+	}
+	if req.Value < 1 {
+		return nil, ErrInvalidThreshold
+	}
+	notifyEmail := true
+	if req.NotifyEmail != nil {
+		notifyEmail = *req.NotifyEmail
+	}
+
+	var count int
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM quota_alert_thresholds WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to count thresholds: %w", err)
+	}
+	if count >= MaxThresholdsPerUser {
+		return nil, ErrTooManyThresholds
+	}
+
+	t := models.QuotaAlertThreshold{
+		UserID:        userID,
+		ThresholdType: req.ThresholdType,
+		Value:         req.Value,
+		NotifyEmail:   notifyEmail,
+	}
+	err := s.db.QueryRow(ctx, `
+		INSERT INTO quota_alert_thresholds (user_id, threshold_type, value, notify_email)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, userID, req.ThresholdType, req.Value, notifyEmail).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create threshold: %w", err)
+	}
+	return &t, nil
+}
+
+// DeleteThreshold removes one of a user's alert thresholds
+func (s *Service) DeleteThreshold(ctx context.Context, userID, thresholdID uuid.UUID) error {
+	result, err := s.db.Exec(ctx, `
+		DELETE FROM quota_alert_thresholds WHERE id = $1 AND user_id = $2
+	`, thresholdID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete threshold: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrThresholdNotFound
+	}
+	return nil
+}
+
+// ListAlerts returns a user's most recently fired alerts, newest first
+func (s *Service) ListAlerts(ctx context.Context, userID uuid.UUID, limit int) ([]models.QuotaAlert, error) {
+	if limit < 1 || limit > 100 {
+		limit = 50
+	}
+	rows, err := s.db.Query(ctx, `
+		SELECT id, user_id, threshold_id, event_type, balance, threshold_quota, emailed_at, created_at
+		FROM quota_alerts
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list alerts: %w", err)
+	}
+	defer rows.Close()
+
+	alerts := []models.QuotaAlert{}
+	for rows.Next() {
+		var a models.QuotaAlert
+		err := rows.Scan(&a.ID, &a.UserID, &a.ThresholdID, &a.EventType, &a.Balance, &a.ThresholdQuota, &a.EmailedAt, &a.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan alert: %w", err)
+		}
+		alerts = append(alerts, a)
+	}
+	return alerts, rows.Err()
+}
+
+// Evaluate fires the user's armed thresholds that the balance has dropped to,
+// and quota.exhausted once the balance reaches zero. Each fired alert is
+// logged, queued for email if requested and enqueued as a webhook event.
+// Call it in the transaction that reduced the balance.
+func Evaluate(ctx context.Context, q Querier, userID uuid.UUID) error {
+	rows, err := q.Query(ctx, balanceCTEs+`
+		UPDATE quota_alert_thresholds t SET fired_at = NOW()
+		FROM b LEFT JOIN lp ON TRUE
+		WHERE t.user_id = $1 AND t.fired_at IS NULL AND b.balance <= `+thresholdQuota+`
+		RETURNING t.id, t.threshold_type, t.value, t.notify_email, `+thresholdQuota+`, b.balance
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate quota alerts: %w", err)
+	}
+	type firedThreshold struct {
+		models.QuotaAlertThreshold
+		quota   int64
+		balance int64
+	}
+	var fired []firedThreshold
+	for rows.Next() {
+		var f firedThreshold
+		if err := rows.Scan(&f.ID, &f.ThresholdType, &f.Value, &f.NotifyEmail, &f.quota, &f.balance); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan fired threshold: %w", err)
+		}
+		fired = append(fired, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to evaluate quota alerts: %w", err)
+	}
+
+	for _, f := range fired {
+		err := fire(ctx, q, userID, models.WebhookEventQuotaLow, &f.ID, f.balance, &f.quota, f.NotifyEmail, map[string]any{
+			"threshold_id":    f.ID,
+			"threshold_type":  f.ThresholdType,
+			"threshold_value": f.Value,
+			"threshold_quota": f.quota,
+			"balance":         f.balance,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var balance int64
+	err = q.QueryRow(ctx, `
+		UPDATE quotas SET exhausted_alerted_at = NOW()
+		WHERE user_id = $1 AND exhausted_alerted_at IS NULL AND total_quota + free_quota - used_quota <= 0
+		RETURNING total_quota + free_quota - used_quota
+	`, userID).Scan(&balance)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to evaluate quota exhaustion: %w", err)
+	}
+	return fire(ctx, q, userID, models.WebhookEventQuotaExhausted, nil, balance, nil, true, map[string]any{
+		"balance": balance,
+	})
+}
+
+// Rearm re-arms the user's fired thresholds that the balance is above again,
+// and quota.exhausted once the balance is positive. Call it in the
+// transaction of a top-up.
+func Rearm(ctx context.Context, q Querier, userID uuid.UUID) error {
+	_, err := q.Exec(ctx, balanceCTEs+`
+		UPDATE quota_alert_thresholds t SET fired_at = NULL
+		FROM b LEFT JOIN lp ON TRUE
+		WHERE t.user_id = $1 AND t.fired_at IS NOT NULL AND b.balance > `+thresholdQuota+`
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to rearm quota alerts: %w", err)
+	}
+
+	_, err = q.Exec(ctx, `
+		UPDATE quotas SET exhausted_alerted_at = NULL
+		WHERE user_id = $1 AND exhausted_alerted_at IS NOT NULL AND total_quota + free_quota - used_quota > 0
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to rearm quota exhaustion alert: %w", err)
+	}
+	return nil
+}
+
+// fire logs an alert and enqueues its webhook event
+func fire(ctx context.Context, q Querier, userID uuid.UUID, event models.WebhookEvent, thresholdID *uuid.UUID,
+	balance int64, quota *int64, notifyEmail bool, data map[string]any) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO quota_alerts (user_id, threshold_id, event_type, balance, threshold_quota, email_pending)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, userID, thresholdID, event, balance, quota, notifyEmail)
+	if err != nil {
+		return fmt.Errorf("failed to record quota alert: %w", err)
+	}
+	return webhook.Enqueue(ctx, q, userID, event, data)
+}
+
+// RunMailer sends pending alert emails every interval until the context is
+// cancelled. Does nothing if sending is disabled or no SMTP server is configured.
+func (s *Service) RunMailer(ctx context.Context) {
+	if s.config.AlertEmailInterval <= 0 || !s.mailer.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.AlertEmailInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.SendPendingEmails(ctx); err != nil {
+				log.Error().Err(err).Msg("Failed to send quota alert emails")
+			}
+		}
+	}
+}
+
+// SendPendingEmails emails a batch of pending alerts, oldest first. Sending
+// stops at the first failure; unsent alerts are retried on the next call.
+// Returns how many were sent.
+func (s *Service) SendPendingEmails(ctx context.Context) (int, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT a.id, a.event_type, a.balance, a.threshold_quota, u.email
+		FROM quota_alerts a
+		JOIN users u ON u.id = a.user_id
+		WHERE a.email_pending = TRUE
+		ORDER BY a.created_at
+		LIMIT $1
+		FOR UPDATE OF a SKIP LOCKED
+	`, emailBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query pending alerts: %w", err)
+	}
+	type pendingEmail struct {
+		alert models.QuotaAlert
+		to    string
+	}
+	var pending []pendingEmail
+	for rows.Next() {
+		var p pendingEmail
+		if err := rows.Scan(&p.alert.ID, &p.alert.EventType, &p.alert.Balance, &p.alert.ThresholdQuota, &p.to); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan pending alert: %w", err)
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query pending alerts: %w", err)
+	}
+
+	sent := 0
+	var sendErr error
+	for _, p := range pending {
+		subject, body := alertEmail(&p.alert)
+		err := s.mailer.Send(p.to, subject, body)
+		if err != nil {
+			if !errors.Is(err, email.ErrInvalidRecipient) {
+				sendErr = err
+				break
+			}
+			// An unusable address will never succeed; drop it rather than retry
+			log.Warn().Err(err).Str("alert_id", p.alert.ID.String()).Msg("Dropping quota alert email")
+		} else {
+			sent++
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE quota_alerts SET email_pending = FALSE, emailed_at = CASE WHEN $2 THEN NOW() END WHERE id = $1
+		`, p.alert.ID, err == nil)
+		if err != nil {
+			return sent, fmt.Errorf("failed to mark alert emailed: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return sent, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return sent, sendErr
+}
+
+// alertEmail returns the subject and body of an alert's email
+func alertEmail(a *models.QuotaAlert) (string, string) {
+	if a.EventType == models.WebhookEventQuotaExhausted {
+		return "Your AgentLink quota is used up",
+			"Your AgentLink quota has run out, so API calls will be rejected until you purchase more quota.\n"
+	}
+	threshold := int64(0)
+	if a.ThresholdQuota != nil {
+		threshold = *a.ThresholdQuota
+	}
+	return "Your AgentLink quota is running low",
+		fmt.Sprintf("Your remaining AgentLink quota is %d calls, at or below your alert threshold of %d calls.\n\n"+
+			"Purchase more quota to avoid failed calls.\n", a.Balance, threshold)
+}
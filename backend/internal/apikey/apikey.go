@@ -7,6 +7,9 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/netip"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/aimerfeng/AgentLink/internal/models"
@@ -17,19 +20,67 @@ import (
 
 // Service errors
 var (
-	ErrAPIKeyNotFound   = errors.New("API key not found")
-	ErrAPIKeyRevoked    = errors.New("API key has been revoked")
-	ErrAPIKeyNotOwned   = errors.New("API key does not belong to user")
-	ErrInvalidAPIKey    = errors.New("invalid API key format")
-	ErrMaxKeysReached   = errors.New("maximum number of API keys reached")
+	ErrAPIKeyNotFound     = errors.New("API key not found")
+	ErrAPIKeyRevoked      = errors.New("API key has been revoked")
+	ErrAPIKeyNotOwned     = errors.New("API key does not belong to user")
+	ErrInvalidAPIKey      = errors.New("invalid API key format")
+	ErrMaxKeysReached     = errors.New("maximum number of API keys reached")
+	ErrInvalidPermission  = errors.New("invalid API key permission")
+	ErrInvalidAgentScope  = errors.New("allowed agent not found")
+	ErrAPIKeyExpired      = errors.New("API key has expired")
+	ErrInvalidExpiry      = errors.New("API key expiry must be in the future")
+	ErrAlreadyRotated     = errors.New("API key has already been rotated")
+	ErrExpiryLocked       = errors.New("expiry of a rotated or expired API key cannot be changed")
+	ErrInvalidGracePeriod = errors.New("invalid rotation grace period")
+	ErrInvalidCIDR        = errors.New("invalid CIDR in allowlist")
+	ErrInvalidOrigin      = errors.New("invalid origin in allowlist")
+	ErrInvalidKeyLimits   = errors.New("invalid API key limits")
+	ErrInvalidCapture     = errors.New("invalid payload capture settings")
+	ErrAccountSuspended   = errors.New("API key owner account is suspended")
+	ErrAccountBanned      = errors.New("API key owner account is banned")
 )
 
+// DefaultPermissions returns the permissions granted to a key created without explicit permissions
+func DefaultPermissions() map[string]bool {
+	return map[string]bool{
+		models.PermissionRead:     true,
+		models.PermissionWrite:    true,
+		models.PermissionChat:     true,
+		models.PermissionStream:   true,
+		models.PermissionBatch:    true,
+		models.PermissionSessions: true,
+	}
+}
+
+// validPermissions lists the permission flags a key may carry (default keys carry all of them)
+var validPermissions = DefaultPermissions()
+
 // MaxAPIKeysPerUser is the maximum number of API keys a user can have
 const MaxAPIKeysPerUser = 10
 
+// Rotation grace period bounds: the old key stays valid this long after rotation
+const (
+	DefaultRotationGracePeriod = 24 * time.Hour
+	MaxRotationGracePeriod     = 7 * 24 * time.Hour
+)
+
+// Per-key limit bounds
+const (
+	MaxKeyRateLimitRPM    = 10000
+	MaxKeyConcurrency     = 1000
+	MaxKeyTokensPerMinute = 100000000
+)
+
+// Payload capture retention bounds
+const (
+	DefaultCaptureTTLHours = 24
+	MaxCaptureTTLHours     = 30 * 24
+)
+
 // Service handles API key operations
 type Service struct {
-	db *pgxpool.Pool
+	db    *pgxpool.Pool
+	usage usageBatcher
 }
 
 // NewService creates a new API key service
@@ -39,30 +90,75 @@ func NewService(db *pgxpool.Pool) *Service {
 
 // CreateAPIKeyRequest represents a request to create an API key
 type CreateAPIKeyRequest struct {
-	Name        string          `json:"name"`
-	Permissions map[string]bool `json:"permissions,omitempty"`
+	Name            string                `json:"name"`
+	Permissions     map[string]bool       `json:"permissions,omitempty"`
+	AllowedAgentIDs []uuid.UUID           `json:"allowed_agent_ids,omitempty"` // Empty allows all agents
+	AllowedCIDRs    []string              `json:"allowed_cidrs,omitempty"`     // IPs or CIDRs; empty allows all
+	AllowedOrigins  []string              `json:"allowed_origins,omitempty"`   // e.g. https://app.example.com; empty allows all
+	Limits          *models.APIKeyLimits  `json:"limits,omitempty"`
+	Capture         *models.APIKeyCapture `json:"capture,omitempty"`    // Off unless enabled
+	ExpiresAt       *time.Time            `json:"expires_at,omitempty"` // Nil never expires
+}
+
+// UpdateAPIKeyRequest represents a request to update an API key's name or scopes
+type UpdateAPIKeyRequest struct {
+	Name            *string               `json:"name,omitempty"`
+	Permissions     map[string]bool       `json:"permissions,omitempty"`
+	AllowedAgentIDs *[]uuid.UUID          `json:"allowed_agent_ids,omitempty"` // Empty list clears the allowlist
+	AllowedCIDRs    *[]string             `json:"allowed_cidrs,omitempty"`     // Empty list clears the allowlist
+	AllowedOrigins  *[]string             `json:"allowed_origins,omitempty"`   // Empty list clears the allowlist
+	Limits          *models.APIKeyLimits  `json:"limits,omitempty"`            // Replaces all limits; omitted fields are cleared
+	Capture         *models.APIKeyCapture `json:"capture,omitempty"`           // Replaces the capture settings
+	ExpiresAt       *time.Time            `json:"expires_at,omitempty"`
+}
+
+// RotateAPIKeyRequest represents a request to rotate an API key
+type RotateAPIKeyRequest struct {
+	GracePeriodSeconds *int       `json:"grace_period_seconds,omitempty"` // Defaults to 24 hours, max 7 days
+	ExpiresAt          *time.Time `json:"expires_at,omitempty"`           // Expiry of the new key; defaults to the old key's
+}
+
+// RotateAPIKeyResponse represents the response when rotating an API key
+type RotateAPIKeyResponse struct {
+	NewKey          CreateAPIKeyResponse `json:"new_key"`
+	OldKeyID        uuid.UUID            `json:"old_key_id"`
+	OldKeyExpiresAt time.Time            `json:"old_key_expires_at"`
 }
 
 // CreateAPIKeyResponse represents the response when creating an API key
 // The raw key is only returned once at creation time
 type CreateAPIKeyResponse struct {
-	ID          uuid.UUID       `json:"id"`
-	Key         string          `json:"key"` // Only returned at creation
-	KeyPrefix   string          `json:"key_prefix"`
-	Name        *string         `json:"name,omitempty"`
-	Permissions map[string]bool `json:"permissions"`
-	CreatedAt   time.Time       `json:"created_at"`
+	ID              uuid.UUID            `json:"id"`
+	Key             string               `json:"key"` // Only returned at creation
+	KeyPrefix       string               `json:"key_prefix"`
+	Name            *string              `json:"name,omitempty"`
+	Permissions     map[string]bool      `json:"permissions"`
+	AllowedAgentIDs []uuid.UUID          `json:"allowed_agent_ids"`
+	AllowedCIDRs    []string             `json:"allowed_cidrs"`
+	AllowedOrigins  []string             `json:"allowed_origins"`
+	Limits          models.APIKeyLimits  `json:"limits"`
+	Capture         models.APIKeyCapture `json:"capture"`
+	ExpiresAt       *time.Time           `json:"expires_at,omitempty"`
+	CreatedAt       time.Time            `json:"created_at"`
 }
 
 // APIKeyResponse represents an API key in list/get responses (without the raw key)
 type APIKeyResponse struct {
-	ID          uuid.UUID       `json:"id"`
-	KeyPrefix   string          `json:"key_prefix"`
-	Name        *string         `json:"name,omitempty"`
-	Permissions map[string]bool `json:"permissions"`
-	LastUsedAt  *time.Time      `json:"last_used_at,omitempty"`
-	CreatedAt   time.Time       `json:"created_at"`
-	RevokedAt   *time.Time      `json:"revoked_at,omitempty"`
+	ID               uuid.UUID            `json:"id"`
+	KeyPrefix        string               `json:"key_prefix"`
+	Name             *string              `json:"name,omitempty"`
+	Permissions      map[string]bool      `json:"permissions"`
+	AllowedAgentIDs  []uuid.UUID          `json:"allowed_agent_ids"`
+	AllowedCIDRs     []string             `json:"allowed_cidrs"`
+	AllowedOrigins   []string             `json:"allowed_origins"`
+	Limits           models.APIKeyLimits  `json:"limits"`
+	Capture          models.APIKeyCapture `json:"capture"`
+	RecentRejections int64                `json:"recent_rejections"` // Requests rejected by IP/origin restrictions in the last 24 hours
+	LastUsedAt       *time.Time           `json:"last_used_at,omitempty"`
+	ExpiresAt        *time.Time           `json:"expires_at,omitempty"`
+	ReplacedBy       *uuid.UUID           `json:"replaced_by,omitempty"`
+	CreatedAt        time.Time            `json:"created_at"`
+	RevokedAt        *time.Time           `json:"revoked_at,omitempty"`
 }
 
 // ListAPIKeysResponse represents the response for listing API keys
@@ -73,19 +169,6 @@ type ListAPIKeysResponse struct {
 
 // Create creates a new API key for a user
 func (s *Service) Create(ctx context.Context, userID uuid.UUID, req *CreateAPIKeyRequest) (*CreateAPIKeyResponse, error) {
-	// Check if user has reached max keys
-	var count int
-	err := s.db.QueryRow(ctx, `
-		SELECT COUNT(*) FROM api_keys 
-		WHERE user_id = $1 AND revoked_at IS NULL
-	`, userID).Scan(&count)
-	if err != nil {
-		return nil, fmt.Errorf("failed to count API keys: %w", err)
-	}
-	if count >= MaxAPIKeysPerUser {
-		return nil, ErrMaxKeysReached
-	}
-
 	// Generate secure API key
 	rawKey, keyHash, keyPrefix, err := generateAPIKey()
 	if err != nil {
@@ -95,11 +178,39 @@ func (s *Service) Create(ctx context.Context, userID uuid.UUID, req *CreateAPIKe
 	// Set default permissions if not provided
 	permissions := req.Permissions
 	if permissions == nil {
-		permissions = map[string]bool{
-			"read":  true,
-			"write": true,
+		permissions = DefaultPermissions()
+	}
+	if err := ValidatePermissions(permissions); err != nil {
+		return nil, err
+	}
+	if err := s.validateAgentScope(ctx, req.AllowedAgentIDs); err != nil {
+		return nil, err
+	}
+	allowedCIDRs, err := NormalizeCIDRs(req.AllowedCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	allowedOrigins, err := NormalizeOrigins(req.AllowedOrigins)
+	if err != nil {
+		return nil, err
+	}
+	limits := models.APIKeyLimits{SpendCapUnit: models.SpendUnitQuota}
+	if req.Limits != nil {
+		limits = *req.Limits
+		if err := ValidateLimits(&limits); err != nil {
+			return nil, err
+		}
+	}
+	capture := models.APIKeyCapture{TTLHours: DefaultCaptureTTLHours}
+	if req.Capture != nil {
+		capture = *req.Capture
+		if err := ValidateCapture(&capture); err != nil {
+			return nil, err
 		}
 	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidExpiry
+	}
 
 	// Insert API key
 	var apiKey models.APIKey
@@ -108,32 +219,86 @@ func (s *Service) Create(ctx context.Context, userID uuid.UUID, req *CreateAPIKe
 		name = &req.Name
 	}
 
-	err = s.db.QueryRow(ctx, `
-		INSERT INTO api_keys (user_id, key_hash, key_prefix, name, permissions)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id, user_id, key_hash, key_prefix, name, permissions, last_used_at, created_at, revoked_at
-	`, userID, keyHash, keyPrefix, name, permissions).Scan(
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Check if user has reached max keys
+	if err := checkKeyLimit(ctx, tx, userID, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO api_keys (
+			user_id, key_hash, key_prefix, name, permissions,
+			allowed_agent_ids, allowed_cidrs, allowed_origins, expires_at,
+			rate_limit_rpm, max_concurrent_requests, tokens_per_minute, daily_spend_cap, monthly_spend_cap, spend_cap_unit,
+			capture_payloads, capture_ttl_hours
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING id, user_id, key_hash, key_prefix, name, permissions,
+			COALESCE(allowed_agent_ids, '{}'), COALESCE(allowed_cidrs, '{}'), COALESCE(allowed_origins, '{}'),
+			rate_limit_rpm, max_concurrent_requests, tokens_per_minute, daily_spend_cap, monthly_spend_cap, spend_cap_unit,
+			capture_payloads, capture_ttl_hours,
+			last_used_at, expires_at, created_at, revoked_at
+	`, userID, keyHash, keyPrefix, name, permissions,
+		req.AllowedAgentIDs, allowedCIDRs, allowedOrigins, req.ExpiresAt,
+		limits.RateLimitRPM, limits.MaxConcurrent, limits.TokensPerMinute, limits.DailySpendCap, limits.MonthlySpendCap, limits.SpendCapUnit,
+		capture.Enabled, capture.TTLHours,
+	).Scan(
 		&apiKey.ID, &apiKey.UserID, &apiKey.KeyHash, &apiKey.KeyPrefix,
-		&apiKey.Name, &apiKey.Permissions, &apiKey.LastUsedAt, &apiKey.CreatedAt, &apiKey.RevokedAt,
+		&apiKey.Name, &apiKey.Permissions,
+		&apiKey.AllowedAgentIDs, &apiKey.AllowedCIDRs, &apiKey.AllowedOrigins,
+		&apiKey.Limits.RateLimitRPM, &apiKey.Limits.MaxConcurrent, &apiKey.Limits.TokensPerMinute, &apiKey.Limits.DailySpendCap, &apiKey.Limits.MonthlySpendCap, &apiKey.Limits.SpendCapUnit,
+		&apiKey.Capture.Enabled, &apiKey.Capture.TTLHours,
+		&apiKey.LastUsedAt, &apiKey.ExpiresAt, &apiKey.CreatedAt, &apiKey.RevokedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create API key: %w", err)
 	}
 
+	if err := insertAuditLog(ctx, tx, apiKey.ID, userID, models.APIKeyAuditCreated, nil, nil); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return toCreateResponse(&apiKey, rawKey), nil
+}
+
+// toCreateResponse builds the one-time response carrying the raw key
+func toCreateResponse(apiKey *models.APIKey, rawKey string) *CreateAPIKeyResponse {
 	return &CreateAPIKeyResponse{
-		ID:          apiKey.ID,
-		Key:         rawKey,
-		KeyPrefix:   apiKey.KeyPrefix,
-		Name:        apiKey.Name,
-		Permissions: apiKey.Permissions,
-		CreatedAt:   apiKey.CreatedAt,
-	}, nil
+		ID:              apiKey.ID,
+		Key:             rawKey,
+		KeyPrefix:       apiKey.KeyPrefix,
+		Name:            apiKey.Name,
+		Permissions:     apiKey.Permissions,
+		AllowedAgentIDs: apiKey.AllowedAgentIDs,
+		AllowedCIDRs:    apiKey.AllowedCIDRs,
+		AllowedOrigins:  apiKey.AllowedOrigins,
+		Limits:          apiKey.Limits,
+		Capture:         apiKey.Capture,
+		ExpiresAt:       apiKey.ExpiresAt,
+		CreatedAt:       apiKey.CreatedAt,
+	}
 }
 
 // List returns all API keys for a user
 func (s *Service) List(ctx context.Context, userID uuid.UUID) (*ListAPIKeysResponse, error) {
 	rows, err := s.db.Query(ctx, `
-		SELECT id, key_prefix, name, permissions, last_used_at, created_at, revoked_at
+		SELECT id, key_prefix, name, permissions,
+			COALESCE(allowed_agent_ids, '{}'), COALESCE(allowed_cidrs, '{}'), COALESCE(allowed_origins, '{}'),
+			rate_limit_rpm, max_concurrent_requests, tokens_per_minute, daily_spend_cap, monthly_spend_cap, spend_cap_unit,
+			capture_payloads, capture_ttl_hours,
+			(
+				SELECT COALESCE(SUM(r.count), 0) FROM api_key_rejections r
+				WHERE r.api_key_id = api_keys.id AND r.bucket_start >= NOW() - INTERVAL '24 hours'
+			),
+			last_used_at, expires_at, replaced_by, created_at, revoked_at
 		FROM api_keys
 		WHERE user_id = $1
 		ORDER BY created_at DESC
@@ -148,7 +313,11 @@ func (s *Service) List(ctx context.Context, userID uuid.UUID) (*ListAPIKeysRespo
 		var key APIKeyResponse
 		err := rows.Scan(
 			&key.ID, &key.KeyPrefix, &key.Name, &key.Permissions,
-			&key.LastUsedAt, &key.CreatedAt, &key.RevokedAt,
+			&key.AllowedAgentIDs, &key.AllowedCIDRs, &key.AllowedOrigins,
+			&key.Limits.RateLimitRPM, &key.Limits.MaxConcurrent, &key.Limits.TokensPerMinute, &key.Limits.DailySpendCap, &key.Limits.MonthlySpendCap, &key.Limits.SpendCapUnit,
+			&key.Capture.Enabled, &key.Capture.TTLHours,
+			&key.RecentRejections,
+			&key.LastUsedAt, &key.ExpiresAt, &key.ReplacedBy, &key.CreatedAt, &key.RevokedAt,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan API key: %w", err)
@@ -166,6 +335,187 @@ func (s *Service) List(ctx context.Context, userID uuid.UUID) (*ListAPIKeysRespo
 	}, nil
 }
 
+// Update changes an API key's name, permissions or agent allowlist
+func (s *Service) Update(ctx context.Context, keyID uuid.UUID, userID uuid.UUID, req *UpdateAPIKeyRequest) (*APIKeyResponse, error) {
+	// Check if key exists and belongs to user
+	var ownerID uuid.UUID
+	var revokedAt *time.Time
+	err := s.db.QueryRow(ctx, `
+		SELECT user_id, revoked_at FROM api_keys WHERE id = $1
+	`, keyID).Scan(&ownerID, &revokedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	// Check ownership
+	if ownerID != userID {
+		return nil, ErrAPIKeyNotOwned
+	}
+
+	// Revoked keys cannot be modified
+	if revokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+
+	if req.Permissions != nil {
+		if err := ValidatePermissions(req.Permissions); err != nil {
+			return nil, err
+		}
+	}
+
+	// A nil allowlist leaves the scope unchanged, an empty one clears it
+	updateAgents := req.AllowedAgentIDs != nil
+	var allowedAgentIDs []uuid.UUID
+	if updateAgents {
+		allowedAgentIDs = *req.AllowedAgentIDs
+		if err := s.validateAgentScope(ctx, allowedAgentIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	// As with agents, nil leaves the network allowlists unchanged and empty clears them
+	var allowedCIDRs, allowedOrigins []string
+	if req.AllowedCIDRs != nil {
+		if allowedCIDRs, err = NormalizeCIDRs(*req.AllowedCIDRs); err != nil {
+			return nil, err
+		}
+		if allowedCIDRs == nil {
+			allowedCIDRs = []string{}
+		}
+	}
+	if req.AllowedOrigins != nil {
+		if allowedOrigins, err = NormalizeOrigins(*req.AllowedOrigins); err != nil {
+			return nil, err
+		}
+		if allowedOrigins == nil {
+			allowedOrigins = []string{}
+		}
+	}
+
+	// Limits are replaced as a whole so a field left out of the request is cleared
+	updateLimits := req.Limits != nil
+	limits := models.APIKeyLimits{SpendCapUnit: models.SpendUnitQuota}
+	if updateLimits {
+		limits = *req.Limits
+		if err := ValidateLimits(&limits); err != nil {
+			return nil, err
+		}
+	}
+
+	// Capture settings are also replaced as a whole
+	updateCapture := req.Capture != nil
+	capture := models.APIKeyCapture{TTLHours: DefaultCaptureTTLHours}
+	if updateCapture {
+		capture = *req.Capture
+		if err := ValidateCapture(&capture); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidExpiry
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Rotated and expired keys keep their expiry, so a grace period cannot be extended
+	if req.ExpiresAt != nil {
+		var expiresAt *time.Time
+		var replacedBy *uuid.UUID
+		err := tx.QueryRow(ctx, `
+			SELECT expires_at, replaced_by FROM api_keys WHERE id = $1 FOR UPDATE
+		`, keyID).Scan(&expiresAt, &replacedBy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get API key: %w", err)
+		}
+		if replacedBy != nil || (expiresAt != nil && !expiresAt.After(time.Now())) {
+			return nil, ErrExpiryLocked
+		}
+	}
+
+	var key APIKeyResponse
+	err = tx.QueryRow(ctx, `
+		UPDATE api_keys SET
+			name = COALESCE($2, name),
+			permissions = COALESCE($3, permissions),
+			allowed_agent_ids = CASE WHEN $4 THEN $5 ELSE allowed_agent_ids END,
+			allowed_cidrs = COALESCE($6, allowed_cidrs),
+			allowed_origins = COALESCE($7, allowed_origins),
+			expires_at = COALESCE($8, expires_at),
+			rate_limit_rpm = CASE WHEN $9 THEN $10 ELSE rate_limit_rpm END,
+			max_concurrent_requests = CASE WHEN $9 THEN $11 ELSE max_concurrent_requests END,
+			tokens_per_minute = CASE WHEN $9 THEN $12 ELSE tokens_per_minute END,
+			daily_spend_cap = CASE WHEN $9 THEN $13 ELSE daily_spend_cap END,
+			monthly_spend_cap = CASE WHEN $9 THEN $14 ELSE monthly_spend_cap END,
+			spend_cap_unit = CASE WHEN $9 THEN $15 ELSE spend_cap_unit END,
+			capture_payloads = CASE WHEN $16 THEN $17 ELSE capture_payloads END,
+			capture_ttl_hours = CASE WHEN $16 THEN $18 ELSE capture_ttl_hours END
+		WHERE id = $1
+		RETURNING id, key_prefix, name, permissions,
+			COALESCE(allowed_agent_ids, '{}'), COALESCE(allowed_cidrs, '{}'), COALESCE(allowed_origins, '{}'),
+			rate_limit_rpm, max_concurrent_requests, tokens_per_minute, daily_spend_cap, monthly_spend_cap, spend_cap_unit,
+			capture_payloads, capture_ttl_hours,
+			last_used_at, expires_at, replaced_by, created_at, revoked_at
+	`, keyID, req.Name, req.Permissions, updateAgents, allowedAgentIDs,
+		allowedCIDRs, allowedOrigins, req.ExpiresAt, updateLimits,
+		limits.RateLimitRPM, limits.MaxConcurrent, limits.TokensPerMinute, limits.DailySpendCap, limits.MonthlySpendCap, limits.SpendCapUnit,
+		updateCapture, capture.Enabled, capture.TTLHours,
+	).Scan(
+		&key.ID, &key.KeyPrefix, &key.Name, &key.Permissions,
+		&key.AllowedAgentIDs, &key.AllowedCIDRs, &key.AllowedOrigins,
+		&key.Limits.RateLimitRPM, &key.Limits.MaxConcurrent, &key.Limits.TokensPerMinute, &key.Limits.DailySpendCap, &key.Limits.MonthlySpendCap, &key.Limits.SpendCapUnit,
+		&key.Capture.Enabled, &key.Capture.TTLHours,
+		&key.LastUsedAt, &key.ExpiresAt, &key.ReplacedBy, &key.CreatedAt, &key.RevokedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update API key: %w", err)
+	}
+
+	// Record which fields changed
+	changed := []string{}
+	if req.Name != nil {
+		changed = append(changed, "name")
+	}
+	if req.Permissions != nil {
+		changed = append(changed, "permissions")
+	}
+	if updateAgents {
+		changed = append(changed, "allowed_agent_ids")
+	}
+	if req.AllowedCIDRs != nil {
+		changed = append(changed, "allowed_cidrs")
+	}
+	if req.AllowedOrigins != nil {
+		changed = append(changed, "allowed_origins")
+	}
+	if updateLimits {
+		changed = append(changed, "limits")
+	}
+	if updateCapture {
+		changed = append(changed, "capture")
+	}
+	if req.ExpiresAt != nil {
+		changed = append(changed, "expires_at")
+	}
+	details := map[string]interface{}{"fields": changed}
+	if err := insertAuditLog(ctx, tx, keyID, userID, models.APIKeyAuditUpdated, nil, details); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &key, nil
+}
+
 // Delete revokes an API key (soft delete)
 func (s *Service) Delete(ctx context.Context, keyID uuid.UUID, userID uuid.UUID) error {
 	// Check if key exists and belongs to user
@@ -191,8 +541,14 @@ func (s *Service) Delete(ctx context.Context, keyID uuid.UUID, userID uuid.UUID)
 		return ErrAPIKeyRevoked
 	}
 
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
 	// Revoke the key
-	_, err = s.db.Exec(ctx, `
+	_, err = tx.Exec(ctx, `
 		UPDATE api_keys 
 		SET revoked_at = NOW()
 		WHERE id = $1
@@ -201,50 +557,392 @@ func (s *Service) Delete(ctx context.Context, keyID uuid.UUID, userID uuid.UUID)
 		return fmt.Errorf("failed to revoke API key: %w", err)
 	}
 
+	if err := insertAuditLog(ctx, tx, keyID, userID, models.APIKeyAuditRevoked, nil, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Rotate issues a replacement for an API key. The new key inherits the old
+// key's name and scopes; the old key keeps working until the grace period ends.
+func (s *Service) Rotate(ctx context.Context, keyID uuid.UUID, userID uuid.UUID, req *RotateAPIKeyRequest) (*RotateAPIKeyResponse, error) {
+	gracePeriod := DefaultRotationGracePeriod
+	if req.GracePeriodSeconds != nil {
+		gracePeriod = time.Duration(*req.GracePeriodSeconds) * time.Second
+		if gracePeriod < 0 || gracePeriod > MaxRotationGracePeriod {
+			return nil, ErrInvalidGracePeriod
+		}
+	}
+	now := time.Now()
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+		return nil, ErrInvalidExpiry
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the old key so concurrent rotations cannot both succeed
+	var old models.APIKey
+	err = tx.QueryRow(ctx, `
+		SELECT id, user_id, name, permissions,
+			COALESCE(allowed_agent_ids, '{}'), COALESCE(allowed_cidrs, '{}'), COALESCE(allowed_origins, '{}'),
+			rate_limit_rpm, max_concurrent_requests, tokens_per_minute, daily_spend_cap, monthly_spend_cap, spend_cap_unit,
+			capture_payloads, capture_ttl_hours,
+			expires_at, replaced_by, revoked_at
+		FROM api_keys WHERE id = $1
+		FOR UPDATE
+	`, keyID).Scan(
+		&old.ID, &old.UserID, &old.Name, &old.Permissions,
+		&old.AllowedAgentIDs, &old.AllowedCIDRs, &old.AllowedOrigins,
+		&old.Limits.RateLimitRPM, &old.Limits.MaxConcurrent, &old.Limits.TokensPerMinute, &old.Limits.DailySpendCap, &old.Limits.MonthlySpendCap, &old.Limits.SpendCapUnit,
+		&old.Capture.Enabled, &old.Capture.TTLHours,
+		&old.ExpiresAt, &old.ReplacedBy, &old.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	if old.UserID != userID {
+		return nil, ErrAPIKeyNotOwned
+	}
+	if old.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+	if old.ReplacedBy != nil {
+		return nil, ErrAlreadyRotated
+	}
+	if old.IsExpired(now) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	// The old key stops counting once replaced, so only the new key is added
+	if err := checkKeyLimit(ctx, tx, userID, keyID); err != nil {
+		return nil, err
+	}
+
+	// New key inherits the old key's expiry unless one is given
+	newExpiresAt := old.ExpiresAt
+	if req.ExpiresAt != nil {
+		newExpiresAt = req.ExpiresAt
+	}
+
+	// Old key expires at the end of the grace period, or earlier if it already would
+	oldExpiresAt := now.Add(gracePeriod)
+	if old.ExpiresAt != nil && old.ExpiresAt.Before(oldExpiresAt) {
+		oldExpiresAt = *old.ExpiresAt
+	}
+
+	rawKey, keyHash, keyPrefix, err := generateAPIKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+
+	var newKey models.APIKey
+	err = tx.QueryRow(ctx, `
+		INSERT INTO api_keys (
+			user_id, key_hash, key_prefix, name, permissions,
+			allowed_agent_ids, allowed_cidrs, allowed_origins, expires_at,
+			rate_limit_rpm, max_concurrent_requests, tokens_per_minute, daily_spend_cap, monthly_spend_cap, spend_cap_unit,
+			capture_payloads, capture_ttl_hours
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING id, user_id, key_hash, key_prefix, name, permissions,
+			COALESCE(allowed_agent_ids, '{}'), COALESCE(allowed_cidrs, '{}'), COALESCE(allowed_origins, '{}'),
+			rate_limit_rpm, max_concurrent_requests, tokens_per_minute, daily_spend_cap, monthly_spend_cap, spend_cap_unit,
+			capture_payloads, capture_ttl_hours,
+			last_used_at, expires_at, created_at, revoked_at
+	`, userID, keyHash, keyPrefix, old.Name, old.Permissions,
+		old.AllowedAgentIDs, old.AllowedCIDRs, old.AllowedOrigins, newExpiresAt,
+		old.Limits.RateLimitRPM, old.Limits.MaxConcurrent, old.Limits.TokensPerMinute, old.Limits.DailySpendCap, old.Limits.MonthlySpendCap, old.Limits.SpendCapUnit,
+		old.Capture.Enabled, old.Capture.TTLHours,
+	).Scan(
+		&newKey.ID, &newKey.UserID, &newKey.KeyHash, &newKey.KeyPrefix,
+		&newKey.Name, &newKey.Permissions,
+		&newKey.AllowedAgentIDs, &newKey.AllowedCIDRs, &newKey.AllowedOrigins,
+		&newKey.Limits.RateLimitRPM, &newKey.Limits.MaxConcurrent, &newKey.Limits.TokensPerMinute, &newKey.Limits.DailySpendCap, &newKey.Limits.MonthlySpendCap, &newKey.Limits.SpendCapUnit,
+		&newKey.Capture.Enabled, &newKey.Capture.TTLHours,
+		&newKey.LastUsedAt, &newKey.ExpiresAt, &newKey.CreatedAt, &newKey.RevokedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rotated API key: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE api_keys SET replaced_by = $1, expires_at = $2 WHERE id = $3
+	`, newKey.ID, oldExpiresAt, keyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire rotated API key: %w", err)
+	}
+
+	// Audit both sides of the rotation
+	err = insertAuditLog(ctx, tx, keyID, userID, models.APIKeyAuditRotated, nil, map[string]interface{}{
+		"replaced_by": newKey.ID,
+		"expires_at":  oldExpiresAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = insertAuditLog(ctx, tx, newKey.ID, userID, models.APIKeyAuditCreated, nil, map[string]interface{}{
+		"rotated_from": keyID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &RotateAPIKeyResponse{
+		NewKey:          *toCreateResponse(&newKey, rawKey),
+		OldKeyID:        keyID,
+		OldKeyExpiresAt: oldExpiresAt,
+	}, nil
+}
+
+// checkKeyLimit returns ErrMaxKeysReached if a user already has
+// MaxAPIKeysPerUser live keys other than excludeID. Revoked, rotated and
+// expired keys do not count. The user row is locked so concurrent key
+// creations are counted one at a time.
+func checkKeyLimit(ctx context.Context, tx pgx.Tx, userID, excludeID uuid.UUID) error {
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM users WHERE id = $1 FOR UPDATE`, userID); err != nil {
+		return fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	var count int
+	err := tx.QueryRow(ctx, `
+		SELECT COUNT(*) FROM api_keys
+		WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL AND replaced_by IS NULL
+		  AND (expires_at IS NULL OR expires_at > NOW())
+	`, userID, excludeID).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to count API keys: %w", err)
+	}
+	if count >= MaxAPIKeysPerUser {
+		return ErrMaxKeysReached
+	}
 	return nil
 }
 
 // ValidateAPIKey validates an API key and returns the associated user ID
-// Returns ErrInvalidAPIKey if the key is invalid or revoked
+// Returns ErrInvalidAPIKey if the key is invalid, ErrAPIKeyRevoked if revoked,
+// ErrAPIKeyExpired if past its expiry and ErrAccountSuspended or
+// ErrAccountBanned if its owner may not use the platform
 func (s *Service) ValidateAPIKey(ctx context.Context, rawKey string) (*models.APIKey, error) {
 	// Validate key format
-	if len(rawKey) < 10 || rawKey[:3] != "ak_" {
+	if !ValidKeyFormat(rawKey) {
 		return nil, ErrInvalidAPIKey
 	}
 
-	// Hash the key
-	keyHash := hashAPIKey(rawKey)
+	apiKey, err := s.GetByHash(ctx, hashAPIKey(rawKey))
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckUsable(apiKey, time.Now()); err != nil {
+		return nil, err
+	}
 
-	// Look up the key
+	s.MarkUsed(apiKey.ID)
+	return apiKey, nil
+}
+
+// ValidKeyFormat reports whether a raw key has the ak_ format
+func ValidKeyFormat(rawKey string) bool {
+	return len(rawKey) >= 10 && rawKey[:3] == "ak_"
+}
+
+// GetByHash looks up a key by its hash, including revoked and expired keys,
+// along with its owner's account status.
+// Returns ErrInvalidAPIKey if no key has the hash.
+func (s *Service) GetByHash(ctx context.Context, keyHash string) (*models.APIKey, error) {
 	var apiKey models.APIKey
 	err := s.db.QueryRow(ctx, `
-		SELECT id, user_id, key_hash, key_prefix, name, permissions, last_used_at, created_at, revoked_at
-		FROM api_keys
-		WHERE key_hash = $1
+		SELECT k.id, k.user_id, k.key_hash, k.key_prefix, k.name, k.permissions,
+			COALESCE(k.allowed_agent_ids, '{}'), COALESCE(k.allowed_cidrs, '{}'), COALESCE(k.allowed_origins, '{}'),
+			k.rate_limit_rpm, k.max_concurrent_requests, k.tokens_per_minute, k.daily_spend_cap, k.monthly_spend_cap, k.spend_cap_unit,
+			k.capture_payloads, k.capture_ttl_hours,
+			k.last_used_at, k.expires_at, k.replaced_by, k.created_at, k.revoked_at,
+			u.status
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_hash = $1
 	`, keyHash).Scan(
 		&apiKey.ID, &apiKey.UserID, &apiKey.KeyHash, &apiKey.KeyPrefix,
-		&apiKey.Name, &apiKey.Permissions, &apiKey.LastUsedAt, &apiKey.CreatedAt, &apiKey.RevokedAt,
+		&apiKey.Name, &apiKey.Permissions,
+		&apiKey.AllowedAgentIDs, &apiKey.AllowedCIDRs, &apiKey.AllowedOrigins,
+		&apiKey.Limits.RateLimitRPM, &apiKey.Limits.MaxConcurrent, &apiKey.Limits.TokensPerMinute, &apiKey.Limits.DailySpendCap, &apiKey.Limits.MonthlySpendCap, &apiKey.Limits.SpendCapUnit,
+		&apiKey.Capture.Enabled, &apiKey.Capture.TTLHours,
+		&apiKey.LastUsedAt, &apiKey.ExpiresAt, &apiKey.ReplacedBy,
+		&apiKey.CreatedAt, &apiKey.RevokedAt,
+		&apiKey.OwnerStatus,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrInvalidAPIKey
 		}
-		return nil, fmt.Errorf("failed to validate API key: %w", err)
+		return nil, fmt.Errorf("failed to get API key: %w", err)
 	}
+	return &apiKey, nil
+}
 
-	// Check if revoked
+// CheckUsable returns ErrAPIKeyRevoked, ErrAPIKeyExpired, ErrAccountSuspended
+// or ErrAccountBanned if the key may not be used at the given time
+func CheckUsable(apiKey *models.APIKey, now time.Time) error {
 	if apiKey.RevokedAt != nil {
-		return nil, ErrAPIKeyRevoked
+		return ErrAPIKeyRevoked
+	}
+	// Includes rotated keys past their grace period
+	if apiKey.IsExpired(now) {
+		return ErrAPIKeyExpired
+	}
+	switch apiKey.OwnerStatus {
+	case models.AccountStatusSuspended:
+		return ErrAccountSuspended
+	case models.AccountStatusBanned:
+		return ErrAccountBanned
+	}
+	return nil
+}
+
+// ValidatePermissions checks that every permission flag is known
+func ValidatePermissions(permissions map[string]bool) error {
+	for name := range permissions {
+		if !validPermissions[name] {
+			return fmt.Errorf("%w: %s", ErrInvalidPermission, name)
+		}
 	}
+	return nil
+}
 
-	// Update last used timestamp (async, don't block on this)
-	go func() {
-		_, _ = s.db.Exec(context.Background(), `
-			UPDATE api_keys SET last_used_at = NOW() WHERE id = $1
-		`, apiKey.ID)
-	}()
+// ValidateLimits checks per-key limits are within bounds, defaulting the spend
+// cap unit to quota units
+func ValidateLimits(limits *models.APIKeyLimits) error {
+	if limits.SpendCapUnit == "" {
+		limits.SpendCapUnit = models.SpendUnitQuota
+	}
+	if limits.SpendCapUnit != models.SpendUnitQuota && limits.SpendCapUnit != models.SpendUnitUSD {
+		return fmt.Errorf("%w: spend_cap_unit must be quota or usd", ErrInvalidKeyLimits)
+	}
+	if limits.RateLimitRPM != nil && (*limits.RateLimitRPM < 1 || *limits.RateLimitRPM > MaxKeyRateLimitRPM) {
+		return fmt.Errorf("%w: rate_limit_rpm must be between 1 and %d", ErrInvalidKeyLimits, MaxKeyRateLimitRPM)
+	}
+	if limits.MaxConcurrent != nil && (*limits.MaxConcurrent < 1 || *limits.MaxConcurrent > MaxKeyConcurrency) {
+		return fmt.Errorf("%w: max_concurrent_requests must be between 1 and %d", ErrInvalidKeyLimits, MaxKeyConcurrency)
+	}
+	if limits.TokensPerMinute != nil && (*limits.TokensPerMinute < 1 || *limits.TokensPerMinute > MaxKeyTokensPerMinute) {
+		return fmt.Errorf("%w: tokens_per_minute must be between 1 and %d", ErrInvalidKeyLimits, MaxKeyTokensPerMinute)
+	}
+	if limits.DailySpendCap != nil && !limits.DailySpendCap.IsPositive() {
+		return fmt.Errorf("%w: daily_spend_cap must be positive", ErrInvalidKeyLimits)
+	}
+	if limits.MonthlySpendCap != nil && !limits.MonthlySpendCap.IsPositive() {
+		return fmt.Errorf("%w: monthly_spend_cap must be positive", ErrInvalidKeyLimits)
+	}
+	return nil
+}
 
-	return &apiKey, nil
+// ValidateCapture validates payload capture settings, defaulting the retention
+func ValidateCapture(capture *models.APIKeyCapture) error {
+	if capture.TTLHours == 0 {
+		capture.TTLHours = DefaultCaptureTTLHours
+	}
+	if capture.TTLHours < 1 || capture.TTLHours > MaxCaptureTTLHours {
+		return fmt.Errorf("%w: ttl_hours must be between 1 and %d", ErrInvalidCapture, MaxCaptureTTLHours)
+	}
+	return nil
+}
+
+// NormalizeCIDRs validates a CIDR allowlist, turning bare IPs into single-host
+// prefixes and masking host bits (10.0.0.7/8 becomes 10.0.0.0/8)
+func NormalizeCIDRs(cidrs []string) ([]string, error) {
+	if len(cidrs) == 0 {
+		return nil, nil
+	}
+	normalized := make([]string, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if !strings.Contains(cidr, "/") {
+			addr, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrInvalidCIDR, cidr)
+			}
+			normalized = append(normalized, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()).String())
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCIDR, cidr)
+		}
+		normalized = append(normalized, prefix.Masked().String())
+	}
+	return normalized, nil
+}
+
+// NormalizeOrigins validates an origin allowlist. Each entry must be a bare
+// http(s) origin without path, optionally with a leading wildcard subdomain.
+func NormalizeOrigins(origins []string) ([]string, error) {
+	if len(origins) == 0 {
+		return nil, nil
+	}
+	normalized := make([]string, 0, len(origins))
+	for _, origin := range origins {
+		origin = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(origin), "/"))
+		u, err := url.Parse(strings.Replace(origin, "://*.", "://wildcard.", 1))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidOrigin, origin)
+		}
+		normalized = append(normalized, origin)
+	}
+	return normalized, nil
+}
+
+// RecordRejection counts a request rejected by a key's network restrictions
+// in the current hourly bucket
+func (s *Service) RecordRejection(ctx context.Context, keyID uuid.UUID, reason string) error {
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO api_key_rejections (api_key_id, bucket_start, reason, count)
+		VALUES ($1, date_trunc('hour', NOW()), $2, 1)
+		ON CONFLICT (api_key_id, bucket_start, reason)
+		DO UPDATE SET count = api_key_rejections.count + 1
+	`, keyID, reason)
+	if err != nil {
+		return fmt.Errorf("failed to record key rejection: %w", err)
+	}
+	return nil
+}
+
+// validateAgentScope checks that every agent in an allowlist exists
+func (s *Service) validateAgentScope(ctx context.Context, agentIDs []uuid.UUID) error {
+	if len(agentIDs) == 0 {
+		return nil
+	}
+
+	var found int
+	err := s.db.QueryRow(ctx, `
+		SELECT COUNT(DISTINCT id) FROM agents WHERE id = ANY($1)
+	`, agentIDs).Scan(&found)
+	if err != nil {
+		return fmt.Errorf("failed to validate allowed agents: %w", err)
+	}
+
+	unique := make(map[uuid.UUID]struct{}, len(agentIDs))
+	for _, id := range agentIDs {
+		unique[id] = struct{}{}
+	}
+	if found != len(unique) {
+		return ErrInvalidAgentScope
+	}
+	return nil
 }
 
 // IsKeyRevoked checks if an API key is revoked by its hash
@@ -2,13 +2,16 @@ package apikey
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/aimerfeng/AgentLink/internal/models"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
 	"pgregory.net/rapid"
 )
 
@@ -303,6 +306,402 @@ func TestAPIKeyHashConsistency(t *testing.T) {
 	})
 }
 
+// TestKeyScopeEnforcement tests that a key scoped to specific agents can only
+// access those agents, and that an empty allowlist allows every agent
+func TestKeyScopeEnforcement(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		n := rapid.IntRange(0, 5).Draw(rt, "allowedCount")
+		allowed := make([]uuid.UUID, n)
+		for i := range allowed {
+			allowed[i] = uuid.New()
+		}
+		key := &models.APIKey{
+			Permissions:     map[string]bool{models.PermissionChat: true},
+			AllowedAgentIDs: allowed,
+		}
+
+		// Every allowlisted agent is accessible
+		for _, id := range allowed {
+			if !key.CanAccessAgent(id) {
+				t.Fatalf("Key should access allowlisted agent %s", id)
+			}
+		}
+
+		// An agent outside the allowlist is only accessible when the allowlist is empty
+		other := uuid.New()
+		if key.CanAccessAgent(other) != (n == 0) {
+			t.Fatalf("CanAccessAgent(%s) with %d allowed agents returned %v", other, n, key.CanAccessAgent(other))
+		}
+
+		// Permissions not granted are denied
+		if !key.HasPermission(models.PermissionChat) || key.HasPermission(models.PermissionStream) {
+			t.Fatalf("Unexpected permissions: %v", key.Permissions)
+		}
+	})
+
+	t.Run("ValidatePermissions", func(t *testing.T) {
+		if err := ValidatePermissions(DefaultPermissions()); err != nil {
+			t.Fatalf("Default permissions should be valid, got: %v", err)
+		}
+		if err := ValidatePermissions(map[string]bool{"admin": true}); !errors.Is(err, ErrInvalidPermission) {
+			t.Fatalf("Unknown permission should return ErrInvalidPermission, got: %v", err)
+		}
+	})
+}
+
+// TestKeyScopeUpdate tests that scope updates are persisted and visible on validation
+func TestKeyScopeUpdate(t *testing.T) {
+	if testDB == nil {
+		t.Skip("Test database not available")
+	}
+
+	ctx := context.Background()
+	service := NewService(testDB)
+
+	userID := createTestDeveloper(t, ctx)
+	defer cleanupTestUser(t, ctx, userID)
+
+	createResp, err := service.Create(ctx, userID, &CreateAPIKeyRequest{Name: "ci"})
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	// Restrict to chat only
+	_, err = service.Update(ctx, createResp.ID, userID, &UpdateAPIKeyRequest{
+		Permissions: map[string]bool{models.PermissionChat: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update API key: %v", err)
+	}
+
+	apiKey, err := service.ValidateAPIKey(ctx, createResp.Key)
+	if err != nil {
+		t.Fatalf("Failed to validate API key: %v", err)
+	}
+	if !apiKey.HasPermission(models.PermissionChat) || apiKey.HasPermission(models.PermissionStream) {
+		t.Fatalf("Updated permissions not applied: %v", apiKey.Permissions)
+	}
+
+	// Unknown agents cannot be allowlisted
+	unknown := []uuid.UUID{uuid.New()}
+	_, err = service.Update(ctx, createResp.ID, userID, &UpdateAPIKeyRequest{AllowedAgentIDs: &unknown})
+	if !errors.Is(err, ErrInvalidAgentScope) {
+		t.Fatalf("Expected ErrInvalidAgentScope, got: %v", err)
+	}
+
+	// Other users cannot update the key
+	_, err = service.Update(ctx, createResp.ID, uuid.New(), &UpdateAPIKeyRequest{})
+	if !errors.Is(err, ErrAPIKeyNotOwned) {
+		t.Fatalf("Expected ErrAPIKeyNotOwned, got: %v", err)
+	}
+}
+
+// TestAPIKeyExpiry tests that a key is expired exactly from its expiry time onwards
+// TestKeyNetworkRestrictions verifies CIDR and origin allowlist matching and validation
+func TestKeyNetworkRestrictions(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		a := rapid.IntRange(1, 254).Draw(rt, "a")
+		b := rapid.IntRange(0, 255).Draw(rt, "b")
+		host := rapid.IntRange(0, 255).Draw(rt, "host")
+
+		cidrs, err := NormalizeCIDRs([]string{fmt.Sprintf("%d.%d.0.0/16", a, b)})
+		if err != nil {
+			t.Fatalf("Valid CIDR rejected: %v", err)
+		}
+		key := &models.APIKey{AllowedCIDRs: cidrs}
+
+		// Addresses inside the /16 are allowed, including IPv4-mapped IPv6 forms
+		inside := fmt.Sprintf("%d.%d.7.%d", a, b, host)
+		if !key.AllowsIP(inside) || !key.AllowsIP("::ffff:"+inside) {
+			t.Fatalf("%s should be allowed by %v", inside, cidrs)
+		}
+		outside := fmt.Sprintf("%d.%d.7.%d", a, (b+1)%256, host)
+		if key.AllowsIP(outside) {
+			t.Fatalf("%s should not be allowed by %v", outside, cidrs)
+		}
+		if key.AllowsIP("not-an-ip") {
+			t.Fatal("Unparseable client IP should be rejected")
+		}
+
+		// Without an allowlist every IP is allowed
+		if !(&models.APIKey{}).AllowsIP(outside) {
+			t.Fatal("Empty CIDR allowlist should allow all IPs")
+		}
+	})
+
+	t.Run("NormalizeCIDRs", func(t *testing.T) {
+		got, err := NormalizeCIDRs([]string{"10.1.2.3", "10.1.2.3/8", "2001:db8::1"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		want := []string{"10.1.2.3/32", "10.0.0.0/8", "2001:db8::1/128"}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("NormalizeCIDRs()[%d] = %s, want %s", i, got[i], want[i])
+			}
+		}
+		for _, bad := range []string{"10.0.0.0/33", "example.com", ""} {
+			if _, err := NormalizeCIDRs([]string{bad}); !errors.Is(err, ErrInvalidCIDR) {
+				t.Fatalf("NormalizeCIDRs(%q) should return ErrInvalidCIDR, got: %v", bad, err)
+			}
+		}
+	})
+
+	t.Run("Origins", func(t *testing.T) {
+		origins, err := NormalizeOrigins([]string{"https://App.Example.com/", "https://*.example.org"})
+		if err != nil {
+			t.Fatalf("Valid origins rejected: %v", err)
+		}
+		key := &models.APIKey{AllowedOrigins: origins}
+
+		allowed := []string{"https://app.example.com", "https://APP.example.com", "https://a.b.example.org"}
+		denied := []string{"", "http://app.example.com", "https://example.org", "https://evilexample.org", "https://other.example.com"}
+		for _, origin := range allowed {
+			if !key.AllowsOrigin(origin) {
+				t.Fatalf("Origin %q should be allowed by %v", origin, origins)
+			}
+		}
+		for _, origin := range denied {
+			if key.AllowsOrigin(origin) {
+				t.Fatalf("Origin %q should be denied by %v", origin, origins)
+			}
+		}
+
+		for _, bad := range []string{"app.example.com", "ftp://example.com", "https://example.com/path", "https://"} {
+			if _, err := NormalizeOrigins([]string{bad}); !errors.Is(err, ErrInvalidOrigin) {
+				t.Fatalf("NormalizeOrigins(%q) should return ErrInvalidOrigin, got: %v", bad, err)
+			}
+		}
+	})
+}
+
+// TestKeyLimitsValidation verifies per-key limit bounds and the default spend cap unit
+func TestKeyLimitsValidation(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		rpm := rapid.IntRange(-10, MaxKeyRateLimitRPM+10).Draw(rt, "rpm")
+		concurrent := rapid.IntRange(-10, MaxKeyConcurrency+10).Draw(rt, "concurrent")
+		limits := &models.APIKeyLimits{RateLimitRPM: &rpm, MaxConcurrent: &concurrent}
+
+		valid := rpm >= 1 && rpm <= MaxKeyRateLimitRPM && concurrent >= 1 && concurrent <= MaxKeyConcurrency
+		err := ValidateLimits(limits)
+		if valid && err != nil {
+			t.Fatalf("Limits rpm=%d concurrent=%d should be valid, got: %v", rpm, concurrent, err)
+		}
+		if !valid && !errors.Is(err, ErrInvalidKeyLimits) {
+			t.Fatalf("Limits rpm=%d concurrent=%d should return ErrInvalidKeyLimits, got: %v", rpm, concurrent, err)
+		}
+		if valid && limits.SpendCapUnit != models.SpendUnitQuota {
+			t.Fatalf("Spend cap unit should default to quota, got %q", limits.SpendCapUnit)
+		}
+	})
+
+	t.Run("SpendCaps", func(t *testing.T) {
+		zero := decimal.Zero
+		if err := ValidateLimits(&models.APIKeyLimits{DailySpendCap: &zero}); !errors.Is(err, ErrInvalidKeyLimits) {
+			t.Fatalf("Zero spend cap should be rejected, got: %v", err)
+		}
+		if err := ValidateLimits(&models.APIKeyLimits{SpendCapUnit: "credits"}); !errors.Is(err, ErrInvalidKeyLimits) {
+			t.Fatalf("Unknown spend cap unit should be rejected, got: %v", err)
+		}
+		monthly := decimal.NewFromFloat(25.5)
+		if err := ValidateLimits(&models.APIKeyLimits{MonthlySpendCap: &monthly, SpendCapUnit: models.SpendUnitUSD}); err != nil {
+			t.Fatalf("USD monthly cap should be valid, got: %v", err)
+		}
+	})
+}
+
+// TestUsageBatching verifies buffered last_used_at updates are written by a flush
+func TestUsageBatching(t *testing.T) {
+	if testDB == nil {
+		t.Skip("Test database not available")
+	}
+
+	ctx := context.Background()
+	service := NewService(testDB)
+	userID := createTestDeveloper(t, ctx)
+	defer cleanupTestUser(t, ctx, userID)
+
+	created, err := service.Create(ctx, userID, &CreateAPIKeyRequest{Name: "batched"})
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	// With a flusher running, uses are buffered rather than written immediately
+	flushCtx, stop := context.WithCancel(ctx)
+	go service.RunUsageFlusher(flushCtx, time.Hour)
+	time.Sleep(50 * time.Millisecond)
+	service.MarkUsed(created.ID)
+	service.MarkUsed(created.ID)
+
+	if err := service.FlushUsage(ctx); err != nil {
+		t.Fatalf("Failed to flush usage: %v", err)
+	}
+	stop()
+
+	var lastUsed *time.Time
+	if err := testDB.QueryRow(ctx, `SELECT last_used_at FROM api_keys WHERE id = $1`, created.ID).Scan(&lastUsed); err != nil {
+		t.Fatalf("Failed to read last_used_at: %v", err)
+	}
+	if lastUsed == nil {
+		t.Fatal("last_used_at should be set after flush")
+	}
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		now := time.Now()
+		offset := time.Duration(rapid.Int64Range(-86400, 86400).Draw(rt, "offsetSeconds")) * time.Second
+		expiresAt := now.Add(offset)
+		key := &models.APIKey{ExpiresAt: &expiresAt}
+
+		if key.IsExpired(now) != (offset <= 0) {
+			t.Fatalf("IsExpired with offset %s returned %v", offset, key.IsExpired(now))
+		}
+
+		// Keys without expiry never expire
+		key.ExpiresAt = nil
+		if key.IsExpired(now) {
+			t.Fatal("Key without expiry should never expire")
+		}
+	})
+}
+
+// TestAPIKeyRotation tests that rotation issues a working key with the same scopes,
+// keeps the old key valid during the grace period and records both in the audit trail
+func TestAPIKeyRotation(t *testing.T) {
+	if testDB == nil {
+		t.Skip("Test database not available")
+	}
+
+	ctx := context.Background()
+	service := NewService(testDB)
+
+	userID := createTestDeveloper(t, ctx)
+	defer cleanupTestUser(t, ctx, userID)
+
+	createResp, err := service.Create(ctx, userID, &CreateAPIKeyRequest{
+		Name:        "rotating",
+		Permissions: map[string]bool{models.PermissionChat: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	grace := 60
+	rotateResp, err := service.Rotate(ctx, createResp.ID, userID, &RotateAPIKeyRequest{GracePeriodSeconds: &grace})
+	if err != nil {
+		t.Fatalf("Failed to rotate API key: %v", err)
+	}
+
+	// New key works and inherits scopes
+	newKey, err := service.ValidateAPIKey(ctx, rotateResp.NewKey.Key)
+	if err != nil {
+		t.Fatalf("Rotated key should be valid: %v", err)
+	}
+	if !newKey.HasPermission(models.PermissionChat) || newKey.HasPermission(models.PermissionStream) {
+		t.Fatalf("Rotated key should inherit permissions, got: %v", newKey.Permissions)
+	}
+
+	// Old key still works during the grace period
+	if _, err := service.ValidateAPIKey(ctx, createResp.Key); err != nil {
+		t.Fatalf("Old key should be valid during grace period: %v", err)
+	}
+
+	// A key cannot be rotated twice
+	if _, err := service.Rotate(ctx, createResp.ID, userID, &RotateAPIKeyRequest{}); !errors.Is(err, ErrAlreadyRotated) {
+		t.Fatalf("Expected ErrAlreadyRotated, got: %v", err)
+	}
+
+	// The grace period of a rotated key cannot be extended
+	later := time.Now().Add(30 * 24 * time.Hour)
+	if _, err := service.Update(ctx, createResp.ID, userID, &UpdateAPIKeyRequest{ExpiresAt: &later}); !errors.Is(err, ErrExpiryLocked) {
+		t.Fatalf("Expected ErrExpiryLocked, got: %v", err)
+	}
+
+	// Once the grace period is over the old key is rejected as expired
+	_, err = testDB.Exec(ctx, `UPDATE api_keys SET expires_at = NOW() - INTERVAL '1 second' WHERE id = $1`, createResp.ID)
+	if err != nil {
+		t.Fatalf("Failed to expire old key: %v", err)
+	}
+	if _, err := service.ValidateAPIKey(ctx, createResp.Key); !errors.Is(err, ErrAPIKeyExpired) {
+		t.Fatalf("Expected ErrAPIKeyExpired, got: %v", err)
+	}
+
+	// Audit trail records creation and rotation
+	logs, err := service.ListAuditLogs(ctx, createResp.ID, userID, 1, 20)
+	if err != nil {
+		t.Fatalf("Failed to list audit logs: %v", err)
+	}
+	events := map[models.APIKeyAuditEvent]bool{}
+	for _, entry := range logs.Logs {
+		events[entry.EventType] = true
+	}
+	if !events[models.APIKeyAuditCreated] || !events[models.APIKeyAuditRotated] {
+		t.Fatalf("Audit trail missing created/rotated events: %v", events)
+	}
+
+	// First use from a new IP is audited once
+	if err := service.RecordKeyUse(ctx, newKey, "203.0.113.7"); err != nil {
+		t.Fatalf("Failed to record key use: %v", err)
+	}
+	if err := service.RecordKeyUse(ctx, newKey, "203.0.113.7"); err != nil {
+		t.Fatalf("Failed to record key use: %v", err)
+	}
+	var newIPEvents int
+	err = testDB.QueryRow(ctx, `
+		SELECT COUNT(*) FROM api_key_audit_logs WHERE api_key_id = $1 AND event_type = 'new_ip'
+	`, newKey.ID).Scan(&newIPEvents)
+	if err != nil {
+		t.Fatalf("Failed to count new_ip events: %v", err)
+	}
+	if newIPEvents != 1 {
+		t.Fatalf("Expected 1 new_ip event, got %d", newIPEvents)
+	}
+}
+
+// TestAPIKeyLimit tests that only live keys count towards MaxAPIKeysPerUser
+// and that rotation cannot be used to exceed it
+func TestAPIKeyLimit(t *testing.T) {
+	if testDB == nil {
+		t.Skip("Test database not available")
+	}
+
+	ctx := context.Background()
+	service := NewService(testDB)
+
+	userID := createTestDeveloper(t, ctx)
+	defer cleanupTestUser(t, ctx, userID)
+
+	var ids []uuid.UUID
+	for i := 0; i < MaxAPIKeysPerUser; i++ {
+		resp, err := service.Create(ctx, userID, &CreateAPIKeyRequest{})
+		if err != nil {
+			t.Fatalf("Failed to create API key %d: %v", i, err)
+		}
+		ids = append(ids, resp.ID)
+	}
+	if _, err := service.Create(ctx, userID, &CreateAPIKeyRequest{}); !errors.Is(err, ErrMaxKeysReached) {
+		t.Fatalf("Expected ErrMaxKeysReached, got: %v", err)
+	}
+
+	// Rotating at the limit replaces a key, so it is allowed
+	if _, err := service.Rotate(ctx, ids[0], userID, &RotateAPIKeyRequest{}); err != nil {
+		t.Fatalf("Rotation at the limit should succeed: %v", err)
+	}
+	if _, err := service.Create(ctx, userID, &CreateAPIKeyRequest{}); !errors.Is(err, ErrMaxKeysReached) {
+		t.Fatalf("Expected ErrMaxKeysReached after rotation, got: %v", err)
+	}
+
+	// An expired key frees a slot
+	_, err := testDB.Exec(ctx, `UPDATE api_keys SET expires_at = NOW() - INTERVAL '1 second' WHERE id = $1`, ids[1])
+	if err != nil {
+		t.Fatalf("Failed to expire key: %v", err)
+	}
+	if _, err := service.Create(ctx, userID, &CreateAPIKeyRequest{}); err != nil {
+		t.Fatalf("Expired key should not count towards the limit: %v", err)
+	}
+}
+
 // Helper functions for test setup
 
 func createTestDeveloper(t *testing.T, ctx context.Context) uuid.UUID {
@@ -341,3 +740,37 @@ func cleanupTestUser(t *testing.T, ctx context.Context, userID uuid.UUID) {
 	// Delete user
 	_, _ = testDB.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
 }
+
+// TestAPIKeyOwnerStatus tests that keys stop validating as soon as their
+// owner is suspended or banned, and work again once reactivated
+func TestAPIKeyOwnerStatus(t *testing.T) {
+	if testDB == nil {
+		t.Skip("Test database not available")
+	}
+
+	ctx := context.Background()
+	service := NewService(testDB)
+	userID := createTestDeveloper(t, ctx)
+	defer cleanupTestUser(t, ctx, userID)
+
+	createResp, err := service.Create(ctx, userID, &CreateAPIKeyRequest{Name: "status"})
+	if err != nil {
+		t.Fatalf("Failed to create API key: %v", err)
+	}
+
+	for _, tt := range []struct {
+		status models.AccountStatus
+		want   error
+	}{
+		{models.AccountStatusSuspended, ErrAccountSuspended},
+		{models.AccountStatusBanned, ErrAccountBanned},
+		{models.AccountStatusActive, nil},
+	} {
+		if _, err := testDB.Exec(ctx, `UPDATE users SET status = $1 WHERE id = $2`, tt.status, userID); err != nil {
+			t.Fatalf("Failed to set status: %v", err)
+		}
+		if _, err := service.ValidateAPIKey(ctx, createResp.Key); !errors.Is(err, tt.want) {
+			t.Errorf("Owner %s: expected %v, got %v", tt.status, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ListAuditLogsResponse represents a paginated API key audit trail
+type ListAuditLogsResponse struct {
+	Logs       []models.APIKeyAuditLog `json:"logs"`
+	Total      int64                   `json:"total"`
+	Page       int                     `json:"page"`
+	PageSize   int                     `json:"page_size"`
+	TotalPages int                     `json:"total_pages"`
+}
+
+// insertAuditLog records an audit event for a key inside a transaction
+func insertAuditLog(ctx context.Context, tx pgx.Tx, keyID, userID uuid.UUID, event models.APIKeyAuditEvent, ipAddress *string, details map[string]interface{}) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO api_key_audit_logs (api_key_id, user_id, event_type, ip_address, details)
+		VALUES ($1, $2, $3, $4, $5)
+	`, keyID, userID, event, ipAddress, details)
+	if err != nil {
+		return fmt.Errorf("failed to write API key audit log: %w", err)
+	}
+	return nil
+}
+
+// RecordKeyUse records the client IP a key was used from and audits the
+// first use of the key from each new IP
+func (s *Service) RecordKeyUse(ctx context.Context, apiKey *models.APIKey, clientIP string) error {
+	if clientIP == "" {
+		return nil
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO api_key_known_ips (api_key_id, ip_address)
+		VALUES ($1, $2)
+		ON CONFLICT (api_key_id, ip_address) DO NOTHING
+	`, apiKey.ID, clientIP)
+	if err != nil {
+		return fmt.Errorf("failed to record key IP: %w", err)
+	}
+
+	// IP already known for this key
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+
+	if err := insertAuditLog(ctx, tx, apiKey.ID, apiKey.UserID, models.APIKeyAuditNewIP, &clientIP, nil); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ListAuditLogs returns the audit trail of a key owned by the user, newest first
+func (s *Service) ListAuditLogs(ctx context.Context, keyID, userID uuid.UUID, page, pageSize int) (*ListAuditLogsResponse, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	offset := (page - 1) * pageSize
+
+	// Check if key exists and belongs to user
+	var ownerID uuid.UUID
+	err := s.db.QueryRow(ctx, `
+		SELECT user_id FROM api_keys WHERE id = $1
+	`, keyID).Scan(&ownerID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+	if ownerID != userID {
+		return nil, ErrAPIKeyNotOwned
+	}
+
+	var total int64
+	err = s.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM api_key_audit_logs WHERE api_key_id = $1
+	`, keyID).Scan(&total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count audit logs: %w", err)
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, api_key_id, user_id, event_type, ip_address, details, created_at
+		FROM api_key_audit_logs
+		WHERE api_key_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, keyID, pageSize, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit logs: %w", err)
+	}
+	defer rows.Close()
+
+	logs := []models.APIKeyAuditLog{}
+	for rows.Next() {
+		var entry models.APIKeyAuditLog
+		err := rows.Scan(
+			&entry.ID, &entry.APIKeyID, &entry.UserID, &entry.EventType,
+			&entry.IPAddress, &entry.Details, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit log: %w", err)
+		}
+		logs = append(logs, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit logs: %w", err)
+	}
+
+	totalPages := int(total) / pageSize
+	if int(total)%pageSize > 0 {
+		totalPages++
+	}
+
+	return &ListAuditLogsResponse{
+		Logs:       logs,
+		Total:      total,
+		Page:       page,
+		PageSize:   pageSize,
+		TotalPages: totalPages,
+	}, nil
+}
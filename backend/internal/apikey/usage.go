@@ -0,0 +1,103 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// usageBatcher buffers last_used_at updates so the hot path does not write
+// to Postgres on every call. Until a flusher runs, each use is written
+// immediately.
+type usageBatcher struct {
+	mu      sync.Mutex
+	pending map[uuid.UUID]time.Time
+	running bool
+}
+
+// MarkUsed records that a key was just used
+func (s *Service) MarkUsed(keyID uuid.UUID) {
+	now := time.Now()
+
+	s.usage.mu.Lock()
+	if s.usage.running {
+		if s.usage.pending == nil {
+			s.usage.pending = make(map[uuid.UUID]time.Time)
+		}
+		s.usage.pending[keyID] = now
+		s.usage.mu.Unlock()
+		return
+	}
+	s.usage.mu.Unlock()
+
+	// No flusher running: update last used timestamp (async, don't block on this)
+	go func() {
+		_, _ = s.db.Exec(context.Background(), `
+			UPDATE api_keys SET last_used_at = $2 WHERE id = $1
+		`, keyID, now)
+	}()
+}
+
+// RunUsageFlusher writes buffered last_used_at updates every interval until
+// the context is cancelled, then flushes once more
+func (s *Service) RunUsageFlusher(ctx context.Context, interval time.Duration) {
+	s.usage.mu.Lock()
+	s.usage.running = true
+	s.usage.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.FlushUsage(ctx); err != nil {
+				log.Warn().Err(err).Msg("Failed to flush API key usage")
+			}
+		case <-ctx.Done():
+			s.usage.mu.Lock()
+			s.usage.running = false
+			s.usage.mu.Unlock()
+
+			flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := s.FlushUsage(flushCtx); err != nil {
+				log.Warn().Err(err).Msg("Failed to flush API key usage on shutdown")
+			}
+			cancel()
+			return
+		}
+	}
+}
+
+// FlushUsage writes buffered last_used_at updates in a single statement
+func (s *Service) FlushUsage(ctx context.Context) error {
+	s.usage.mu.Lock()
+	pending := s.usage.pending
+	s.usage.pending = nil
+	s.usage.mu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, 0, len(pending))
+	times := make([]time.Time, 0, len(pending))
+	for id, usedAt := range pending {
+		ids = append(ids, id)
+		times = append(times, usedAt)
+	}
+
+	_, err := s.db.Exec(ctx, `
+		UPDATE api_keys k SET last_used_at = u.used_at
+		FROM unnest($1::uuid[], $2::timestamptz[]) AS u(id, used_at)
+		WHERE k.id = u.id AND (k.last_used_at IS NULL OR k.last_used_at < u.used_at)
+	`, ids, times)
+	if err != nil {
+		return fmt.Errorf("failed to update API key last used: %w", err)
+	}
+	return nil
+}
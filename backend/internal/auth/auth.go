@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/ledger"
 	"github.com/aimerfeng/AgentLink/internal/models"
 	"github.com/alexedwards/argon2id"
 	"github.com/golang-jwt/jwt/v5"
@@ -75,6 +76,7 @@ type UserResponse struct {
 	UserType      models.UserType  `json:"user_type"`
 	WalletAddress *string          `json:"wallet_address,omitempty"`
 	EmailVerified bool             `json:"email_verified"`
+	Status        models.AccountStatus `json:"status"`
 	CreatedAt     time.Time        `json:"created_at"`
 }
 
@@ -127,10 +129,11 @@ func (s *Service) Register(ctx context.Context, req *RegisterRequest) (*Register
 	err = tx.QueryRow(ctx, `
 		INSERT INTO users (email, password_hash, user_type, email_verified)
 		VALUES ($1, $2, $3, $4)
-		RETURNING id, email, password_hash, user_type, wallet_address, email_verified, created_at, updated_at
+		RETURNING id, email, password_hash, user_type, wallet_address, email_verified, status, status_reason, created_at, updated_at
 	`, req.Email, passwordHash, req.UserType, false).Scan(
 		&user.ID, &user.Email, &user.PasswordHash, &user.UserType,
-		&user.WalletAddress, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt,
+		&user.WalletAddress, &user.EmailVerified, &user.Status, &user.StatusReason,
+		&user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create user: %w", err)
@@ -144,6 +147,14 @@ func (s *Service) Register(ctx context.Context, req *RegisterRequest) (*Register
 	if err != nil {
 		return nil, fmt.Errorf("failed to create quota: %w", err)
 	}
+	err = ledger.Record(ctx, tx, &models.QuotaLedgerEntry{
+		UserID:    user.ID,
+		EntryType: models.QuotaLedgerSignupGrant,
+		Amount:    s.quota.FreeInitial * 2, // total_quota and free_quota both start at FreeInitial
+	})
+	if err != nil {
+		return nil, err
+	}
 
 	// If creator, create profile
 	if req.UserType == models.UserTypeCreator {
@@ -179,11 +190,12 @@ func (s *Service) Login(ctx context.Context, req *LoginRequest) (*LoginResponse,
 	// Find user by email
 	var user models.User
 	err := s.db.QueryRow(ctx, `
-		SELECT id, email, password_hash, user_type, wallet_address, email_verified, created_at, updated_at
+		SELECT id, email, password_hash, user_type, wallet_address, email_verified, status, status_reason, created_at, updated_at
 		FROM users WHERE email = $1
 	`, req.Email).Scan(
 		&user.ID, &user.Email, &user.PasswordHash, &user.UserType,
-		&user.WalletAddress, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt,
+		&user.WalletAddress, &user.EmailVerified, &user.Status, &user.StatusReason,
+		&user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -201,6 +213,9 @@ func (s *Service) Login(ctx context.Context, req *LoginRequest) (*LoginResponse,
 	if !match {
 		return nil, ErrInvalidCredentials
 	}
+	if err := checkAccountStatus(user.Status); err != nil {
+		return nil, err
+	}
 
 	// Generate tokens
 	tokens, err := s.generateTokenPair(&user)
@@ -236,11 +251,12 @@ func (s *Service) RefreshTokens(ctx context.Context, refreshToken string) (*Toke
 
 	var user models.User
 	err = s.db.QueryRow(ctx, `
-		SELECT id, email, password_hash, user_type, wallet_address, email_verified, created_at, updated_at
+		SELECT id, email, password_hash, user_type, wallet_address, email_verified, status, status_reason, created_at, updated_at
 		FROM users WHERE id = $1
 	`, userID).Scan(
 		&user.ID, &user.Email, &user.PasswordHash, &user.UserType,
-		&user.WalletAddress, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt,
+		&user.WalletAddress, &user.EmailVerified, &user.Status, &user.StatusReason,
+		&user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -248,6 +264,9 @@ func (s *Service) RefreshTokens(ctx context.Context, refreshToken string) (*Toke
 		}
 		return nil, fmt.Errorf("failed to find user: %w", err)
 	}
+	if err := checkAccountStatus(user.Status); err != nil {
+		return nil, err
+	}
 
 	// Generate new token pair (token rotation)
 	return s.generateTokenPair(&user)
@@ -272,11 +291,12 @@ func (s *Service) ValidateAccessToken(tokenString string) (*Claims, error) {
 func (s *Service) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
 	var user models.User
 	err := s.db.QueryRow(ctx, `
-		SELECT id, email, password_hash, user_type, wallet_address, email_verified, created_at, updated_at
+		SELECT id, email, password_hash, user_type, wallet_address, email_verified, status, status_reason, created_at, updated_at
 		FROM users WHERE id = $1
 	`, userID).Scan(
 		&user.ID, &user.Email, &user.PasswordHash, &user.UserType,
-		&user.WalletAddress, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt,
+		&user.WalletAddress, &user.EmailVerified, &user.Status, &user.StatusReason,
+		&user.CreatedAt, &user.UpdatedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -287,6 +307,32 @@ func (s *Service) GetUserByID(ctx context.Context, userID uuid.UUID) (*models.Us
 	return &user, nil
 }
 
+// AccountStatus returns a user's account status, or an empty status if the
+// user no longer exists
+func (s *Service) AccountStatus(ctx context.Context, userID uuid.UUID) (models.AccountStatus, error) {
+	var status models.AccountStatus
+	err := s.db.QueryRow(ctx, `SELECT status FROM users WHERE id = $1`, userID).Scan(&status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get account status: %w", err)
+	}
+	return status, nil
+}
+
+// checkAccountStatus returns ErrAccountSuspended or ErrAccountBanned if the
+// account may not sign in
+func checkAccountStatus(status models.AccountStatus) error {
+	switch status {
+	case models.AccountStatusSuspended:
+		return ErrAccountSuspended
+	case models.AccountStatusBanned:
+		return ErrAccountBanned
+	}
+	return nil
+}
+
 // generateTokenPair creates access and refresh tokens
 func (s *Service) generateTokenPair(user *models.User) (*TokenPair, error) {
 	now := time.Now()
@@ -373,6 +419,7 @@ func toUserResponse(user *models.User) UserResponse {
 		UserType:      user.UserType,
 		WalletAddress: user.WalletAddress,
 		EmailVerified: user.EmailVerified,
+		Status:        user.Status,
 		CreatedAt:     user.CreatedAt,
 	}
 }
@@ -13,4 +13,6 @@ var (
 	ErrUnauthorized           = errors.New("unauthorized")
 	ErrInvalidWalletAddress   = errors.New("invalid ethereum wallet address")
 	ErrNotCreator             = errors.New("only creators can bind wallet addresses")
+	ErrAccountSuspended       = errors.New("account is suspended")
+	ErrAccountBanned          = errors.New("account is banned")
 )
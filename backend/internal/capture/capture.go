@@ -0,0 +1,329 @@
+package capture
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrCaptureNotFound = errors.New("capture not found")
+)
+
+// maxRequestIDLength is the longest request ID a capture can be stored under
+const maxRequestIDLength = 128
+
+// Cipher encrypts and decrypts captured payloads. agent.Service implements
+// it with the AES-256-GCM key used for agent configs.
+type Cipher interface {
+	Encrypt(plaintext []byte) (ciphertext, nonce []byte, err error)
+	Decrypt(ciphertext, nonce []byte) ([]byte, error)
+}
+
+// Message is a captured chat message sent by the caller
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Payload is a captured exchange. It holds the caller's messages, never the
+// agent's system prompt.
+type Payload struct {
+	Messages []Message `json:"messages"`
+	Stream   bool      `json:"stream"`
+	// Response is the assistant's reply, or the raw response body if it could not be parsed
+	Response          string `json:"response"`
+	RequestTruncated  bool   `json:"request_truncated,omitempty"`
+	ResponseTruncated bool   `json:"response_truncated,omitempty"`
+}
+
+// Exchange is a stored capture
+type Exchange struct {
+	RequestID string     `json:"request_id"`
+	APIKeyID  uuid.UUID  `json:"api_key_id"`
+	AgentID   *uuid.UUID `json:"agent_id,omitempty"`
+	Status    string     `json:"status"`
+	ErrorCode *string    `json:"error_code,omitempty"`
+	Payload   *Payload   `json:"payload"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+// Record is an exchange to capture
+type Record struct {
+	UserID       uuid.UUID
+	APIKeyID     uuid.UUID
+	AgentID      uuid.UUID
+	RequestID    string
+	Success      bool
+	ErrorCode    string
+	TTL          time.Duration
+	SystemPrompt string // Redacted wherever it appears in the exchange
+	Messages     []Message
+	Stream       bool
+	Response     *Buffer // What was written to the caller
+}
+
+// Service stores encrypted request/response captures for API keys that
+// opted in, and serves them back to the key's owner until they expire
+type Service struct {
+	db     *pgxpool.Pool
+	cipher Cipher
+	config *config.CaptureConfig
+
+	mu        sync.RWMutex
+	redactors []Redactor
+}
+
+// NewService creates a new capture service
+func NewService(db *pgxpool.Pool, cipher Cipher, cfg *config.CaptureConfig) *Service {
+	s := &Service{
+		db:     db,
+		cipher: cipher,
+		config: cfg,
+	}
+	if cfg.RedactPII {
+		s.redactors = DefaultRedactors()
+	}
+	return s
+}
+
+// AddRedactor adds a redactor applied to every captured message and response
+func (s *Service) AddRedactor(r Redactor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.redactors = append(s.redactors, r)
+}
+
+// redact removes the system prompt from text, then applies the redactors
+func (s *Service) redact(text, prompt string) string {
+	text = redactPrompt(text, prompt)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, r := range s.redactors {
+		text = r.Redact(text)
+	}
+	return text
+}
+
+// sanitize builds the payload stored for a record: messages are cut to the
+// size limit, and everything is redacted
+func (s *Service) sanitize(rec *Record) *Payload {
+	p := &Payload{Messages: make([]Message, 0, len(rec.Messages)), Stream: rec.Stream}
+
+	budget := s.config.MaxBytes
+	for _, m := range rec.Messages {
+		content := m.Content
+		if len(content) > budget {
+			content = strings.ToValidUTF8(content[:budget], "")
+			p.RequestTruncated = true
+		}
+		budget -= len(content)
+		p.Messages = append(p.Messages, Message{Role: m.Role, Content: s.redact(content, rec.SystemPrompt)})
+	}
+
+	if rec.Response != nil {
+		p.Response = s.redact(responseText(rec.Response.Bytes(), rec.Stream), rec.SystemPrompt)
+		p.ResponseTruncated = rec.Response.Truncated()
+	}
+	return p
+}
+
+// Store encrypts and stores a captured exchange until its TTL passes. A
+// request already captured is left alone.
+func (s *Service) Store(ctx context.Context, rec *Record) error {
+	if rec.RequestID == "" || len(rec.RequestID) > maxRequestIDLength {
+		return fmt.Errorf("cannot capture request ID %q", rec.RequestID)
+	}
+
+	plaintext, err := json.Marshal(s.sanitize(rec))
+	if err != nil {
+		return fmt.Errorf("failed to encode capture: %w", err)
+	}
+	ciphertext, nonce, err := s.cipher.Encrypt(plaintext)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt capture: %w", err)
+	}
+
+	status := models.CallStatusSuccess
+	if !rec.Success {
+		status = models.CallStatusError
+	}
+	var errorCode *string
+	if rec.ErrorCode != "" {
+		errorCode = &rec.ErrorCode
+	}
+
+	_, err = s.db.Exec(ctx, `
+		INSERT INTO call_payloads (
+			user_id, api_key_id, agent_id, request_id, status, error_code,
+			payload_encrypted, payload_iv, expires_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW() + $9::interval)
+		ON CONFLICT (user_id, request_id) DO NOTHING
+	`, rec.UserID, rec.APIKeyID, rec.AgentID, rec.RequestID, status, errorCode,
+		ciphertext, nonce, rec.TTL)
+	if err != nil {
+		return fmt.Errorf("failed to store capture: %w", err)
+	}
+	return nil
+}
+
+// Get returns the user's unexpired capture of a request
+func (s *Service) Get(ctx context.Context, userID uuid.UUID, requestID string) (*Exchange, error) {
+	var e Exchange
+	var ciphertext, nonce []byte
+	err := s.db.QueryRow(ctx, `
+		SELECT request_id, api_key_id, agent_id, status, error_code,
+		       payload_encrypted, payload_iv, created_at, expires_at
+		FROM call_payloads
+		WHERE user_id = $1 AND request_id = $2 AND expires_at > NOW()
+	`, userID, requestID).Scan(&e.RequestID, &e.APIKeyID, &e.AgentID, &e.Status, &e.ErrorCode,
+		&ciphertext, &nonce, &e.CreatedAt, &e.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrCaptureNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get capture: %w", err)
+	}
+
+	plaintext, err := s.cipher.Decrypt(ciphertext, nonce)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt capture: %w", err)
+	}
+	e.Payload = &Payload{}
+	if err := json.Unmarshal(plaintext, e.Payload); err != nil {
+		return nil, fmt.Errorf("failed to decode capture: %w", err)
+	}
+	return &e, nil
+}
+
+// DeleteExpired deletes captures past their TTL
+func (s *Service) DeleteExpired(ctx context.Context) (int64, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM call_payloads WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired captures: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// RunCleanup deletes expired captures every interval until the context is
+// cancelled. Does nothing if cleanup is disabled.
+func (s *Service) RunCleanup(ctx context.Context) {
+	if s.config.CleanupInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.CleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		deleted, err := s.DeleteExpired(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to clean up payload captures")
+		} else if deleted > 0 {
+			log.Debug().Int64("deleted", deleted).Msg("Deleted expired payload captures")
+		}
+	}
+}
+
+// chatReply is the part of a chat response or stream chunk holding the reply
+type chatReply struct {
+	Choices []struct {
+		Message *Message `json:"message"`
+		Delta   *Message `json:"delta"`
+	} `json:"choices"`
+}
+
+// responseText extracts the assistant's reply from a chat response body, or
+// from the data events of a stream. Returns the body itself if it holds no
+// reply, e.g. for an error response.
+func responseText(body []byte, stream bool) string {
+	var text strings.Builder
+	found := false
+	appendReply := func(data []byte) {
+		var reply chatReply
+		if json.Unmarshal(data, &reply) != nil {
+			return
+		}
+		for _, choice := range reply.Choices {
+			if m := choice.Message; m != nil {
+				text.WriteString(m.Content)
+				found = true
+			}
+			if m := choice.Delta; m != nil {
+				text.WriteString(m.Content)
+				found = true
+			}
+		}
+	}
+
+	if stream {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+		for scanner.Scan() {
+			if data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data: ")); ok {
+				appendReply(data)
+			}
+		}
+	} else {
+		appendReply(body)
+	}
+
+	if !found {
+		return strings.ToValidUTF8(string(body), "")
+	}
+	return text.String()
+}
+
+// Buffer keeps the first bytes written to it, up to a limit. Writes never
+// fail, so it can sit behind an io.MultiWriter with the response.
+type Buffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+// NewBuffer creates a buffer keeping up to limit bytes
+func NewBuffer(limit int) *Buffer {
+	return &Buffer{limit: limit}
+}
+
+// Write keeps as much of p as fits
+func (b *Buffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	b.buf.Write(p)
+	return len(p), nil
+}
+
+// Bytes returns the bytes kept
+func (b *Buffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// Truncated reports whether bytes were dropped
+func (b *Buffer) Truncated() bool {
+	return b.truncated
+}
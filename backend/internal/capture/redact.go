@@ -0,0 +1,109 @@
+package capture
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// redacted replaces the agent's system prompt wherever it appears
+const redacted = "[REDACTED]"
+
+// Redactor rewrites captured text before it is stored, e.g. to mask PII
+type Redactor interface {
+	Redact(text string) string
+}
+
+// RedactorFunc adapts a function to a Redactor
+type RedactorFunc func(text string) string
+
+// Redact calls f(text)
+func (f RedactorFunc) Redact(text string) string {
+	return f(text)
+}
+
+// PatternRedactor replaces every match of pattern with replacement
+func PatternRedactor(pattern *regexp.Regexp, replacement string) Redactor {
+	return RedactorFunc(func(text string) string {
+		return pattern.ReplaceAllLiteralString(text, replacement)
+	})
+}
+
+var (
+	emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	cardPattern  = regexp.MustCompile(`\b(?:\d[ \-]?){12,18}\d\b`)
+	phonePattern = regexp.MustCompile(`(?:\+\d{1,3}[ .\-]?)?(?:\(\d{2,4}\)[ .\-]?|\b\d{2,4}[ .\-])\d{3,4}[ .\-]\d{3,4}\b|\+\d{8,15}\b`)
+)
+
+// DefaultRedactors mask email addresses, payment card numbers and phone
+// numbers. Card numbers are only masked if they pass the Luhn check.
+func DefaultRedactors() []Redactor {
+	return []Redactor{
+		PatternRedactor(emailPattern, "[EMAIL]"),
+		RedactorFunc(redactCards),
+		PatternRedactor(phonePattern, "[PHONE]"),
+	}
+}
+
+// redactCards masks card numbers. A run of digits failing the Luhn check is
+// searched again from its second group, as it may be a card number preceded
+// by other digits.
+func redactCards(text string) string {
+	var out strings.Builder
+	for {
+		loc := cardPattern.FindStringIndex(text)
+		if loc == nil {
+			break
+		}
+		if luhnValid(text[loc[0]:loc[1]]) {
+			out.WriteString(text[:loc[0]])
+			out.WriteString("[CARD]")
+			text = text[loc[1]:]
+			continue
+		}
+		next := loc[0]
+		for next < loc[1] && text[next] >= '0' && text[next] <= '9' {
+			next++
+		}
+		out.WriteString(text[:next])
+		text = text[next:]
+	}
+	out.WriteString(text)
+	return out.String()
+}
+
+// luhnValid reports whether the digits in s pass the Luhn checksum
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+// redactPrompt replaces the system prompt in text, also where it appears
+// escaped inside a JSON string
+func redactPrompt(text, prompt string) string {
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		return text
+	}
+	text = strings.ReplaceAll(text, prompt, redacted)
+	if quoted, err := json.Marshal(prompt); err == nil {
+		if escaped := string(quoted[1 : len(quoted)-1]); escaped != prompt {
+			text = strings.ReplaceAll(text, escaped, redacted)
+		}
+	}
+	return text
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/aimerfeng/AgentLink/internal/tracing"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
 )
@@ -27,6 +28,9 @@ func New(databaseURL string) (*DB, error) {
 	config.MaxConnIdleTime = 30 * time.Minute
 	config.HealthCheckPeriod = time.Minute
 
+	// Record query spans for requests being traced
+	config.ConnConfig.Tracer = tracing.PgxTracer{}
+
 	pool, err := pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
 		return nil, err
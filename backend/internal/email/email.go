@@ -0,0 +1,72 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/config"
+)
+
+// ErrInvalidRecipient is returned for an address that cannot be sent to
+var ErrInvalidRecipient = errors.New("invalid email recipient")
+
+// Mailer sends plain-text emails through the configured SMTP server
+type Mailer struct {
+	config *config.SMTPConfig
+}
+
+// NewMailer creates a new mailer
+func NewMailer(cfg *config.SMTPConfig) *Mailer {
+	return &Mailer{config: cfg}
+}
+
+// Enabled reports whether an SMTP server is configured
+func (m *Mailer) Enabled() bool {
+	return m.config.Host != ""
+}
+
+// Send sends a plain-text email to a single recipient
+func (m *Mailer) Send(to, subject, body string) error {
+	addr, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRecipient, err)
+	}
+
+	var auth smtp.Auth
+	if m.config.User != "" {
+		auth = smtp.PlainAuth("", m.config.User, m.config.Password, m.config.Host)
+	}
+
+	msg := BuildMessage(m.config.FromName, m.config.FromEmail, addr.Address, subject, body, time.Now())
+	server := fmt.Sprintf("%s:%d", m.config.Host, m.config.Port)
+	if err := smtp.SendMail(server, auth, m.config.FromEmail, []string{addr.Address}, msg); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// BuildMessage formats an RFC 5322 message. Header values are stripped of line
+// breaks so they cannot inject headers.
+func BuildMessage(fromName, fromEmail, to, subject, body string, date time.Time) []byte {
+	from := mail.Address{Name: stripLineBreaks(fromName), Address: stripLineBreaks(fromEmail)}
+
+	var b strings.Builder
+	b.WriteString("From: " + from.String() + "\r\n")
+	b.WriteString("To: " + stripLineBreaks(to) + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", stripLineBreaks(subject)) + "\r\n")
+	b.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+func stripLineBreaks(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
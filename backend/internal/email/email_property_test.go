@@ -0,0 +1,29 @@
+package email
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"pgregory.net/rapid"
+)
+
+// TestProperty_HeadersCannotBeInjected tests that no header value, however
+// malformed, adds lines to the message header
+func TestProperty_HeadersCannotBeInjected(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		fromName := rapid.String().Draw(t, "fromName")
+		to := rapid.String().Draw(t, "to")
+		subject := rapid.String().Draw(t, "subject")
+		body := rapid.String().Draw(t, "body")
+
+		msg := string(BuildMessage(fromName, "noreply@example.com", to, subject, body, time.Now()))
+		header, _, ok := strings.Cut(msg, "\r\n\r\n")
+		if !ok {
+			t.Fatalf("PROPERTY VIOLATION: message has no header/body separator")
+		}
+		if lines := strings.Split(header, "\r\n"); len(lines) != 6 {
+			t.Fatalf("PROPERTY VIOLATION: expected 6 header lines, got %d: %q", len(lines), header)
+		}
+	})
+}
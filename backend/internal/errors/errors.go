@@ -22,33 +22,55 @@ const (
 	ErrTokenExpired       ErrorCode = "40102"
 	ErrInvalidAPIKey      ErrorCode = "40103"
 	ErrMissingAPIKey      ErrorCode = "40104"
+	ErrAPIKeyExpired      ErrorCode = "40105"
 
 	// Authorization errors (403xx)
-	ErrForbidden       ErrorCode = "40301"
-	ErrAgentNotOwned   ErrorCode = "40302"
-	ErrAgentNotActive  ErrorCode = "40303"
-	ErrAccessDenied    ErrorCode = "40304"
+	ErrForbidden           ErrorCode = "40301"
+	ErrAgentNotOwned       ErrorCode = "40302"
+	ErrAgentNotActive      ErrorCode = "40303"
+	ErrAccessDenied        ErrorCode = "40304"
+	ErrKeyScopeDenied      ErrorCode = "40305"
+	ErrRequestSourceDenied ErrorCode = "40306"
+	ErrAccountSuspended    ErrorCode = "40307"
+	ErrAccountBanned       ErrorCode = "40308"
+	ErrReviewNotAllowed    ErrorCode = "40309"
 
 	// Resource errors (404xx)
-	ErrNotFound      ErrorCode = "40400"
-	ErrAgentNotFound ErrorCode = "40401"
-	ErrUserNotFound  ErrorCode = "40402"
+	ErrNotFound       ErrorCode = "40400"
+	ErrAgentNotFound  ErrorCode = "40401"
+	ErrUserNotFound   ErrorCode = "40402"
 	ErrAPIKeyNotFound ErrorCode = "40403"
+	ErrReviewNotFound ErrorCode = "40404"
+
+	// Conflict errors (409xx)
+	ErrIdempotencyKeyInUse       ErrorCode = "40901"
+	ErrReviewExists              ErrorCode = "40902"
+	ErrIdempotencyReplayTooLarge ErrorCode = "40903"
+
+	// Unprocessable errors (422xx)
+	ErrIdempotencyKeyMismatch ErrorCode = "42201"
 
 	// Rate limit errors (429xx)
-	ErrQuotaExhausted ErrorCode = "42901"
-	ErrRateLimited    ErrorCode = "42902"
+	ErrQuotaExhausted          ErrorCode = "42901"
+	ErrRateLimited             ErrorCode = "42902"
+	ErrKeyRateLimited          ErrorCode = "42903"
+	ErrKeyConcurrencyLimited   ErrorCode = "42904"
+	ErrKeySpendCapExceeded     ErrorCode = "42905"
+	ErrTokenRateLimited        ErrorCode = "42906"
+	ErrAgentRateLimited        ErrorCode = "42907"
+	ErrAgentConcurrencyLimited ErrorCode = "42908"
 
 	// Server errors (500xx)
-	ErrInternalServer      ErrorCode = "50001"
-	ErrDatabaseError       ErrorCode = "50002"
-	ErrCacheError          ErrorCode = "50003"
-	ErrUpstreamError       ErrorCode = "50004"
+	ErrInternalServer ErrorCode = "50001"
+	ErrDatabaseError  ErrorCode = "50002"
+	ErrCacheError     ErrorCode = "50003"
+	ErrUpstreamError  ErrorCode = "50004"
 
 	// Gateway errors (502xx, 503xx, 504xx)
 	ErrBadGateway          ErrorCode = "50201"
 	ErrUpstreamUnavailable ErrorCode = "50301"
 	ErrCircuitBreakerOpen  ErrorCode = "50302"
+	ErrLimitsUnavailable   ErrorCode = "50303"
 	ErrUpstreamTimeout     ErrorCode = "50401"
 )
 
@@ -94,6 +116,7 @@ type ErrorResponse struct {
 	Error         ErrorDetail `json:"error"`
 	RequestID     string      `json:"request_id,omitempty"`
 	CorrelationID string      `json:"correlation_id,omitempty"`
+	TraceID       string      `json:"trace_id,omitempty"`
 }
 
 // ErrorDetail contains the detailed error information
@@ -148,6 +171,12 @@ var (
 		HTTPStatus: http.StatusUnauthorized,
 	}
 
+	ErrAPIKeyExpiredError = &APIError{
+		Code:       ErrAPIKeyExpired,
+		Message:    "API key has expired",
+		HTTPStatus: http.StatusUnauthorized,
+	}
+
 	ErrMissingAPIKeyError = &APIError{
 		Code:       ErrMissingAPIKey,
 		Message:    "Missing X-AgentLink-Key header",
@@ -160,6 +189,36 @@ var (
 		HTTPStatus: http.StatusForbidden,
 	}
 
+	ErrKeyScopeDeniedError = &APIError{
+		Code:       ErrKeyScopeDenied,
+		Message:    "API key is not permitted to perform this request",
+		HTTPStatus: http.StatusForbidden,
+	}
+
+	ErrRequestSourceDeniedError = &APIError{
+		Code:       ErrRequestSourceDenied,
+		Message:    "API key is not permitted from this IP address or origin",
+		HTTPStatus: http.StatusForbidden,
+	}
+
+	ErrAccountSuspendedError = &APIError{
+		Code:       ErrAccountSuspended,
+		Message:    "Account is suspended",
+		HTTPStatus: http.StatusForbidden,
+	}
+
+	ErrAccountBannedError = &APIError{
+		Code:       ErrAccountBanned,
+		Message:    "Account is banned",
+		HTTPStatus: http.StatusForbidden,
+	}
+
+	ErrReviewNotAllowedError = &APIError{
+		Code:       ErrReviewNotAllowed,
+		Message:    "Only users who have successfully called this agent can review it",
+		HTTPStatus: http.StatusForbidden,
+	}
+
 	ErrAgentNotActiveError = &APIError{
 		Code:       ErrAgentNotActive,
 		Message:    "Agent is not active",
@@ -220,11 +279,47 @@ var (
 		HTTPStatus: http.StatusServiceUnavailable,
 	}
 
+	ErrLimitsUnavailableError = &APIError{
+		Code:       ErrLimitsUnavailable,
+		Message:    "API key limits cannot be checked right now",
+		HTTPStatus: http.StatusServiceUnavailable,
+	}
+
 	ErrBadGatewayError = &APIError{
 		Code:       ErrBadGateway,
 		Message:    "Bad gateway",
 		HTTPStatus: http.StatusBadGateway,
 	}
+
+	ErrIdempotencyKeyInUseError = &APIError{
+		Code:       ErrIdempotencyKeyInUse,
+		Message:    "A request with this Idempotency-Key is still in progress",
+		HTTPStatus: http.StatusConflict,
+	}
+
+	ErrIdempotencyReplayTooLargeError = &APIError{
+		Code:       ErrIdempotencyReplayTooLarge,
+		Message:    "A request with this Idempotency-Key already completed; its response is too large to replay",
+		HTTPStatus: http.StatusConflict,
+	}
+
+	ErrReviewExistsError = &APIError{
+		Code:       ErrReviewExists,
+		Message:    "You have already reviewed this agent",
+		HTTPStatus: http.StatusConflict,
+	}
+
+	ErrReviewNotFoundError = &APIError{
+		Code:       ErrReviewNotFound,
+		Message:    "Review not found",
+		HTTPStatus: http.StatusNotFound,
+	}
+
+	ErrIdempotencyKeyMismatchError = &APIError{
+		Code:       ErrIdempotencyKeyMismatch,
+		Message:    "Idempotency-Key was already used with a different request",
+		HTTPStatus: http.StatusUnprocessableEntity,
+	}
 )
 
 // NewValidationError creates a validation error with details
@@ -256,6 +351,67 @@ func NewRateLimitError(retryAfterSeconds int64) *APIError {
 	}
 }
 
+// NewKeyRateLimitError creates an error for an API key over its own per-minute limit
+func NewKeyRateLimitError(retryAfterSeconds int64) *APIError {
+	return &APIError{
+		Code:       ErrKeyRateLimited,
+		Message:    fmt.Sprintf("API key rate limit exceeded. Retry after %d seconds", retryAfterSeconds),
+		Details:    map[string]int64{"retry_after_seconds": retryAfterSeconds},
+		HTTPStatus: http.StatusTooManyRequests,
+	}
+}
+
+// NewTokenRateLimitError creates a tokens-per-minute rate limit error for the given scope
+func NewTokenRateLimitError(scope string, retryAfterSeconds int64) *APIError {
+	return &APIError{
+		Code:       ErrTokenRateLimited,
+		Message:    fmt.Sprintf("Token rate limit exceeded. Retry after %d seconds", retryAfterSeconds),
+		Details:    map[string]interface{}{"scope": scope, "retry_after_seconds": retryAfterSeconds},
+		HTTPStatus: http.StatusTooManyRequests,
+	}
+}
+
+// NewAgentRateLimitError creates an error for a request over an agent's
+// creator-defined rate limit; scope is "agent" or "caller"
+func NewAgentRateLimitError(scope string, retryAfterSeconds int64) *APIError {
+	return &APIError{
+		Code:       ErrAgentRateLimited,
+		Message:    fmt.Sprintf("Agent rate limit exceeded. Retry after %d seconds", retryAfterSeconds),
+		Details:    map[string]interface{}{"scope": scope, "retry_after_seconds": retryAfterSeconds},
+		HTTPStatus: http.StatusTooManyRequests,
+	}
+}
+
+// NewAgentConcurrencyLimitError creates an error for an agent with too many requests in flight
+func NewAgentConcurrencyLimitError(limit int) *APIError {
+	return &APIError{
+		Code:       ErrAgentConcurrencyLimited,
+		Message:    fmt.Sprintf("Agent is handling its maximum of %d concurrent requests", limit),
+		Details:    map[string]int{"max_concurrent_requests": limit},
+		HTTPStatus: http.StatusTooManyRequests,
+	}
+}
+
+// NewKeyConcurrencyLimitError creates an error for an API key with too many requests in flight
+func NewKeyConcurrencyLimitError(limit int) *APIError {
+	return &APIError{
+		Code:       ErrKeyConcurrencyLimited,
+		Message:    "API key concurrent request limit reached",
+		Details:    map[string]int{"max_concurrent_requests": limit},
+		HTTPStatus: http.StatusTooManyRequests,
+	}
+}
+
+// NewKeySpendCapError creates an error for an API key that reached its daily or monthly spend cap
+func NewKeySpendCapError(period, unit string) *APIError {
+	return &APIError{
+		Code:       ErrKeySpendCapExceeded,
+		Message:    fmt.Sprintf("API key %s spend cap reached", period),
+		Details:    map[string]string{"period": period, "unit": unit},
+		HTTPStatus: http.StatusTooManyRequests,
+	}
+}
+
 // NewUpstreamError creates an upstream error with details
 func NewUpstreamError(provider string, statusCode int) *APIError {
 	return &APIError{
@@ -266,6 +422,20 @@ func NewUpstreamError(provider string, statusCode int) *APIError {
 	}
 }
 
+// NewKeyScopeDeniedError creates a key scope error with the reason and, if any, the missing permission
+func NewKeyScopeDeniedError(reason, permission string) *APIError {
+	details := map[string]string{"reason": reason}
+	if permission != "" {
+		details["permission"] = permission
+	}
+	return ErrKeyScopeDeniedError.WithDetails(details)
+}
+
+// NewRequestSourceDeniedError creates a 403 error for a request from a disallowed IP or origin
+func NewRequestSourceDeniedError(reason string) *APIError {
+	return ErrRequestSourceDeniedError.WithDetails(map[string]string{"reason": reason})
+}
+
 // NewNotFoundError creates a not found error for a specific resource
 func NewNotFoundError(resource string) *APIError {
 	return &APIError{
@@ -278,7 +448,9 @@ func NewNotFoundError(resource string) *APIError {
 // IsRetryable returns true if the error is retryable
 func IsRetryable(err *APIError) bool {
 	switch err.Code {
-	case ErrUpstreamTimeout, ErrUpstreamUnavailable, ErrCircuitBreakerOpen, ErrRateLimited:
+	case ErrUpstreamTimeout, ErrUpstreamUnavailable, ErrCircuitBreakerOpen, ErrLimitsUnavailable, ErrRateLimited,
+		ErrKeyRateLimited, ErrKeyConcurrencyLimited, ErrTokenRateLimited, ErrAgentRateLimited, ErrAgentConcurrencyLimited,
+		ErrIdempotencyKeyInUse:
 		return true
 	default:
 		return false
@@ -300,17 +472,23 @@ func GetHTTPStatusFromCode(code ErrorCode) int {
 	switch code {
 	case ErrInvalidRequest, ErrValidationFailed, ErrInvalidJSON, ErrMissingParameter:
 		return http.StatusBadRequest
-	case ErrUnauthorized, ErrInvalidCredentials, ErrTokenExpired, ErrInvalidAPIKey, ErrMissingAPIKey:
+	case ErrUnauthorized, ErrInvalidCredentials, ErrTokenExpired, ErrInvalidAPIKey, ErrMissingAPIKey, ErrAPIKeyExpired:
 		return http.StatusUnauthorized
-	case ErrForbidden, ErrAgentNotOwned, ErrAgentNotActive, ErrAccessDenied:
+	case ErrForbidden, ErrAgentNotOwned, ErrAgentNotActive, ErrAccessDenied, ErrKeyScopeDenied, ErrRequestSourceDenied,
+		ErrAccountSuspended, ErrAccountBanned, ErrReviewNotAllowed:
 		return http.StatusForbidden
-	case ErrNotFound, ErrAgentNotFound, ErrUserNotFound, ErrAPIKeyNotFound:
+	case ErrNotFound, ErrAgentNotFound, ErrUserNotFound, ErrAPIKeyNotFound, ErrReviewNotFound:
 		return http.StatusNotFound
-	case ErrQuotaExhausted, ErrRateLimited:
+	case ErrIdempotencyKeyInUse, ErrReviewExists, ErrIdempotencyReplayTooLarge:
+		return http.StatusConflict
+	case ErrIdempotencyKeyMismatch:
+		return http.StatusUnprocessableEntity
+	case ErrQuotaExhausted, ErrRateLimited, ErrKeyRateLimited, ErrKeyConcurrencyLimited, ErrKeySpendCapExceeded, ErrTokenRateLimited,
+		ErrAgentRateLimited, ErrAgentConcurrencyLimited:
 		return http.StatusTooManyRequests
 	case ErrBadGateway:
 		return http.StatusBadGateway
-	case ErrUpstreamUnavailable, ErrCircuitBreakerOpen:
+	case ErrUpstreamUnavailable, ErrCircuitBreakerOpen, ErrLimitsUnavailable:
 		return http.StatusServiceUnavailable
 	case ErrUpstreamTimeout:
 		return http.StatusGatewayTimeout
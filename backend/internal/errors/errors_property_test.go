@@ -99,10 +99,12 @@ func TestProperty_ErrorResponse_HTTPStatusMapping(t *testing.T) {
 		// Test 4xx client errors
 		clientErrorCodes := []ErrorCode{
 			ErrInvalidRequest, ErrValidationFailed, ErrInvalidJSON, ErrMissingParameter,
-			ErrUnauthorized, ErrInvalidCredentials, ErrTokenExpired, ErrInvalidAPIKey, ErrMissingAPIKey,
-			ErrForbidden, ErrAgentNotOwned, ErrAgentNotActive, ErrAccessDenied,
+			ErrUnauthorized, ErrInvalidCredentials, ErrTokenExpired, ErrInvalidAPIKey, ErrMissingAPIKey, ErrAPIKeyExpired,
+			ErrForbidden, ErrAgentNotOwned, ErrAgentNotActive, ErrAccessDenied, ErrKeyScopeDenied, ErrRequestSourceDenied,
 			ErrNotFound, ErrAgentNotFound, ErrUserNotFound, ErrAPIKeyNotFound,
-			ErrQuotaExhausted, ErrRateLimited,
+			ErrQuotaExhausted, ErrRateLimited, ErrKeyRateLimited, ErrKeyConcurrencyLimited, ErrKeySpendCapExceeded, ErrTokenRateLimited,
+			ErrAgentRateLimited, ErrAgentConcurrencyLimited, ErrIdempotencyKeyInUse, ErrIdempotencyKeyMismatch,
+			ErrReviewNotAllowed, ErrReviewNotFound, ErrReviewExists, ErrIdempotencyReplayTooLarge,
 		}
 
 		codeIdx := rapid.IntRange(0, len(clientErrorCodes)-1).Draw(rt, "clientCodeIdx")
@@ -122,7 +124,7 @@ func TestProperty_ErrorResponse_ServerErrorMapping(t *testing.T) {
 		// Test 5xx server errors
 		serverErrorCodes := []ErrorCode{
 			ErrInternalServer, ErrDatabaseError, ErrCacheError, ErrUpstreamError,
-			ErrBadGateway, ErrUpstreamUnavailable, ErrCircuitBreakerOpen, ErrUpstreamTimeout,
+			ErrBadGateway, ErrUpstreamUnavailable, ErrCircuitBreakerOpen, ErrLimitsUnavailable, ErrUpstreamTimeout,
 		}
 
 		codeIdx := rapid.IntRange(0, len(serverErrorCodes)-1).Draw(rt, "serverCodeIdx")
@@ -145,6 +147,7 @@ func TestProperty_ErrorResponse_RetryableErrors(t *testing.T) {
 		ErrUpstreamTimeoutError,
 		ErrUpstreamUnavailableError,
 		ErrCircuitBreakerOpenError,
+		ErrLimitsUnavailableError,
 		ErrRateLimitedError,
 	}
 
@@ -162,6 +165,7 @@ func TestProperty_ErrorResponse_RetryableErrors(t *testing.T) {
 		ErrAgentNotFoundError,
 		ErrQuotaExhaustedError,
 		ErrInternalServerError,
+		ErrIdempotencyReplayTooLargeError,
 	}
 
 	for _, err := range nonRetryableErrors {
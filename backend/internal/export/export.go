@@ -0,0 +1,436 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Format is the file format of an export
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	if f == FormatJSONL {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// maxRange bounds the period of one export
+const maxRange = 366 * 24 * time.Hour
+
+// defaultRange is the period exported when no start is given
+const defaultRange = 30 * 24 * time.Hour
+
+// Export errors
+var (
+	ErrUnknownDataset = errors.New("unknown dataset")
+	ErrInvalidFormat  = errors.New("format must be csv or jsonl")
+	ErrUnknownColumn  = errors.New("unknown column")
+	ErrInvalidRange   = errors.New("from must be before to")
+	ErrRangeTooLarge  = errors.New("date range cannot exceed 366 days")
+	ErrTooManyRows    = errors.New("export is too large to stream")
+)
+
+// Column is one exported field. Every value is selected as text; numeric
+// columns are written to JSONL as numbers.
+type Column struct {
+	Name    string
+	expr    string
+	numeric bool
+}
+
+// Dataset is a table a user can export their own rows of
+type Dataset struct {
+	Name    string
+	Role    models.UserType
+	Columns []Column
+	from    string // FROM clause; the exported table is aliased t
+	owner   string // Column that must equal the exporting user's ID
+}
+
+// timestamp formats a timestamp column as RFC 3339 in UTC
+func timestamp(expr string) string {
+	return `to_char(` + expr + ` AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"')`
+}
+
+// datasets are the exports available to developers (their calls, payments
+// and quota ledger) and creators (their agents' calls and settlements)
+var datasets = []*Dataset{
+	{
+		Name:  "calls",
+		Role:  models.UserTypeDeveloper,
+		from:  "call_logs t",
+		owner: "t.user_id",
+		Columns: []Column{
+			{Name: "id", expr: "t.id::text"},
+			{Name: "created_at", expr: timestamp("t.created_at")},
+			{Name: "request_id", expr: "t.request_id"},
+			{Name: "trace_id", expr: "t.trace_id"},
+			{Name: "agent_id", expr: "t.agent_id::text"},
+			{Name: "api_key_id", expr: "t.api_key_id::text"},
+			{Name: "status", expr: "t.status"},
+			{Name: "error_code", expr: "t.error_code"},
+			{Name: "input_tokens", expr: "t.input_tokens::text", numeric: true},
+			{Name: "output_tokens", expr: "t.output_tokens::text", numeric: true},
+			{Name: "latency_ms", expr: "t.latency_ms::text", numeric: true},
+			{Name: "cost_usd", expr: "t.cost_usd::text", numeric: true},
+		},
+	},
+	{
+		Name:  "payments",
+		Role:  models.UserTypeDeveloper,
+		from:  "payments t",
+		owner: "t.user_id",
+		Columns: []Column{
+			{Name: "id", expr: "t.id::text"},
+			{Name: "created_at", expr: timestamp("t.created_at")},
+			{Name: "amount_usd", expr: "t.amount_usd::text", numeric: true},
+			{Name: "quota_purchased", expr: "t.quota_purchased::text", numeric: true},
+			{Name: "payment_method", expr: "t.payment_method"},
+			{Name: "status", expr: "t.status"},
+			{Name: "completed_at", expr: timestamp("t.completed_at")},
+			{Name: "failed_at", expr: timestamp("t.failed_at")},
+			{Name: "failure_reason", expr: "t.failure_reason"},
+		},
+	},
+	{
+		Name:  "quota_ledger",
+		Role:  models.UserTypeDeveloper,
+		from:  "quota_ledger t",
+		owner: "t.user_id",
+		Columns: []Column{
+			{Name: "id", expr: "t.id::text", numeric: true},
+			{Name: "created_at", expr: timestamp("t.created_at")},
+			{Name: "entry_type", expr: "t.entry_type"},
+			{Name: "amount", expr: "t.amount::text", numeric: true},
+			{Name: "balance_after", expr: "t.balance_after::text", numeric: true},
+			{Name: "payment_id", expr: "t.payment_id::text"},
+			{Name: "request_id", expr: "t.request_id"},
+			{Name: "agent_id", expr: "t.agent_id::text"},
+			{Name: "note", expr: "t.note"},
+		},
+	},
+	{
+		// Creators see calls to their agents, but not who made them
+		Name:  "agent_calls",
+		Role:  models.UserTypeCreator,
+		from:  "call_logs t JOIN agents a ON a.id = t.agent_id",
+		owner: "a.creator_id",
+		Columns: []Column{
+			{Name: "id", expr: "t.id::text"},
+			{Name: "created_at", expr: timestamp("t.created_at")},
+			{Name: "agent_id", expr: "t.agent_id::text"},
+			{Name: "agent_name", expr: "a.name"},
+			{Name: "status", expr: "t.status"},
+			{Name: "error_code", expr: "t.error_code"},
+			{Name: "input_tokens", expr: "t.input_tokens::text", numeric: true},
+			{Name: "output_tokens", expr: "t.output_tokens::text", numeric: true},
+			{Name: "latency_ms", expr: "t.latency_ms::text", numeric: true},
+			{Name: "revenue_usd", expr: "t.cost_usd::text", numeric: true},
+		},
+	},
+	{
+		Name:  "settlements",
+		Role:  models.UserTypeCreator,
+		from:  "settlements t",
+		owner: "t.creator_id",
+		Columns: []Column{
+			{Name: "id", expr: "t.id::text"},
+			{Name: "created_at", expr: timestamp("t.created_at")},
+			{Name: "amount", expr: "t.amount::text", numeric: true},
+			{Name: "platform_fee", expr: "t.platform_fee::text", numeric: true},
+			{Name: "net_amount", expr: "t.net_amount::text", numeric: true},
+			{Name: "status", expr: "t.status"},
+			{Name: "tx_hash", expr: "t.tx_hash"},
+			{Name: "settled_at", expr: timestamp("t.settled_at")},
+		},
+	},
+}
+
+// DatasetInfo describes a dataset to API clients
+type DatasetInfo struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"`
+}
+
+// Datasets returns the datasets a role can export
+func Datasets(role models.UserType) []DatasetInfo {
+	var infos []DatasetInfo
+	for _, d := range datasets {
+		if d.Role != role {
+			continue
+		}
+		info := DatasetInfo{Name: d.Name}
+		for _, col := range d.Columns {
+			info.Columns = append(info.Columns, col.Name)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// Spec describes an export. Empty columns export all of the dataset's
+// columns; a zero To is now and a zero From is 30 days before To.
+type Spec struct {
+	Dataset string    `json:"dataset" binding:"required"`
+	Format  Format    `json:"format"`
+	Columns []string  `json:"columns"`
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+}
+
+// plan is a validated export of one user's rows
+type plan struct {
+	dataset *Dataset
+	columns []Column
+	format  Format
+	userID  uuid.UUID
+	from    time.Time
+	to      time.Time
+}
+
+// newPlan validates a spec against the datasets the role can export and
+// fills in its defaults
+func newPlan(userID uuid.UUID, role models.UserType, spec *Spec) (*plan, error) {
+	p := &plan{userID: userID, format: spec.Format, from: spec.From.UTC(), to: spec.To.UTC()}
+
+	for _, d := range datasets {
+		if d.Name == spec.Dataset && d.Role == role {
+			p.dataset = d
+		}
+	}
+	if p.dataset == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownDataset, spec.Dataset)
+	}
+
+	switch p.format {
+	case "":
+		p.format = FormatCSV
+	case FormatCSV, FormatJSONL:
+	default:
+		return nil, ErrInvalidFormat
+	}
+
+	if len(spec.Columns) == 0 {
+		p.columns = p.dataset.Columns
+	}
+	seen := make(map[string]bool, len(spec.Columns))
+	for _, name := range spec.Columns {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		found := false
+		for _, col := range p.dataset.Columns {
+			if col.Name == name {
+				p.columns = append(p.columns, col)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownColumn, name)
+		}
+	}
+
+	if p.to.IsZero() {
+		p.to = time.Now().UTC()
+	}
+	if p.from.IsZero() {
+		p.from = p.to.Add(-defaultRange)
+	}
+	if !p.from.Before(p.to) {
+		return nil, ErrInvalidRange
+	}
+	if p.to.Sub(p.from) > maxRange {
+		return nil, ErrRangeTooLarge
+	}
+	return p, nil
+}
+
+// columnNames returns the names of the plan's columns
+func (p *plan) columnNames() []string {
+	names := make([]string, len(p.columns))
+	for i, col := range p.columns {
+		names[i] = col.Name
+	}
+	return names
+}
+
+// where is the filter shared by the count and the export; $1 is the user,
+// $2 and $3 the period
+func (p *plan) where() string {
+	return ` FROM ` + p.dataset.from + ` WHERE ` + p.dataset.owner + ` = $1 AND t.created_at >= $2 AND t.created_at < $3`
+}
+
+// querier is implemented by both the connection pool and a transaction
+type querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// exceeds reports whether the export has more than limit rows, counting at
+// most limit+1 of them
+func (p *plan) exceeds(ctx context.Context, q querier, limit int64) (bool, error) {
+	var n int64
+	err := q.QueryRow(ctx, `SELECT COUNT(*) FROM (SELECT 1`+p.where()+` LIMIT $4) s`,
+		p.userID, p.from, p.to, limit+1).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("failed to count export rows: %w", err)
+	}
+	return n > limit, nil
+}
+
+// write streams the export to w in time order and returns the number of rows
+func (p *plan) write(ctx context.Context, q querier, w io.Writer) (int64, error) {
+	exprs := make([]string, len(p.columns))
+	for i, col := range p.columns {
+		exprs[i] = col.expr
+	}
+	rows, err := q.Query(ctx, `SELECT `+strings.Join(exprs, ", ")+p.where()+` ORDER BY t.created_at, t.id`,
+		p.userID, p.from, p.to)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query export: %w", err)
+	}
+	defer rows.Close()
+
+	enc := newEncoder(p.format, w, p.columns)
+	if err := enc.header(); err != nil {
+		return 0, err
+	}
+	var n int64
+	values := make([]*string, len(p.columns))
+	dest := make([]any, len(p.columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return n, fmt.Errorf("failed to scan export row: %w", err)
+		}
+		if err := enc.row(values); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, fmt.Errorf("failed to read export: %w", err)
+	}
+	return n, enc.flush()
+}
+
+// encoder writes rows of nullable text values in an export format
+type encoder interface {
+	header() error
+	row(values []*string) error
+	flush() error
+}
+
+func newEncoder(format Format, w io.Writer, columns []Column) encoder {
+	if format == FormatJSONL {
+		return &jsonlEncoder{w: bufio.NewWriter(w), columns: columns}
+	}
+	return &csvEncoder{w: csv.NewWriter(w), columns: columns}
+}
+
+// csvEncoder writes a header row then one record per row; NULL is empty
+type csvEncoder struct {
+	w       *csv.Writer
+	columns []Column
+	record  []string
+}
+
+func (e *csvEncoder) header() error {
+	names := make([]string, len(e.columns))
+	for i, col := range e.columns {
+		names[i] = col.Name
+	}
+	return e.w.Write(names)
+}
+
+func (e *csvEncoder) row(values []*string) error {
+	e.record = e.record[:0]
+	for i, v := range values {
+		switch {
+		case v == nil:
+			e.record = append(e.record, "")
+		case !e.columns[i].numeric:
+			e.record = append(e.record, escapeFormula(*v))
+		default:
+			e.record = append(e.record, *v)
+		}
+	}
+	return e.w.Write(e.record)
+}
+
+func (e *csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// escapeFormula keeps text that spreadsheets would evaluate as a formula,
+// such as a client-chosen request ID, from being evaluated when a CSV
+// export is opened
+func escapeFormula(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+// jsonlEncoder writes one JSON object per line, with keys in column order
+type jsonlEncoder struct {
+	w       *bufio.Writer
+	columns []Column
+}
+
+func (e *jsonlEncoder) header() error {
+	return nil
+}
+
+func (e *jsonlEncoder) row(values []*string) error {
+	e.w.WriteByte('{')
+	for i, v := range values {
+		if i > 0 {
+			e.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(e.columns[i].Name)
+		e.w.Write(key)
+		e.w.WriteByte(':')
+		switch {
+		case v == nil:
+			e.w.WriteString("null")
+		case e.columns[i].numeric:
+			e.w.WriteString(*v)
+		default:
+			b, err := json.Marshal(*v)
+			if err != nil {
+				return fmt.Errorf("failed to encode export value: %w", err)
+			}
+			e.w.Write(b)
+		}
+	}
+	_, err := e.w.WriteString("}\n")
+	return err
+}
+
+func (e *jsonlEncoder) flush() error {
+	return e.w.Flush()
+}
@@ -0,0 +1,401 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+// JobStatus is the state of a background export
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+)
+
+const (
+	// MaxActiveJobs is how many unfinished exports a user may have queued
+	MaxActiveJobs = 3
+	// chunkSize is the size of the pieces an export file is stored in
+	chunkSize = 1 << 20
+	// staleAfter is how long a running job must have been started before it
+	// is reclaimed. Jobs being worked on are locked and never reclaimed; this
+	// only has to outlast the gap between claiming a job and locking it.
+	staleAfter = 5 * time.Minute
+)
+
+// Job errors
+var (
+	ErrJobNotFound = errors.New("export job not found")
+	ErrJobNotReady = errors.New("export job has not completed")
+	ErrJobExpired  = errors.New("export job has expired")
+	ErrTooManyJobs = errors.New("too many export jobs in progress")
+)
+
+// Job is a background export whose file is kept for download until it expires
+type Job struct {
+	ID          uuid.UUID  `json:"id"`
+	Dataset     string     `json:"dataset"`
+	Format      Format     `json:"format"`
+	Columns     []string   `json:"columns"`
+	From        time.Time  `json:"from"`
+	To          time.Time  `json:"to"`
+	Status      JobStatus  `json:"status"`
+	RowCount    *int64     `json:"row_count,omitempty"`
+	SizeBytes   *int64     `json:"size_bytes,omitempty"`
+	Error       *string    `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	DownloadURL string     `json:"download_url,omitempty"` // Set by the API once completed
+}
+
+const jobColumns = `id, dataset, format, columns, from_time, to_time, status, row_count, size_bytes,
+	error, created_at, started_at, completed_at, expires_at`
+
+func scanJob(row pgx.Row) (*Job, error) {
+	var job Job
+	err := row.Scan(&job.ID, &job.Dataset, &job.Format, &job.Columns, &job.From, &job.To, &job.Status,
+		&job.RowCount, &job.SizeBytes, &job.Error, &job.CreatedAt, &job.StartedAt, &job.CompletedAt, &job.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Service streams small exports directly and runs large ones as background
+// jobs whose output is stored in the database, so any replica can serve it
+type Service struct {
+	db     *pgxpool.Pool
+	config *config.ExportConfig
+}
+
+// NewService creates a new export service
+func NewService(db *pgxpool.Pool, cfg *config.ExportConfig) *Service {
+	return &Service{
+		db:     db,
+		config: cfg,
+	}
+}
+
+// Stream writes an export to w once start has been called. Start is called,
+// with the validated format, only if the export is valid and has at most
+// the configured number of rows, so errors can still be reported before.
+func (s *Service) Stream(ctx context.Context, userID uuid.UUID, role models.UserType, spec *Spec,
+	w io.Writer, start func(Format)) error {
+	p, err := newPlan(userID, role, spec)
+	if err != nil {
+		return err
+	}
+	tooMany, err := p.exceeds(ctx, s.db, s.config.MaxStreamRows)
+	if err != nil {
+		return err
+	}
+	if tooMany {
+		return ErrTooManyRows
+	}
+
+	start(p.format)
+	_, err = p.write(ctx, s.db, w)
+	return err
+}
+
+// CreateJob validates an export and queues it for the worker
+func (s *Service) CreateJob(ctx context.Context, userID uuid.UUID, role models.UserType, spec *Spec) (*Job, error) {
+	p, err := newPlan(userID, role, spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var active int
+	err = s.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM export_jobs WHERE user_id = $1 AND status IN ('pending', 'running')
+	`, userID).Scan(&active)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count export jobs: %w", err)
+	}
+	if active >= MaxActiveJobs {
+		return nil, ErrTooManyJobs
+	}
+
+	job, err := scanJob(s.db.QueryRow(ctx, `
+		INSERT INTO export_jobs (user_id, dataset, format, columns, from_time, to_time)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+jobColumns,
+		userID, p.dataset.Name, p.format, p.columnNames(), p.from, p.to))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export job: %w", err)
+	}
+	return job, nil
+}
+
+// ListJobs returns a user's most recent export jobs
+func (s *Service) ListJobs(ctx context.Context, userID uuid.UUID, limit int) ([]*Job, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT `+jobColumns+` FROM export_jobs
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list export jobs: %w", err)
+	}
+	defer rows.Close()
+
+	jobs := []*Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan export job: %w", err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// GetJob returns one of a user's export jobs
+func (s *Service) GetJob(ctx context.Context, userID, jobID uuid.UUID) (*Job, error) {
+	job, err := scanJob(s.db.QueryRow(ctx, `
+		SELECT `+jobColumns+` FROM export_jobs WHERE id = $1 AND user_id = $2
+	`, jobID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get export job: %w", err)
+	}
+	return job, nil
+}
+
+// Download writes a completed export's file to w once start has been called
+// with the job. Errors found before start can still be reported.
+func (s *Service) Download(ctx context.Context, userID, jobID uuid.UUID, w io.Writer, start func(*Job)) error {
+	job, err := s.GetJob(ctx, userID, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Status != JobCompleted {
+		return ErrJobNotReady
+	}
+	if job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt) {
+		return ErrJobExpired
+	}
+
+	rows, err := s.db.Query(ctx, `SELECT data FROM export_job_chunks WHERE job_id = $1 ORDER BY seq`, jobID)
+	if err != nil {
+		return fmt.Errorf("failed to read export: %w", err)
+	}
+	defer rows.Close()
+
+	start(job)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return fmt.Errorf("failed to scan export chunk: %w", err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// RunWorker runs queued export jobs and removes expired ones every interval
+// until the context is cancelled. Does nothing if the worker is disabled.
+func (s *Service) RunWorker(ctx context.Context) {
+	if s.config.WorkerInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.WorkerInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.ProcessDue(ctx); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to process export jobs")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue runs queued export jobs, one at a time, until none are left,
+// then deletes expired exports
+func (s *Service) ProcessDue(ctx context.Context) error {
+	for {
+		job, userID, err := s.claim(ctx)
+		if err != nil {
+			return err
+		}
+		if job == nil {
+			break
+		}
+		if err := s.run(ctx, job, userID); err != nil {
+			if ctx.Err() != nil {
+				// Shutting down; the job is reclaimed once stale
+				return ctx.Err()
+			}
+			log.Error().Err(err).Str("job_id", job.ID.String()).Msg("Export job failed")
+			_, ferr := s.db.Exec(ctx, `
+				UPDATE export_jobs
+				SET status = 'failed', error = 'export failed', completed_at = NOW(), expires_at = NOW() + $2::interval
+				WHERE id = $1
+			`, job.ID, s.config.Retention)
+			if ferr != nil {
+				return fmt.Errorf("failed to mark export job failed: %w", ferr)
+			}
+		}
+	}
+
+	if _, err := s.db.Exec(ctx, `DELETE FROM export_jobs WHERE expires_at < NOW()`); err != nil {
+		return fmt.Errorf("failed to delete expired exports: %w", err)
+	}
+	return nil
+}
+
+// claim marks the oldest queued job, or a job whose worker died, as running.
+// Returns a nil job if there is none.
+func (s *Service) claim(ctx context.Context) (*Job, uuid.UUID, error) {
+	var userID uuid.UUID
+	var job Job
+	err := s.db.QueryRow(ctx, `
+		UPDATE export_jobs SET status = 'running', started_at = NOW()
+		WHERE id = (
+			SELECT id FROM export_jobs
+			WHERE status = 'pending' OR (status = 'running' AND started_at < NOW() - $1::interval)
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, user_id, dataset, format, columns, from_time, to_time
+	`, staleAfter).Scan(&job.ID, &userID, &job.Dataset, &job.Format, &job.Columns, &job.From, &job.To)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, uuid.Nil, nil
+	}
+	if err != nil {
+		return nil, uuid.Nil, fmt.Errorf("failed to claim export job: %w", err)
+	}
+	return &job, userID, nil
+}
+
+// run writes a claimed job's export in one transaction that holds the job's
+// row lock, so the job is not reclaimed while it runs and its file appears
+// only once complete
+func (s *Service) run(ctx context.Context, job *Job, userID uuid.UUID) error {
+	role := models.UserType("")
+	for _, d := range datasets {
+		if d.Name == job.Dataset {
+			role = d.Role
+		}
+	}
+	p, err := newPlan(userID, role, &Spec{
+		Dataset: job.Dataset,
+		Format:  job.Format,
+		Columns: job.Columns,
+		From:    job.From,
+		To:      job.To,
+	})
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `SELECT 1 FROM export_jobs WHERE id = $1 FOR UPDATE`, job.ID)
+	if err != nil {
+		return fmt.Errorf("failed to lock export job: %w", err)
+	}
+	// Remove anything left by a worker that died
+	if _, err := tx.Exec(ctx, `DELETE FROM export_job_chunks WHERE job_id = $1`, job.ID); err != nil {
+		return fmt.Errorf("failed to clear export chunks: %w", err)
+	}
+
+	// Rows are read on a separate connection while the transaction's
+	// connection stores the chunks
+	cw := &chunkWriter{ctx: ctx, tx: tx, jobID: job.ID}
+	n, err := p.write(ctx, s.db, cw)
+	if err != nil {
+		return err
+	}
+	if err := cw.flush(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE export_jobs
+		SET status = 'completed', row_count = $2, size_bytes = $3, completed_at = NOW(), expires_at = NOW() + $4::interval
+		WHERE id = $1
+	`, job.ID, n, cw.size, s.config.Retention)
+	if err != nil {
+		return fmt.Errorf("failed to complete export job: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Info().Str("job_id", job.ID.String()).Int64("rows", n).Int64("bytes", cw.size).Msg("Export job completed")
+	return nil
+}
+
+// chunkWriter stores what is written to it as numbered chunks of an export
+type chunkWriter struct {
+	ctx   context.Context
+	tx    pgx.Tx
+	jobID uuid.UUID
+	buf   bytes.Buffer
+	seq   int
+	size  int64
+}
+
+func (w *chunkWriter) Write(b []byte) (int, error) {
+	w.buf.Write(b)
+	for w.buf.Len() >= chunkSize {
+		if err := w.store(w.buf.Next(chunkSize)); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// flush stores whatever is buffered as the last chunk
+func (w *chunkWriter) flush() error {
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	return w.store(w.buf.Next(w.buf.Len()))
+}
+
+func (w *chunkWriter) store(data []byte) error {
+	_, err := w.tx.Exec(w.ctx, `INSERT INTO export_job_chunks (job_id, seq, data) VALUES ($1, $2, $3)`,
+		w.jobID, w.seq, data)
+	if err != nil {
+		return fmt.Errorf("failed to store export chunk: %w", err)
+	}
+	w.seq++
+	w.size += int64(len(data))
+	return nil
+}
@@ -0,0 +1,265 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aimerfeng/AgentLink/internal/alerts"
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Service errors
+var (
+	ErrQuotaNotFound       = errors.New("quota record not found")
+	ErrInvalidAdjustment   = errors.New("adjustment amount must be non-zero")
+	ErrReasonRequired      = errors.New("adjustment reason is required")
+	ErrInsufficientBalance = errors.New("adjustment would make the balance negative")
+)
+
+// Querier is implemented by both the connection pool and a transaction, so
+// ledger entries can be written in the same transaction as the quota change
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// NotifyQuotaChange tells proxies to drop their cached balance of the user.
+// Run it in the transaction changing the quota: the notification is sent once
+// it commits, so the next call reloads the balance from the database.
+func NotifyQuotaChange(ctx context.Context, q Querier, userID uuid.UUID) error {
+	if _, err := q.Exec(ctx, `SELECT pg_notify('cache_invalidation', $1)`, "quota:"+userID.String()); err != nil {
+		return fmt.Errorf("failed to notify quota change: %w", err)
+	}
+	return nil
+}
+
+// Record appends an entry to the quota ledger. It must run after the quota
+// change it describes, in the same transaction, so balance_after reflects it.
+func Record(ctx context.Context, q Querier, entry *models.QuotaLedgerEntry) error {
+	err := q.QueryRow(ctx, `
+		INSERT INTO quota_ledger (user_id, entry_type, amount, balance_after, payment_id, request_id, agent_id, note, created_by)
+		SELECT $1, $2, $3,
+		       (SELECT total_quota + free_quota - used_quota FROM quotas WHERE user_id = $1),
+		       $4, $5, $6, $7, $8
+		RETURNING id, balance_after, created_at
+	`, entry.UserID, entry.EntryType, entry.Amount, entry.PaymentID, entry.RequestID,
+		entry.AgentID, entry.Note, entry.CreatedBy,
+	).Scan(&entry.ID, &entry.BalanceAfter, &entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record quota ledger entry: %w", err)
+	}
+	return nil
+}
+
+// Service handles quota ledger queries and admin adjustments
+type Service struct {
+	db *pgxpool.Pool
+}
+
+// NewService creates a new ledger service
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{db: db}
+}
+
+// LedgerResponse represents a page of a user's ledger, newest first
+type LedgerResponse struct {
+	Entries  []models.QuotaLedgerEntry `json:"entries"`
+	Balance  int64                     `json:"balance"`
+	Total    int                       `json:"total"`
+	Page     int                       `json:"page"`
+	PageSize int                       `json:"page_size"`
+}
+
+// ListEntries returns a page of a user's ledger entries, newest first
+func (s *Service) ListEntries(ctx context.Context, userID uuid.UUID, page, pageSize int) (*LedgerResponse, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	offset := (page - 1) * pageSize
+
+	var total int
+	var balance int64
+	err := s.db.QueryRow(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM quota_ledger WHERE user_id = $1
+	`, userID).Scan(&total, &balance)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count ledger entries: %w", err)
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT id, user_id, entry_type, amount, balance_after, payment_id, request_id,
+		       agent_id, note, created_by, created_at
+		FROM quota_ledger
+		WHERE user_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`, userID, pageSize, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ledger entries: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]models.QuotaLedgerEntry, 0, pageSize)
+	for rows.Next() {
+		var e models.QuotaLedgerEntry
+		err := rows.Scan(
+			&e.ID, &e.UserID, &e.EntryType, &e.Amount, &e.BalanceAfter, &e.PaymentID,
+			&e.RequestID, &e.AgentID, &e.Note, &e.CreatedBy, &e.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ledger entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate ledger entries: %w", err)
+	}
+
+	return &LedgerResponse{
+		Entries:  entries,
+		Balance:  balance,
+		Total:    total,
+		Page:     page,
+		PageSize: pageSize,
+	}, nil
+}
+
+// Adjust credits (positive amount) or debits (negative amount) a user's
+// purchased quota on behalf of an admin and records why
+func (s *Service) Adjust(ctx context.Context, userID, adminID uuid.UUID, amount int64, reason string) (*models.QuotaLedgerEntry, error) {
+	if amount == 0 {
+		return nil, ErrInvalidAdjustment
+	}
+	if reason == "" {
+		return nil, ErrReasonRequired
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var remaining int64
+	err = tx.QueryRow(ctx, `
+		SELECT total_quota + free_quota - used_quota FROM quotas WHERE user_id = $1 FOR UPDATE
+	`, userID).Scan(&remaining)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrQuotaNotFound
+		}
+		return nil, fmt.Errorf("failed to get quota: %w", err)
+	}
+	if remaining+amount < 0 {
+		return nil, ErrInsufficientBalance
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE quotas SET total_quota = total_quota + $1, updated_at = NOW() WHERE user_id = $2
+	`, amount, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to adjust quota: %w", err)
+	}
+
+	entry := &models.QuotaLedgerEntry{
+		UserID:    userID,
+		EntryType: models.QuotaLedgerAdminAdjustment,
+		Amount:    amount,
+		Note:      &reason,
+		CreatedBy: &adminID,
+	}
+	if err := Record(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+
+	if err := NotifyQuotaChange(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	// A credit is a top-up that re-arms alerts; a debit may fire them
+	if amount > 0 {
+		err = alerts.Rearm(ctx, tx, userID)
+	} else {
+		err = alerts.Evaluate(ctx, tx, userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return entry, nil
+}
+
+// Balance compares a user's ledger balance with the balance in the quotas table
+type Balance struct {
+	UserID        uuid.UUID `json:"user_id"`
+	LedgerBalance int64     `json:"ledger_balance"`
+	QuotaBalance  int64     `json:"quota_balance"`
+}
+
+// Drifted reports whether the quotas table disagrees with the ledger
+func (b *Balance) Drifted() bool {
+	return b.LedgerBalance != b.QuotaBalance
+}
+
+const balanceColumns = `q.user_id,
+	COALESCE((SELECT SUM(l.amount) FROM quota_ledger l WHERE l.user_id = q.user_id), 0),
+	q.total_quota + q.free_quota - q.used_quota`
+
+// ListBalances returns the ledger and quotas table balances of up to limit
+// users after the given user ID, in user ID order
+func (s *Service) ListBalances(ctx context.Context, after uuid.UUID, limit int) ([]Balance, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+balanceColumns+`
+		FROM quotas q
+		WHERE q.user_id > $1
+		ORDER BY q.user_id
+		LIMIT $2
+	`, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query balances: %w", err)
+	}
+	return scanBalances(rows)
+}
+
+// GetBalances returns the ledger and quotas table balances of the given
+// users, in user ID order
+func (s *Service) GetBalances(ctx context.Context, userIDs []uuid.UUID) ([]Balance, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+balanceColumns+`
+		FROM quotas q
+		WHERE q.user_id = ANY($1)
+		ORDER BY q.user_id
+	`, userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query balances: %w", err)
+	}
+	return scanBalances(rows)
+}
+
+func scanBalances(rows pgx.Rows) ([]Balance, error) {
+	defer rows.Close()
+
+	var balances []Balance
+	for rows.Next() {
+		var b Balance
+		if err := rows.Scan(&b.UserID, &b.LedgerBalance, &b.QuotaBalance); err != nil {
+			return nil, fmt.Errorf("failed to scan balance: %w", err)
+		}
+		balances = append(balances, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate balances: %w", err)
+	}
+	return balances, nil
+}
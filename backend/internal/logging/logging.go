@@ -76,6 +76,7 @@ func RequestLogger() gin.HandlerFunc {
 		// Log request details
 		event.
 			Str("request_id", requestID.(string)).
+			Str("trace_id", c.GetString("trace_id")).
 			Str("method", c.Request.Method).
 			Str("path", path).
 			Str("query", raw).
@@ -0,0 +1,272 @@
+package marketplace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/usage"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrAgentNotFound is returned for agents that do not exist or are not active
+var ErrAgentNotFound = errors.New("agent not found")
+
+// CallStats summarizes calls to an agent over a rolling window
+type CallStats struct {
+	Calls int64 `json:"calls"`
+	// SuccessRate is successful calls over calls; nil without calls
+	SuccessRate *float64 `json:"success_rate"`
+	// Latency percentiles of successful calls, estimated from the usage
+	// latency histograms; nil without them
+	P50LatencyMs *int `json:"p50_latency_ms"`
+	P95LatencyMs *int `json:"p95_latency_ms"`
+}
+
+// maxCachedStats is the most agents whose call statistics are cached
+const maxCachedStats = 10000
+
+// statsCache holds an agent's call statistics until they expire
+type statsCache struct {
+	week, month CallStats
+	expires     time.Time
+}
+
+// VersionInfo is when a version of an agent was released
+type VersionInfo struct {
+	Version    int       `json:"version"`
+	ReleasedAt time.Time `json:"released_at"`
+}
+
+// AgentDetail is the public profile of an agent. It never includes the agent's config.
+type AgentDetail struct {
+	AgentSummary
+	Version    int       `json:"version"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	TrialCalls int       `json:"trial_calls"` // Free calls per developer; 0 when trial is disabled
+	// RatingDistribution counts approved reviews by rating, 1 to 5
+	RatingDistribution map[int]int64 `json:"rating_distribution"`
+	Stats7d            CallStats     `json:"stats_7d"`
+	Stats30d           CallStats     `json:"stats_30d"`
+	Versions           []VersionInfo `json:"versions"` // Newest first
+}
+
+// GetAgent returns the public profile of an active agent with its rating
+// distribution, call statistics and version history
+func (s *Service) GetAgent(ctx context.Context, agentID uuid.UUID) (*AgentDetail, error) {
+	var d AgentDetail
+	var bio, avatarURL *string
+	a, err := scanSummary(s.db.QueryRow(ctx, `
+		SELECT `+summaryColumns+`, cp.bio, cp.avatar_url, COALESCE(a.version, 1), a.updated_at
+		FROM agents a
+		LEFT JOIN creator_profiles cp ON cp.user_id = a.creator_id
+		WHERE a.id = $1 AND a.status = 'active'
+	`, agentID), &bio, &avatarURL, &d.Version, &d.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAgentNotFound
+		}
+		return nil, fmt.Errorf("failed to get agent: %w", err)
+	}
+	d.AgentSummary = *a
+	d.Creator.Bio, d.Creator.AvatarURL = bio, avatarURL
+
+	if d.RatingDistribution, err = s.ratingDistribution(ctx, agentID); err != nil {
+		return nil, err
+	}
+	if d.Stats7d, d.Stats30d, err = s.cachedCallStats(ctx, agentID); err != nil {
+		return nil, err
+	}
+	if d.Versions, err = s.versions(ctx, agentID); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// ratingDistribution counts an agent's approved reviews by rating
+func (s *Service) ratingDistribution(ctx context.Context, agentID uuid.UUID) (map[int]int64, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT rating, COUNT(*)
+		FROM reviews
+		WHERE agent_id = $1 AND status = 'approved'
+		GROUP BY rating
+	`, agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rating distribution: %w", err)
+	}
+	defer rows.Close()
+
+	// Every rating is listed so gaps show as zeros
+	dist := map[int]int64{1: 0, 2: 0, 3: 0, 4: 0, 5: 0}
+	for rows.Next() {
+		var rating int
+		var count int64
+		if err := rows.Scan(&rating, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan rating distribution: %w", err)
+		}
+		dist[rating] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get rating distribution: %w", err)
+	}
+	return dist, nil
+}
+
+// cachedCallStats returns an agent's call statistics over the last 7 and 30
+// days. They are recomputed at most once per StatsCacheTTL.
+func (s *Service) cachedCallStats(ctx context.Context, agentID uuid.UUID) (week, month CallStats, err error) {
+	now := time.Now()
+	s.mu.Lock()
+	cached, ok := s.stats[agentID]
+	s.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.week, cached.month, nil
+	}
+
+	watermark, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return week, month, err
+	}
+	if err := s.callStats(ctx, agentID, now, watermark, &week, &month); err != nil {
+		return week, month, err
+	}
+	if s.config.StatsCacheTTL <= 0 {
+		return week, month, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.stats) >= maxCachedStats {
+		for id, c := range s.stats {
+			if !now.Before(c.expires) {
+				delete(s.stats, id)
+			}
+		}
+		if len(s.stats) >= maxCachedStats {
+			s.stats = make(map[uuid.UUID]*statsCache)
+		}
+	}
+	s.stats[agentID] = &statsCache{week: week, month: month, expires: now.Add(s.config.StatsCacheTTL)}
+	return week, month, nil
+}
+
+// callStats fills the call statistics of an agent over the last 7 and 30
+// days. Calls are read from the hourly usage rollups before the watermark,
+// plus call logs not yet rolled up, so both windows start on the hour. A nil
+// watermark reads only call logs.
+func (s *Service) callStats(ctx context.Context, agentID uuid.UUID, now time.Time, watermark *time.Time, week, month *CallStats) error {
+	weekFrom := now.UTC().AddDate(0, 0, -7).Truncate(time.Hour)
+	monthFrom := now.UTC().AddDate(0, 0, -30).Truncate(time.Hour)
+
+	// Calls are counted once per source row, on its first histogram bucket
+	rows, err := s.db.Query(ctx, `
+		WITH src AS (
+			SELECT bucket_start >= $2 AS recent, status, calls, latency_histogram
+			FROM call_usage_hourly
+			WHERE agent_id = $1 AND bucket_start >= $3
+			  AND $4::timestamptz IS NOT NULL AND bucket_start < $4::timestamptz
+			UNION ALL
+			SELECT created_at >= $2, COALESCE(status, 'unknown'), COUNT(*), `+usage.LatencyHistogramExpr+`
+			FROM call_logs
+			WHERE agent_id = $1 AND created_at >= GREATEST($3, $4::timestamptz)
+			GROUP BY 1, 2
+		)
+		SELECT recent, status = 'success', h.i, SUM(h.n)::BIGINT,
+			COALESCE(SUM(calls) FILTER (WHERE h.i = 1), 0)::BIGINT
+		FROM src, unnest(src.latency_histogram) WITH ORDINALITY AS h(n, i)
+		GROUP BY 1, 2, 3
+	`, agentID, weekFrom, monthFrom, watermark)
+	if err != nil {
+		return fmt.Errorf("failed to get call statistics: %w", err)
+	}
+	defer rows.Close()
+
+	var weekSuccess, monthSuccess int64
+	weekHistogram := make([]int64, len(usage.LatencyBounds)+1)
+	monthHistogram := make([]int64, len(usage.LatencyBounds)+1)
+	for rows.Next() {
+		var recent, success bool
+		var i, n, calls int64
+		if err := rows.Scan(&recent, &success, &i, &n, &calls); err != nil {
+			return fmt.Errorf("failed to scan call statistics: %w", err)
+		}
+		month.Calls += calls
+		if recent {
+			week.Calls += calls
+		}
+		if !success {
+			continue
+		}
+		monthSuccess += calls
+		if recent {
+			weekSuccess += calls
+		}
+		if i >= 1 && int(i) <= len(monthHistogram) {
+			monthHistogram[i-1] += n
+			if recent {
+				weekHistogram[i-1] += n
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to get call statistics: %w", err)
+	}
+
+	week.SuccessRate = successRate(week.Calls, weekSuccess)
+	week.P50LatencyMs, week.P95LatencyMs = percentileMs(weekHistogram, 0.50), percentileMs(weekHistogram, 0.95)
+	month.SuccessRate = successRate(month.Calls, monthSuccess)
+	month.P50LatencyMs, month.P95LatencyMs = percentileMs(monthHistogram, 0.50), percentileMs(monthHistogram, 0.95)
+	return nil
+}
+
+// versions returns when each version of an agent was released, newest first.
+// A version's history row is written when it is replaced, so version n+1 was
+// released when the row for version n was created.
+func (s *Service) versions(ctx context.Context, agentID uuid.UUID) ([]VersionInfo, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT 1, created_at FROM agents WHERE id = $1
+		UNION ALL
+		SELECT version + 1, created_at FROM agent_versions WHERE agent_id = $1
+		ORDER BY 1 DESC
+	`, agentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := []VersionInfo{}
+	for rows.Next() {
+		var v VersionInfo
+		if err := rows.Scan(&v.Version, &v.ReleasedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list versions: %w", err)
+	}
+	return versions, nil
+}
+
+// successRate returns successful over calls, or nil without calls
+func successRate(calls, successful int64) *float64 {
+	if calls == 0 {
+		return nil
+	}
+	rate := float64(successful) / float64(calls)
+	return &rate
+}
+
+// percentileMs estimates a latency percentile from a histogram, or returns
+// nil for an empty histogram
+func percentileMs(histogram []int64, p float64) *int {
+	for _, n := range histogram {
+		if n > 0 {
+			ms := int(usage.Percentile(histogram, p))
+			return &ms
+		}
+	}
+	return nil
+}
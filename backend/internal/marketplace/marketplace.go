@@ -0,0 +1,336 @@
+package marketplace
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/agent"
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// Search errors
+var (
+	ErrInvalidSort        = errors.New("sort must be relevance, popularity, rating or newest")
+	ErrInvalidCursor      = errors.New("invalid cursor")
+	ErrInvalidPriceRange  = errors.New("min_price must be non-negative and not above max_price")
+	ErrInvalidRating      = errors.New("min_rating must be between 0 and 5")
+	ErrRelevanceNeedsText = errors.New("relevance sort requires a search query")
+)
+
+// Sort orders of search results
+const (
+	SortRelevance  = "relevance"
+	SortPopularity = "popularity"
+	SortRating     = "rating"
+	SortNewest     = "newest"
+)
+
+// Search result limits
+const (
+	DefaultLimit = 20
+	MaxLimit     = 50
+)
+
+// sortKey is the expression agents are ordered by, descending, with its SQL
+// type and a constructor for its Go value. Ties are broken by agent ID so that
+// cursors are stable.
+type sortKey struct {
+	expr     string
+	sqlType  string
+	newValue func() any
+}
+
+var sortKeys = map[string]sortKey{
+	SortRelevance: {
+		"ts_rank_cd(a.search_vector, websearch_to_tsquery('english', $1))::float8", "float8",
+		func() any { return new(float64) },
+	},
+	SortPopularity: {"COALESCE(a.total_calls, 0)", "bigint", func() any { return new(int64) }},
+	SortRating:     {"COALESCE(a.average_rating, 0)", "numeric", func() any { return new(decimal.Decimal) }},
+	SortNewest:     {"COALESCE(a.published_at, a.created_at)", "timestamptz", func() any { return new(time.Time) }},
+}
+
+// Service handles marketplace search and rankings of active agents
+type Service struct {
+	db     *pgxpool.Pool
+	config *config.MarketplaceConfig
+
+	mu       sync.Mutex
+	featured *featuredCache
+	stats    map[uuid.UUID]*statsCache
+}
+
+// NewService creates a new marketplace service
+func NewService(db *pgxpool.Pool, cfg *config.MarketplaceConfig) *Service {
+	return &Service{db: db, config: cfg, stats: make(map[uuid.UUID]*statsCache)}
+}
+
+// SearchQuery filters and orders a marketplace search. Zero values do not filter.
+type SearchQuery struct {
+	Query       string // Full-text search over name, category, creator and description
+	Category    string
+	MinPrice    *decimal.Decimal // Bounds on the price per call
+	MaxPrice    *decimal.Decimal
+	MinRating   *decimal.Decimal
+	Trial       *bool  // Whether the agent offers free trial calls
+	Provider    string // e.g. openai or anthropic
+	ModelFamily string // Snapshot suffixes are ignored, e.g. gpt-4o-2024-08-06 matches gpt-4o
+	Sort        string // Defaults to relevance with a query and popularity without
+	Cursor      string // next_cursor of the previous page
+	Limit       int
+}
+
+// Creator is the public profile of an agent's creator
+type Creator struct {
+	ID          uuid.UUID `json:"id"`
+	DisplayName string    `json:"display_name"`
+	Verified    bool      `json:"verified"`
+	Bio         *string   `json:"bio,omitempty"` // Only on agent detail
+	AvatarURL   *string   `json:"avatar_url,omitempty"`
+}
+
+// AgentSummary is the public listing of an agent. It never includes the agent's config.
+type AgentSummary struct {
+	ID                     uuid.UUID           `json:"id"`
+	Name                   string              `json:"name"`
+	Description            *string             `json:"description,omitempty"`
+	Category               *string             `json:"category,omitempty"`
+	Creator                Creator             `json:"creator"`
+	PricingModel           models.PricingModel `json:"pricing_model"`
+	PricePerCall           decimal.Decimal     `json:"price_per_call"`
+	PricePer1KInputTokens  decimal.Decimal     `json:"price_per_1k_input_tokens"`
+	PricePer1KOutputTokens decimal.Decimal     `json:"price_per_1k_output_tokens"`
+	TrialEnabled           bool                `json:"trial_enabled"`
+	Provider               *string             `json:"provider,omitempty"`
+	ModelFamily            *string             `json:"model_family,omitempty"`
+	TotalCalls             int64               `json:"total_calls"`
+	AverageRating          decimal.Decimal     `json:"average_rating"`
+	ReviewCount            int                 `json:"review_count"`
+	PublishedAt            *time.Time          `json:"published_at,omitempty"`
+}
+
+// SearchResponse represents a page of search results
+type SearchResponse struct {
+	Agents     []AgentSummary `json:"agents"`
+	Sort       string         `json:"sort"`
+	NextCursor string         `json:"next_cursor,omitempty"` // Empty on the last page
+}
+
+// cursor is the position after the last agent of a page
+type cursor struct {
+	Sort  string          `json:"s"`
+	Value json.RawMessage `json:"v"` // Sort key of the last agent
+	ID    uuid.UUID       `json:"id"`
+}
+
+// encodeCursor returns an opaque cursor after an agent with the given sort key value
+func encodeCursor(sort string, value any, id uuid.UUID) string {
+	v, _ := json.Marshal(value)
+	data, _ := json.Marshal(cursor{Sort: sort, Value: v, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor parses a cursor returned by encodeCursor for the given sort,
+// returning a pointer to the sort key value and the agent ID
+func decodeCursor(s, sort string) (any, uuid.UUID, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != sort || len(c.Value) == 0 {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	value := sortKeys[sort].newValue()
+	if err := json.Unmarshal(c.Value, value); err != nil {
+		return nil, uuid.Nil, ErrInvalidCursor
+	}
+	return value, c.ID, nil
+}
+
+// normalizeSearch validates a search query and applies the default sort and limit
+func normalizeSearch(q *SearchQuery) error {
+	q.Query = strings.TrimSpace(q.Query)
+	q.Category = strings.TrimSpace(q.Category)
+	q.Provider = strings.ToLower(strings.TrimSpace(q.Provider))
+	q.ModelFamily = agent.ModelFamily(q.ModelFamily)
+
+	if q.Sort == "" {
+		q.Sort = SortPopularity
+		if q.Query != "" {
+			q.Sort = SortRelevance
+		}
+	}
+	if _, ok := sortKeys[q.Sort]; !ok {
+		return ErrInvalidSort
+	}
+	if q.Sort == SortRelevance && q.Query == "" {
+		return ErrRelevanceNeedsText
+	}
+	if q.MinPrice != nil && q.MinPrice.IsNegative() || q.MaxPrice != nil && q.MaxPrice.IsNegative() ||
+		q.MinPrice != nil && q.MaxPrice != nil && q.MinPrice.GreaterThan(*q.MaxPrice) {
+		return ErrInvalidPriceRange
+	}
+	if q.MinRating != nil && (q.MinRating.IsNegative() || q.MinRating.GreaterThan(decimal.NewFromInt(5))) {
+		return ErrInvalidRating
+	}
+	if q.Limit < 1 || q.Limit > MaxLimit {
+		q.Limit = DefaultLimit
+	}
+	return nil
+}
+
+// summaryColumns are the columns scanned by scanSummary, from agents a joined
+// with creator_profiles cp
+const summaryColumns = `a.id, a.name, a.description, a.category, COALESCE(a.pricing_model, 'per_call'),
+	a.price_per_call, COALESCE(a.price_per_1k_input_tokens, 0), COALESCE(a.price_per_1k_output_tokens, 0),
+	COALESCE(a.trial_enabled, true), a.provider, a.model_family, COALESCE(a.total_calls, 0),
+	COALESCE(a.average_rating, 0), COALESCE(a.review_count, 0), a.published_at,
+	a.creator_id, COALESCE(cp.display_name, ''), COALESCE(cp.verified, false)`
+
+// scanSummary scans summaryColumns, followed by any extra destinations
+func scanSummary(row pgx.Row, extra ...any) (*AgentSummary, error) {
+	var a AgentSummary
+	dest := []any{
+		&a.ID, &a.Name, &a.Description, &a.Category, &a.PricingModel,
+		&a.PricePerCall, &a.PricePer1KInputTokens, &a.PricePer1KOutputTokens,
+		&a.TrialEnabled, &a.Provider, &a.ModelFamily, &a.TotalCalls,
+		&a.AverageRating, &a.ReviewCount, &a.PublishedAt,
+		&a.Creator.ID, &a.Creator.DisplayName, &a.Creator.Verified,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+// Search returns a page of active agents matching a query
+func (s *Service) Search(ctx context.Context, q *SearchQuery) (*SearchResponse, error) {
+	if err := normalizeSearch(q); err != nil {
+		return nil, err
+	}
+	key := sortKeys[q.Sort]
+	var afterValue any
+	var afterID uuid.UUID
+	if q.Cursor != "" {
+		var err error
+		if afterValue, afterID, err = decodeCursor(q.Cursor, q.Sort); err != nil {
+			return nil, err
+		}
+	}
+
+	// $1 is always the search text so the relevance key can refer to it
+	args := []any{q.Query}
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	conds := []string{"a.status = 'active'"}
+	if q.Query != "" {
+		conds = append(conds, "a.search_vector @@ websearch_to_tsquery('english', $1)")
+	}
+	if q.Category != "" {
+		conds = append(conds, "LOWER(a.category) = LOWER("+arg(q.Category)+")")
+	}
+	if q.MinPrice != nil {
+		conds = append(conds, "a.price_per_call >= "+arg(*q.MinPrice))
+	}
+	if q.MaxPrice != nil {
+		conds = append(conds, "a.price_per_call <= "+arg(*q.MaxPrice))
+	}
+	if q.MinRating != nil {
+		conds = append(conds, "COALESCE(a.average_rating, 0) >= "+arg(*q.MinRating))
+	}
+	if q.Trial != nil {
+		conds = append(conds, "COALESCE(a.trial_enabled, true) = "+arg(*q.Trial))
+	}
+	if q.Provider != "" {
+		conds = append(conds, "a.provider = "+arg(q.Provider))
+	}
+	if q.ModelFamily != "" {
+		conds = append(conds, "a.model_family = "+arg(q.ModelFamily))
+	}
+
+	if afterValue != nil {
+		conds = append(conds, fmt.Sprintf("(%s, a.id) < (%s::%s, %s)",
+			key.expr, arg(afterValue), key.sqlType, arg(afterID)))
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT `+summaryColumns+`, `+key.expr+`
+		FROM agents a
+		LEFT JOIN creator_profiles cp ON cp.user_id = a.creator_id
+		WHERE `+strings.Join(conds, " AND ")+`
+		ORDER BY `+key.expr+` DESC, a.id DESC
+		LIMIT `+arg(q.Limit+1),
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search agents: %w", err)
+	}
+	defer rows.Close()
+
+	resp := &SearchResponse{Agents: []AgentSummary{}, Sort: q.Sort}
+	lastValue := key.newValue()
+	for rows.Next() {
+		// The extra row only tells that there is a next page
+		if len(resp.Agents) == q.Limit {
+			last := resp.Agents[len(resp.Agents)-1]
+			resp.NextCursor = encodeCursor(q.Sort, lastValue, last.ID)
+			break
+		}
+		a, err := scanSummary(rows, lastValue)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan agent: %w", err)
+		}
+		resp.Agents = append(resp.Agents, *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to search agents: %w", err)
+	}
+	return resp, nil
+}
+
+// Category is a category of active agents
+type Category struct {
+	Name       string `json:"name"`
+	AgentCount int64  `json:"agent_count"`
+}
+
+// Categories returns the categories of active agents, largest first
+func (s *Service) Categories(ctx context.Context) ([]Category, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT category, COUNT(*)
+		FROM agents
+		WHERE status = 'active' AND category IS NOT NULL AND category <> ''
+		GROUP BY category
+		ORDER BY COUNT(*) DESC, category
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list categories: %w", err)
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var c Category
+		if err := rows.Scan(&c.Name, &c.AgentCount); err != nil {
+			return nil, fmt.Errorf("failed to scan category: %w", err)
+		}
+		categories = append(categories, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list categories: %w", err)
+	}
+	return categories, nil
+}
@@ -0,0 +1,148 @@
+package marketplace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Featured ranking limits
+const (
+	DefaultFeaturedLimit = 10
+	MaxFeaturedLimit     = 50
+)
+
+// Ratings are shrunk towards ratingPrior as if each agent had
+// ratingPriorWeight extra reviews, so a single 5-star review does not
+// outrank many good ones
+const (
+	ratingPrior       = 3.5
+	ratingPriorWeight = 5
+)
+
+// FeaturedAgent is an agent in the featured ranking
+type FeaturedAgent struct {
+	AgentSummary
+	RecentCalls int64   `json:"recent_calls"` // Successful calls in the trending window
+	Score       float64 `json:"trending_score"`
+}
+
+// FeaturedResponse represents the featured ranking
+type FeaturedResponse struct {
+	Agents     []FeaturedAgent `json:"agents"`
+	Window     string          `json:"window"`
+	ComputedAt time.Time       `json:"computed_at"`
+}
+
+// featuredCache holds the top of the featured ranking until it expires
+type featuredCache struct {
+	resp    *FeaturedResponse
+	expires time.Time
+}
+
+// Featured returns the trending active agents. The ranking is recomputed at
+// most once per FeaturedCacheTTL.
+func (s *Service) Featured(ctx context.Context, limit int) (*FeaturedResponse, error) {
+	if limit < 1 || limit > MaxFeaturedLimit {
+		limit = DefaultFeaturedLimit
+	}
+
+	s.mu.Lock()
+	cached := s.featured
+	s.mu.Unlock()
+
+	now := time.Now()
+	if cached == nil || !now.Before(cached.expires) {
+		resp, err := s.rankFeatured(ctx, now)
+		if err != nil {
+			return nil, err
+		}
+		cached = &featuredCache{resp: resp, expires: now.Add(s.config.FeaturedCacheTTL)}
+		s.mu.Lock()
+		s.featured = cached
+		s.mu.Unlock()
+	}
+
+	resp := *cached.resp
+	if len(resp.Agents) > limit {
+		resp.Agents = resp.Agents[:limit]
+	}
+	return &resp, nil
+}
+
+// rollupWatermark returns the time before which calls are in the hourly usage
+// rollups, or nil before the first rollup
+func (s *Service) rollupWatermark(ctx context.Context) (*time.Time, error) {
+	var watermark *time.Time
+	err := s.db.QueryRow(ctx, `SELECT rolled_up_to FROM usage_rollup_state`).Scan(&watermark)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get rollup state: %w", err)
+	}
+	return watermark, nil
+}
+
+// rankFeatured ranks active agents by the log of their successful calls in the
+// trending window times their shrunk rating. Calls are read from the hourly
+// usage rollups, plus call logs not yet rolled up.
+func (s *Service) rankFeatured(ctx context.Context, now time.Time) (*FeaturedResponse, error) {
+	from := now.UTC().Add(-s.config.TrendingWindow).Truncate(time.Hour)
+
+	watermark, err := s.rollupWatermark(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(ctx, `
+		WITH recent AS (
+			SELECT agent_id, SUM(calls)::BIGINT AS calls
+			FROM (
+				SELECT agent_id, calls
+				FROM call_usage_hourly
+				WHERE status = 'success' AND bucket_start >= $1
+				  AND $2::timestamptz IS NOT NULL AND bucket_start < $2::timestamptz
+				UNION ALL
+				SELECT agent_id, COUNT(*)
+				FROM call_logs
+				WHERE status = 'success' AND created_at >= GREATEST($1, $2::timestamptz)
+				GROUP BY agent_id
+			) src
+			GROUP BY agent_id
+		), scored AS (
+			SELECT a.id, COALESCE(r.calls, 0) AS calls,
+				LN(1 + COALESCE(r.calls, 0)::float8)
+					* (COALESCE(a.review_count, 0) * COALESCE(a.average_rating, 0)::float8 + $3::float8 * $4::float8)
+					/ (COALESCE(a.review_count, 0) + $4::float8) AS score
+			FROM agents a
+			LEFT JOIN recent r ON r.agent_id = a.id
+			WHERE a.status = 'active'
+		)
+		SELECT `+summaryColumns+`, sc.calls, sc.score
+		FROM scored sc
+		JOIN agents a ON a.id = sc.id
+		LEFT JOIN creator_profiles cp ON cp.user_id = a.creator_id
+		ORDER BY sc.score DESC, COALESCE(a.total_calls, 0) DESC, a.id DESC
+		LIMIT $5
+	`, from, watermark, ratingPrior, ratingPriorWeight, MaxFeaturedLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rank featured agents: %w", err)
+	}
+	defer rows.Close()
+
+	resp := &FeaturedResponse{Agents: []FeaturedAgent{}, Window: s.config.TrendingWindow.String(), ComputedAt: now}
+	for rows.Next() {
+		var f FeaturedAgent
+		a, err := scanSummary(rows, &f.RecentCalls, &f.Score)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan agent: %w", err)
+		}
+		f.AgentSummary = *a
+		resp.Agents = append(resp.Agents, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to rank featured agents: %w", err)
+	}
+	return resp, nil
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -30,9 +31,16 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// AccountChecker looks up a user's account status. An empty status means the
+// user no longer exists.
+type AccountChecker interface {
+	AccountStatus(ctx context.Context, userID uuid.UUID) (models.AccountStatus, error)
+}
+
 // JWTAuthenticator handles JWT token validation
 type JWTAuthenticator struct {
-	config *config.JWTConfig
+	config   *config.JWTConfig
+	accounts AccountChecker
 }
 
 // NewJWTAuthenticator creates a new JWT authenticator
@@ -42,6 +50,13 @@ func NewJWTAuthenticator(cfg *config.JWTConfig) *JWTAuthenticator {
 	}
 }
 
+// WithAccountChecker makes JWTAuth reject tokens of suspended, banned or
+// deleted accounts on every request, not just when the token is issued
+func (j *JWTAuthenticator) WithAccountChecker(accounts AccountChecker) *JWTAuthenticator {
+	j.accounts = accounts
+	return j
+}
+
 // JWTAuth creates a middleware that validates JWT tokens from the Authorization header
 // It extracts the Bearer token, validates it, and sets user information in the context
 func (j *JWTAuthenticator) JWTAuth() gin.HandlerFunc {
@@ -74,6 +89,14 @@ func (j *JWTAuthenticator) JWTAuth() gin.HandlerFunc {
 			return
 		}
 
+		if j.accounts != nil {
+			if apiErr := j.checkAccount(c.Request.Context(), claims.UserID); apiErr != nil {
+				respondWithError(c, apiErr)
+				c.Abort()
+				return
+			}
+		}
+
 		// Set user info in context
 		c.Set(ContextKeyUserID, claims.UserID)
 		c.Set(ContextKeyUserType, claims.UserType)
@@ -84,6 +107,29 @@ func (j *JWTAuthenticator) JWTAuth() gin.HandlerFunc {
 	}
 }
 
+// checkAccount returns the error to respond with if the token's account may
+// not make requests
+func (j *JWTAuthenticator) checkAccount(ctx context.Context, userIDStr string) *apierrors.APIError {
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		return apierrors.ErrInvalidCredentialsError
+	}
+	status, err := j.accounts.AccountStatus(ctx, userID)
+	if err != nil {
+		return apierrors.ErrInternalServerError
+	}
+	switch status {
+	case models.AccountStatusActive:
+		return nil
+	case models.AccountStatusSuspended:
+		return apierrors.ErrAccountSuspendedError
+	case models.AccountStatusBanned:
+		return apierrors.ErrAccountBannedError
+	default:
+		return apierrors.ErrInvalidCredentialsError
+	}
+}
+
 // ValidateAccessToken validates an access token and returns claims
 func (j *JWTAuthenticator) ValidateAccessToken(tokenString string) (*Claims, error) {
 	claims, err := j.validateToken(tokenString)
@@ -159,6 +205,7 @@ func respondWithError(c *gin.Context, err *apierrors.APIError) {
 		c.Request.URL.Path,
 		c.Request.Method,
 	)
+	response.TraceID = c.GetString("trace_id")
 
 	c.JSON(err.HTTPStatus, response)
 }
@@ -313,6 +360,24 @@ func GetRequestIDFromContext(c *gin.Context) string {
 	return requestID.(string)
 }
 
+// exposedHeaders are the response headers browsers may read, including the
+// per-key and token limit headers set by the proxy
+var exposedHeaders = []string{
+	"X-Request-ID",
+	"X-RateLimit-Remaining",
+	"Idempotent-Replayed",
+	"X-AgentLink-Key-RateLimit-Limit",
+	"X-AgentLink-Key-RateLimit-Remaining",
+	"X-AgentLink-Key-Concurrency-Limit",
+	"X-AgentLink-Key-Concurrency-InFlight",
+	"X-AgentLink-Key-Budget-Unit",
+	"X-AgentLink-Key-Budget-Daily-Remaining",
+	"X-AgentLink-Key-Budget-Monthly-Remaining",
+	"X-RateLimit-Tokens-Limit",
+	"X-RateLimit-Tokens-Remaining",
+	"X-RateLimit-Tokens-Reset",
+}
+
 // CORS configures CORS headers
 func CORS(allowedOrigins []string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -330,8 +395,8 @@ func CORS(allowedOrigins []string) gin.HandlerFunc {
 		if allowed {
 			c.Header("Access-Control-Allow-Origin", origin)
 			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID, X-AgentLink-Key")
-			c.Header("Access-Control-Expose-Headers", "X-Request-ID, X-RateLimit-Remaining")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Request-ID, X-AgentLink-Key, Idempotency-Key")
+			c.Header("Access-Control-Expose-Headers", strings.Join(exposedHeaders, ", "))
 			c.Header("Access-Control-Allow-Credentials", "true")
 			c.Header("Access-Control-Max-Age", "43200") // 12 hours
 		}
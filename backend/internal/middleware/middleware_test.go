@@ -1,8 +1,10 @@
 package middleware
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,6 +12,7 @@ import (
 	"github.com/aimerfeng/AgentLink/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 func init() {
@@ -623,3 +626,78 @@ func TestProperty_RequestID_PropagatedFromHeader(t *testing.T) {
 			expectedRequestID, requestID)
 	}
 }
+
+// stubAccounts returns a fixed account status
+type stubAccounts struct {
+	status models.AccountStatus
+}
+
+func (s stubAccounts) AccountStatus(ctx context.Context, userID uuid.UUID) (models.AccountStatus, error) {
+	return s.status, nil
+}
+
+func TestJWTAuth_AccountStatus(t *testing.T) {
+	secret := "test-secret-key-for-jwt-testing"
+	cfg := &config.JWTConfig{
+		Secret:             secret,
+		AccessTokenExpiry:  15 * time.Minute,
+		RefreshTokenExpiry: 7 * 24 * time.Hour,
+		Issuer:             "agentlink",
+	}
+	token := createTestToken(secret, uuid.New().String(), "developer", "test@example.com", "access", 15*time.Minute)
+
+	tests := []struct {
+		status models.AccountStatus
+		want   int
+	}{
+		{models.AccountStatusActive, http.StatusOK},
+		{models.AccountStatusSuspended, http.StatusForbidden},
+		{models.AccountStatusBanned, http.StatusForbidden},
+		{"", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.Use(NewJWTAuthenticator(cfg).WithAccountChecker(stubAccounts{tt.status}).JWTAuth())
+		router.GET("/protected", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"message": "success"})
+		})
+
+		req := httptest.NewRequest("GET", "/protected", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("Status %q: expected %d, got %d", tt.status, tt.want, w.Code)
+		}
+	}
+}
+
+// TestCORS_ExposesLimitHeaders tests that browsers may read the proxy's
+// per-key and token limit headers
+func TestCORS_ExposesLimitHeaders(t *testing.T) {
+	router := gin.New()
+	router.Use(CORS([]string{"https://app.example.com"}))
+	router.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	exposed := w.Header().Get("Access-Control-Expose-Headers")
+	for _, header := range []string{
+		"X-RateLimit-Remaining",
+		"X-AgentLink-Key-RateLimit-Remaining",
+		"X-AgentLink-Key-Budget-Daily-Remaining",
+		"X-AgentLink-Key-Budget-Monthly-Remaining",
+		"X-RateLimit-Tokens-Remaining",
+		"X-RateLimit-Tokens-Reset",
+	} {
+		if !strings.Contains(exposed, header) {
+			t.Errorf("Expected %s to be exposed, got %q", header, exposed)
+		}
+	}
+}
@@ -16,28 +16,75 @@ const (
 	AgentStatusInactive AgentStatus = "inactive"
 )
 
+// PricingModel represents how calls to an agent are billed
+type PricingModel string
+
+const (
+	PricingModelPerCall  PricingModel = "per_call"
+	PricingModelPerToken PricingModel = "per_token"
+	PricingModelHybrid   PricingModel = "hybrid"
+)
+
 // Agent represents an AI agent
 type Agent struct {
-	ID              uuid.UUID       `json:"id" db:"id"`
-	CreatorID       uuid.UUID       `json:"creator_id" db:"creator_id"`
-	Name            string          `json:"name" db:"name"`
-	Description     *string         `json:"description,omitempty" db:"description"`
-	Category        *string         `json:"category,omitempty" db:"category"`
-	Status          AgentStatus     `json:"status" db:"status"`
-	ConfigEncrypted []byte          `json:"-" db:"config_encrypted"`
-	ConfigIV        []byte          `json:"-" db:"config_iv"`
-	PricePerCall    decimal.Decimal `json:"price_per_call" db:"price_per_call"`
-	TotalCalls      int64           `json:"total_calls" db:"total_calls"`
-	TotalRevenue    decimal.Decimal `json:"total_revenue" db:"total_revenue"`
-	AverageRating   decimal.Decimal `json:"average_rating" db:"average_rating"`
-	ReviewCount     int             `json:"review_count" db:"review_count"`
-	TokenID         *int64          `json:"token_id,omitempty" db:"token_id"`
-	TokenTxHash     *string         `json:"token_tx_hash,omitempty" db:"token_tx_hash"`
-	TrialEnabled    bool            `json:"trial_enabled" db:"trial_enabled"` // D5.4: Creator can disable trial
-	Version         int             `json:"version" db:"version"`
-	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
-	UpdatedAt       time.Time       `json:"updated_at" db:"updated_at"`
-	PublishedAt     *time.Time      `json:"published_at,omitempty" db:"published_at"`
+	ID               uuid.UUID       `json:"id" db:"id"`
+	CreatorID        uuid.UUID       `json:"creator_id" db:"creator_id"`
+	Name             string          `json:"name" db:"name"`
+	Description      *string         `json:"description,omitempty" db:"description"`
+	Category         *string         `json:"category,omitempty" db:"category"`
+	Status           AgentStatus     `json:"status" db:"status"`
+	ConfigEncrypted  []byte          `json:"-" db:"config_encrypted"`
+	ConfigIV         []byte          `json:"-" db:"config_iv"`
+	PricingModel     PricingModel    `json:"pricing_model" db:"pricing_model"`
+	PricePerCall     decimal.Decimal `json:"price_per_call" db:"price_per_call"`
+	PricePer1KInput  decimal.Decimal `json:"price_per_1k_input_tokens" db:"price_per_1k_input_tokens"`
+	PricePer1KOutput decimal.Decimal `json:"price_per_1k_output_tokens" db:"price_per_1k_output_tokens"`
+	TotalCalls       int64           `json:"total_calls" db:"total_calls"`
+	TotalRevenue     decimal.Decimal `json:"total_revenue" db:"total_revenue"`
+	AverageRating    decimal.Decimal `json:"average_rating" db:"average_rating"`
+	ReviewCount      int             `json:"review_count" db:"review_count"`
+	TokenID          *int64          `json:"token_id,omitempty" db:"token_id"`
+	TokenTxHash      *string         `json:"token_tx_hash,omitempty" db:"token_tx_hash"`
+	TrialEnabled     bool            `json:"trial_enabled" db:"trial_enabled"` // D5.4: Creator can disable trial
+	Limits           AgentLimits     `json:"limits"`
+	Version          int             `json:"version" db:"version"`
+	CreatedAt        time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at" db:"updated_at"`
+	PublishedAt      *time.Time      `json:"published_at,omitempty" db:"published_at"`
+}
+
+// AgentLimits are optional creator-defined limits on calls to an agent.
+// A nil field means no limit.
+type AgentLimits struct {
+	RateLimitRPM  *int `json:"rate_limit_rpm,omitempty" db:"rate_limit_rpm"`                   // Across all callers
+	PerCallerRPM  *int `json:"per_caller_rpm,omitempty" db:"per_caller_rpm"`                   // For each calling user
+	MaxConcurrent *int `json:"max_concurrent_requests,omitempty" db:"max_concurrent_requests"` // Across all callers
+}
+
+// Reasons a request was rejected by an agent's limits
+const (
+	AgentRejectionRateLimited        = "agent_rate_limited"
+	AgentRejectionCallerRateLimited  = "caller_rate_limited"
+	AgentRejectionConcurrencyLimited = "concurrency_limited"
+)
+
+// CallCost returns the USD cost of a single call with the given token usage.
+// Per-call agents ignore token counts; hybrid agents add token charges on top
+// of the base per-call price.
+func (a *Agent) CallCost(inputTokens, outputTokens int) decimal.Decimal {
+	thousand := decimal.NewFromInt(1000)
+	tokenCost := decimal.NewFromInt(int64(inputTokens)).Mul(a.PricePer1KInput).Div(thousand).
+		Add(decimal.NewFromInt(int64(outputTokens)).Mul(a.PricePer1KOutput).Div(thousand)).
+		Round(6)
+
+	switch a.PricingModel {
+	case PricingModelPerToken:
+		return tokenCost
+	case PricingModelHybrid:
+		return a.PricePerCall.Add(tokenCost)
+	default:
+		return a.PricePerCall
+	}
 }
 
 // AgentConfig represents the decrypted agent configuration
@@ -1,22 +1,173 @@
 package models
 
 import (
+	"net/netip"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// API key permission flags
+const (
+	PermissionRead     = "read"
+	PermissionWrite    = "write"
+	PermissionChat     = "chat"
+	PermissionStream   = "stream"
+	PermissionBatch    = "batch"
+	PermissionSessions = "sessions"
 )
 
 // APIKey represents a developer's API key
 type APIKey struct {
-	ID          uuid.UUID          `json:"id" db:"id"`
-	UserID      uuid.UUID          `json:"user_id" db:"user_id"`
-	KeyHash     string             `json:"-" db:"key_hash"`
-	KeyPrefix   string             `json:"key_prefix" db:"key_prefix"`
-	Name        *string            `json:"name,omitempty" db:"name"`
-	Permissions map[string]bool    `json:"permissions" db:"permissions"`
-	LastUsedAt  *time.Time         `json:"last_used_at,omitempty" db:"last_used_at"`
-	CreatedAt   time.Time          `json:"created_at" db:"created_at"`
-	RevokedAt   *time.Time         `json:"revoked_at,omitempty" db:"revoked_at"`
+	ID              uuid.UUID       `json:"id" db:"id"`
+	UserID          uuid.UUID       `json:"user_id" db:"user_id"`
+	KeyHash         string          `json:"-" db:"key_hash"`
+	KeyPrefix       string          `json:"key_prefix" db:"key_prefix"`
+	Name            *string         `json:"name,omitempty" db:"name"`
+	Permissions     map[string]bool `json:"permissions" db:"permissions"`
+	AllowedAgentIDs []uuid.UUID     `json:"allowed_agent_ids" db:"allowed_agent_ids"` // Empty allows all agents
+	AllowedCIDRs    []string        `json:"allowed_cidrs" db:"allowed_cidrs"`         // Empty allows all client IPs
+	AllowedOrigins  []string        `json:"allowed_origins" db:"allowed_origins"`     // Empty allows all origins
+	Limits          APIKeyLimits    `json:"limits"`
+	Capture         APIKeyCapture   `json:"capture"`
+	LastUsedAt      *time.Time      `json:"last_used_at,omitempty" db:"last_used_at"`
+	ExpiresAt       *time.Time      `json:"expires_at,omitempty" db:"expires_at"`
+	ReplacedBy      *uuid.UUID      `json:"replaced_by,omitempty" db:"replaced_by"` // Set once the key has been rotated
+	CreatedAt       time.Time       `json:"created_at" db:"created_at"`
+	RevokedAt       *time.Time      `json:"revoked_at,omitempty" db:"revoked_at"`
+	OwnerStatus     AccountStatus   `json:"owner_status,omitempty"` // Status of the owning account, set when proxying
+}
+
+// AllowsIP reports whether the client IP falls inside the key's CIDR allowlist
+func (k *APIKey) AllowsIP(clientIP string) bool {
+	if len(k.AllowedCIDRs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, cidr := range k.AllowedCIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			continue
+		}
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsOrigin reports whether the request origin matches the key's origin allowlist.
+// Entries may use a leading wildcard subdomain, e.g. https://*.example.com.
+// When an allowlist is set, requests without an Origin header are rejected.
+func (k *APIKey) AllowsOrigin(origin string) bool {
+	if len(k.AllowedOrigins) == 0 {
+		return true
+	}
+	if origin == "" {
+		return false
+	}
+	origin = strings.ToLower(strings.TrimSuffix(origin, "/"))
+	for _, allowed := range k.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == origin {
+			return true
+		}
+		// https://*.example.com matches https://app.example.com but not https://example.com
+		if scheme, host, ok := strings.Cut(allowed, "://*."); ok {
+			if strings.HasPrefix(origin, scheme+"://") && strings.HasSuffix(origin, "."+host) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsExpired reports whether the key has passed its expiry time
+func (k *APIKey) IsExpired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// HasPermission reports whether the key has the given permission flag
+func (k *APIKey) HasPermission(permission string) bool {
+	return k.Permissions[permission]
+}
+
+// CanAccessAgent reports whether the key is scoped to the given agent
+func (k *APIKey) CanAccessAgent(agentID uuid.UUID) bool {
+	if len(k.AllowedAgentIDs) == 0 {
+		return true
+	}
+	for _, id := range k.AllowedAgentIDs {
+		if id == agentID {
+			return true
+		}
+	}
+	return false
+}
+
+// SpendUnit is the unit an API key's spend caps are expressed in
+type SpendUnit string
+
+const (
+	SpendUnitQuota SpendUnit = "quota" // One quota unit per successful call
+	SpendUnitUSD   SpendUnit = "usd"   // Agent cost of successful calls
+)
+
+// APIKeyLimits holds the optional per-key limits enforced by the proxy.
+// Nil fields are not enforced.
+type APIKeyLimits struct {
+	RateLimitRPM    *int             `json:"rate_limit_rpm,omitempty" db:"rate_limit_rpm"`
+	MaxConcurrent   *int             `json:"max_concurrent_requests,omitempty" db:"max_concurrent_requests"`
+	TokensPerMinute *int             `json:"tokens_per_minute,omitempty" db:"tokens_per_minute"`
+	DailySpendCap   *decimal.Decimal `json:"daily_spend_cap,omitempty" db:"daily_spend_cap"`
+	MonthlySpendCap *decimal.Decimal `json:"monthly_spend_cap,omitempty" db:"monthly_spend_cap"`
+	SpendCapUnit    SpendUnit        `json:"spend_cap_unit" db:"spend_cap_unit"`
+}
+
+// APIKeyCapture holds a key's opt-in capture of request and response
+// payloads for debugging
+type APIKeyCapture struct {
+	Enabled  bool `json:"enabled" db:"capture_payloads"`
+	TTLHours int  `json:"ttl_hours" db:"capture_ttl_hours"` // How long captured payloads are kept
+}
+
+// HasSpendCap reports whether a daily or monthly spend cap is set
+func (l APIKeyLimits) HasSpendCap() bool {
+	return l.DailySpendCap != nil || l.MonthlySpendCap != nil
+}
+
+// Reasons a request was rejected by an API key's network restrictions
+const (
+	RejectionIPNotAllowed     = "ip_not_allowed"
+	RejectionOriginNotAllowed = "origin_not_allowed"
+)
+
+// APIKeyAuditEvent represents the type of an API key audit event
+type APIKeyAuditEvent string
+
+const (
+	APIKeyAuditCreated APIKeyAuditEvent = "created"
+	APIKeyAuditUpdated APIKeyAuditEvent = "updated"
+	APIKeyAuditRotated APIKeyAuditEvent = "rotated"
+	APIKeyAuditRevoked APIKeyAuditEvent = "revoked"
+	APIKeyAuditNewIP   APIKeyAuditEvent = "new_ip" // First use of the key from an unseen client IP
+)
+
+// APIKeyAuditLog represents an entry in an API key's audit trail
+type APIKeyAuditLog struct {
+	ID        uuid.UUID              `json:"id" db:"id"`
+	APIKeyID  uuid.UUID              `json:"api_key_id" db:"api_key_id"`
+	UserID    uuid.UUID              `json:"user_id" db:"user_id"`
+	EventType APIKeyAuditEvent       `json:"event_type" db:"event_type"`
+	IPAddress *string                `json:"ip_address,omitempty" db:"ip_address"`
+	Details   map[string]interface{} `json:"details,omitempty" db:"details"`
+	CreatedAt time.Time              `json:"created_at" db:"created_at"`
 }
 
 // Quota represents a user's API quota
@@ -32,3 +183,62 @@ type Quota struct {
 func (q *Quota) RemainingQuota() int64 {
 	return q.TotalQuota + q.FreeQuota - q.UsedQuota
 }
+
+// QuotaLedgerEntryType represents the kind of change recorded in the quota ledger
+type QuotaLedgerEntryType string
+
+const (
+	QuotaLedgerOpeningBalance  QuotaLedgerEntryType = "opening_balance"
+	QuotaLedgerSignupGrant     QuotaLedgerEntryType = "signup_grant"
+	QuotaLedgerPurchase        QuotaLedgerEntryType = "purchase"
+	QuotaLedgerCallDebit       QuotaLedgerEntryType = "call_debit"
+	QuotaLedgerCallRefund      QuotaLedgerEntryType = "call_refund"
+	QuotaLedgerTrial           QuotaLedgerEntryType = "trial"
+	QuotaLedgerAdminAdjustment QuotaLedgerEntryType = "admin_adjustment"
+)
+
+// QuotaLedgerEntry represents one change to a user's quota balance
+type QuotaLedgerEntry struct {
+	ID           int64                `json:"id" db:"id"`
+	UserID       uuid.UUID            `json:"user_id" db:"user_id"`
+	EntryType    QuotaLedgerEntryType `json:"entry_type" db:"entry_type"`
+	Amount       int64                `json:"amount" db:"amount"`
+	BalanceAfter *int64               `json:"balance_after,omitempty" db:"balance_after"`
+	PaymentID    *uuid.UUID           `json:"payment_id,omitempty" db:"payment_id"`
+	RequestID    *string              `json:"request_id,omitempty" db:"request_id"`
+	AgentID      *uuid.UUID           `json:"agent_id,omitempty" db:"agent_id"`
+	Note         *string              `json:"note,omitempty" db:"note"`
+	CreatedBy    *uuid.UUID           `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt    time.Time            `json:"created_at" db:"created_at"`
+}
+
+// QuotaAlertThresholdType represents how a quota alert threshold is measured
+type QuotaAlertThresholdType string
+
+const (
+	QuotaAlertAbsolute   QuotaAlertThresholdType = "absolute"   // Quota units
+	QuotaAlertPercentage QuotaAlertThresholdType = "percentage" // Percent of the last purchase
+)
+
+// QuotaAlertThreshold represents a balance at which a developer wants a quota.low alert
+type QuotaAlertThreshold struct {
+	ID            uuid.UUID               `json:"id" db:"id"`
+	UserID        uuid.UUID               `json:"user_id" db:"user_id"`
+	ThresholdType QuotaAlertThresholdType `json:"threshold_type" db:"threshold_type"`
+	Value         int64                   `json:"value" db:"value"`
+	NotifyEmail   bool                    `json:"notify_email" db:"notify_email"`
+	FiredAt       *time.Time              `json:"fired_at,omitempty" db:"fired_at"` // Set until the next top-up
+	CreatedAt     time.Time               `json:"created_at" db:"created_at"`
+}
+
+// QuotaAlert represents a fired quota.low or quota.exhausted alert
+type QuotaAlert struct {
+	ID             uuid.UUID    `json:"id" db:"id"`
+	UserID         uuid.UUID    `json:"user_id" db:"user_id"`
+	ThresholdID    *uuid.UUID   `json:"threshold_id,omitempty" db:"threshold_id"`
+	EventType      WebhookEvent `json:"event_type" db:"event_type"`
+	Balance        int64        `json:"balance" db:"balance"`
+	ThresholdQuota *int64       `json:"threshold_quota,omitempty" db:"threshold_quota"`
+	EmailedAt      *time.Time   `json:"emailed_at,omitempty" db:"emailed_at"`
+	CreatedAt      time.Time    `json:"created_at" db:"created_at"`
+}
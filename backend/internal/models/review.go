@@ -17,11 +17,16 @@ const (
 
 // Review represents a user review of an agent
 type Review struct {
-	ID        uuid.UUID    `json:"id" db:"id"`
-	AgentID   uuid.UUID    `json:"agent_id" db:"agent_id"`
-	UserID    uuid.UUID    `json:"user_id" db:"user_id"`
-	Rating    int          `json:"rating" db:"rating"`
-	Content   *string      `json:"content,omitempty" db:"content"`
-	Status    ReviewStatus `json:"status" db:"status"`
-	CreatedAt time.Time    `json:"created_at" db:"created_at"`
+	ID              uuid.UUID    `json:"id" db:"id"`
+	AgentID         uuid.UUID    `json:"agent_id" db:"agent_id"`
+	UserID          uuid.UUID    `json:"user_id" db:"user_id"`
+	Rating          int          `json:"rating" db:"rating"`
+	Content         *string      `json:"content,omitempty" db:"content"`
+	Status          ReviewStatus `json:"status" db:"status"`
+	RejectionReason *string      `json:"rejection_reason,omitempty" db:"rejection_reason"`
+	ModeratedAt     *time.Time   `json:"moderated_at,omitempty" db:"moderated_at"`
+	Reply           *string      `json:"reply,omitempty" db:"reply"` // The agent creator's public reply
+	RepliedAt       *time.Time   `json:"replied_at,omitempty" db:"replied_at"`
+	CreatedAt       time.Time    `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time    `json:"updated_at" db:"updated_at"`
 }
@@ -15,16 +15,36 @@ const (
 	UserTypeAdmin     UserType = "admin"
 )
 
+// AccountStatus represents whether a user may use the platform
+type AccountStatus string
+
+const (
+	AccountStatusActive    AccountStatus = "active"
+	AccountStatusSuspended AccountStatus = "suspended"
+	AccountStatusBanned    AccountStatus = "banned"
+)
+
+// IsValid reports whether s is a known account status
+func (s AccountStatus) IsValid() bool {
+	switch s {
+	case AccountStatusActive, AccountStatusSuspended, AccountStatusBanned:
+		return true
+	}
+	return false
+}
+
 // User represents a user in the system
 type User struct {
-	ID            uuid.UUID  `json:"id" db:"id"`
-	Email         string     `json:"email" db:"email"`
-	PasswordHash  string     `json:"-" db:"password_hash"`
-	UserType      UserType   `json:"user_type" db:"user_type"`
-	WalletAddress *string    `json:"wallet_address,omitempty" db:"wallet_address"`
-	EmailVerified bool       `json:"email_verified" db:"email_verified"`
-	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt     time.Time  `json:"updated_at" db:"updated_at"`
+	ID            uuid.UUID     `json:"id" db:"id"`
+	Email         string        `json:"email" db:"email"`
+	PasswordHash  string        `json:"-" db:"password_hash"`
+	UserType      UserType      `json:"user_type" db:"user_type"`
+	WalletAddress *string       `json:"wallet_address,omitempty" db:"wallet_address"`
+	EmailVerified bool          `json:"email_verified" db:"email_verified"`
+	Status        AccountStatus `json:"status" db:"status"`
+	StatusReason  *string       `json:"status_reason,omitempty" db:"status_reason"`
+	CreatedAt     time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time     `json:"updated_at" db:"updated_at"`
 }
 
 // CreatorProfile represents additional creator information
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -11,18 +12,52 @@ type WebhookEvent string
 
 const (
 	WebhookEventQuotaLow       WebhookEvent = "quota.low"
+	WebhookEventQuotaExhausted WebhookEvent = "quota.exhausted"
 	WebhookEventCallCompleted  WebhookEvent = "call.completed"
 	WebhookEventPaymentDone    WebhookEvent = "payment.completed"
 	WebhookEventPaymentFailed  WebhookEvent = "payment.failed"
+	WebhookEventTest           WebhookEvent = "webhook.test" // Sent on request to a single endpoint
 )
 
 // Webhook represents a webhook configuration
 type Webhook struct {
-	ID        uuid.UUID      `json:"id" db:"id"`
-	UserID    uuid.UUID      `json:"user_id" db:"user_id"`
-	URL       string         `json:"url" db:"url"`
-	Events    []WebhookEvent `json:"events" db:"events"`
-	Secret    string         `json:"-" db:"secret"`
-	Active    bool           `json:"active" db:"active"`
-	CreatedAt time.Time      `json:"created_at" db:"created_at"`
+	ID                  uuid.UUID      `json:"id" db:"id"`
+	UserID              uuid.UUID      `json:"user_id" db:"user_id"`
+	URL                 string         `json:"url" db:"url"`
+	Description         *string        `json:"description,omitempty" db:"description"`
+	Events              []WebhookEvent `json:"events" db:"events"`
+	Secret              string         `json:"-" db:"secret"`
+	Active              bool           `json:"active" db:"active"`
+	ConsecutiveFailures int            `json:"consecutive_failures" db:"consecutive_failures"`
+	DisabledAt          *time.Time     `json:"disabled_at,omitempty" db:"disabled_at"`
+	DisabledReason      *string        `json:"disabled_reason,omitempty" db:"disabled_reason"`
+	CreatedAt           time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// WebhookDeliveryStatus represents the status of a webhook delivery
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed" // Retries exhausted
+)
+
+// WebhookDelivery represents one event sent (or to be sent) to a webhook endpoint
+type WebhookDelivery struct {
+	ID             uuid.UUID             `json:"id" db:"id"`
+	WebhookID      uuid.UUID             `json:"webhook_id" db:"webhook_id"`
+	EventID        uuid.UUID             `json:"event_id" db:"event_id"`
+	EventType      WebhookEvent          `json:"event_type" db:"event_type"`
+	Payload        json.RawMessage       `json:"payload" db:"payload"`
+	Status         WebhookDeliveryStatus `json:"status" db:"status"`
+	Attempts       int                   `json:"attempts" db:"attempts"`
+	NextAttemptAt  *time.Time            `json:"next_attempt_at,omitempty" db:"next_attempt_at"`
+	LastAttemptAt  *time.Time            `json:"last_attempt_at,omitempty" db:"last_attempt_at"`
+	ResponseStatus *int                  `json:"response_status,omitempty" db:"response_status"`
+	LastError      *string               `json:"last_error,omitempty" db:"last_error"`
+	DurationMs     *int                  `json:"duration_ms,omitempty" db:"duration_ms"`
+	CreatedAt      time.Time             `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time            `json:"delivered_at,omitempty" db:"delivered_at"`
 }
@@ -0,0 +1,99 @@
+package monitoring
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// OtherLabel is the label value shared by everything outside a bounded set
+const OtherLabel = "other"
+
+// maxModelLabels is the most distinct model names given their own series
+const maxModelLabels = 100
+
+// topAgents holds the agents given their own series; see SetTopAgents
+var topAgents struct {
+	mu  sync.RWMutex
+	ids map[string]struct{}
+}
+
+// SetTopAgents sets the agents whose IDs are used as metric labels, usually
+// the most-called ones. Every other agent is counted as "other". Series of
+// agents that dropped out of the set are deleted so they stop being scraped.
+func SetTopAgents(agentIDs []string) {
+	ids := make(map[string]struct{}, len(agentIDs))
+	for _, id := range agentIDs {
+		ids[id] = struct{}{}
+	}
+
+	topAgents.mu.Lock()
+	previous := topAgents.ids
+	topAgents.ids = ids
+	topAgents.mu.Unlock()
+
+	m := Get()
+	for id := range previous {
+		if _, ok := ids[id]; ok {
+			continue
+		}
+		m.APICallsTotal.DeletePartialMatch(prometheus.Labels{"agent": id})
+		m.QuotaUsed.DeletePartialMatch(prometheus.Labels{"agent": id})
+	}
+}
+
+// AgentLabel returns the metric label for an agent: its ID if it is one of
+// the top agents, otherwise "other"
+func AgentLabel(agentID string) string {
+	topAgents.mu.RLock()
+	defer topAgents.mu.RUnlock()
+	if _, ok := topAgents.ids[agentID]; ok {
+		return agentID
+	}
+	return OtherLabel
+}
+
+// boundedLabel admits the first limit distinct values of a label and maps
+// later ones to "other"
+type boundedLabel struct {
+	mu    sync.RWMutex
+	limit int
+	seen  map[string]struct{}
+}
+
+// value returns v if it is admitted, otherwise "other"
+func (b *boundedLabel) value(v string) string {
+	b.mu.RLock()
+	_, ok := b.seen[v]
+	full := len(b.seen) >= b.limit
+	b.mu.RUnlock()
+	if ok {
+		return v
+	}
+	if full {
+		return OtherLabel
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.seen[v]; ok {
+		return v
+	}
+	if len(b.seen) >= b.limit {
+		return OtherLabel
+	}
+	b.seen[v] = struct{}{}
+	return v
+}
+
+// modelLabels bounds the model label, which creators set freely on agents
+var modelLabels = &boundedLabel{limit: maxModelLabels, seen: map[string]struct{}{}}
+
+// ModelLabel returns the metric label for a model name. Only the first 100
+// distinct models get their own series; later ones are counted as "other".
+func ModelLabel(model string) string {
+	if model == "" {
+		return "unknown"
+	}
+	return modelLabels.value(model)
+}
@@ -22,13 +22,18 @@ type Metrics struct {
 	AIProviderLatency    *prometheus.HistogramVec
 	AIProviderRequests   *prometheus.CounterVec
 	AIProviderErrors     *prometheus.CounterVec
+	AITimeToFirstToken   *prometheus.HistogramVec
+	AIStreamDuration     *prometheus.HistogramVec
 
 	// Quota metrics
-	QuotaRemaining *prometheus.GaugeVec
 	QuotaUsed      *prometheus.CounterVec
+	QuotaExhausted *prometheus.CounterVec
+	QuotaDrift     *prometheus.CounterVec
 
 	// Rate limiting metrics
-	RateLimitHits *prometheus.CounterVec
+	RateLimitHits         *prometheus.CounterVec
+	RateLimitDegraded     prometheus.Gauge
+	RateLimitDegradedHits *prometheus.CounterVec
 
 	// Cache metrics
 	CacheHits   *prometheus.CounterVec
@@ -46,6 +51,12 @@ type Metrics struct {
 	PaymentsTotal    *prometheus.CounterVec
 	RevenueTotal     *prometheus.CounterVec
 
+	// Payout metrics
+	SettlementsTotal *prometheus.CounterVec
+	SettlementVolume prometheus.Counter
+	WithdrawalsTotal *prometheus.CounterVec
+	WithdrawalVolume *prometheus.CounterVec
+
 	// Circuit breaker metrics
 	CircuitBreakerState *prometheus.GaugeVec
 }
@@ -105,30 +116,66 @@ func Init() *Metrics {
 			},
 			[]string{"provider", "model", "error_type"},
 		),
-
-		// Quota metrics
-		QuotaRemaining: promauto.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Name: "quota_remaining",
-				Help: "Remaining API quota per user",
+		AITimeToFirstToken: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "ai_time_to_first_token_seconds",
+				Help:    "Time from sending a streaming request to the first streamed chunk, in seconds",
+				Buckets: []float64{.1, .25, .5, 1, 2, 3, 5, 10, 20},
 			},
-			[]string{"user_id"},
+			[]string{"provider", "model"},
 		),
+		AIStreamDuration: promauto.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "ai_stream_duration_seconds",
+				Help:    "Duration of streamed responses from the first chunk to the end, in seconds",
+				Buckets: []float64{.5, 1, 2, 5, 10, 20, 30, 60, 120, 300},
+			},
+			[]string{"provider", "model"},
+		),
+
+		// Quota metrics
 		QuotaUsed: promauto.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "quota_used_total",
-				Help: "Total quota used",
+				Help: "Total quota used, by user tier and agent (top agents only, others as \"other\")",
+			},
+			[]string{"tier", "agent"},
+		),
+		QuotaExhausted: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "quota_exhausted_total",
+				Help: "Total number of calls rejected because the caller's quota was exhausted",
 			},
-			[]string{"user_id", "agent_id"},
+			[]string{"tier"},
+		),
+		QuotaDrift: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "quota_reconcile_discrepancies_total",
+				Help: "Total number of quota balances found to disagree with the ledger",
+			},
+			[]string{"store"},
 		),
 
 		// Rate limiting metrics
 		RateLimitHits: promauto.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "rate_limit_hits_total",
-				Help: "Total number of rate limit hits",
+				Help: "Total number of rate limit hits, by limit (user, key, agent or tokens) and user tier",
+			},
+			[]string{"limit", "tier"},
+		),
+		RateLimitDegraded: promauto.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "rate_limit_degraded",
+				Help: "Whether rate limiting is running without Redis (1=degraded, 0=normal)",
+			},
+		),
+		RateLimitDegradedHits: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "rate_limit_degraded_decisions_total",
+				Help: "Total number of rate limit decisions made without Redis",
 			},
-			[]string{"user_type"},
+			[]string{"mode", "result"},
 		),
 
 		// Cache metrics
@@ -185,9 +232,9 @@ func Init() *Metrics {
 		APICallsTotal: promauto.NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "api_calls_total",
-				Help: "Total number of API calls",
+				Help: "Total number of API calls, by agent (top agents only, others as \"other\") and status",
 			},
-			[]string{"agent_id", "status"},
+			[]string{"agent", "status"},
 		),
 		PaymentsTotal: promauto.NewCounterVec(
 			prometheus.CounterOpts{
@@ -204,6 +251,35 @@ func Init() *Metrics {
 			[]string{"type"},
 		),
 
+		// Payout metrics
+		SettlementsTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "settlements_total",
+				Help: "Total number of creator settlements by status",
+			},
+			[]string{"status"},
+		),
+		SettlementVolume: promauto.NewCounter(
+			prometheus.CounterOpts{
+				Name: "settlement_volume_usd_total",
+				Help: "Total creator earnings settled in USD",
+			},
+		),
+		WithdrawalsTotal: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "withdrawals_total",
+				Help: "Total number of creator withdrawals by method and status",
+			},
+			[]string{"method", "status"},
+		),
+		WithdrawalVolume: promauto.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "withdrawal_volume_usd_total",
+				Help: "Total USD paid out by completed withdrawals",
+			},
+			[]string{"method"},
+		),
+
 		// Circuit breaker metrics
 		CircuitBreakerState: promauto.NewGaugeVec(
 			prometheus.GaugeOpts{
@@ -268,32 +344,67 @@ func MetricsMiddleware() gin.HandlerFunc {
 
 // RecordAIProviderLatency records AI provider latency
 func RecordAIProviderLatency(provider, model string, duration time.Duration) {
-	Get().AIProviderLatency.WithLabelValues(provider, model).Observe(duration.Seconds())
+	Get().AIProviderLatency.WithLabelValues(provider, ModelLabel(model)).Observe(duration.Seconds())
 }
 
 // RecordAIProviderRequest records an AI provider request
+// status: success or error
 func RecordAIProviderRequest(provider, model, status string) {
-	Get().AIProviderRequests.WithLabelValues(provider, model, status).Inc()
+	Get().AIProviderRequests.WithLabelValues(provider, ModelLabel(model), status).Inc()
 }
 
 // RecordAIProviderError records an AI provider error
+// errorType: timeout, status_<code>, circuit_open, decode or network
 func RecordAIProviderError(provider, model, errorType string) {
-	Get().AIProviderErrors.WithLabelValues(provider, model, errorType).Inc()
+	Get().AIProviderErrors.WithLabelValues(provider, ModelLabel(model), errorType).Inc()
+}
+
+// RecordTimeToFirstToken records how long a streaming call waited for its first chunk
+func RecordTimeToFirstToken(provider, model string, duration time.Duration) {
+	Get().AITimeToFirstToken.WithLabelValues(provider, ModelLabel(model)).Observe(duration.Seconds())
+}
+
+// RecordStreamDuration records how long a response streamed after its first chunk
+func RecordStreamDuration(provider, model string, duration time.Duration) {
+	Get().AIStreamDuration.WithLabelValues(provider, ModelLabel(model)).Observe(duration.Seconds())
+}
+
+// RecordQuotaUsage records quota used by a call
+// tier: free or paid
+func RecordQuotaUsage(tier, agentID string, amount float64) {
+	Get().QuotaUsed.WithLabelValues(tier, AgentLabel(agentID)).Add(amount)
 }
 
-// RecordQuotaUsage records quota usage
-func RecordQuotaUsage(userID, agentID string, amount float64) {
-	Get().QuotaUsed.WithLabelValues(userID, agentID).Add(amount)
+// RecordQuotaExhausted records a call rejected for exhausted quota
+func RecordQuotaExhausted(tier string) {
+	Get().QuotaExhausted.WithLabelValues(tier).Inc()
 }
 
-// SetQuotaRemaining sets the remaining quota for a user
-func SetQuotaRemaining(userID string, remaining float64) {
-	Get().QuotaRemaining.WithLabelValues(userID).Set(remaining)
+// RecordQuotaDrift records a balance that disagreed with the quota ledger
+// store: database or redis
+func RecordQuotaDrift(store string) {
+	Get().QuotaDrift.WithLabelValues(store).Inc()
 }
 
 // RecordRateLimitHit records a rate limit hit
-func RecordRateLimitHit(userType string) {
-	Get().RateLimitHits.WithLabelValues(userType).Inc()
+// limit: user, key, agent or tokens; tier: free or paid
+func RecordRateLimitHit(limit, tier string) {
+	Get().RateLimitHits.WithLabelValues(limit, tier).Inc()
+}
+
+// SetRateLimitDegraded sets whether rate limiting is running without Redis
+func SetRateLimitDegraded(degraded bool) {
+	value := 0.0
+	if degraded {
+		value = 1
+	}
+	Get().RateLimitDegraded.Set(value)
+}
+
+// RecordRateLimitDegradedDecision records a rate limit decision made without Redis
+// result: allowed or rejected
+func RecordRateLimitDegradedDecision(mode, result string) {
+	Get().RateLimitDegradedHits.WithLabelValues(mode, result).Inc()
 }
 
 // RecordCacheHit records a cache hit
@@ -328,8 +439,9 @@ func RecordAgentPublished() {
 }
 
 // RecordAPICall records an API call
+// status: success or the call's error code
 func RecordAPICall(agentID, status string) {
-	Get().APICallsTotal.WithLabelValues(agentID, status).Inc()
+	Get().APICallsTotal.WithLabelValues(AgentLabel(agentID), status).Inc()
 }
 
 // RecordPayment records a payment
@@ -342,6 +454,26 @@ func RecordRevenue(revenueType string, amount float64) {
 	Get().RevenueTotal.WithLabelValues(revenueType).Add(amount)
 }
 
+// RecordSettlement records a settlement reaching status
+func RecordSettlement(status string) {
+	Get().SettlementsTotal.WithLabelValues(status).Inc()
+}
+
+// RecordSettlementVolume records creator earnings settled
+func RecordSettlementVolume(amountUSD float64) {
+	Get().SettlementVolume.Add(amountUSD)
+}
+
+// RecordWithdrawal records a withdrawal reaching status
+func RecordWithdrawal(method, status string) {
+	Get().WithdrawalsTotal.WithLabelValues(method, status).Inc()
+}
+
+// RecordWithdrawalVolume records USD paid out by a completed withdrawal
+func RecordWithdrawalVolume(method string, amountUSD float64) {
+	Get().WithdrawalVolume.WithLabelValues(method).Add(amountUSD)
+}
+
 // SetCircuitBreakerState sets the circuit breaker state
 // state: 0=closed, 1=open, 0.5=half-open
 func SetCircuitBreakerState(provider string, state float64) {
@@ -0,0 +1,96 @@
+package monitoring
+
+import (
+	"fmt"
+	"testing"
+
+	"pgregory.net/rapid"
+)
+
+// TestProperty_BoundedLabelNeverExceedsLimit tests that however many
+// distinct values are seen, at most limit of them are kept and the rest
+// become "other"
+func TestProperty_BoundedLabelNeverExceedsLimit(t *testing.T) {
+	rapid.Check(t, func(t *rapid.T) {
+		limit := rapid.IntRange(1, 20).Draw(t, "limit")
+		values := rapid.SliceOf(rapid.StringMatching(`[a-z0-9-]{1,12}`)).Draw(t, "values")
+		b := &boundedLabel{limit: limit, seen: map[string]struct{}{}}
+
+		admitted := map[string]bool{}
+		for _, v := range values {
+			got := b.value(v)
+			if got != v && got != OtherLabel {
+				t.Fatalf("PROPERTY VIOLATION: %q labelled %q", v, got)
+			}
+			if got == v && v != OtherLabel {
+				admitted[v] = true
+			}
+			if admitted[v] && got != v {
+				t.Fatalf("PROPERTY VIOLATION: admitted value %q later labelled %q", v, got)
+			}
+		}
+		if len(admitted) > limit {
+			t.Fatalf("PROPERTY VIOLATION: %d values admitted with limit %d", len(admitted), limit)
+		}
+	})
+}
+
+// TestProperty_AgentLabelOnlyForTopAgents tests that only agents in the top
+// set get their own label
+func TestProperty_AgentLabelOnlyForTopAgents(t *testing.T) {
+	defer SetTopAgents(nil)
+	rapid.Check(t, func(t *rapid.T) {
+		n := rapid.IntRange(0, 10).Draw(t, "top")
+		top := make([]string, n)
+		for i := range top {
+			top[i] = fmt.Sprintf("agent-%d", i)
+		}
+		SetTopAgents(top)
+
+		for i := 0; i < 15; i++ {
+			id := fmt.Sprintf("agent-%d", i)
+			want := OtherLabel
+			if i < n {
+				want = id
+			}
+			if got := AgentLabel(id); got != want {
+				t.Fatalf("PROPERTY VIOLATION: agent %s labelled %q with %d top agents", id, got, n)
+			}
+		}
+	})
+}
+
+// TestSetTopAgents_DeletesDroppedSeries tests that an agent leaving the top
+// set stops being exported under its own label
+func TestSetTopAgents_DeletesDroppedSeries(t *testing.T) {
+	defer SetTopAgents(nil)
+	m := Get()
+
+	SetTopAgents([]string{"a", "b"})
+	RecordAPICall("a", "success")
+	RecordAPICall("b", "success")
+	RecordQuotaUsage("free", "a", 1)
+
+	SetTopAgents([]string{"b"})
+	if m.APICallsTotal.DeleteLabelValues("a", "success") {
+		t.Error("Expected the dropped agent's call series to be deleted")
+	}
+	if m.QuotaUsed.DeleteLabelValues("free", "a") {
+		t.Error("Expected the dropped agent's quota series to be deleted")
+	}
+	if !m.APICallsTotal.DeleteLabelValues("b", "success") {
+		t.Error("Expected the remaining agent's series to be kept")
+	}
+
+	RecordAPICall("a", "success")
+	if !m.APICallsTotal.DeleteLabelValues(OtherLabel, "success") {
+		t.Error("Expected the dropped agent to be counted as other")
+	}
+}
+
+// TestModelLabel_Empty tests that a missing model is labelled unknown
+func TestModelLabel_Empty(t *testing.T) {
+	if got := ModelLabel(""); got != "unknown" {
+		t.Errorf("Expected unknown, got %q", got)
+	}
+}
@@ -0,0 +1,318 @@
+package partition
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// defaultPartition catches call logs outside every monthly partition
+	defaultPartition = "call_logs_default"
+	// lockKey is the advisory lock held while maintaining partitions, so
+	// replicas and the migrate command never maintain them concurrently
+	lockKey    int64 = 0x63616c6c5f6c6f67 // "call_log"
+	nameLayout       = "call_logs_2006_01"
+)
+
+// Name returns the name of the monthly partition holding t
+func Name(t time.Time) string {
+	return monthOf(t).Format(nameLayout)
+}
+
+// parseName returns the month of a monthly partition
+func parseName(name string) (time.Time, bool) {
+	month, err := time.Parse(nameLayout, name)
+	if err != nil || month.Format(nameLayout) != name {
+		return time.Time{}, false
+	}
+	return month, true
+}
+
+// monthOf returns the start of t's month in UTC
+func monthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Report lists what one maintenance run changed
+type Report struct {
+	Created  []string // Monthly partitions created
+	Moved    int64    // Calls moved out of the default partition
+	Archived []string // Partitions archived and dropped
+	Skipped  bool     // Another process was maintaining the partitions
+}
+
+// Service keeps call_logs partitioned by month: it creates partitions ahead
+// of time, moves calls that landed in the default partition into their own
+// month, and archives and drops months past the retention period once they
+// have been rolled up
+type Service struct {
+	db     *pgxpool.Pool
+	config *config.PartitionConfig
+}
+
+// NewService creates a new partition maintenance service
+func NewService(db *pgxpool.Pool, cfg *config.PartitionConfig) *Service {
+	return &Service{
+		db:     db,
+		config: cfg,
+	}
+}
+
+// RunMaintenance maintains the partitions every interval until the context
+// is cancelled. Does nothing if maintenance is disabled.
+func (s *Service) RunMaintenance(ctx context.Context) {
+	if s.config.MaintenanceInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.MaintenanceInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Maintain(ctx); err != nil && ctx.Err() == nil {
+			log.Error().Err(err).Msg("Failed to maintain call log partitions")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Maintain runs one round of partition maintenance. It is skipped if another
+// process holds the maintenance lock.
+func (s *Service) Maintain(ctx context.Context) (*Report, error) {
+	conn, err := s.db.Acquire(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err := conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, lockKey).Scan(&locked); err != nil {
+		return nil, fmt.Errorf("failed to take maintenance lock: %w", err)
+	}
+	if !locked {
+		return &Report{Skipped: true}, nil
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	report := &Report{}
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS `+defaultPartition+` PARTITION OF call_logs DEFAULT`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create default partition: %w", err)
+	}
+
+	// Create partitions from the oldest call in the default partition, or
+	// the current month, through the months ahead
+	now := monthOf(time.Now())
+	start := now
+	var oldest *time.Time
+	if err := conn.QueryRow(ctx, `SELECT MIN(created_at) FROM `+defaultPartition).Scan(&oldest); err != nil {
+		return nil, fmt.Errorf("failed to find oldest default call: %w", err)
+	}
+	if oldest != nil && oldest.Before(start) {
+		start = monthOf(*oldest)
+	}
+	for month := start; !month.After(now.AddDate(0, s.config.MonthsAhead, 0)); month = month.AddDate(0, 1, 0) {
+		created, moved, err := createPartition(ctx, conn.Conn(), month)
+		if err != nil {
+			return report, err
+		}
+		if created {
+			report.Created = append(report.Created, Name(month))
+			report.Moved += moved
+		}
+	}
+
+	if s.config.RetentionMonths > 0 {
+		cutoff := now.AddDate(0, 1-s.config.RetentionMonths, 0)
+		if report.Archived, err = s.expire(ctx, conn.Conn(), cutoff); err != nil {
+			return report, err
+		}
+	}
+
+	if len(report.Created) > 0 || len(report.Archived) > 0 {
+		log.Info().
+			Strs("created", report.Created).
+			Int64("moved", report.Moved).
+			Strs("archived", report.Archived).
+			Msg("Maintained call log partitions")
+	}
+	return report, nil
+}
+
+// createPartition creates a month's partition unless it exists. Calls for
+// the month already in the default partition are moved into it; the default
+// partition is detached meanwhile, as it may not overlap the new partition.
+func createPartition(ctx context.Context, conn *pgx.Conn, month time.Time) (bool, int64, error) {
+	name := Name(month)
+	var exists bool
+	if err := conn.QueryRow(ctx, `SELECT to_regclass($1::text) IS NOT NULL`, name).Scan(&exists); err != nil {
+		return false, 0, fmt.Errorf("failed to check partition %s: %w", name, err)
+	}
+	if exists {
+		return false, 0, nil
+	}
+
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	end := month.AddDate(0, 1, 0)
+	var stray bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM `+defaultPartition+` WHERE created_at >= $1 AND created_at < $2)
+	`, month, end).Scan(&stray)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to check default partition: %w", err)
+	}
+
+	if stray {
+		if _, err := tx.Exec(ctx, `ALTER TABLE call_logs DETACH PARTITION `+defaultPartition); err != nil {
+			return false, 0, fmt.Errorf("failed to detach default partition: %w", err)
+		}
+	}
+	// DDL takes no parameters; the bounds are formatted from times, not input
+	_, err = tx.Exec(ctx, fmt.Sprintf(`CREATE TABLE %s PARTITION OF call_logs FOR VALUES FROM ('%s') TO ('%s')`,
+		pgx.Identifier{name}.Sanitize(), month.Format(time.RFC3339), end.Format(time.RFC3339)))
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to create partition %s: %w", name, err)
+	}
+	var moved int64
+	if stray {
+		tag, err := tx.Exec(ctx, `
+			WITH moved AS (
+				DELETE FROM `+defaultPartition+` WHERE created_at >= $1 AND created_at < $2 RETURNING *
+			)
+			INSERT INTO call_logs SELECT * FROM moved
+		`, month, end)
+		if err != nil {
+			return false, 0, fmt.Errorf("failed to move calls into partition %s: %w", name, err)
+		}
+		moved = tag.RowsAffected()
+		if _, err := tx.Exec(ctx, `ALTER TABLE call_logs ATTACH PARTITION `+defaultPartition+` DEFAULT`); err != nil {
+			return false, 0, fmt.Errorf("failed to attach default partition: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, moved, nil
+}
+
+// expire archives and drops the monthly partitions before the cutoff month,
+// oldest first, stopping at the first one not yet fully rolled up. Partitions
+// left detached by an interrupted run are picked up again.
+func (s *Service) expire(ctx context.Context, conn *pgx.Conn, cutoff time.Time) ([]string, error) {
+	rows, err := conn.Query(ctx, `
+		SELECT c.relname, EXISTS (
+			SELECT 1 FROM pg_inherits i WHERE i.inhrelid = c.oid AND i.inhparent = 'call_logs'::regclass
+		)
+		FROM pg_class c
+		WHERE c.relkind = 'r' AND pg_table_is_visible(c.oid) AND c.relname ~ '^call_logs_[0-9]{4}_[0-9]{2}$'
+		ORDER BY c.relname
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	type partition struct {
+		name     string
+		month    time.Time
+		attached bool
+	}
+	var expired []partition
+	for rows.Next() {
+		var p partition
+		if err := rows.Scan(&p.name, &p.attached); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan partition: %w", err)
+		}
+		var ok bool
+		if p.month, ok = parseName(p.name); ok && p.month.Before(cutoff) {
+			expired = append(expired, p)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list partitions: %w", err)
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+
+	var rolledUpTo *time.Time
+	if err := conn.QueryRow(ctx, `SELECT rolled_up_to FROM usage_rollup_state WHERE id`).Scan(&rolledUpTo); err != nil {
+		return nil, fmt.Errorf("failed to get rollup state: %w", err)
+	}
+
+	var archived []string
+	for _, p := range expired {
+		if rolledUpTo == nil || rolledUpTo.Before(p.month.AddDate(0, 1, 0)) {
+			log.Warn().Str("partition", p.name).Msg("Keeping expired call log partition until it is rolled up")
+			break
+		}
+		ident := pgx.Identifier{p.name}.Sanitize()
+		if p.attached {
+			if _, err := conn.Exec(ctx, `ALTER TABLE call_logs DETACH PARTITION `+ident); err != nil {
+				return archived, fmt.Errorf("failed to detach partition %s: %w", p.name, err)
+			}
+		}
+		if err := s.archive(ctx, conn, p.name); err != nil {
+			return archived, err
+		}
+		if _, err := conn.Exec(ctx, `DROP TABLE `+ident); err != nil {
+			return archived, fmt.Errorf("failed to drop partition %s: %w", p.name, err)
+		}
+		archived = append(archived, p.name)
+	}
+	return archived, nil
+}
+
+// archive copies a detached partition to <archive dir>/<name>.csv.gz. The
+// file is written under a temporary name and renamed once complete.
+func (s *Service) archive(ctx context.Context, conn *pgx.Conn, name string) error {
+	if err := os.MkdirAll(s.config.ArchiveDir, 0o750); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	path := filepath.Join(s.config.ArchiveDir, name+".csv.gz")
+	f, err := os.CreateTemp(s.config.ArchiveDir, name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	_, err = conn.PgConn().CopyTo(ctx, gz, `COPY (SELECT * FROM `+pgx.Identifier{name}.Sanitize()+
+		` ORDER BY created_at) TO STDOUT WITH (FORMAT csv, HEADER)`)
+	if err != nil {
+		return fmt.Errorf("failed to archive partition %s: %w", name, err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
@@ -13,8 +13,12 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/aimerfeng/AgentLink/internal/alerts"
 	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/ledger"
 	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/aimerfeng/AgentLink/internal/monitoring"
+	outbound "github.com/aimerfeng/AgentLink/internal/webhook"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -214,6 +218,7 @@ func (s *Service) CreateCheckoutSession(ctx context.Context, userID uuid.UUID, r
 	if err != nil {
 		// Mark payment as failed
 		s.db.Exec(ctx, `UPDATE payments SET status = $1 WHERE id = $2`, models.PaymentStatusFailed, paymentID)
+		monitoring.RecordPayment(string(models.PaymentMethodStripe), string(models.PaymentStatusFailed))
 		return nil, fmt.Errorf("failed to create Stripe checkout session: %w", err)
 	}
 
@@ -222,6 +227,7 @@ func (s *Service) CreateCheckoutSession(ctx context.Context, userID uuid.UUID, r
 	if err != nil {
 		return nil, fmt.Errorf("failed to update payment with session ID: %w", err)
 	}
+	monitoring.RecordPayment(string(models.PaymentMethodStripe), string(models.PaymentStatusPending))
 
 	return &CreateCheckoutResponse{
 		SessionID:   sess.ID,
@@ -474,11 +480,13 @@ func (s *Service) CompletePayment(ctx context.Context, paymentID uuid.UUID, stri
 
 	// Get payment details
 	var userID uuid.UUID
+	var amountUSD decimal.Decimal
 	var quotaPurchased int64
 	var status models.PaymentStatus
+	var method models.PaymentMethod
 	err = tx.QueryRow(ctx, `
-		SELECT user_id, quota_purchased, status FROM payments WHERE id = $1 FOR UPDATE
-	`, paymentID).Scan(&userID, &quotaPurchased, &status)
+		SELECT user_id, amount_usd, quota_purchased, status, payment_method FROM payments WHERE id = $1 FOR UPDATE
+	`, paymentID).Scan(&userID, &amountUSD, &quotaPurchased, &status, &method)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return ErrPaymentNotFound
@@ -524,11 +532,43 @@ func (s *Service) CompletePayment(ctx context.Context, paymentID uuid.UUID, stri
 		}
 	}
 
+	// Record the purchase in the quota ledger
+	err = ledger.Record(ctx, tx, &models.QuotaLedgerEntry{
+		UserID:    userID,
+		EntryType: models.QuotaLedgerPurchase,
+		Amount:    quotaPurchased,
+		PaymentID: &paymentID,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ledger.NotifyQuotaChange(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	// The top-up re-arms the user's quota alerts
+	if err := alerts.Rearm(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	// Queue the payment.completed event with the payment
+	err = outbound.Enqueue(ctx, tx, userID, models.WebhookEventPaymentDone, map[string]any{
+		"payment_id":      paymentID,
+		"amount_usd":      amountUSD.String(),
+		"quota_purchased": quotaPurchased,
+		"completed_at":    now,
+	})
+	if err != nil {
+		return err
+	}
+
 	// Commit transaction
 	if err := tx.Commit(ctx); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	monitoring.RecordPayment(string(method), string(models.PaymentStatusCompleted))
+	monitoring.RecordRevenue("quota_purchase", amountUSD.InexactFloat64())
 	return nil
 }
 
@@ -547,10 +587,11 @@ func (s *Service) FailPayment(ctx context.Context, paymentID uuid.UUID, reason s
 	var amountUSD decimal.Decimal
 	var quotaPurchased int64
 	var status models.PaymentStatus
+	var method models.PaymentMethod
 	err = tx.QueryRow(ctx, `
-		SELECT user_id, amount_usd, quota_purchased, status 
+		SELECT user_id, amount_usd, quota_purchased, status, payment_method
 		FROM payments WHERE id = $1 FOR UPDATE
-	`, paymentID).Scan(&userID, &amountUSD, &quotaPurchased, &status)
+	`, paymentID).Scan(&userID, &amountUSD, &quotaPurchased, &status, &method)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return ErrPaymentNotFound
@@ -578,6 +619,7 @@ func (s *Service) FailPayment(ctx context.Context, paymentID uuid.UUID, reason s
 	if err := tx.Commit(ctx); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	monitoring.RecordPayment(string(method), string(models.PaymentStatusFailed))
 
 	// Create notification for the developer (async, don't block on failure)
 	notification := &PaymentFailedNotification{
@@ -622,14 +664,21 @@ func mapReasonToFailureReason(reason string) PaymentFailureReason {
 func (s *Service) storePaymentFailureNotification(ctx context.Context, notification *PaymentFailedNotification) {
 	// Store notification in a notifications table for later webhook delivery
 	// This is a best-effort operation - we don't want to fail the payment failure handling
+	payload, err := json.Marshal(map[string]any{
+		"failure_reason":  notification.FailureReason,
+		"failure_message": notification.FailureMessage,
+		"amount_usd":      notification.AmountUSD,
+		"quota_attempted": notification.QuotaAttempted,
+	})
+	if err != nil {
+		return
+	}
 	_, _ = s.db.Exec(ctx, `
 		INSERT INTO payment_notifications (id, user_id, payment_id, event_type, payload, created_at)
 		VALUES ($1, $2, $3, $4, $5, $6)
 		ON CONFLICT DO NOTHING
-	`, uuid.New(), notification.UserID, notification.PaymentID, models.WebhookEventPaymentFailed, 
-		fmt.Sprintf(`{"failure_reason":"%s","failure_message":"%s","amount_usd":"%s","quota_attempted":%d}`,
-			notification.FailureReason, notification.FailureMessage, notification.AmountUSD, notification.QuotaAttempted),
-		notification.FailedAt)
+	`, uuid.New(), notification.UserID, notification.PaymentID, models.WebhookEventPaymentFailed,
+		payload, notification.FailedAt)
 }
 
 // GetFailedPayments retrieves failed payments for a user
@@ -857,6 +906,7 @@ func (s *Service) CreateCoinbaseCharge(ctx context.Context, userID uuid.UUID, re
 		// Mark payment as failed
 		s.db.Exec(ctx, `UPDATE payments SET status = $1, failure_reason = $2 WHERE id = $3`, 
 			models.PaymentStatusFailed, "failed to create coinbase charge", paymentID)
+		monitoring.RecordPayment(string(models.PaymentMethodCoinbase), string(models.PaymentStatusFailed))
 		return nil, fmt.Errorf("failed to create Coinbase charge: %w", err)
 	}
 
@@ -865,6 +915,7 @@ func (s *Service) CreateCoinbaseCharge(ctx context.Context, userID uuid.UUID, re
 	if err != nil {
 		return nil, fmt.Errorf("failed to update payment with charge ID: %w", err)
 	}
+	monitoring.RecordPayment(string(models.PaymentMethodCoinbase), string(models.PaymentStatusPending))
 
 	return &CoinbaseChargeResponse{
 		ChargeID:   chargeResp.Data.ID,
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/cache"
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// AgentLimiter enforces optional creator-defined per-agent rate and concurrency limits in Redis
+type AgentLimiter struct {
+	redis       *cache.Redis
+	rateLimiter *RateLimiter
+}
+
+// AgentLimitStatus describes an agent's limits after a check.
+// Fields are nil/zero for limits the agent does not set.
+type AgentLimitStatus struct {
+	RateLimit        *RateLimitResult
+	CallerRateLimit  *RateLimitResult
+	ConcurrencyLimit int
+	InFlight         int64
+	RejectionReason  string // models.AgentRejection* when a limit was hit
+}
+
+// NewAgentLimiter creates a new per-agent limiter
+func NewAgentLimiter(redis *cache.Redis, rateLimiter *RateLimiter) *AgentLimiter {
+	return &AgentLimiter{
+		redis:       redis,
+		rateLimiter: rateLimiter,
+	}
+}
+
+// agentRateWindow is the window of the agent and per-caller rate limits
+const agentRateWindow = time.Minute
+
+// Lua script checking an agent's per-caller and global sliding windows
+// together. The request is added to every window only if all of them have
+// room, so a rejected request never uses up another window.
+// Returns: {allowed, rejected window index, oldest score of the rejected window, count per window}
+const luaCheckAgentRates = `
+local now = tonumber(ARGV[1])
+local window_start = tonumber(ARGV[2])
+local member = ARGV[3]
+local ttl = tonumber(ARGV[4])
+
+local result = {1, 0, 0}
+for i = 1, #KEYS do
+    redis.call('ZREMRANGEBYSCORE', KEYS[i], '0', window_start)
+    local count = redis.call('ZCARD', KEYS[i])
+    result[i + 3] = count
+    if count >= tonumber(ARGV[4 + i]) and result[1] == 1 then
+        result[1] = 0
+        result[2] = i
+        local oldest = redis.call('ZRANGE', KEYS[i], 0, 0, 'WITHSCORES')
+        if #oldest == 2 then
+            result[3] = tonumber(oldest[2])
+        end
+    end
+end
+
+if result[1] == 0 then
+    return result
+end
+
+for i = 1, #KEYS do
+    redis.call('ZADD', KEYS[i], now, member)
+    redis.call('EXPIRE', KEYS[i], ttl)
+end
+return result
+`
+
+// agentRateScope is one of the sliding windows checked by CheckRates
+type agentRateScope struct {
+	key    string
+	limit  int
+	result **RateLimitResult
+}
+
+// CheckRates applies the agent's per-caller and global requests-per-minute
+// limits in one atomic step, counting the request against both only if both
+// allow it. Results are nil for limits the agent does not set.
+func (a *AgentLimiter) CheckRates(ctx context.Context, agent *models.Agent, userID uuid.UUID, requestID string) (caller, global *RateLimitResult, err error) {
+	var scopes []agentRateScope
+	// Per-caller first, so a caller over its own limit is told so
+	if agent.Limits.PerCallerRPM != nil {
+		scopes = append(scopes, agentRateScope{
+			key:    fmt.Sprintf("ratelimit:sliding:agent:%s:user:%s", agent.ID, userID),
+			limit:  *agent.Limits.PerCallerRPM,
+			result: &caller,
+		})
+	}
+	if agent.Limits.RateLimitRPM != nil {
+		scopes = append(scopes, agentRateScope{
+			key:    fmt.Sprintf("ratelimit:sliding:agent:%s", agent.ID),
+			limit:  *agent.Limits.RateLimitRPM,
+			result: &global,
+		})
+	}
+	if len(scopes) == 0 {
+		return nil, nil, nil
+	}
+
+	now := time.Now()
+	keys := make([]string, 0, len(scopes))
+	args := []interface{}{now.UnixNano(), now.Add(-agentRateWindow).UnixNano(), requestID, int((2 * agentRateWindow).Seconds())}
+	for _, scope := range scopes {
+		keys = append(keys, scope.key)
+		args = append(args, scope.limit)
+	}
+
+	var values []int64
+	err = a.rateLimiter.guard.Do(func() error {
+		var err error
+		values, err = a.redis.Client.Eval(ctx, luaCheckAgentRates, keys, args...).Int64Slice()
+		return err
+	})
+	if err != nil || len(values) != len(scopes)+3 {
+		if !errors.Is(err, ErrRedisUnavailable) {
+			log.Error().Err(err).Str("agent_id", agent.ID.String()).Msg("Failed to check agent rate limits")
+		}
+		// On Redis error, decide according to the configured failure mode,
+		// stopping at the first window that rejects
+		for _, scope := range scopes {
+			*scope.result = a.rateLimiter.guard.fallbackTake(scope.key, 1, scope.limit, agentRateWindow)
+			if !(*scope.result).Allowed {
+				break
+			}
+		}
+		return caller, global, nil
+	}
+
+	allowed, rejected := values[0] == 1, int(values[1])-1
+	for i, scope := range scopes {
+		result := &RateLimitResult{Allowed: i != rejected, Limit: scope.limit, ResetAt: now.Add(agentRateWindow)}
+		count := values[i+3]
+		if allowed {
+			count++
+		}
+		result.Remaining = int64(scope.limit) - count
+		if result.Remaining < 0 {
+			result.Remaining = 0
+		}
+		if i == rejected {
+			result.RetryAfter = agentRateWindow
+			if oldest := values[2]; oldest > 0 {
+				result.RetryAfter = time.Unix(0, oldest).Add(agentRateWindow).Sub(now)
+				if result.RetryAfter < 0 {
+					result.RetryAfter = time.Second
+				}
+			}
+		}
+		*scope.result = result
+	}
+	return caller, global, nil
+}
+
+// AcquireSlot reserves an in-flight slot on the agent for the request.
+// Always succeeds if the agent has no concurrency limit.
+func (a *AgentLimiter) AcquireSlot(ctx context.Context, agent *models.Agent, requestID string) (bool, int64) {
+	if agent.Limits.MaxConcurrent == nil {
+		return true, 0
+	}
+	return a.rateLimiter.acquireSlot(ctx, agentInFlightKey(agent), *agent.Limits.MaxConcurrent, requestID)
+}
+
+// ReleaseSlot frees the request's in-flight slot on the agent
+func (a *AgentLimiter) ReleaseSlot(ctx context.Context, agent *models.Agent, requestID string) error {
+	if agent.Limits.MaxConcurrent == nil {
+		return nil
+	}
+	return a.rateLimiter.releaseSlot(ctx, agentInFlightKey(agent), requestID)
+}
+
+// agentInFlightKey returns the Redis sorted set of an agent's in-flight requests
+func agentInFlightKey(agent *models.Agent) string {
+	return fmt.Sprintf("ratelimit:inflight:agent:%s", agent.ID)
+}
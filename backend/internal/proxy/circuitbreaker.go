@@ -7,6 +7,8 @@ import (
 	"sync"
 	"time"
 
+	"github.com/aimerfeng/AgentLink/internal/cache"
+	"github.com/aimerfeng/AgentLink/internal/monitoring"
 	"github.com/rs/zerolog/log"
 	"github.com/sony/gobreaker"
 )
@@ -43,6 +45,8 @@ func DefaultCircuitBreakerConfig() *CircuitBreakerConfig {
 type CircuitBreakerManager struct {
 	breakers map[string]*gobreaker.CircuitBreaker
 	config   *CircuitBreakerConfig
+	shared   *sharedBreaker                 // nil unless state is shared across replicas
+	forced   map[string]CircuitBreakerState // Breakers held open or closed by an admin; unused with shared state
 	mu       sync.RWMutex
 }
 
@@ -59,13 +63,18 @@ const (
 type CircuitBreakerStatus struct {
 	Name         string              `json:"name"`
 	State        CircuitBreakerState `json:"state"`
+	Forced       bool                `json:"forced"` // State was set by an admin and ignores traffic
 	Requests     uint32              `json:"requests"`
 	TotalSuccess uint32              `json:"total_success"`
 	TotalFailure uint32              `json:"total_failure"`
 }
 
-// ErrCircuitOpen is returned when the circuit breaker is open
-var ErrCircuitOpen = errors.New("circuit breaker is open")
+var (
+	// ErrCircuitOpen is returned when the circuit breaker is open
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+	// ErrInvalidBreakerState is returned when forcing a breaker into a state other than open or closed
+	ErrInvalidBreakerState = errors.New("circuit breaker can only be forced open or closed")
+)
 
 // NewCircuitBreakerManager creates a new circuit breaker manager
 func NewCircuitBreakerManager(config *CircuitBreakerConfig) *CircuitBreakerManager {
@@ -75,7 +84,21 @@ func NewCircuitBreakerManager(config *CircuitBreakerConfig) *CircuitBreakerManag
 	return &CircuitBreakerManager{
 		breakers: make(map[string]*gobreaker.CircuitBreaker),
 		config:   config,
+		forced:   make(map[string]CircuitBreakerState),
+	}
+}
+
+// NewSharedCircuitBreakerManager creates a circuit breaker manager whose state
+// lives in Redis and is shared by all proxy replicas. While Redis is
+// unavailable each replica falls back to its own in-memory breakers.
+func NewSharedCircuitBreakerManager(config *CircuitBreakerConfig, redis *cache.Redis, guard *RedisGuard) *CircuitBreakerManager {
+	m := NewCircuitBreakerManager(config)
+	m.shared = &sharedBreaker{
+		redis:  redis,
+		guard:  guard,
+		config: m.config,
 	}
+	return m
 }
 
 // GetBreaker returns or creates a circuit breaker for the given provider
@@ -106,24 +129,9 @@ func (m *CircuitBreakerManager) GetBreaker(provider string) *gobreaker.CircuitBr
 			return counts.ConsecutiveFailures >= m.config.FailureThreshold
 		},
 		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
-			log.Info().
-				Str("circuit_breaker", name).
-				Str("from", stateToString(from)).
-				Str("to", stateToString(to)).
-				Msg("Circuit breaker state changed")
-		},
-		IsSuccessful: func(err error) bool {
-			// Consider these errors as failures that should trip the breaker
-			if err == nil {
-				return true
-			}
-			// Upstream errors should trip the breaker
-			if errors.Is(err, ErrUpstreamError) || errors.Is(err, ErrUpstreamTimeout) {
-				return false
-			}
-			// Client errors (invalid request, etc.) should not trip the breaker
-			return true
+			logStateChange(name, CircuitBreakerState(stateToString(from)), CircuitBreakerState(stateToString(to)), false)
 		},
+		IsSuccessful: isBreakerSuccess,
 	})
 
 	m.breakers[provider] = cb
@@ -133,6 +141,15 @@ func (m *CircuitBreakerManager) GetBreaker(provider string) *gobreaker.CircuitBr
 // Execute executes a function with circuit breaker protection
 func (m *CircuitBreakerManager) Execute(ctx context.Context, provider string, fn func() (interface{}, error)) (interface{}, error) {
 	cb := m.GetBreaker(provider)
+	if forced, ok := m.forcedState(provider); ok {
+		if forced == CircuitBreakerStateOpen {
+			return nil, ErrCircuitOpen
+		}
+		return fn()
+	}
+	if m.shared != nil {
+		return m.executeShared(ctx, provider, cb, fn)
+	}
 
 	result, err := cb.Execute(func() (interface{}, error) {
 		// Check context cancellation
@@ -157,6 +174,53 @@ func (m *CircuitBreakerManager) Execute(ctx context.Context, provider string, fn
 	return result, nil
 }
 
+// ExecuteModel executes a function behind both the provider's breaker and the
+// breaker for the provider's model, so a failing model trips on its own while
+// the provider keeps serving other models
+func (m *CircuitBreakerManager) ExecuteModel(ctx context.Context, provider, model string, fn func() (interface{}, error)) (interface{}, error) {
+	if model == "" {
+		return m.Execute(ctx, provider, fn)
+	}
+	return m.Execute(ctx, provider, func() (interface{}, error) {
+		return m.Execute(ctx, ModelBreakerName(provider, model), fn)
+	})
+}
+
+// executeShared executes a function against the breaker state in Redis,
+// falling back to the in-memory breaker when Redis cannot be reached
+func (m *CircuitBreakerManager) executeShared(ctx context.Context, name string, cb *gobreaker.CircuitBreaker, fn func() (interface{}, error)) (interface{}, error) {
+	allowed, generation, err := m.shared.allow(ctx, name)
+	if err != nil {
+		if !errors.Is(err, ErrRedisUnavailable) {
+			log.Warn().Err(err).Str("circuit_breaker", name).Msg("Shared circuit breaker unavailable, using local state")
+		}
+		result, err := cb.Execute(fn)
+		if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+			return nil, ErrCircuitOpen
+		}
+		return result, err
+	}
+	if !allowed {
+		log.Warn().
+			Str("provider", name).
+			Msg("Circuit breaker is open, rejecting request")
+		return nil, ErrCircuitOpen
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	default:
+	}
+	result, err := fn()
+
+	// Record even if the caller has gone away; the outcome is still valid
+	if recordErr := m.shared.record(context.WithoutCancel(ctx), name, generation, isBreakerSuccess(err)); recordErr != nil && !errors.Is(recordErr, ErrRedisUnavailable) {
+		log.Warn().Err(recordErr).Str("circuit_breaker", name).Msg("Failed to record circuit breaker outcome")
+	}
+	return result, err
+}
+
 // GetStatus returns the status of a circuit breaker
 func (m *CircuitBreakerManager) GetStatus(provider string) *CircuitBreakerStatus {
 	m.mu.RLock()
@@ -166,35 +230,47 @@ func (m *CircuitBreakerManager) GetStatus(provider string) *CircuitBreakerStatus
 	if !exists {
 		return nil
 	}
+	if status := m.sharedStatus(provider); status != nil {
+		return m.applyForced(status)
+	}
 
 	counts := cb.Counts()
 	state := cb.State()
 
-	return &CircuitBreakerStatus{
+	return m.applyForced(&CircuitBreakerStatus{
 		Name:         provider,
 		State:        CircuitBreakerState(stateToString(state)),
 		Requests:     counts.Requests,
 		TotalSuccess: counts.TotalSuccesses,
 		TotalFailure: counts.TotalFailures,
-	}
+	})
 }
 
 // GetAllStatus returns status of all circuit breakers
 func (m *CircuitBreakerManager) GetAllStatus() []*CircuitBreakerStatus {
+	// Shared state is read from Redis without holding the lock
 	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	statuses := make([]*CircuitBreakerStatus, 0, len(m.breakers))
+	breakers := make(map[string]*gobreaker.CircuitBreaker, len(m.breakers))
 	for provider, cb := range m.breakers {
+		breakers[provider] = cb
+	}
+	m.mu.RUnlock()
+
+	statuses := make([]*CircuitBreakerStatus, 0, len(breakers))
+	for provider, cb := range breakers {
+		if status := m.sharedStatus(provider); status != nil {
+			statuses = append(statuses, m.applyForced(status))
+			continue
+		}
 		counts := cb.Counts()
 		state := cb.State()
-		statuses = append(statuses, &CircuitBreakerStatus{
+		statuses = append(statuses, m.applyForced(&CircuitBreakerStatus{
 			Name:         provider,
 			State:        CircuitBreakerState(stateToString(state)),
 			Requests:     counts.Requests,
 			TotalSuccess: counts.TotalSuccesses,
 			TotalFailure: counts.TotalFailures,
-		})
+		}))
 	}
 	return statuses
 }
@@ -202,15 +278,111 @@ func (m *CircuitBreakerManager) GetAllStatus() []*CircuitBreakerStatus {
 // Reset resets a circuit breaker (for testing or admin purposes)
 func (m *CircuitBreakerManager) Reset(provider string) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
 	delete(m.breakers, provider)
+	delete(m.forced, provider)
+	m.mu.Unlock()
+
+	m.resetShared(provider)
+	monitoring.SetCircuitBreakerState(provider, breakerStateValue(CircuitBreakerStateClosed))
 }
 
 // ResetAll resets all circuit breakers
 func (m *CircuitBreakerManager) ResetAll() {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	providers := make([]string, 0, len(m.breakers))
+	for provider := range m.breakers {
+		providers = append(providers, provider)
+	}
 	m.breakers = make(map[string]*gobreaker.CircuitBreaker)
+	m.forced = make(map[string]CircuitBreakerState)
+	m.mu.Unlock()
+
+	for _, provider := range providers {
+		m.resetShared(provider)
+		monitoring.SetCircuitBreakerState(provider, breakerStateValue(CircuitBreakerStateClosed))
+	}
+}
+
+// ForceState holds a breaker open, rejecting every request, or closed,
+// letting every request through regardless of failures, until it is reset.
+// With shared state the override is kept only in Redis, so it applies to all
+// replicas and a reset on any of them clears it.
+func (m *CircuitBreakerManager) ForceState(ctx context.Context, name string, state CircuitBreakerState) error {
+	if state != CircuitBreakerStateOpen && state != CircuitBreakerStateClosed {
+		return ErrInvalidBreakerState
+	}
+
+	m.GetBreaker(name)
+	if m.shared != nil {
+		if err := m.shared.force(ctx, name, state); err != nil {
+			return fmt.Errorf("failed to force shared circuit breaker: %w", err)
+		}
+	} else {
+		m.mu.Lock()
+		m.forced[name] = state
+		m.mu.Unlock()
+	}
+
+	log.Warn().
+		Str("circuit_breaker", name).
+		Str("state", string(state)).
+		Msg("Circuit breaker forced by admin")
+	monitoring.SetCircuitBreakerState(name, breakerStateValue(state))
+	return nil
+}
+
+// PublishMetrics sets the circuit breaker gauge for every known breaker
+func (m *CircuitBreakerManager) PublishMetrics() {
+	for _, status := range m.GetAllStatus() {
+		monitoring.SetCircuitBreakerState(status.Name, breakerStateValue(status.State))
+	}
+}
+
+// forcedState returns the local admin override for a breaker, if any. Shared
+// overrides are applied by the allow script in Redis.
+func (m *CircuitBreakerManager) forcedState(name string) (CircuitBreakerState, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	state, ok := m.forced[name]
+	return state, ok
+}
+
+// applyForced reports a local admin override in a breaker's status
+func (m *CircuitBreakerManager) applyForced(status *CircuitBreakerStatus) *CircuitBreakerStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if state, ok := m.forced[status.Name]; ok {
+		status.State = state
+		status.Forced = true
+	}
+	return status
+}
+
+// sharedStatus returns a breaker's state from Redis, or nil if state is not
+// shared or cannot be read
+func (m *CircuitBreakerManager) sharedStatus(name string) *CircuitBreakerStatus {
+	if m.shared == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	status, err := m.shared.status(ctx, name)
+	if err != nil {
+		return nil
+	}
+	return status
+}
+
+// resetShared clears a breaker's state in Redis, if state is shared
+func (m *CircuitBreakerManager) resetShared(name string) {
+	if m.shared == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.shared.reset(ctx, name); err != nil {
+		log.Warn().Err(err).Str("circuit_breaker", name).Msg("Failed to reset shared circuit breaker")
+	}
 }
 
 // IsOpen checks if the circuit breaker for a provider is open
@@ -222,10 +394,58 @@ func (m *CircuitBreakerManager) IsOpen(provider string) bool {
 	if !exists {
 		return false
 	}
+	if forced, ok := m.forcedState(provider); ok {
+		return forced == CircuitBreakerStateOpen
+	}
+	if status := m.sharedStatus(provider); status != nil {
+		return status.State == CircuitBreakerStateOpen
+	}
 
 	return cb.State() == gobreaker.StateOpen
 }
 
+// ModelBreakerName returns the breaker name for one model of a provider
+func ModelBreakerName(provider, model string) string {
+	return fmt.Sprintf("%s:%s", provider, model)
+}
+
+// isBreakerSuccess reports whether an outcome counts as a success for a breaker
+func isBreakerSuccess(err error) bool {
+	// Consider these errors as failures that should trip the breaker
+	if err == nil {
+		return true
+	}
+	// Upstream errors should trip the breaker
+	if errors.Is(err, ErrUpstreamError) || errors.Is(err, ErrUpstreamTimeout) {
+		return false
+	}
+	// Client errors (invalid request, etc.) should not trip the breaker
+	return true
+}
+
+// logStateChange logs a breaker moving between states and updates its gauge
+func logStateChange(name string, from, to CircuitBreakerState, shared bool) {
+	log.Info().
+		Str("circuit_breaker", name).
+		Str("from", string(from)).
+		Str("to", string(to)).
+		Bool("shared", shared).
+		Msg("Circuit breaker state changed")
+	monitoring.SetCircuitBreakerState(name, breakerStateValue(to))
+}
+
+// breakerStateValue converts a state to the circuit breaker gauge value
+func breakerStateValue(state CircuitBreakerState) float64 {
+	switch state {
+	case CircuitBreakerStateOpen:
+		return 1
+	case CircuitBreakerStateHalfOpen:
+		return 0.5
+	default:
+		return 0
+	}
+}
+
 // stateToString converts gobreaker.State to string
 func stateToString(state gobreaker.State) string {
 	switch state {
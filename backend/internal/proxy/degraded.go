@@ -0,0 +1,344 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/monitoring"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+	"github.com/sony/gobreaker"
+)
+
+// FailureMode selects how limits behave while Redis is unavailable
+type FailureMode string
+
+const (
+	FailureModeOpen   FailureMode = "open"   // Allow every request
+	FailureModeLocal  FailureMode = "local"  // Enforce per-instance limits in memory
+	FailureModeClosed FailureMode = "closed" // Reject requests that need a limit check
+)
+
+// Redis circuit breaker settings. The breaker stops every request from waiting
+// on Redis timeouts during an outage and probes it again after the timeout.
+const (
+	redisBreakerFailures = 3
+	redisBreakerTimeout  = 5 * time.Second
+)
+
+// ErrRedisUnavailable is returned when the Redis circuit breaker is open
+var ErrRedisUnavailable = errors.New("redis unavailable")
+
+// RedisGuard wraps Redis calls on the request path in a circuit breaker and
+// makes limit decisions locally while Redis is unavailable
+type RedisGuard struct {
+	breaker *gobreaker.CircuitBreaker
+	mode    FailureMode
+	local   *localLimiter
+}
+
+// NewRedisGuard creates a Redis guard. Local limits are divided across the
+// expected number of replicas so the fleet as a whole stays near the limit.
+func NewRedisGuard(mode string, replicas int) *RedisGuard {
+	g := &RedisGuard{
+		mode:  parseFailureMode(mode),
+		local: newLocalLimiter(replicas),
+	}
+	g.breaker = gobreaker.NewCircuitBreaker(gobreaker.Settings{
+		Name:        "redis",
+		MaxRequests: 1,
+		Timeout:     redisBreakerTimeout,
+		ReadyToTrip: func(counts gobreaker.Counts) bool {
+			return counts.ConsecutiveFailures >= redisBreakerFailures
+		},
+		OnStateChange: func(name string, from gobreaker.State, to gobreaker.State) {
+			switch to {
+			case gobreaker.StateOpen:
+				monitoring.SetRateLimitDegraded(true)
+				log.Error().
+					Str("event", "rate_limit_degraded").
+					Str("failure_mode", string(g.mode)).
+					Msg("Redis unavailable, rate limiting running degraded")
+			case gobreaker.StateClosed:
+				monitoring.SetRateLimitDegraded(false)
+				log.Info().
+					Str("event", "rate_limit_recovered").
+					Msg("Redis available again, rate limiting restored")
+			}
+		},
+		IsSuccessful: func(err error) bool {
+			// Misses and cancelled requests say nothing about Redis health
+			return err == nil || errors.Is(err, redis.Nil) || errors.Is(err, context.Canceled)
+		},
+	})
+	return g
+}
+
+// Do runs a Redis call through the circuit breaker.
+// Returns ErrRedisUnavailable without calling Redis while the breaker is open.
+func (g *RedisGuard) Do(fn func() error) error {
+	_, err := g.breaker.Execute(func() (interface{}, error) {
+		return nil, fn()
+	})
+	if errors.Is(err, gobreaker.ErrOpenState) || errors.Is(err, gobreaker.ErrTooManyRequests) {
+		return ErrRedisUnavailable
+	}
+	return err
+}
+
+// Degraded reports whether the breaker is open
+func (g *RedisGuard) Degraded() bool {
+	return g.breaker.State() == gobreaker.StateOpen
+}
+
+// Mode returns the configured failure mode
+func (g *RedisGuard) Mode() FailureMode {
+	return g.mode
+}
+
+// fallbackTake decides a rate or token limit check without Redis
+func (g *RedisGuard) fallbackTake(key string, cost int64, limit int, window time.Duration) *RateLimitResult {
+	now := time.Now()
+	result := &RateLimitResult{Limit: limit, ResetAt: now.Add(window)}
+
+	switch g.mode {
+	case FailureModeLocal:
+		result.Allowed, result.Remaining, result.RetryAfter = g.local.take(key, cost, limit, window, now)
+	case FailureModeClosed:
+		result.RetryAfter = redisBreakerTimeout
+	default:
+		result.Allowed = true
+		result.Remaining = int64(limit)
+	}
+
+	recordDegradedDecision(g.mode, result.Allowed)
+	return result
+}
+
+// fallbackAcquire decides a concurrency slot without Redis
+func (g *RedisGuard) fallbackAcquire(key string, limit int, requestID string) (bool, int64) {
+	var acquired bool
+	var inFlight int64
+	switch g.mode {
+	case FailureModeLocal:
+		acquired, inFlight = g.local.acquire(key, requestID, limit)
+	case FailureModeClosed:
+		acquired = false
+	default:
+		acquired = true
+	}
+
+	recordDegradedDecision(g.mode, acquired)
+	return acquired, inFlight
+}
+
+func recordDegradedDecision(mode FailureMode, allowed bool) {
+	result := "allowed"
+	if !allowed {
+		result = "rejected"
+	}
+	monitoring.RecordRateLimitDegradedDecision(string(mode), result)
+}
+
+// parseFailureMode converts a configured mode; unknown values fail open,
+// matching the behaviour before failure modes existed
+func parseFailureMode(mode string) FailureMode {
+	switch FailureMode(mode) {
+	case FailureModeLocal, FailureModeClosed:
+		return FailureMode(mode)
+	default:
+		return FailureModeOpen
+	}
+}
+
+// localLimiter keeps per-instance token buckets, in-flight sets and spend
+// counters used while Redis is unavailable. Each limit is divided by the
+// replica count.
+type localLimiter struct {
+	mu        sync.Mutex
+	replicas  int
+	buckets   map[string]*tokenBucket
+	inFlight  map[string]map[string]struct{}
+	spend     map[string]*spendCounter
+	lastSweep time.Time
+}
+
+// spendCounter is spend recorded by this instance while Redis is unavailable
+type spendCounter struct {
+	amount  int64
+	expires time.Time
+}
+
+// tokenBucket refills continuously to its capacity over one window.
+// Tokens may go negative when a single request costs more than the capacity.
+type tokenBucket struct {
+	tokens   float64
+	capacity float64
+	window   time.Duration
+	updated  time.Time
+}
+
+func newLocalLimiter(replicas int) *localLimiter {
+	if replicas < 1 {
+		replicas = 1
+	}
+	return &localLimiter{
+		replicas: replicas,
+		buckets:  make(map[string]*tokenBucket),
+		inFlight: make(map[string]map[string]struct{}),
+		spend:    make(map[string]*spendCounter),
+	}
+}
+
+// share returns this instance's part of a limit, at least 1
+func (l *localLimiter) share(limit int) float64 {
+	return math.Max(1, math.Floor(float64(limit)/float64(l.replicas)))
+}
+
+// shareOf returns this instance's part of a spend cap
+func (l *localLimiter) shareOf(cap decimal.Decimal) decimal.Decimal {
+	return cap.Div(decimal.NewFromInt(int64(l.replicas)))
+}
+
+// addSpend adds to a local spend counter, which is dropped after ttl
+func (l *localLimiter) addSpend(key string, amount int64, ttl time.Duration, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c, ok := l.spend[key]
+	if !ok || !now.Before(c.expires) {
+		c = &spendCounter{expires: now.Add(ttl)}
+		l.spend[key] = c
+	}
+	c.amount += amount
+}
+
+// spent returns local spend counters as Redis would, nil for unset counters
+func (l *localLimiter) spent(now time.Time, keys ...string) []interface{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if c, ok := l.spend[key]; ok && now.Before(c.expires) {
+			values[i] = strconv.FormatInt(c.amount, 10)
+		}
+	}
+	return values
+}
+
+// take removes cost tokens from a bucket if available.
+// Returns whether the request is allowed, the tokens left and when to retry.
+func (l *localLimiter) take(key string, cost int64, limit int, window time.Duration, now time.Time) (bool, int64, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	capacity := l.share(limit)
+	b, ok := l.buckets[key]
+	if !ok || b.capacity != capacity || b.window != window {
+		b = &tokenBucket{tokens: capacity, capacity: capacity, window: window, updated: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+
+	// A request larger than the whole bucket only passes on a full bucket
+	allowed := b.tokens >= float64(cost) || (float64(cost) > b.capacity && b.tokens >= b.capacity)
+	if !allowed {
+		return false, int64(math.Max(b.tokens, 0)), b.timeUntil(float64(cost))
+	}
+	b.tokens -= float64(cost)
+	return true, int64(math.Max(b.tokens, 0)), 0
+}
+
+// adjust corrects a bucket after the actual cost of a request is known
+func (l *localLimiter) adjust(key string, delta int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if b, ok := l.buckets[key]; ok {
+		b.tokens = math.Min(b.capacity, b.tokens-float64(delta))
+	}
+}
+
+// acquire adds a request to a key's local in-flight set if it has room
+func (l *localLimiter) acquire(key, requestID string, limit int) (bool, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	set := l.inFlight[key]
+	if set == nil {
+		set = make(map[string]struct{})
+		l.inFlight[key] = set
+	}
+	if float64(len(set)) >= l.share(limit) {
+		return false, int64(len(set))
+	}
+	set[requestID] = struct{}{}
+	return true, int64(len(set))
+}
+
+// release removes a request from a key's local in-flight set.
+// Returns false if the request did not hold a local slot.
+func (l *localLimiter) release(key, requestID string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	set, ok := l.inFlight[key]
+	if !ok {
+		return false
+	}
+	if _, held := set[requestID]; !held {
+		return false
+	}
+	delete(set, requestID)
+	if len(set) == 0 {
+		delete(l.inFlight, key)
+	}
+	return true
+}
+
+// sweep drops buckets that have refilled completely, since a new bucket
+// starts full anyway, and expired spend counters. Must be called with the
+// lock held.
+func (l *localLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= b.capacity {
+			delete(l.buckets, key)
+		}
+	}
+	for key, c := range l.spend {
+		if !now.Before(c.expires) {
+			delete(l.spend, key)
+		}
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated)
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens = math.Min(b.capacity, b.tokens+b.capacity*elapsed.Seconds()/b.window.Seconds())
+	b.updated = now
+}
+
+// timeUntil returns how long until the bucket holds the given number of tokens
+func (b *tokenBucket) timeUntil(tokens float64) time.Duration {
+	target := math.Min(tokens, b.capacity)
+	wait := time.Duration((target - b.tokens) / b.capacity * float64(b.window))
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
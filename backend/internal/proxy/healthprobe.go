@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/rs/zerolog/log"
+)
+
+// probeTimeout bounds a single provider probe
+const probeTimeout = 10 * time.Second
+
+// ProbeResult is the outcome of the latest health probe of a provider
+type ProbeResult struct {
+	Provider  string    `json:"provider"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMs int       `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// HealthProber periodically sends a cheap request (listing models) to each
+// configured provider. Outcomes go through the provider's circuit breaker, so
+// an outage trips it before user traffic does and a recovery closes it.
+type HealthProber struct {
+	breakers *CircuitBreakerManager
+	client   *http.Client
+	keys     map[string]string // Provider -> API key, for providers with a key configured
+	interval time.Duration
+	results  map[string]*ProbeResult
+	mu       sync.RWMutex
+}
+
+// NewHealthProber creates a health prober for the providers with an API key configured
+func NewHealthProber(breakers *CircuitBreakerManager, cfg *config.Config) *HealthProber {
+	keys := make(map[string]string)
+	for provider, key := range map[string]string{
+		"openai":    cfg.AI.OpenAIKey,
+		"anthropic": cfg.AI.AnthropicKey,
+		"google":    cfg.AI.GoogleAIKey,
+	} {
+		if key != "" {
+			keys[provider] = key
+		}
+	}
+	return &HealthProber{
+		breakers: breakers,
+		client:   &http.Client{Timeout: probeTimeout},
+		keys:     keys,
+		interval: cfg.Proxy.HealthProbeInterval,
+		results:  make(map[string]*ProbeResult),
+	}
+}
+
+// Run probes every provider each interval until the context is cancelled.
+// Does nothing if probing is disabled or no provider is configured.
+func (p *HealthProber) Run(ctx context.Context) {
+	if p.interval <= 0 || len(p.keys) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		p.ProbeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProbeAll probes every configured provider once and refreshes the circuit breaker gauges
+func (p *HealthProber) ProbeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for provider := range p.keys {
+		wg.Add(1)
+		go func(provider string) {
+			defer wg.Done()
+			p.probe(ctx, provider)
+		}(provider)
+	}
+	wg.Wait()
+	p.breakers.PublishMetrics()
+}
+
+// Results returns the latest probe result for each provider, sorted by provider
+func (p *HealthProber) Results() []*ProbeResult {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	results := make([]*ProbeResult, 0, len(p.results))
+	for _, result := range p.results {
+		r := *result
+		results = append(results, &r)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Provider < results[j].Provider })
+	return results
+}
+
+// probe sends one probe to a provider through its circuit breaker
+func (p *HealthProber) probe(ctx context.Context, provider string) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err := p.breakers.Execute(ctx, provider, func() (interface{}, error) {
+		return nil, p.send(ctx, provider)
+	})
+
+	result := &ProbeResult{
+		Provider:  provider,
+		Healthy:   err == nil,
+		LatencyMs: int(time.Since(start).Milliseconds()),
+		CheckedAt: time.Now(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		log.Warn().Err(err).Str("provider", provider).Msg("Provider health probe failed")
+	}
+
+	p.mu.Lock()
+	p.results[provider] = result
+	p.mu.Unlock()
+}
+
+// send lists the provider's models. Server errors and rate limiting count
+// as upstream failures; other rejections (e.g. a revoked key) do not trip the breaker.
+func (p *HealthProber) send(ctx context.Context, provider string) error {
+	req, err := newProbeRequest(ctx, provider, p.keys[provider])
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return ErrUpstreamTimeout
+		}
+		return fmt.Errorf("%w: %v", ErrUpstreamError, err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("%w: probe returned status %d", ErrUpstreamError, resp.StatusCode)
+	default:
+		return fmt.Errorf("probe rejected with status %d", resp.StatusCode)
+	}
+}
+
+// newProbeRequest builds the models listing request for a provider
+func newProbeRequest(ctx context.Context, provider, apiKey string) (*http.Request, error) {
+	var url string
+	switch provider {
+	case "anthropic":
+		url = "https://api.anthropic.com/v1/models"
+	case "google":
+		url = "https://generativelanguage.googleapis.com/v1beta/models"
+	default:
+		url = "https://api.openai.com/v1/models"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create probe request: %w", err)
+	}
+	switch provider {
+	case "anthropic":
+		req.Header.Set("x-api-key", apiKey)
+		req.Header.Set("anthropic-version", "2023-06-01")
+	case "google":
+		req.Header.Set("x-goog-api-key", apiKey)
+	default:
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	return req, nil
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/cache"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// IdempotencyTTL is how long a completed response can be replayed
+	IdempotencyTTL = 24 * time.Hour
+	// idempotencyLockTTL bounds how long an unfinished request holds its key if
+	// the proxy dies mid-call; it exceeds the longest upstream timeout
+	idempotencyLockTTL = 5 * time.Minute
+	// MaxIdempotencyKeyLength is the longest Idempotency-Key header accepted
+	MaxIdempotencyKeyLength = 255
+	// MaxIdempotentResponseSize is the largest response body stored for replay
+	MaxIdempotentResponseSize = 4 << 20
+	// MaxIdempotentRequestSize is the largest request body read to fingerprint
+	// a request with an Idempotency-Key
+	MaxIdempotentRequestSize = 4 << 20
+)
+
+var (
+	ErrIdempotencyKeyInUse    = errors.New("idempotency key is in use by a request in progress")
+	ErrIdempotencyKeyMismatch = errors.New("idempotency key was used with a different request")
+)
+
+// IdempotentResponse is a completed response stored under an idempotency key
+type IdempotentResponse struct {
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body,omitempty"` // nil if the response was too large to store
+}
+
+// idempotencyRecord is the Redis value for an idempotency key
+type idempotencyRecord struct {
+	Fingerprint string              `json:"fingerprint"`
+	Completed   bool                `json:"completed"`
+	Response    *IdempotentResponse `json:"response,omitempty"`
+}
+
+// IdempotencyStore keeps Idempotency-Key claims and completed responses in
+// Redis, scoped per API key, so client retries are not called or charged twice
+type IdempotencyStore struct {
+	redis *cache.Redis
+	guard *RedisGuard
+}
+
+// NewIdempotencyStore creates a new idempotency store
+func NewIdempotencyStore(redis *cache.Redis, guard *RedisGuard) *IdempotencyStore {
+	return &IdempotencyStore{
+		redis: redis,
+		guard: guard,
+	}
+}
+
+// Begin claims an idempotency key for a request with the given fingerprint.
+// Returns nil if the key was claimed and the request should proceed, or the
+// stored response if the same request already completed. Returns
+// ErrIdempotencyKeyInUse while the first request is still running and
+// ErrIdempotencyKeyMismatch if the key was used for a different request.
+func (s *IdempotencyStore) Begin(ctx context.Context, apiKeyID uuid.UUID, key, fingerprint string) (*IdempotentResponse, error) {
+	redisKey := idempotencyKey(apiKeyID, key)
+	claim, err := json.Marshal(idempotencyRecord{Fingerprint: fingerprint})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	// A claim can expire between SETNX and GET; try once more if it does
+	for attempt := 0; attempt < 2; attempt++ {
+		var claimed bool
+		err := s.guard.Do(func() error {
+			var err error
+			claimed, err = s.redis.Client.SetNX(ctx, redisKey, claim, idempotencyLockTTL).Result()
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+		}
+		if claimed {
+			return nil, nil
+		}
+
+		var raw []byte
+		err = s.guard.Do(func() error {
+			var err error
+			raw, err = s.redis.Client.Get(ctx, redisKey).Bytes()
+			return err
+		})
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get idempotency record: %w", err)
+		}
+
+		var record idempotencyRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return nil, fmt.Errorf("failed to decode idempotency record: %w", err)
+		}
+		if record.Fingerprint != fingerprint {
+			return nil, ErrIdempotencyKeyMismatch
+		}
+		if !record.Completed {
+			return nil, ErrIdempotencyKeyInUse
+		}
+		return record.Response, nil
+	}
+	return nil, ErrIdempotencyKeyInUse
+}
+
+// Complete stores the response of a claimed request for replay
+func (s *IdempotencyStore) Complete(ctx context.Context, apiKeyID uuid.UUID, key, fingerprint string, resp *IdempotentResponse) error {
+	if len(resp.Body) > MaxIdempotentResponseSize {
+		resp = &IdempotentResponse{StatusCode: resp.StatusCode, ContentType: resp.ContentType}
+	}
+	value, err := json.Marshal(idempotencyRecord{Fingerprint: fingerprint, Completed: true, Response: resp})
+	if err != nil {
+		return fmt.Errorf("failed to encode idempotency record: %w", err)
+	}
+
+	err = s.guard.Do(func() error {
+		return s.redis.Client.Set(ctx, idempotencyKey(apiKeyID, key), value, IdempotencyTTL).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store idempotent response: %w", err)
+	}
+	return nil
+}
+
+// Release frees a claimed key so a failed request can be retried with it
+func (s *IdempotencyStore) Release(ctx context.Context, apiKeyID uuid.UUID, key string) error {
+	err := s.guard.Do(func() error {
+		return s.redis.Client.Del(ctx, idempotencyKey(apiKeyID, key)).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// IdempotencyFingerprint identifies a request body sent to an agent, so a
+// reused key can be told apart from a genuine retry
+func IdempotencyFingerprint(agentID uuid.UUID, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(agentID.String()))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// idempotencyKey returns the Redis key for an API key's idempotency key
+func idempotencyKey(apiKeyID uuid.UUID, key string) string {
+	return fmt.Sprintf("idempotency:%s:%s", apiKeyID, key)
+}
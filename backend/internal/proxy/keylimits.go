@@ -0,0 +1,290 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/cache"
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// concurrencySlotTTL bounds how long an in-flight slot is held if a release is
+// lost (e.g. the proxy crashes mid-request). It must exceed the longest call.
+const concurrencySlotTTL = 10 * time.Minute
+
+// Spend periods for key budgets
+const (
+	SpendPeriodDaily   = "daily"
+	SpendPeriodMonthly = "monthly"
+)
+
+// usdMicros scales USD spend to integer micro-dollars for Redis INCRBY
+const usdMicros = 6
+
+// Lua script for acquiring an in-flight slot
+// Returns: {acquired, in_flight}
+const luaAcquireSlot = `
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+local stale_before = tonumber(ARGV[3])
+local member = ARGV[4]
+local ttl = tonumber(ARGV[5])
+
+-- Drop slots whose release was lost
+redis.call('ZREMRANGEBYSCORE', key, '-inf', stale_before)
+
+local count = redis.call('ZCARD', key)
+if count >= limit then
+    return {0, count}
+end
+
+redis.call('ZADD', key, now, member)
+redis.call('EXPIRE', key, ttl)
+return {1, count + 1}
+`
+
+// KeyLimiter enforces optional per-API-key rate, concurrency and spend limits in Redis
+type KeyLimiter struct {
+	redis       *cache.Redis
+	rateLimiter *RateLimiter
+}
+
+// KeyLimitStatus describes a key's limits after a check, for response headers.
+// Fields are nil/zero for limits the key does not set.
+type KeyLimitStatus struct {
+	RateLimit        *RateLimitResult
+	ConcurrencyLimit int
+	InFlight         int64
+	Budget           *KeyBudget
+}
+
+// KeyBudget is the remaining spend of a key in its cap unit
+type KeyBudget struct {
+	Unit             models.SpendUnit
+	DailyRemaining   *decimal.Decimal
+	MonthlyRemaining *decimal.Decimal
+	ExceededPeriod   string // SpendPeriodDaily or SpendPeriodMonthly when a cap is reached
+}
+
+// NewKeyLimiter creates a new per-key limiter
+func NewKeyLimiter(redis *cache.Redis, rateLimiter *RateLimiter) *KeyLimiter {
+	return &KeyLimiter{
+		redis:       redis,
+		rateLimiter: rateLimiter,
+	}
+}
+
+// CheckRate applies the key's requests-per-minute limit.
+// Returns nil if the key has no rate limit.
+func (k *KeyLimiter) CheckRate(ctx context.Context, apiKey *models.APIKey) (*RateLimitResult, error) {
+	if apiKey.Limits.RateLimitRPM == nil {
+		return nil, nil
+	}
+	return k.rateLimiter.checkSlidingWindow(ctx, "apikey:"+apiKey.ID.String(), *apiKey.Limits.RateLimitRPM, 60)
+}
+
+// AcquireSlot reserves an in-flight slot for the request.
+// Always succeeds if the key has no concurrency limit.
+func (k *KeyLimiter) AcquireSlot(ctx context.Context, apiKey *models.APIKey, requestID string) (bool, int64) {
+	if apiKey.Limits.MaxConcurrent == nil {
+		return true, 0
+	}
+	return k.rateLimiter.acquireSlot(ctx, inFlightKey(apiKey), *apiKey.Limits.MaxConcurrent, requestID)
+}
+
+// ReleaseSlot frees the request's in-flight slot
+func (k *KeyLimiter) ReleaseSlot(ctx context.Context, apiKey *models.APIKey, requestID string) error {
+	if apiKey.Limits.MaxConcurrent == nil {
+		return nil
+	}
+	return k.rateLimiter.releaseSlot(ctx, inFlightKey(apiKey), requestID)
+}
+
+// GetBudget returns the key's remaining daily and monthly spend.
+// Without Redis it follows the failure mode: no budget when failing open,
+// ErrKeyLimitsUnavailable when failing closed, and this instance's share of
+// each cap against its own spend when failing local.
+// Returns nil if the key has no spend cap.
+func (k *KeyLimiter) GetBudget(ctx context.Context, apiKey *models.APIKey, now time.Time) (*KeyBudget, error) {
+	limits := apiKey.Limits
+	if !limits.HasSpendCap() {
+		return nil, nil
+	}
+
+	dailyKey, monthlyKey := spendKeys(apiKey, limits.SpendCapUnit, now)
+	var values []interface{}
+	err := k.rateLimiter.guard.Do(func() error {
+		var err error
+		values, err = k.redis.Client.MGet(ctx, dailyKey, monthlyKey).Result()
+		return err
+	})
+	local := false
+	if err != nil {
+		if !errors.Is(err, ErrRedisUnavailable) {
+			log.Error().Err(err).Str("api_key_id", apiKey.ID.String()).Msg("Failed to get key spend")
+		}
+		// On Redis error, decide according to the configured failure mode
+		switch guard := k.rateLimiter.guard; guard.Mode() {
+		case FailureModeLocal:
+			values = guard.local.spent(now, dailyKey, monthlyKey)
+			local = true
+		case FailureModeClosed:
+			recordDegradedDecision(FailureModeClosed, false)
+			return nil, ErrKeyLimitsUnavailable
+		default:
+			recordDegradedDecision(FailureModeOpen, true)
+			return nil, nil
+		}
+	}
+
+	budget := &KeyBudget{Unit: limits.SpendCapUnit}
+	periods := []struct {
+		name      string
+		cap       *decimal.Decimal
+		raw       interface{}
+		remaining **decimal.Decimal
+	}{
+		{SpendPeriodDaily, limits.DailySpendCap, values[0], &budget.DailyRemaining},
+		{SpendPeriodMonthly, limits.MonthlySpendCap, values[1], &budget.MonthlyRemaining},
+	}
+	for _, p := range periods {
+		if p.cap == nil {
+			continue
+		}
+		limit := *p.cap
+		if local {
+			// Only this instance's spend is known, so hold it to its share
+			limit = k.rateLimiter.guard.local.shareOf(limit)
+		}
+		spent := parseSpend(p.raw, limits.SpendCapUnit)
+		remaining := decimal.Max(limit.Sub(spent), decimal.Zero)
+		*p.remaining = &remaining
+		if budget.ExceededPeriod == "" && spendCapReached(limits.SpendCapUnit, spent, limit) {
+			budget.ExceededPeriod = p.name
+		}
+	}
+
+	if local {
+		recordDegradedDecision(FailureModeLocal, budget.ExceededPeriod == "")
+	}
+	return budget, nil
+}
+
+// RecordSpend adds a successful call's cost to the key's spend counters.
+// Both units are tracked so a key can switch cap unit mid-period. Keys without
+// a spend cap are not tracked, so a cap only counts spend from when it is set.
+func (k *KeyLimiter) RecordSpend(ctx context.Context, apiKey *models.APIKey, quotaUnits int64, cost decimal.Decimal, now time.Time) error {
+	if !apiKey.Limits.HasSpendCap() {
+		return nil
+	}
+
+	quotaDaily, quotaMonthly := spendKeys(apiKey, models.SpendUnitQuota, now)
+	usdDaily, usdMonthly := spendKeys(apiKey, models.SpendUnitUSD, now)
+	costMicros := cost.Shift(usdMicros).IntPart()
+
+	pipe := k.redis.Client.Pipeline()
+	pipe.IncrBy(ctx, quotaDaily, quotaUnits)
+	pipe.IncrBy(ctx, usdDaily, costMicros)
+	pipe.IncrBy(ctx, quotaMonthly, quotaUnits)
+	pipe.IncrBy(ctx, usdMonthly, costMicros)
+	// Keep counters a little past their period so late reads still see them
+	pipe.Expire(ctx, quotaDaily, 48*time.Hour)
+	pipe.Expire(ctx, usdDaily, 48*time.Hour)
+	pipe.Expire(ctx, quotaMonthly, 32*24*time.Hour)
+	pipe.Expire(ctx, usdMonthly, 32*24*time.Hour)
+	err := k.rateLimiter.guard.Do(func() error {
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if err != nil {
+		if guard := k.rateLimiter.guard; guard.Mode() == FailureModeLocal {
+			// Count the spend locally so this instance keeps to its share
+			guard.local.addSpend(quotaDaily, quotaUnits, 48*time.Hour, now)
+			guard.local.addSpend(usdDaily, costMicros, 48*time.Hour, now)
+			guard.local.addSpend(quotaMonthly, quotaUnits, 32*24*time.Hour, now)
+			guard.local.addSpend(usdMonthly, costMicros, 32*24*time.Hour, now)
+		}
+		return fmt.Errorf("failed to record key spend: %w", err)
+	}
+	return nil
+}
+
+// acquireSlot adds a request to an in-flight set if it holds fewer than limit requests.
+// Returns whether the slot was acquired and the number of requests in flight.
+func (r *RateLimiter) acquireSlot(ctx context.Context, key string, limit int, requestID string) (bool, int64) {
+	now := time.Now()
+	var result []int64
+	err := r.guard.Do(func() error {
+		var err error
+		result, err = r.redis.Client.Eval(ctx, luaAcquireSlot, []string{key},
+			limit,
+			now.UnixNano(),
+			now.Add(-concurrencySlotTTL).UnixNano(),
+			requestID,
+			int(concurrencySlotTTL.Seconds()),
+		).Int64Slice()
+		return err
+	})
+	if err != nil {
+		if !errors.Is(err, ErrRedisUnavailable) {
+			log.Error().Err(err).Str("key", key).Msg("Failed to acquire concurrency slot")
+		}
+		// On Redis error, decide according to the configured failure mode
+		return r.guard.fallbackAcquire(key, limit, requestID)
+	}
+
+	return result[0] == 1, result[1]
+}
+
+// releaseSlot frees a slot taken by acquireSlot, locally or in Redis
+func (r *RateLimiter) releaseSlot(ctx context.Context, key, requestID string) error {
+	if r.guard.local.release(key, requestID) {
+		return nil
+	}
+	return r.guard.Do(func() error {
+		return r.redis.Client.ZRem(ctx, key, requestID).Err()
+	})
+}
+
+// spendCapReached reports whether a key may not start another call.
+// A quota call costs exactly one unit, so it must still fit under the cap;
+// USD cost is only known afterwards, so calls are allowed until the cap is hit.
+func spendCapReached(unit models.SpendUnit, spent, cap decimal.Decimal) bool {
+	if unit == models.SpendUnitQuota {
+		return spent.Add(decimal.NewFromInt(1)).GreaterThan(cap)
+	}
+	return spent.GreaterThanOrEqual(cap)
+}
+
+// parseSpend converts a raw Redis counter to spend in the given unit
+func parseSpend(raw interface{}, unit models.SpendUnit) decimal.Decimal {
+	str, ok := raw.(string)
+	if !ok {
+		return decimal.Zero
+	}
+	spent, err := decimal.NewFromString(str)
+	if err != nil {
+		return decimal.Zero
+	}
+	if unit == models.SpendUnitUSD {
+		return spent.Shift(-usdMicros)
+	}
+	return spent
+}
+
+// spendKeys returns the Redis counters for a key's spend in the current UTC day and month
+func spendKeys(apiKey *models.APIKey, unit models.SpendUnit, now time.Time) (string, string) {
+	now = now.UTC()
+	prefix := fmt.Sprintf("spend:apikey:%s:%s", apiKey.ID, unit)
+	return prefix + ":d:" + now.Format("20060102"), prefix + ":m:" + now.Format("200601")
+}
+
+// inFlightKey returns the Redis sorted set of a key's in-flight requests
+func inFlightKey(apiKey *models.APIKey) string {
+	return fmt.Sprintf("ratelimit:inflight:apikey:%s", apiKey.ID)
+}
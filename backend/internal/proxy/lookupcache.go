@@ -0,0 +1,321 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/agent"
+	"github.com/aimerfeng/AgentLink/internal/apikey"
+	"github.com/aimerfeng/AgentLink/internal/cache"
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+// invalidationChannel is the Postgres NOTIFY channel written by the
+// cache invalidation triggers on api_keys and agents
+const invalidationChannel = "cache_invalidation"
+
+// knownIPTTL is how long a key's recorded client IP is remembered. A recorded
+// IP stays recorded, so this only bounds memory and Redis use.
+const knownIPTTL = 24 * time.Hour
+
+// LookupCache caches API key and agent lookups for the proxy hot path in two
+// tiers: an in-process LRU and Redis shared across proxies. Entries are
+// invalidated through Postgres LISTEN/NOTIFY and otherwise expire after a
+// short TTL, which bounds staleness if a notification is missed.
+//
+// Cached values are shared between requests and must not be modified.
+type LookupCache struct {
+	db            *pgxpool.Pool
+	redis         *cache.Redis
+	agentService  *agent.Service
+	apiKeyService *apikey.Service
+	ttl           time.Duration
+	keys          *lruCache[*models.APIKey]
+	agents        *lruCache[*cachedAgent]
+	knownIPs      *lruCache[struct{}]
+	// generation is bumped on every invalidation so a lookup racing with
+	// one does not store the value it loaded before the change
+	generation atomic.Uint64
+	enabled    bool
+}
+
+// cachedAgent is an agent with its decrypted config, held only in process
+type cachedAgent struct {
+	agent  *models.Agent
+	config *models.AgentConfig
+}
+
+// redisAgent is the shared-tier form of an agent. The config stays encrypted
+// so plaintext system prompts and provider credentials never leave the process.
+type redisAgent struct {
+	Agent           *models.Agent `json:"agent"`
+	ConfigEncrypted []byte        `json:"config_encrypted"`
+	ConfigIV        []byte        `json:"config_iv"`
+}
+
+// NewLookupCache creates a lookup cache. A size of 0 disables caching.
+func NewLookupCache(db *pgxpool.Pool, redis *cache.Redis, agentSvc *agent.Service, apiKeySvc *apikey.Service, size int, ttl time.Duration) *LookupCache {
+	if ttl <= 0 {
+		ttl = 30 * time.Second
+	}
+	return &LookupCache{
+		db:            db,
+		redis:         redis,
+		agentService:  agentSvc,
+		apiKeyService: apiKeySvc,
+		ttl:           ttl,
+		keys:          newLRUCache[*models.APIKey](size, ttl),
+		agents:        newLRUCache[*cachedAgent](size, ttl),
+		knownIPs:      newLRUCache[struct{}](size, knownIPTTL),
+		enabled:       size > 0,
+	}
+}
+
+// GetAPIKey returns the key with the given hash, including revoked and expired keys.
+// Returns apikey.ErrInvalidAPIKey if no key has the hash; misses are not cached.
+func (c *LookupCache) GetAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	if !c.enabled {
+		return c.apiKeyService.GetByHash(ctx, keyHash)
+	}
+
+	if apiKey, ok := c.keys.Get(keyHash); ok {
+		return apiKey, nil
+	}
+
+	generation := c.generation.Load()
+	redisKey := lookupRedisKey("apikey", keyHash)
+
+	var apiKey *models.APIKey
+	if c.getRedis(ctx, redisKey, &apiKey) && apiKey != nil {
+		apiKey.KeyHash = keyHash // Not serialized
+	} else {
+		var err error
+		apiKey, err = c.apiKeyService.GetByHash(ctx, keyHash)
+		if err != nil {
+			return nil, err
+		}
+		if c.generation.Load() == generation {
+			c.setRedis(ctx, redisKey, apiKey)
+		}
+	}
+
+	if c.generation.Load() == generation {
+		c.keys.Set(keyHash, apiKey)
+	}
+	return apiKey, nil
+}
+
+// GetAgent returns an agent and its decrypted config, whatever its status.
+// Returns agent.ErrAgentNotFound if the agent does not exist.
+func (c *LookupCache) GetAgent(ctx context.Context, agentID uuid.UUID) (*models.Agent, *models.AgentConfig, error) {
+	id := agentID.String()
+	if c.enabled {
+		if cached, ok := c.agents.Get(id); ok {
+			return cached.agent, cached.config, nil
+		}
+	}
+
+	generation := c.generation.Load()
+	redisKey := lookupRedisKey("agent", id)
+
+	var shared redisAgent
+	var agentModel *models.Agent
+	if c.enabled && c.getRedis(ctx, redisKey, &shared) && shared.Agent != nil {
+		agentModel = shared.Agent
+		agentModel.ConfigEncrypted = shared.ConfigEncrypted
+		agentModel.ConfigIV = shared.ConfigIV
+	} else {
+		var err error
+		agentModel, err = c.agentService.GetByID(ctx, agentID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.enabled && c.generation.Load() == generation {
+			c.setRedis(ctx, redisKey, &redisAgent{
+				Agent:           agentModel,
+				ConfigEncrypted: agentModel.ConfigEncrypted,
+				ConfigIV:        agentModel.ConfigIV,
+			})
+		}
+	}
+
+	agentConfig, err := c.agentService.DecryptConfig(agentModel)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.enabled && c.generation.Load() == generation {
+		c.agents.Set(id, &cachedAgent{agent: agentModel, config: agentConfig})
+	}
+	return agentModel, agentConfig, nil
+}
+
+// KeyIPKnown reports whether the process has seen the key's use from ip
+// recorded. It checks only the local tier, so it is cheap on the hot path.
+func (c *LookupCache) KeyIPKnown(keyID uuid.UUID, ip string) bool {
+	_, ok := c.knownIPs.Get(knownIPKey(keyID, ip))
+	return ok
+}
+
+// SharedKeyIPKnown reports whether any proxy has seen the key's use from ip
+// recorded, remembering it locally if so
+func (c *LookupCache) SharedKeyIPKnown(ctx context.Context, keyID uuid.UUID, ip string) bool {
+	if c.KeyIPKnown(keyID, ip) {
+		return true
+	}
+	var known bool
+	if !c.enabled || !c.getRedis(ctx, lookupRedisKey("keyip", knownIPKey(keyID, ip)), &known) {
+		return false
+	}
+	c.knownIPs.Set(knownIPKey(keyID, ip), struct{}{})
+	return true
+}
+
+// SetKeyIPKnown remembers in both tiers that the key's use from ip is recorded
+func (c *LookupCache) SetKeyIPKnown(ctx context.Context, keyID uuid.UUID, ip string) {
+	if !c.enabled {
+		return
+	}
+	c.knownIPs.Set(knownIPKey(keyID, ip), struct{}{})
+	if c.redis != nil {
+		if err := c.redis.Client.Set(ctx, lookupRedisKey("keyip", knownIPKey(keyID, ip)), "true", knownIPTTL).Err(); err != nil {
+			log.Warn().Err(err).Str("api_key_id", keyID.String()).Msg("Failed to write shared lookup cache")
+		}
+	}
+}
+
+// Invalidate drops a cached entry from both tiers. The payload is the
+// trigger's notification, e.g. "apikey:<key_hash>" or "agent:<id>", or
+// "quota:<user_id>" after a balance changed outside the proxy.
+func (c *LookupCache) Invalidate(ctx context.Context, payload string) {
+	kind, id, ok := strings.Cut(payload, ":")
+	if !ok {
+		return
+	}
+
+	c.generation.Add(1)
+	redisKey := lookupRedisKey(kind, id)
+	switch kind {
+	case "apikey":
+		c.keys.Delete(id)
+	case "agent":
+		c.agents.Delete(id)
+	case "quota":
+		// The cached balance is reloaded from the database by the next call
+		redisKey = payload
+	default:
+		log.Warn().Str("payload", payload).Msg("Unknown cache invalidation payload")
+		return
+	}
+
+	if c.redis != nil {
+		if err := c.redis.Client.Del(ctx, redisKey).Err(); err != nil {
+			log.Warn().Err(err).Str("payload", payload).Msg("Failed to invalidate shared lookup cache")
+		}
+	}
+}
+
+// ListenForInvalidations applies invalidation notifications until the context
+// is cancelled, reconnecting with backoff. The local tier is purged after a
+// disconnect because notifications may have been missed meanwhile. It runs
+// even with caching disabled, since quota notifications apply regardless.
+func (c *LookupCache) ListenForInvalidations(ctx context.Context) {
+	backoff := time.Second
+	for {
+		connectedAt := time.Now()
+		err := c.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		c.generation.Add(1)
+		c.keys.Purge()
+		c.agents.Purge()
+
+		if time.Since(connectedAt) > time.Minute {
+			backoff = time.Second
+		}
+		log.Warn().Err(err).Dur("retry_in", backoff).Msg("Cache invalidation listener disconnected")
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// listen holds a dedicated connection in LISTEN mode and applies notifications
+func (c *LookupCache) listen(ctx context.Context) error {
+	poolConn, err := c.db.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// Take the connection out of the pool so it is never reused while listening
+	conn := poolConn.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+invalidationChannel); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		c.Invalidate(ctx, notification.Payload)
+	}
+}
+
+// getRedis reads a shared-tier entry; any error counts as a miss
+func (c *LookupCache) getRedis(ctx context.Context, key string, dest interface{}) bool {
+	if c.redis == nil {
+		return false
+	}
+	data, err := c.redis.Client.Get(ctx, key).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Warn().Err(err).Str("key", key).Msg("Failed to read shared lookup cache")
+		}
+		return false
+	}
+	if err := json.Unmarshal(data, dest); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("Failed to decode shared lookup cache entry")
+		return false
+	}
+	return true
+}
+
+// setRedis writes a shared-tier entry with the cache TTL
+func (c *LookupCache) setRedis(ctx context.Context, key string, value interface{}) {
+	if c.redis == nil {
+		return
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	if err := c.redis.Client.Set(ctx, key, data, c.ttl).Err(); err != nil {
+		log.Warn().Err(err).Str("key", key).Msg("Failed to write shared lookup cache")
+	}
+}
+
+// knownIPKey returns the cache key of a key's client IP
+func knownIPKey(keyID uuid.UUID, ip string) string {
+	return keyID.String() + ":" + ip
+}
+
+// lookupRedisKey returns the shared-tier key for a cached lookup
+func lookupRedisKey(kind, id string) string {
+	return "lookup:" + kind + ":" + id
+}
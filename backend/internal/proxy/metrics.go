@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/aimerfeng/AgentLink/internal/monitoring"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// upstreamStatusError is an upstream response with an unexpected status. It
+// matches ErrUpstreamError.
+type upstreamStatusError struct {
+	status int
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("%s: status %d", ErrUpstreamError, e.status)
+}
+
+func (e *upstreamStatusError) Unwrap() error {
+	return ErrUpstreamError
+}
+
+// providerName returns the agent's provider, defaulting to openai
+func providerName(agentConfig *models.AgentConfig) string {
+	if agentConfig.Provider == "" {
+		return "openai"
+	}
+	return agentConfig.Provider
+}
+
+// TierLabel returns the metric label for a caller's tier
+func TierLabel(isPaidUser bool) string {
+	if isPaidUser {
+		return "paid"
+	}
+	return "free"
+}
+
+// recordUpstream records the outcome and latency of one provider call
+func recordUpstream(agentConfig *models.AgentConfig, start time.Time, err error) {
+	provider := providerName(agentConfig)
+	monitoring.RecordAIProviderLatency(provider, agentConfig.Model, time.Since(start))
+	if err == nil {
+		monitoring.RecordAIProviderRequest(provider, agentConfig.Model, "success")
+		return
+	}
+	monitoring.RecordAIProviderRequest(provider, agentConfig.Model, "error")
+	monitoring.RecordAIProviderError(provider, agentConfig.Model, upstreamErrorType(err))
+}
+
+// upstreamErrorType classifies a provider call failure for metrics
+func upstreamErrorType(err error) string {
+	var statusErr *upstreamStatusError
+	switch {
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("status_%d", statusErr.status)
+	case errors.Is(err, ErrUpstreamTimeout), errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, ErrCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, ErrUpstreamError):
+		return "network"
+	default:
+		return "decode"
+	}
+}
+
+// recordStream records time to first token and stream duration of a
+// streamed response that produced at least one chunk
+func recordStream(agentConfig *models.AgentConfig, start time.Time, result *StreamResult) {
+	if result == nil || result.FirstChunkAt.IsZero() {
+		return
+	}
+	provider := providerName(agentConfig)
+	monitoring.RecordTimeToFirstToken(provider, agentConfig.Model, result.FirstChunkAt.Sub(start))
+	monitoring.RecordStreamDuration(provider, agentConfig.Model, time.Since(result.FirstChunkAt))
+}
+
+// runTopAgents keeps the agents labelled individually in metrics up to date
+// with the most-called agents until the context is cancelled
+func (s *Service) runTopAgents(ctx context.Context) {
+	cfg := s.config.Monitoring
+	if cfg.TopAgents <= 0 || cfg.TopAgentsRefresh <= 0 {
+		return
+	}
+
+	refresh := func() {
+		if err := s.refreshTopAgents(ctx, cfg.TopAgents); err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("Failed to refresh top agents for metrics")
+		}
+	}
+	refresh()
+
+	ticker := time.NewTicker(cfg.TopAgentsRefresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			refresh()
+		}
+	}
+}
+
+// refreshTopAgents sets the limit most-called agents as metric labels
+func (s *Service) refreshTopAgents(ctx context.Context, limit int) error {
+	rows, err := s.db.Query(ctx, `
+		SELECT id FROM agents
+		WHERE total_calls > 0
+		ORDER BY total_calls DESC, id
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return fmt.Errorf("failed to query top agents: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0, limit)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return fmt.Errorf("failed to scan agent: %w", err)
+		}
+		ids = append(ids, id.String())
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query top agents: %w", err)
+	}
+
+	monitoring.SetTopAgents(ids)
+	return nil
+}
@@ -11,11 +11,16 @@ import (
 	"time"
 
 	"github.com/aimerfeng/AgentLink/internal/agent"
+	"github.com/aimerfeng/AgentLink/internal/alerts"
 	"github.com/aimerfeng/AgentLink/internal/apikey"
 	"github.com/aimerfeng/AgentLink/internal/cache"
 	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/ledger"
 	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/aimerfeng/AgentLink/internal/tracing"
+	"github.com/aimerfeng/AgentLink/internal/webhook"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/rs/zerolog/log"
 	"github.com/shopspring/decimal"
@@ -23,14 +28,29 @@ import (
 
 // Service errors
 var (
-	ErrAgentNotFound      = errors.New("agent not found")
-	ErrAgentNotActive     = errors.New("agent is not active")
-	ErrInvalidAPIKey      = errors.New("invalid or revoked API key")
-	ErrQuotaExhausted     = errors.New("API quota exhausted")
-	ErrRateLimited        = errors.New("rate limit exceeded")
-	ErrUpstreamTimeout    = errors.New("upstream service timeout")
-	ErrUpstreamError      = errors.New("upstream service error")
-	ErrInvalidRequest     = errors.New("invalid request")
+	ErrAgentNotFound         = errors.New("agent not found")
+	ErrAgentNotActive        = errors.New("agent is not active")
+	ErrInvalidAPIKey         = errors.New("invalid or revoked API key")
+	ErrAPIKeyExpired         = errors.New("API key has expired")
+	ErrAccountSuspended      = errors.New("account is suspended")
+	ErrAccountBanned         = errors.New("account is banned")
+	ErrQuotaExhausted        = errors.New("API quota exhausted")
+	ErrQuotaNotFound         = errors.New("quota record not found")
+	ErrRateLimited           = errors.New("rate limit exceeded")
+	ErrUpstreamTimeout       = errors.New("upstream service timeout")
+	ErrUpstreamError         = errors.New("upstream service error")
+	ErrInvalidRequest        = errors.New("invalid request")
+	ErrKeyAgentNotAllowed    = errors.New("API key is not allowed to call this agent")
+	ErrKeyPermissionDenied   = errors.New("API key lacks required permission")
+	ErrKeyIPNotAllowed       = errors.New("API key is not allowed from this IP address")
+	ErrKeyOriginNotAllowed   = errors.New("API key is not allowed from this origin")
+	ErrKeyRateLimited        = errors.New("API key rate limit exceeded")
+	ErrKeyConcurrencyLimit   = errors.New("API key concurrent request limit reached")
+	ErrKeySpendCapExceeded   = errors.New("API key spend cap reached")
+	ErrKeyLimitsUnavailable  = errors.New("API key limits unavailable")
+	ErrTokenRateLimited      = errors.New("token rate limit exceeded")
+	ErrAgentRateLimited      = errors.New("agent rate limit exceeded")
+	ErrAgentConcurrencyLimit = errors.New("agent concurrent request limit reached")
 )
 
 // Service handles proxy gateway operations
@@ -45,7 +65,13 @@ type Service struct {
 	promptInjector        *PromptInjector
 	streamHandler         *StreamHandler
 	rateLimiter           *RateLimiter
+	keyLimiter            *KeyLimiter
+	agentLimiter          *AgentLimiter
+	lookupCache           *LookupCache
 	circuitBreakerManager *CircuitBreakerManager
+	healthProber          *HealthProber
+	idempotency           *IdempotencyStore
+	reconciler            *QuotaReconciler
 	timeoutManager        *TimeoutManager
 }
 
@@ -71,13 +97,25 @@ func NewService(
 		config:         cfg,
 		httpClient: &http.Client{
 			Timeout: time.Duration(cfg.Proxy.DefaultTimeout) * time.Second,
+			// Records upstream spans and propagates traceparent to providers
+			Transport: tracing.NewTransport(nil),
 		},
 		promptInjector:        promptInjector,
 		streamHandler:         NewStreamHandler(promptInjector),
 		rateLimiter:           NewRateLimiter(redis, &cfg.RateLimit),
-		circuitBreakerManager: NewCircuitBreakerManager(DefaultCircuitBreakerConfig()),
 		timeoutManager:        NewTimeoutManager(timeoutCfg),
 	}
+	if cfg.Proxy.SharedCircuitBreaker {
+		svc.circuitBreakerManager = NewSharedCircuitBreakerManager(DefaultCircuitBreakerConfig(), redis, svc.rateLimiter.guard)
+	} else {
+		svc.circuitBreakerManager = NewCircuitBreakerManager(DefaultCircuitBreakerConfig())
+	}
+	svc.healthProber = NewHealthProber(svc.circuitBreakerManager, cfg)
+	svc.idempotency = NewIdempotencyStore(redis, svc.rateLimiter.guard)
+	svc.reconciler = NewQuotaReconciler(ledger.NewService(db), redis, svc.rateLimiter.guard, cfg)
+	svc.keyLimiter = NewKeyLimiter(redis, svc.rateLimiter)
+	svc.agentLimiter = NewAgentLimiter(redis, svc.rateLimiter)
+	svc.lookupCache = NewLookupCache(db, redis, agentSvc, apiKeySvc, cfg.Proxy.CacheSize, cfg.Proxy.CacheTTL)
 	svc.quotaManager = NewQuotaManager(svc)
 	return svc
 }
@@ -102,17 +140,52 @@ func (s *Service) GetRateLimiter() *RateLimiter {
 	return s.rateLimiter
 }
 
+// GetKeyLimiter returns the per-key limiter
+func (s *Service) GetKeyLimiter() *KeyLimiter {
+	return s.keyLimiter
+}
+
+// GetLookupCache returns the API key and agent lookup cache
+func (s *Service) GetLookupCache() *LookupCache {
+	return s.lookupCache
+}
+
+// RunBackground runs the service's background workers until the context is
+// cancelled: cache invalidation, batched API key last-used writes and the
+// top agents labelled in metrics
+func (s *Service) RunBackground(ctx context.Context) {
+	go s.lookupCache.ListenForInvalidations(ctx)
+	go s.apiKeyService.RunUsageFlusher(ctx, s.config.Proxy.LastUsedFlushInterval)
+	go s.healthProber.Run(ctx)
+	go s.reconciler.Run(ctx)
+	go s.runTopAgents(ctx)
+}
+
 // GetCircuitBreakerManager returns the circuit breaker manager
 func (s *Service) GetCircuitBreakerManager() *CircuitBreakerManager {
 	return s.circuitBreakerManager
 }
 
+// GetHealthProber returns the provider health prober
+func (s *Service) GetHealthProber() *HealthProber {
+	return s.healthProber
+}
+
+// GetIdempotencyStore returns the Idempotency-Key store
+func (s *Service) GetIdempotencyStore() *IdempotencyStore {
+	return s.idempotency
+}
+
+// GetQuotaReconciler returns the quota ledger reconciler
+func (s *Service) GetQuotaReconciler() *QuotaReconciler {
+	return s.reconciler
+}
+
 // GetTimeoutManager returns the timeout manager
 func (s *Service) GetTimeoutManager() *TimeoutManager {
 	return s.timeoutManager
 }
 
-
 // ChatRequest represents a chat request to the proxy
 type ChatRequest struct {
 	Messages []ChatMessage `json:"messages" binding:"required"`
@@ -157,6 +230,7 @@ type StreamChunk struct {
 	Created int64        `json:"created"`
 	Model   string       `json:"model"`
 	Choices []ChatChoice `json:"choices"`
+	Usage   *ChatUsage   `json:"usage,omitempty"`
 }
 
 // CallContext holds context for a single API call
@@ -170,6 +244,7 @@ type CallContext struct {
 	AgentConfig   *models.AgentConfig
 	StartTime     time.Time
 	IsPaidUser    bool
+	TraceID       string // OpenTelemetry trace of the request, recorded in call_logs
 }
 
 // CallResult holds the result of an API call
@@ -182,23 +257,88 @@ type CallResult struct {
 	Cost         decimal.Decimal
 }
 
-
 // ValidateAPIKey validates an API key and returns the associated user info
 func (s *Service) ValidateAPIKey(ctx context.Context, rawKey string) (*models.APIKey, error) {
-	apiKey, err := s.apiKeyService.ValidateAPIKey(ctx, rawKey)
+	if !apikey.ValidKeyFormat(rawKey) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	apiKey, err := s.lookupCache.GetAPIKey(ctx, apikey.HashAPIKey(rawKey))
+	if err == nil {
+		err = apikey.CheckUsable(apiKey, time.Now())
+	}
 	if err != nil {
 		if errors.Is(err, apikey.ErrInvalidAPIKey) || errors.Is(err, apikey.ErrAPIKeyRevoked) {
 			return nil, ErrInvalidAPIKey
 		}
+		if errors.Is(err, apikey.ErrAPIKeyExpired) {
+			return nil, ErrAPIKeyExpired
+		}
+		if errors.Is(err, apikey.ErrAccountSuspended) {
+			return nil, ErrAccountSuspended
+		}
+		if errors.Is(err, apikey.ErrAccountBanned) {
+			return nil, ErrAccountBanned
+		}
 		return nil, fmt.Errorf("failed to validate API key: %w", err)
 	}
+
+	s.apiKeyService.MarkUsed(apiKey.ID)
 	return apiKey, nil
 }
 
+// KeyIPKnown reports whether this proxy knows the key's use from clientIP is
+// already recorded, so the caller can skip RecordKeyUse
+func (s *Service) KeyIPKnown(apiKey *models.APIKey, clientIP string) bool {
+	return s.lookupCache.KeyIPKnown(apiKey.ID, clientIP)
+}
+
+// RecordKeyUse records the client IP an API key was used from for the key
+// audit trail. Only IPs not known to any proxy reach the database.
+func (s *Service) RecordKeyUse(ctx context.Context, apiKey *models.APIKey, clientIP string) error {
+	if clientIP == "" || s.lookupCache.SharedKeyIPKnown(ctx, apiKey.ID, clientIP) {
+		return nil
+	}
+	if err := s.apiKeyService.RecordKeyUse(ctx, apiKey, clientIP); err != nil {
+		return err
+	}
+	s.lookupCache.SetKeyIPKnown(ctx, apiKey.ID, clientIP)
+	return nil
+}
+
+// CheckKeyScope verifies that an API key may call the agent with the given permissions
+func (s *Service) CheckKeyScope(apiKey *models.APIKey, agentID uuid.UUID, permissions ...string) error {
+	if !apiKey.CanAccessAgent(agentID) {
+		return ErrKeyAgentNotAllowed
+	}
+	for _, permission := range permissions {
+		if !apiKey.HasPermission(permission) {
+			return fmt.Errorf("%w: %s", ErrKeyPermissionDenied, permission)
+		}
+	}
+	return nil
+}
+
+// CheckKeyNetwork verifies that an API key may be used from the client IP and origin
+func (s *Service) CheckKeyNetwork(apiKey *models.APIKey, clientIP, origin string) error {
+	if !apiKey.AllowsIP(clientIP) {
+		return ErrKeyIPNotAllowed
+	}
+	if !apiKey.AllowsOrigin(origin) {
+		return ErrKeyOriginNotAllowed
+	}
+	return nil
+}
+
+// RecordKeyRejection counts a request rejected by the key's network restrictions
+func (s *Service) RecordKeyRejection(ctx context.Context, apiKey *models.APIKey, reason string) error {
+	return s.apiKeyService.RecordRejection(ctx, apiKey.ID, reason)
+}
+
 // GetAgent retrieves and validates an agent for API calls
 func (s *Service) GetAgent(ctx context.Context, agentID uuid.UUID) (*models.Agent, *models.AgentConfig, error) {
-	// Get agent from database
-	agentModel, err := s.agentService.GetByID(ctx, agentID)
+	// Get agent with decrypted config, from cache when possible
+	agentModel, agentConfig, err := s.lookupCache.GetAgent(ctx, agentID)
 	if err != nil {
 		if errors.Is(err, agent.ErrAgentNotFound) {
 			return nil, nil, ErrAgentNotFound
@@ -211,19 +351,18 @@ func (s *Service) GetAgent(ctx context.Context, agentID uuid.UUID) (*models.Agen
 		return nil, nil, ErrAgentNotActive
 	}
 
-	// Get decrypted config
-	agentConfig, err := s.agentService.GetConfig(ctx, agentID)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get agent config: %w", err)
-	}
-
 	return agentModel, agentConfig, nil
 }
 
 // CheckQuota checks if user has sufficient quota
 func (s *Service) CheckQuota(ctx context.Context, userID uuid.UUID) (int64, error) {
 	// First check Redis cache
-	remaining, err := s.redis.GetQuota(ctx, userID.String())
+	var remaining int64
+	err := s.rateLimiter.guard.Do(func() error {
+		var err error
+		remaining, err = s.redis.GetQuota(ctx, userID.String())
+		return err
+	})
 	if err == nil && remaining > 0 {
 		return remaining, nil
 	}
@@ -242,7 +381,9 @@ func (s *Service) CheckQuota(ctx context.Context, userID uuid.UUID) (int64, erro
 	
 	// Cache the quota in Redis
 	if remaining > 0 {
-		_ = s.redis.SetQuota(ctx, userID.String(), remaining)
+		_ = s.rateLimiter.guard.Do(func() error {
+			return s.redis.SetQuota(ctx, userID.String(), remaining)
+		})
 	}
 
 	return remaining, nil
@@ -263,6 +404,138 @@ func (s *Service) CheckRateLimitWithResult(ctx context.Context, userID uuid.UUID
 	return s.rateLimiter.Check(ctx, userID.String(), isPaidUser)
 }
 
+// EnforceKeyLimits applies the API key's own spend caps, rate limit and
+// concurrency limit. On success with a concurrency limit set, the caller must
+// release the slot with ReleaseKeySlot. The status is returned even on
+// rejection so callers can report remaining budget.
+func (s *Service) EnforceKeyLimits(ctx context.Context, apiKey *models.APIKey, requestID string) (*KeyLimitStatus, error) {
+	status := &KeyLimitStatus{}
+
+	// Spend caps first: checking them consumes nothing
+	budget, err := s.keyLimiter.GetBudget(ctx, apiKey, time.Now())
+	if err != nil {
+		return status, err
+	}
+	status.Budget = budget
+	if budget != nil && budget.ExceededPeriod != "" {
+		return status, ErrKeySpendCapExceeded
+	}
+
+	rateResult, err := s.keyLimiter.CheckRate(ctx, apiKey)
+	if err != nil {
+		return status, err
+	}
+	status.RateLimit = rateResult
+	if rateResult != nil && !rateResult.Allowed {
+		return status, ErrKeyRateLimited
+	}
+
+	if apiKey.Limits.MaxConcurrent != nil {
+		status.ConcurrencyLimit = *apiKey.Limits.MaxConcurrent
+	}
+	acquired, inFlight := s.keyLimiter.AcquireSlot(ctx, apiKey, requestID)
+	status.InFlight = inFlight
+	if !acquired {
+		return status, ErrKeyConcurrencyLimit
+	}
+
+	return status, nil
+}
+
+// ReleaseKeySlot frees the in-flight slot taken by EnforceKeyLimits
+func (s *Service) ReleaseKeySlot(ctx context.Context, apiKey *models.APIKey, requestID string) error {
+	return s.keyLimiter.ReleaseSlot(ctx, apiKey, requestID)
+}
+
+// RecordKeySpend adds a successful call to the API key's spend counters
+func (s *Service) RecordKeySpend(ctx context.Context, apiKey *models.APIKey, quotaUnits int64, cost decimal.Decimal) error {
+	return s.keyLimiter.RecordSpend(ctx, apiKey, quotaUnits, cost, time.Now())
+}
+
+// EstimatePromptTokens returns a rough prompt token count for a request,
+// including the agent's system prompt
+func (s *Service) EstimatePromptTokens(agentConfig *models.AgentConfig, messages []ChatMessage) int64 {
+	return int64(estimateMessageTokens(agentConfig.SystemPrompt, messages))
+}
+
+// ReserveTokens counts a request's estimated tokens against the user's tier,
+// API key and agent tokens-per-minute limits. The result is returned even on
+// rejection so callers can report the tightest limit; it is nil if no limit applies.
+func (s *Service) ReserveTokens(ctx context.Context, apiKey *models.APIKey, agentID uuid.UUID, isPaidUser bool, tokens int64) (*TokenLimitResult, error) {
+	userTPM := s.config.RateLimit.FreeUserTPM
+	if isPaidUser {
+		userTPM = s.config.RateLimit.PaidUserTPM
+	}
+	scopes := []TokenScope{
+		{Name: TokenScopeUser, ID: apiKey.UserID.String(), Limit: userTPM},
+		{Name: TokenScopeAgent, ID: agentID.String(), Limit: s.config.RateLimit.AgentTPM},
+	}
+	if apiKey.Limits.TokensPerMinute != nil {
+		scopes = append(scopes, TokenScope{Name: TokenScopeAPIKey, ID: apiKey.ID.String(), Limit: *apiKey.Limits.TokensPerMinute})
+	}
+
+	result, err := s.rateLimiter.ReserveTokens(ctx, scopes, tokens)
+	if err != nil {
+		return nil, err
+	}
+	if result != nil && !result.Allowed {
+		return result, ErrTokenRateLimited
+	}
+	return result, nil
+}
+
+// ReconcileTokens corrects a token reservation with the call's actual usage
+func (s *Service) ReconcileTokens(ctx context.Context, result *TokenLimitResult, actualTokens int64) error {
+	if result == nil {
+		return nil
+	}
+	return s.rateLimiter.ReconcileTokens(ctx, result.Reservation, actualTokens)
+}
+
+// EnforceAgentLimits applies the creator's limits on the agent: global rate,
+// per-caller rate and concurrency. On success with a concurrency limit set, the
+// caller must release the slot with ReleaseAgentSlot. The status is returned
+// even on rejection and names the limit that was hit.
+func (s *Service) EnforceAgentLimits(ctx context.Context, agentModel *models.Agent, userID uuid.UUID, requestID string) (*AgentLimitStatus, error) {
+	status := &AgentLimitStatus{}
+
+	callerResult, rateResult, err := s.agentLimiter.CheckRates(ctx, agentModel, userID, requestID)
+	if err != nil {
+		return status, err
+	}
+	status.CallerRateLimit, status.RateLimit = callerResult, rateResult
+	if callerResult != nil && !callerResult.Allowed {
+		status.RejectionReason = models.AgentRejectionCallerRateLimited
+		return status, ErrAgentRateLimited
+	}
+	if rateResult != nil && !rateResult.Allowed {
+		status.RejectionReason = models.AgentRejectionRateLimited
+		return status, ErrAgentRateLimited
+	}
+
+	if agentModel.Limits.MaxConcurrent != nil {
+		status.ConcurrencyLimit = *agentModel.Limits.MaxConcurrent
+	}
+	acquired, inFlight := s.agentLimiter.AcquireSlot(ctx, agentModel, requestID)
+	status.InFlight = inFlight
+	if !acquired {
+		status.RejectionReason = models.AgentRejectionConcurrencyLimited
+		return status, ErrAgentConcurrencyLimit
+	}
+
+	return status, nil
+}
+
+// ReleaseAgentSlot frees the in-flight slot taken by EnforceAgentLimits
+func (s *Service) ReleaseAgentSlot(ctx context.Context, agentModel *models.Agent, requestID string) error {
+	return s.agentLimiter.ReleaseSlot(ctx, agentModel, requestID)
+}
+
+// RecordAgentRejection counts a request rejected by the agent's limits for the creator's analytics
+func (s *Service) RecordAgentRejection(ctx context.Context, agentID uuid.UUID, reason string) error {
+	return s.agentService.RecordLimitRejection(ctx, agentID, reason)
+}
+
 // IsPaidUser checks if a user is a paid user (has purchased quota)
 func (s *Service) IsPaidUser(ctx context.Context, userID uuid.UUID) (bool, error) {
 	var totalQuota int64
@@ -276,77 +549,173 @@ func (s *Service) IsPaidUser(ctx context.Context, userID uuid.UUID) (bool, error
 	return totalQuota > 0, nil
 }
 
-
 // DecrementQuota decrements the user's quota atomically
 // Returns the new remaining quota
 func (s *Service) DecrementQuota(ctx context.Context, userID uuid.UUID, amount int64) (int64, error) {
+	return s.DecrementQuotaForRequest(ctx, userID, "", amount)
+}
+
+// DecrementQuotaForRequest decrements user's quota for a call, recording the
+// debit in the quota ledger against the request ID
+func (s *Service) DecrementQuotaForRequest(ctx context.Context, userID uuid.UUID, requestID string, amount int64) (int64, error) {
 	// Use Redis for atomic decrement
-	remaining, err := s.redis.DecrementQuota(ctx, userID.String(), amount)
+	var remaining int64
+	err := s.rateLimiter.guard.Do(func() error {
+		var err error
+		remaining, err = s.redis.DecrementQuota(ctx, userID.String(), amount)
+		return err
+	})
 	if err != nil {
-		// Fall back to database
-		return s.decrementQuotaDB(ctx, userID, amount)
+		// Fall back to database, which stays authoritative while Redis is down
+		return s.decrementQuotaDB(ctx, userID, requestID, amount)
 	}
 
 	// If Redis shows negative, sync with database
 	if remaining < 0 {
-		return s.decrementQuotaDB(ctx, userID, amount)
+		return s.decrementQuotaDB(ctx, userID, requestID, amount)
 	}
 
-	// Async update database
-	go func() {
-		_, _ = s.decrementQuotaDB(context.Background(), userID, amount)
-	}()
+	// Async update database; Redis already accepted the decrement
+	go s.quotaManager.syncQuotaToDB(context.Background(), userID, requestID, amount)
 
 	return remaining, nil
 }
 
-// decrementQuotaDB decrements quota in the database
-func (s *Service) decrementQuotaDB(ctx context.Context, userID uuid.UUID, amount int64) (int64, error) {
+// decrementQuotaDB decrements quota in the database if enough remains.
+// Returns ErrQuotaExhausted otherwise.
+func (s *Service) decrementQuotaDB(ctx context.Context, userID uuid.UUID, requestID string, amount int64) (int64, error) {
+	remaining, err := s.debitQuota(ctx, userID, requestID, amount, true)
+	if err != nil {
+		return 0, err
+	}
+
+	// Update Redis cache
+	_ = s.rateLimiter.guard.Do(func() error {
+		return s.redis.SetQuota(ctx, userID.String(), remaining)
+	})
+
+	return remaining, nil
+}
+
+// debitQuota adds a call's debit to used_quota and the quota ledger in one
+// transaction. With checkBalance set, returns ErrQuotaExhausted rather than
+// overdrawing; without it, returns ErrQuotaNotFound if the user has no quota.
+func (s *Service) debitQuota(ctx context.Context, userID uuid.UUID, requestID string, amount int64, checkBalance bool) (int64, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
 	var remaining int64
-	err := s.db.QueryRow(ctx, `
+	err = tx.QueryRow(ctx, `
 		UPDATE quotas 
 		SET used_quota = used_quota + $1, updated_at = NOW()
-		WHERE user_id = $2
+		WHERE user_id = $2 AND (NOT $3 OR total_quota + free_quota - used_quota >= $1)
 		RETURNING (total_quota + free_quota - used_quota)
-	`, amount, userID).Scan(&remaining)
+	`, amount, userID, checkBalance).Scan(&remaining)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if checkBalance {
+			return 0, ErrQuotaExhausted
+		}
+		return 0, ErrQuotaNotFound
+	}
 	if err != nil {
 		return 0, fmt.Errorf("failed to decrement quota: %w", err)
 	}
 
-	// Update Redis cache
-	_ = s.redis.SetQuota(ctx, userID.String(), remaining)
+	err = ledger.Record(ctx, tx, &models.QuotaLedgerEntry{
+		UserID:    userID,
+		EntryType: models.QuotaLedgerCallDebit,
+		Amount:    -amount,
+		RequestID: optionalString(requestID),
+	})
+	if err != nil {
+		return 0, err
+	}
 
+	// Fire any low-quota alerts this debit crossed
+	if err := alerts.Evaluate(ctx, tx, userID); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return remaining, nil
 }
 
 // RefundQuota refunds quota when a call fails
 // This ensures failed calls don't cost quota (Requirement A6.5)
 func (s *Service) RefundQuota(ctx context.Context, userID uuid.UUID, amount int64) error {
+	return s.RefundQuotaForRequest(ctx, userID, "", amount)
+}
+
+// RefundQuotaForRequest refunds a failed call's quota, recording the refund
+// in the quota ledger against the request ID
+func (s *Service) RefundQuotaForRequest(ctx context.Context, userID uuid.UUID, requestID string, amount int64) error {
 	if amount <= 0 {
 		return nil // Nothing to refund
 	}
 
 	// Increment in Redis first for immediate effect
-	_, err := s.redis.Client.IncrBy(ctx, fmt.Sprintf("quota:%s", userID.String()), amount).Result()
+	err := s.rateLimiter.guard.Do(func() error {
+		return s.redis.Client.IncrBy(ctx, fmt.Sprintf("quota:%s", userID.String()), amount).Err()
+	})
 	if err != nil {
 		log.Warn().Err(err).Str("user_id", userID.String()).Int64("amount", amount).Msg("Failed to refund quota in Redis")
 	}
 
 	// Update database for persistence
-	result, err := s.db.Exec(ctx, `
-		UPDATE quotas 
-		SET used_quota = GREATEST(0, used_quota - $1), updated_at = NOW()
-		WHERE user_id = $2
-	`, amount, userID)
+	err = s.refundQuotaDB(ctx, userID, requestID, amount)
+	if errors.Is(err, ErrQuotaNotFound) {
+		// Log if no rows were affected (user might not exist)
+		log.Warn().Str("user_id", userID.String()).Int64("amount", amount).Msg("No quota record found to refund")
+		return nil
+	}
+	return err
+}
+
+// refundQuotaDB returns a refund to used_quota and records it in the quota
+// ledger in one transaction. used_quota never drops below zero, so the
+// ledger records what was actually refunded.
+func (s *Service) refundQuotaDB(ctx context.Context, userID uuid.UUID, requestID string, amount int64) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var refunded int64
+	err = tx.QueryRow(ctx, `
+		UPDATE quotas q
+		SET used_quota = GREATEST(0, q.used_quota - $1), updated_at = NOW()
+		FROM (SELECT used_quota FROM quotas WHERE user_id = $2 FOR UPDATE) old
+		WHERE q.user_id = $2
+		RETURNING old.used_quota - q.used_quota
+	`, amount, userID).Scan(&refunded)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrQuotaNotFound
+	}
 	if err != nil {
 		return fmt.Errorf("failed to refund quota: %w", err)
 	}
 
-	// Log if no rows were affected (user might not exist)
-	if result.RowsAffected() == 0 {
-		log.Warn().Str("user_id", userID.String()).Int64("amount", amount).Msg("No quota record found to refund")
+	if refunded > 0 {
+		err = ledger.Record(ctx, tx, &models.QuotaLedgerEntry{
+			UserID:    userID,
+			EntryType: models.QuotaLedgerCallRefund,
+			Amount:    refunded,
+			RequestID: optionalString(requestID),
+		})
+		if err != nil {
+			return err
+		}
 	}
 
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return nil
 }
 
@@ -362,8 +731,11 @@ func (s *Service) LogCall(ctx context.Context, callCtx *CallContext, result *Cal
 		errorCode = &result.ErrorCode
 	}
 
-	// Use correlation_id for trace_id if available, otherwise use request_id
-	traceID := callCtx.CorrelationID
+	// Prefer the OpenTelemetry trace ID, then correlation_id, then request_id
+	traceID := callCtx.TraceID
+	if traceID == "" {
+		traceID = callCtx.CorrelationID
+	}
 	if traceID == "" {
 		traceID = callCtx.RequestID
 	}
@@ -393,10 +765,25 @@ func (s *Service) LogCall(ctx context.Context, callCtx *CallContext, result *Cal
 		}
 	}
 
+	// Queue the call.completed event for the caller's webhooks
+	err = webhook.Enqueue(ctx, s.db, callCtx.UserID, models.WebhookEventCallCompleted, map[string]any{
+		"request_id":    callCtx.RequestID,
+		"agent_id":      callCtx.AgentID,
+		"api_key_id":    callCtx.APIKeyID,
+		"status":        status,
+		"error_code":    errorCode,
+		"input_tokens":  result.InputTokens,
+		"output_tokens": result.OutputTokens,
+		"latency_ms":    result.LatencyMs,
+		"cost_usd":      result.Cost.String(),
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("request_id", callCtx.RequestID).Msg("Failed to queue call webhook")
+	}
+
 	return nil
 }
 
-
 // InjectSystemPrompt injects the agent's system prompt into the messages
 // The system prompt is prepended and never exposed in responses
 func (s *Service) InjectSystemPrompt(messages []ChatMessage, systemPrompt string) []ChatMessage {
@@ -425,6 +812,14 @@ func (s *Service) BuildUpstreamRequest(agentConfig *models.AgentConfig, messages
 		"top_p":       agentConfig.TopP,
 		"stream":      stream,
 	}
+
+	// Ask OpenAI-compatible providers to report real token usage on the
+	// final stream chunk so token-priced agents are billed accurately
+	if stream && agentConfig.Provider != "anthropic" && agentConfig.Provider != "google" {
+		request["stream_options"] = map[string]interface{}{
+			"include_usage": true,
+		}
+	}
 	
 	return request, nil
 }
@@ -457,7 +852,6 @@ func (s *Service) getProviderAPIKey(provider string) string {
 	}
 }
 
-
 // CallUpstream makes the actual call to the AI provider
 func (s *Service) CallUpstream(ctx context.Context, agentConfig *models.AgentConfig, request map[string]interface{}) (*ChatResponse, error) {
 	provider := agentConfig.Provider
@@ -465,10 +859,12 @@ func (s *Service) CallUpstream(ctx context.Context, agentConfig *models.AgentCon
 		provider = "openai"
 	}
 
-	// Execute with circuit breaker protection
-	result, err := s.circuitBreakerManager.Execute(ctx, provider, func() (interface{}, error) {
+	// Execute with circuit breaker protection for the provider and its model
+	start := time.Now()
+	result, err := s.circuitBreakerManager.ExecuteModel(ctx, provider, agentConfig.Model, func() (interface{}, error) {
 		return s.callUpstreamInternal(ctx, agentConfig, request)
 	})
+	recordUpstream(agentConfig, start, err)
 
 	if err != nil {
 		if errors.Is(err, ErrCircuitOpen) {
@@ -524,7 +920,7 @@ func (s *Service) callUpstreamInternal(ctx context.Context, agentConfig *models.
 			Int("status", resp.StatusCode).
 			Str("body", string(body)).
 			Msg("Upstream error")
-		return nil, fmt.Errorf("%w: status %d", ErrUpstreamError, resp.StatusCode)
+		return nil, &upstreamStatusError{status: resp.StatusCode}
 	}
 
 	// Parse response
@@ -538,6 +934,27 @@ func (s *Service) callUpstreamInternal(ctx context.Context, agentConfig *models.
 
 // CallUpstreamStream makes a streaming call to the AI provider
 func (s *Service) CallUpstreamStream(ctx context.Context, agentConfig *models.AgentConfig, request map[string]interface{}, writer io.Writer, flusher http.Flusher) (*ChatUsage, error) {
+	start := time.Now()
+	result, err := s.callUpstreamStreamInternal(ctx, agentConfig, request, writer, flusher)
+	recordUpstream(agentConfig, start, err)
+	recordStream(agentConfig, start, result)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer provider-reported usage, fall back to the streamed estimate
+	if result.Usage != nil {
+		return result.Usage, nil
+	}
+	return &ChatUsage{
+		CompletionTokens: result.TotalTokens,
+		TotalTokens:      result.TotalTokens,
+	}, nil
+}
+
+// callUpstreamStreamInternal makes the HTTP call to the AI provider and
+// streams its response. The result is returned with any streaming error.
+func (s *Service) callUpstreamStreamInternal(ctx context.Context, agentConfig *models.AgentConfig, request map[string]interface{}, writer io.Writer, flusher http.Flusher) (*StreamResult, error) {
 	// Serialize request
 	reqBody, err := json.Marshal(request)
 	if err != nil {
@@ -581,24 +998,14 @@ func (s *Service) CallUpstreamStream(ctx context.Context, agentConfig *models.Ag
 			Int("status", resp.StatusCode).
 			Str("body", string(body)).
 			Msg("Upstream streaming error")
-		return nil, fmt.Errorf("%w: status %d", ErrUpstreamError, resp.StatusCode)
+		return nil, &upstreamStatusError{status: resp.StatusCode}
 	}
 
 	// Use the stream handler for processing
 	streamConfig := DefaultStreamConfig(agentConfig.SystemPrompt)
-	result, err := s.streamHandler.StreamResponse(ctx, resp.Body, writer, flusher, streamConfig)
-	if err != nil {
-		return nil, err
-	}
-
-	// Return usage info
-	return &ChatUsage{
-		CompletionTokens: result.TotalTokens,
-		TotalTokens:      result.TotalTokens,
-	}, nil
+	return s.streamHandler.StreamResponse(ctx, resp.Body, writer, flusher, streamConfig)
 }
 
-
 // SanitizeResponse removes any system prompt content from the response
 // This ensures the hidden prompt is never exposed to the client
 func (s *Service) SanitizeResponse(response *ChatResponse, systemPrompt string) *ChatResponse {
@@ -660,8 +1067,13 @@ func (s *Service) ProcessChat(ctx context.Context, callCtx *CallContext, req *Ch
 	// Calculate latency
 	result.LatencyMs = int(time.Since(callCtx.StartTime).Milliseconds())
 
-	// Calculate cost
-	result.Cost = callCtx.Agent.PricePerCall
+	// Estimate prompt tokens when the provider did not report them
+	if result.InputTokens == 0 {
+		result.InputTokens = estimateMessageTokens(callCtx.AgentConfig.SystemPrompt, req.Messages)
+	}
+
+	// Calculate cost from the agent's pricing model and actual usage
+	result.Cost = callCtx.Agent.CallCost(result.InputTokens, result.OutputTokens)
 
 	return result, nil
 }
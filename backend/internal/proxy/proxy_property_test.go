@@ -18,8 +18,13 @@ import (
 	"github.com/aimerfeng/AgentLink/internal/apikey"
 	"github.com/aimerfeng/AgentLink/internal/cache"
 	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/ledger"
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/aimerfeng/AgentLink/internal/payment"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+	"github.com/shopspring/decimal"
 	"pgregory.net/rapid"
 )
 
@@ -903,6 +908,520 @@ func TestProperty6_RateLimitingEnforcement_IsolatedUsers(t *testing.T) {
 }
 
 
+// TestKeyLimits_SpendCaps tests that per-key spend caps stop calls once the budget is used up.
+// A quota call must fit under the cap; a USD call is allowed until spend reaches the cap.
+func TestKeyLimits_SpendCaps(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		capUnits := rapid.Int64Range(1, 10000).Draw(rt, "cap")
+		spentUnits := rapid.Int64Range(0, 20000).Draw(rt, "spent")
+		capValue := decimal.NewFromInt(capUnits)
+		spent := decimal.NewFromInt(spentUnits)
+
+		if spendCapReached(models.SpendUnitQuota, spent, capValue) != (spentUnits+1 > capUnits) {
+			t.Fatalf("PROPERTY VIOLATION: quota cap %d with %d spent gave wrong result", capUnits, spentUnits)
+		}
+		if spendCapReached(models.SpendUnitUSD, spent, capValue) != (spentUnits >= capUnits) {
+			t.Fatalf("PROPERTY VIOLATION: USD cap %d with %d spent gave wrong result", capUnits, spentUnits)
+		}
+
+		// USD spend round-trips through integer micro-dollars
+		cost := decimal.New(rapid.Int64Range(0, 1_000_000_000).Draw(rt, "micros"), -usdMicros)
+		raw := fmt.Sprintf("%d", cost.Shift(usdMicros).IntPart())
+		if got := parseSpend(raw, models.SpendUnitUSD); !got.Equal(cost) {
+			t.Fatalf("PROPERTY VIOLATION: parseSpend(%s) = %s, want %s", raw, got, cost)
+		}
+	})
+
+	t.Run("PeriodKeys", func(t *testing.T) {
+		key := &models.APIKey{ID: uuid.New()}
+		now := time.Date(2026, 1, 31, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*3600))
+		daily, monthly := spendKeys(key, models.SpendUnitUSD, now)
+		// Periods are UTC: 23:30 at UTC-2 is already February 1st
+		if !strings.HasSuffix(daily, ":d:20260201") || !strings.HasSuffix(monthly, ":m:202602") {
+			t.Fatalf("Unexpected spend keys %s, %s", daily, monthly)
+		}
+	})
+
+	t.Run("UncappedKeyNotTracked", func(t *testing.T) {
+		if testRedis == nil {
+			t.Skip("Test Redis not available")
+		}
+
+		ctx := context.Background()
+		limiter := NewKeyLimiter(testRedis, NewRateLimiter(testRedis, &testCfg.RateLimit))
+		key := &models.APIKey{ID: uuid.New()}
+		now := time.Now()
+		if err := limiter.RecordSpend(ctx, key, 1, decimal.NewFromFloat(0.01), now); err != nil {
+			t.Fatalf("Failed to record spend: %v", err)
+		}
+		daily, monthly := spendKeys(key, models.SpendUnitQuota, now)
+		if n, _ := testRedis.Client.Exists(ctx, daily, monthly).Result(); n != 0 {
+			t.Fatalf("Expected no spend counters for a key without a cap, found %d", n)
+		}
+	})
+}
+
+// TestKeyLimits_Concurrency tests that a key cannot hold more in-flight slots than its limit
+func TestKeyLimits_Concurrency(t *testing.T) {
+	if testRedis == nil {
+		t.Skip("Test Redis not available")
+	}
+
+	ctx := context.Background()
+	limiter := NewKeyLimiter(testRedis, NewRateLimiter(testRedis, &testCfg.RateLimit))
+
+	rapid.Check(t, func(rt *rapid.T) {
+		limit := rapid.IntRange(1, 10).Draw(rt, "limit")
+		key := &models.APIKey{ID: uuid.New(), Limits: models.APIKeyLimits{MaxConcurrent: &limit}}
+		defer testRedis.Client.Del(ctx, inFlightKey(key))
+
+		for i := 0; i < limit; i++ {
+			if ok, _ := limiter.AcquireSlot(ctx, key, fmt.Sprintf("req-%d", i)); !ok {
+				t.Fatalf("PROPERTY VIOLATION: slot %d should be granted (limit: %d)", i, limit)
+			}
+		}
+		if ok, inFlight := limiter.AcquireSlot(ctx, key, "req-over"); ok || inFlight != int64(limit) {
+			t.Fatalf("PROPERTY VIOLATION: slot over limit granted (in flight: %d)", inFlight)
+		}
+
+		// Releasing a slot frees room for exactly one more request
+		if err := limiter.ReleaseSlot(ctx, key, "req-0"); err != nil {
+			t.Fatalf("Failed to release slot: %v", err)
+		}
+		if ok, _ := limiter.AcquireSlot(ctx, key, "req-next"); !ok {
+			t.Fatal("PROPERTY VIOLATION: slot should be granted after release")
+		}
+	})
+}
+
+// TestAgentLimits tests that an agent's per-caller rate limit isolates callers
+// and that its concurrency limit is shared by all of them
+func TestAgentLimits(t *testing.T) {
+	if testRedis == nil {
+		t.Skip("Test Redis not available")
+	}
+
+	ctx := context.Background()
+	limiter := NewAgentLimiter(testRedis, NewRateLimiter(testRedis, &testCfg.RateLimit))
+
+	rapid.Check(t, func(rt *rapid.T) {
+		perCaller := rapid.IntRange(1, 10).Draw(rt, "perCaller")
+		concurrent := rapid.IntRange(1, 10).Draw(rt, "concurrent")
+		agentModel := &models.Agent{ID: uuid.New(), Limits: models.AgentLimits{PerCallerRPM: &perCaller, MaxConcurrent: &concurrent}}
+		callerA, callerB := uuid.New(), uuid.New()
+		defer testRedis.Client.Del(ctx, agentInFlightKey(agentModel),
+			fmt.Sprintf("ratelimit:sliding:agent:%s:user:%s", agentModel.ID, callerA),
+			fmt.Sprintf("ratelimit:sliding:agent:%s:user:%s", agentModel.ID, callerB))
+
+		for i := 0; i < perCaller; i++ {
+			if result, _, err := limiter.CheckRates(ctx, agentModel, callerA, fmt.Sprintf("a-%d", i)); err != nil || !result.Allowed {
+				t.Fatalf("PROPERTY VIOLATION: request %d of caller A should be allowed (limit: %d)", i, perCaller)
+			}
+		}
+		if result, _, _ := limiter.CheckRates(ctx, agentModel, callerA, "a-over"); result.Allowed {
+			t.Fatalf("PROPERTY VIOLATION: caller A allowed over its limit of %d", perCaller)
+		}
+		caller, global, _ := limiter.CheckRates(ctx, agentModel, callerB, "b-0")
+		if !caller.Allowed {
+			t.Fatal("PROPERTY VIOLATION: caller B limited by caller A's requests")
+		}
+		if global != nil {
+			t.Fatal("PROPERTY VIOLATION: agent without a global limit returned a rate limit result")
+		}
+
+		for i := 0; i < concurrent; i++ {
+			if ok, _ := limiter.AcquireSlot(ctx, agentModel, fmt.Sprintf("req-%d", i)); !ok {
+				t.Fatalf("PROPERTY VIOLATION: slot %d should be granted (limit: %d)", i, concurrent)
+			}
+		}
+		if ok, inFlight := limiter.AcquireSlot(ctx, agentModel, "req-over"); ok || inFlight != int64(concurrent) {
+			t.Fatalf("PROPERTY VIOLATION: slot over limit granted (in flight: %d)", inFlight)
+		}
+		if err := limiter.ReleaseSlot(ctx, agentModel, "req-0"); err != nil {
+			t.Fatalf("Failed to release slot: %v", err)
+		}
+		if ok, _ := limiter.AcquireSlot(ctx, agentModel, "req-next"); !ok {
+			t.Fatal("PROPERTY VIOLATION: slot should be granted after release")
+		}
+	})
+}
+
+// TestAgentLimits_RatesAtomic tests that a request rejected by an agent's
+// global rate limit is not counted against its caller's limit
+func TestAgentLimits_RatesAtomic(t *testing.T) {
+	if testRedis == nil {
+		t.Skip("Test Redis not available")
+	}
+
+	ctx := context.Background()
+	limiter := NewAgentLimiter(testRedis, NewRateLimiter(testRedis, &testCfg.RateLimit))
+
+	rapid.Check(t, func(rt *rapid.T) {
+		global := rapid.IntRange(1, 5).Draw(rt, "global")
+		perCaller := global + rapid.IntRange(1, 5).Draw(rt, "extra")
+		agentModel := &models.Agent{ID: uuid.New(), Limits: models.AgentLimits{RateLimitRPM: &global, PerCallerRPM: &perCaller}}
+		callerA, callerB := uuid.New(), uuid.New()
+		callerKey := fmt.Sprintf("ratelimit:sliding:agent:%s:user:%s", agentModel.ID, callerB)
+		defer testRedis.Client.Del(ctx, fmt.Sprintf("ratelimit:sliding:agent:%s", agentModel.ID),
+			fmt.Sprintf("ratelimit:sliding:agent:%s:user:%s", agentModel.ID, callerA), callerKey)
+
+		for i := 0; i < global; i++ {
+			if _, result, err := limiter.CheckRates(ctx, agentModel, callerA, fmt.Sprintf("a-%d", i)); err != nil || !result.Allowed {
+				t.Fatalf("PROPERTY VIOLATION: request %d should be allowed (global limit: %d)", i, global)
+			}
+		}
+
+		rejected := rapid.IntRange(1, 5).Draw(rt, "rejected")
+		for i := 0; i < rejected; i++ {
+			caller, result, _ := limiter.CheckRates(ctx, agentModel, callerB, fmt.Sprintf("b-%d", i))
+			if result.Allowed || !caller.Allowed || result.RetryAfter <= 0 {
+				t.Fatalf("PROPERTY VIOLATION: request over the global limit of %d should be rejected by it", global)
+			}
+		}
+		if n := testRedis.Client.ZCard(ctx, callerKey).Val(); n != 0 {
+			t.Fatalf("PROPERTY VIOLATION: %d rejected requests counted against the caller", n)
+		}
+	})
+}
+
+// TestTokenLimits_Window tests the sliding window weighting and scope selection
+func TestTokenLimits_Window(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		offset := time.Duration(rapid.Int64Range(0, int64(tokenWindow)-1).Draw(rt, "offset"))
+		now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC).Add(offset)
+
+		// The previous bucket fully counts at the start of a minute and fades out by its end
+		weight := previousWindowWeight(now)
+		if weight <= 0 || weight > 1 {
+			t.Fatalf("PROPERTY VIOLATION: weight %f out of range at offset %s", weight, offset)
+		}
+		if later := previousWindowWeight(now.Add(time.Second)); offset+time.Second < tokenWindow && later >= weight {
+			t.Fatalf("PROPERTY VIOLATION: weight should decrease within a minute (%f -> %f)", weight, later)
+		}
+
+		// The tightest scope has the least room left
+		n := rapid.IntRange(1, 3).Draw(rt, "scopes")
+		scopes := make([]TokenScope, n)
+		used := make([]int64, n)
+		for i := range scopes {
+			scopes[i] = TokenScope{Name: fmt.Sprintf("s%d", i), Limit: rapid.IntRange(1, 100000).Draw(rt, "limit")}
+			used[i] = rapid.Int64Range(0, 100000).Draw(rt, "used")
+		}
+		tightest := tightestScope(scopes, used)
+		for i := range scopes {
+			if int64(scopes[i].Limit)-used[i] < int64(scopes[tightest].Limit)-used[tightest] {
+				t.Fatalf("PROPERTY VIOLATION: scope %d has less room than chosen scope %d", i, tightest)
+			}
+		}
+	})
+}
+
+// TestTokenLimits_ReserveAndReconcile tests that token reservations respect
+// every scope's limit and that reconciliation replaces the estimate
+func TestTokenLimits_ReserveAndReconcile(t *testing.T) {
+	if testRedis == nil {
+		t.Skip("Test Redis not available")
+	}
+
+	ctx := context.Background()
+	rateLimiter := NewRateLimiter(testRedis, &testCfg.RateLimit)
+
+	rapid.Check(t, func(rt *rapid.T) {
+		userLimit := rapid.IntRange(100, 10000).Draw(rt, "userLimit")
+		keyLimit := rapid.IntRange(100, 10000).Draw(rt, "keyLimit")
+		scopes := []TokenScope{
+			{Name: TokenScopeUser, ID: uuid.New().String(), Limit: userLimit},
+			{Name: TokenScopeAPIKey, ID: uuid.New().String(), Limit: keyLimit},
+			{Name: TokenScopeAgent, ID: uuid.New().String(), Limit: 0}, // Disabled
+		}
+		defer func() {
+			for _, scope := range scopes {
+				bucket := time.Now().Truncate(tokenWindow)
+				testRedis.Client.Del(ctx, tokenBucketKey(scope, bucket), tokenBucketKey(scope, bucket.Add(-tokenWindow)))
+			}
+		}()
+		limit := int64(min(userLimit, keyLimit))
+
+		estimate := rapid.Int64Range(1, limit).Draw(rt, "estimate")
+		result, err := rateLimiter.ReserveTokens(ctx, scopes, estimate)
+		if err != nil {
+			t.Fatalf("Failed to reserve tokens: %v", err)
+		}
+		if !result.Allowed || result.Limit != int(limit) || result.Remaining != limit-estimate {
+			t.Fatalf("PROPERTY VIOLATION: first reservation of %d under limit %d gave %+v", estimate, limit, result)
+		}
+
+		// Actual usage replaces the estimate
+		actual := rapid.Int64Range(0, limit).Draw(rt, "actual")
+		if err := rateLimiter.ReconcileTokens(ctx, result.Reservation, actual); err != nil {
+			t.Fatalf("Failed to reconcile tokens: %v", err)
+		}
+		if actual == 0 {
+			return
+		}
+
+		// A request that no longer fits is rejected without being counted
+		over := limit - actual + 1
+		rejected, err := rateLimiter.ReserveTokens(ctx, scopes, over)
+		if err != nil {
+			t.Fatalf("Failed to reserve tokens: %v", err)
+		}
+		if rejected.Allowed || rejected.RetryAfter <= 0 || rejected.Reservation != nil {
+			t.Fatalf("PROPERTY VIOLATION: reservation of %d with %d used under limit %d allowed", over, actual, limit)
+		}
+		if fits := limit - actual; fits > 0 {
+			if ok, _ := rateLimiter.ReserveTokens(ctx, scopes, fits); !ok.Allowed {
+				t.Fatalf("PROPERTY VIOLATION: reservation of the remaining %d tokens rejected", fits)
+			}
+		}
+	})
+}
+
+// TestLookupCache_LRU tests that the lookup LRU never exceeds its capacity and
+// evicts the least recently used entry first
+func TestLookupCache_LRU(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		capacity := rapid.IntRange(1, 20).Draw(rt, "capacity")
+		c := newLRUCache[int](capacity, time.Minute)
+
+		ops := rapid.SliceOfN(rapid.IntRange(0, 40), 1, 200).Draw(rt, "ops")
+		var recent []int // Model of recency, most recent last
+		for _, k := range ops {
+			key := fmt.Sprintf("k%d", k)
+			if v, ok := c.Get(key); ok {
+				if v != k {
+					t.Fatalf("PROPERTY VIOLATION: Get(%s) = %d", key, v)
+				}
+			} else {
+				c.Set(key, k)
+			}
+			for i, r := range recent {
+				if r == k {
+					recent = append(recent[:i], recent[i+1:]...)
+					break
+				}
+			}
+			recent = append(recent, k)
+			if len(recent) > capacity {
+				recent = recent[1:]
+			}
+
+			if c.Len() > capacity {
+				t.Fatalf("PROPERTY VIOLATION: cache holds %d entries, capacity %d", c.Len(), capacity)
+			}
+		}
+
+		// Exactly the most recently used keys survive
+		for _, k := range recent {
+			if _, ok := c.Get(fmt.Sprintf("k%d", k)); !ok {
+				t.Fatalf("PROPERTY VIOLATION: recently used key k%d was evicted", k)
+			}
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		c := newLRUCache[string](10, 10*time.Millisecond)
+		c.Set("a", "value")
+		time.Sleep(20 * time.Millisecond)
+		if _, ok := c.Get("a"); ok {
+			t.Fatal("Expired entry should not be returned")
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		c := newLRUCache[string](0, time.Minute)
+		c.Set("a", "value")
+		if _, ok := c.Get("a"); ok {
+			t.Fatal("Zero-capacity cache should not store entries")
+		}
+	})
+}
+
+// TestLookupCache_KnownKeyIPs tests that only the first use of a key from an
+// IP reaches the database and its new_ip audit
+func TestLookupCache_KnownKeyIPs(t *testing.T) {
+	ctx := context.Background()
+	keyID := uuid.New()
+
+	t.Run("KnownSkipsDatabase", func(t *testing.T) {
+		// No database: recording a known IP must not touch it
+		svc := &Service{
+			apiKeyService: apikey.NewService(nil),
+			lookupCache:   NewLookupCache(nil, nil, nil, nil, 100, time.Minute),
+		}
+		key := &models.APIKey{ID: keyID}
+		if svc.KeyIPKnown(key, "203.0.113.7") {
+			t.Fatal("IP should not be known before it is recorded")
+		}
+		svc.lookupCache.SetKeyIPKnown(ctx, keyID, "203.0.113.7")
+		if !svc.KeyIPKnown(key, "203.0.113.7") || svc.KeyIPKnown(key, "203.0.113.8") {
+			t.Fatal("Only the recorded IP should be known")
+		}
+		if svc.KeyIPKnown(&models.APIKey{ID: uuid.New()}, "203.0.113.7") {
+			t.Fatal("IPs should be known per key")
+		}
+		if err := svc.RecordKeyUse(ctx, key, "203.0.113.7"); err != nil {
+			t.Fatalf("Recording a known IP failed: %v", err)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		c := NewLookupCache(nil, nil, nil, nil, 0, time.Minute)
+		c.SetKeyIPKnown(ctx, keyID, "203.0.113.7")
+		if c.SharedKeyIPKnown(ctx, keyID, "203.0.113.7") {
+			t.Fatal("Disabled cache should not remember IPs")
+		}
+	})
+
+	t.Run("FirstSightingAudited", func(t *testing.T) {
+		if testDB == nil {
+			t.Skip("Test database not available")
+		}
+
+		userID := createTestUser(t, ctx, 100)
+		defer cleanupTestUser(t, ctx, userID)
+
+		apiKeySvc := apikey.NewService(testDB)
+		created, err := apiKeySvc.Create(ctx, userID, &apikey.CreateAPIKeyRequest{Name: "known-ips"})
+		if err != nil {
+			t.Fatalf("Failed to create API key: %v", err)
+		}
+		key := &models.APIKey{ID: created.ID, UserID: userID}
+		svc := &Service{
+			apiKeyService: apiKeySvc,
+			lookupCache:   NewLookupCache(testDB, nil, nil, apiKeySvc, 100, time.Minute),
+		}
+
+		for i := 0; i < 3; i++ {
+			if err := svc.RecordKeyUse(ctx, key, "203.0.113.7"); err != nil {
+				t.Fatalf("Failed to record key use: %v", err)
+			}
+			// Forgetting the row shows later calls never reach the database
+			if _, err := testDB.Exec(ctx, `DELETE FROM api_key_known_ips WHERE api_key_id = $1`, key.ID); err != nil {
+				t.Fatalf("Failed to delete known IPs: %v", err)
+			}
+		}
+
+		var newIPEvents int
+		err = testDB.QueryRow(ctx, `
+			SELECT COUNT(*) FROM api_key_audit_logs WHERE api_key_id = $1 AND event_type = 'new_ip'
+		`, key.ID).Scan(&newIPEvents)
+		if err != nil {
+			t.Fatalf("Failed to count new_ip events: %v", err)
+		}
+		if newIPEvents != 1 {
+			t.Fatalf("Expected 1 new_ip event, got %d", newIPEvents)
+		}
+	})
+}
+
+// TestLookupCache_Invalidate tests that invalidation notifications evict only the named entry
+func TestLookupCache_Invalidate(t *testing.T) {
+	c := NewLookupCache(nil, nil, nil, nil, 100, time.Minute)
+	keyA, keyB := &models.APIKey{ID: uuid.New()}, &models.APIKey{ID: uuid.New()}
+	agentID := uuid.New()
+	c.keys.Set("hash-a", keyA)
+	c.keys.Set("hash-b", keyB)
+	c.agents.Set(agentID.String(), &cachedAgent{agent: &models.Agent{ID: agentID}})
+
+	before := c.generation.Load()
+	c.Invalidate(context.Background(), "apikey:hash-a")
+	if _, ok := c.keys.Get("hash-a"); ok {
+		t.Fatal("Invalidated key should be evicted")
+	}
+	if _, ok := c.keys.Get("hash-b"); !ok {
+		t.Fatal("Other keys should stay cached")
+	}
+	if c.generation.Load() == before {
+		t.Fatal("Invalidation should bump the generation so racing loads are not stored")
+	}
+
+	c.Invalidate(context.Background(), "agent:"+agentID.String())
+	if _, ok := c.agents.Get(agentID.String()); ok {
+		t.Fatal("Invalidated agent should be evicted")
+	}
+}
+
+// TestLookupCache_InvalidateQuota tests that a quota notification drops the
+// cached balance so the next call reloads it
+func TestLookupCache_InvalidateQuota(t *testing.T) {
+	if testRedis == nil {
+		t.Skip("Test Redis not available")
+	}
+
+	ctx := context.Background()
+	c := NewLookupCache(nil, testRedis, nil, nil, 0, time.Minute)
+	userID := uuid.New()
+	if err := testRedis.Client.Set(ctx, quotaKey(userID), 42, 0).Err(); err != nil {
+		t.Fatalf("Failed to cache quota: %v", err)
+	}
+
+	c.Invalidate(ctx, "quota:"+userID.String())
+	if n, err := testRedis.Client.Exists(ctx, quotaKey(userID)).Result(); err != nil || n != 0 {
+		t.Fatalf("Expected the cached quota to be dropped, got %d: %v", n, err)
+	}
+}
+
+// TestLookupCache_ReloadsQuotaAfterPayment tests that a completed payment
+// drops the cached balance, so the next quota check sees the purchase
+func TestLookupCache_ReloadsQuotaAfterPayment(t *testing.T) {
+	if testDB == nil {
+		t.Skip("Test database not available")
+	}
+	if testRedis == nil {
+		t.Skip("Test Redis not available")
+	}
+
+	ctx := context.Background()
+	proxySvc := NewService(testDB, testRedis, nil, nil, testCfg)
+	paymentSvc := payment.NewService(testDB, &testCfg.Stripe, &testCfg.Coinbase, "http://localhost:3000", false)
+	userID := createTestUser(t, ctx, 5)
+	defer cleanupTestUser(t, ctx, userID)
+	defer testDB.Exec(ctx, `DELETE FROM payments WHERE user_id = $1`, userID)
+
+	if remaining, err := proxySvc.CheckQuota(ctx, userID); err != nil || remaining != 5 {
+		t.Fatalf("Expected 5 cached calls, got %d: %v", remaining, err)
+	}
+
+	conn, err := testDB.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("Failed to acquire connection: %v", err)
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, "LISTEN "+invalidationChannel); err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Exec(context.Background(), "UNLISTEN "+invalidationChannel)
+
+	paymentID := uuid.New()
+	if _, err := testDB.Exec(ctx, `
+		INSERT INTO payments (id, user_id, amount_usd, quota_purchased, payment_method, status)
+		VALUES ($1, $2, 9.99, 100, $3, $4)
+	`, paymentID, userID, models.PaymentMethodStripe, models.PaymentStatusPending); err != nil {
+		t.Fatalf("Failed to create payment: %v", err)
+	}
+	if err := paymentSvc.CompletePayment(ctx, paymentID, "test_session_id"); err != nil {
+		t.Fatalf("Failed to complete payment: %v", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	for {
+		notification, err := conn.Conn().WaitForNotification(waitCtx)
+		if err != nil {
+			t.Fatalf("Expected a quota notification: %v", err)
+		}
+		if notification.Payload == "quota:"+userID.String() {
+			proxySvc.GetLookupCache().Invalidate(ctx, notification.Payload)
+			break
+		}
+	}
+
+	if remaining, err := proxySvc.CheckQuota(ctx, userID); err != nil || remaining != 105 {
+		t.Fatalf("Expected the purchase to be reloaded as 105 calls, got %d: %v", remaining, err)
+	}
+}
+
 // TestProperty_CircuitBreaker_OpensAfterFailures tests that circuit breaker opens after consecutive failures
 // *For any* provider, after FailureThreshold consecutive failures, the circuit breaker SHALL open
 // and reject subsequent requests until timeout expires.
@@ -1927,3 +2446,612 @@ func cleanupTestAgent(t *testing.T, ctx context.Context, agentID uuid.UUID) {
 	_, _ = testDB.Exec(ctx, `DELETE FROM agent_versions WHERE agent_id = $1`, agentID)
 	_, _ = testDB.Exec(ctx, `DELETE FROM agents WHERE id = $1`, agentID)
 }
+
+// TestDegradedMode_LocalLimiter tests that the local fallback never allows more
+// than this instance's share of a limit within a window, and refills over time
+func TestDegradedMode_LocalLimiter(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		replicas := rapid.IntRange(1, 5).Draw(rt, "replicas")
+		limit := rapid.IntRange(1, 200).Draw(rt, "limit")
+		l := newLocalLimiter(replicas)
+		share := int64(l.share(limit))
+		if share < 1 || (limit >= replicas && share > int64(limit/replicas)) {
+			t.Fatalf("PROPERTY VIOLATION: share of %d over %d replicas is %d", limit, replicas, share)
+		}
+
+		now := time.Now()
+		var allowed int64
+		for i := 0; i < int(share)*2+5; i++ {
+			if ok, _, retry := l.take("k", 1, limit, time.Minute, now); ok {
+				allowed++
+			} else if retry <= 0 {
+				t.Fatal("PROPERTY VIOLATION: rejection without a retry delay")
+			}
+		}
+		if allowed != share {
+			t.Fatalf("PROPERTY VIOLATION: %d requests allowed, share is %d", allowed, share)
+		}
+
+		// A full window later the bucket is full again
+		if ok, _, _ := l.take("k", share, limit, time.Minute, now.Add(time.Minute)); !ok {
+			t.Fatal("PROPERTY VIOLATION: bucket did not refill after a window")
+		}
+	})
+
+	t.Run("OversizedCost", func(t *testing.T) {
+		l := newLocalLimiter(1)
+		now := time.Now()
+		if ok, _, _ := l.take("k", 500, 100, time.Minute, now); !ok {
+			t.Fatal("Request larger than the limit should pass on a full bucket")
+		}
+		if ok, _, _ := l.take("k", 1, 100, time.Minute, now); ok {
+			t.Fatal("Bucket should be empty after an oversized request")
+		}
+		l.adjust("k", -500)
+		if ok, _, _ := l.take("k", 1, 100, time.Minute, now); !ok {
+			t.Fatal("Refunded tokens should be available again")
+		}
+	})
+
+	t.Run("Slots", func(t *testing.T) {
+		l := newLocalLimiter(2)
+		for i := 0; i < 2; i++ {
+			if ok, _ := l.acquire("k", fmt.Sprintf("r%d", i), 4); !ok {
+				t.Fatalf("Slot %d should be acquired", i)
+			}
+		}
+		if ok, inFlight := l.acquire("k", "r2", 4); ok || inFlight != 2 {
+			t.Fatalf("Third slot should be rejected with 2 in flight, got %v %d", ok, inFlight)
+		}
+		if l.release("k", "unknown") {
+			t.Fatal("Releasing a request without a local slot should report false")
+		}
+		if !l.release("k", "r0") {
+			t.Fatal("Releasing a held slot should report true")
+		}
+		if ok, _ := l.acquire("k", "r2", 4); !ok {
+			t.Fatal("Released slot should be reusable")
+		}
+	})
+}
+
+// TestDegradedMode_FailureModes tests the decision made without Redis in each mode
+func TestDegradedMode_FailureModes(t *testing.T) {
+	if parseFailureMode("") != FailureModeOpen || parseFailureMode("bogus") != FailureModeOpen {
+		t.Fatal("Unknown failure modes should fail open")
+	}
+
+	tests := []struct {
+		mode    string
+		allowed []bool
+	}{
+		{"open", []bool{true, true, true}},
+		{"local", []bool{true, true, false}},
+		{"closed", []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			guard := NewRedisGuard(tt.mode, 1)
+			for i, want := range tt.allowed {
+				result := guard.fallbackTake("k", 1, 2, time.Minute)
+				if result.Allowed != want {
+					t.Fatalf("Request %d: allowed = %v, want %v", i, result.Allowed, want)
+				}
+				if !result.Allowed && result.RetryAfter <= 0 {
+					t.Fatalf("Request %d: rejection without a retry delay", i)
+				}
+			}
+			if acquired, _ := guard.fallbackAcquire("slot", 1, "r1"); acquired != tt.allowed[0] {
+				t.Fatalf("Slot acquired = %v, want %v", acquired, tt.allowed[0])
+			}
+		})
+	}
+
+	t.Run("Breaker", func(t *testing.T) {
+		guard := NewRedisGuard("local", 1)
+		failure := errors.New("connection refused")
+		for i := 0; i < redisBreakerFailures; i++ {
+			if err := guard.Do(func() error { return failure }); !errors.Is(err, failure) {
+				t.Fatalf("Call %d should return the Redis error, got %v", i, err)
+			}
+		}
+		if !guard.Degraded() {
+			t.Fatal("Breaker should open after consecutive failures")
+		}
+		called := false
+		if err := guard.Do(func() error { called = true; return nil }); !errors.Is(err, ErrRedisUnavailable) || called {
+			t.Fatalf("Open breaker should skip Redis and return ErrRedisUnavailable, got %v", err)
+		}
+	})
+}
+
+// TestDegradedMode_SpendCaps tests that key spend caps follow the failure mode
+// while Redis is unavailable: open allows, closed rejects, and local holds
+// the instance to its share of the cap using its own spend
+func TestDegradedMode_SpendCaps(t *testing.T) {
+	ctx := context.Background()
+	unreachable := &cache.Redis{Client: redis.NewClient(&redis.Options{Addr: "127.0.0.1:1"})}
+	defer unreachable.Client.Close()
+
+	dailyCap, monthlyCap := decimal.NewFromInt(1), decimal.NewFromInt(10)
+	key := &models.APIKey{ID: uuid.New(), Limits: models.APIKeyLimits{
+		DailySpendCap:   &dailyCap,
+		MonthlySpendCap: &monthlyCap,
+		SpendCapUnit:    models.SpendUnitUSD,
+	}}
+	newLimiter := func(t *testing.T, mode string) *KeyLimiter {
+		rateLimiter := NewRateLimiter(unreachable, &config.RateLimitConfig{RedisFailureMode: mode, ExpectedReplicas: 2})
+		failure := errors.New("connection refused")
+		for i := 0; i < redisBreakerFailures; i++ {
+			_ = rateLimiter.guard.Do(func() error { return failure })
+		}
+		if !rateLimiter.guard.Degraded() {
+			t.Fatal("Breaker should be open")
+		}
+		return NewKeyLimiter(unreachable, rateLimiter)
+	}
+
+	t.Run("open", func(t *testing.T) {
+		budget, err := newLimiter(t, "open").GetBudget(ctx, key, time.Now())
+		if err != nil || budget != nil {
+			t.Fatalf("Expected no budget when failing open, got %+v, %v", budget, err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		if _, err := newLimiter(t, "closed").GetBudget(ctx, key, time.Now()); !errors.Is(err, ErrKeyLimitsUnavailable) {
+			t.Fatalf("Expected ErrKeyLimitsUnavailable when failing closed, got %v", err)
+		}
+	})
+
+	t.Run("local", func(t *testing.T) {
+		limiter, now := newLimiter(t, "local"), time.Now()
+		// Two replicas, so this instance may spend half of each cap
+		for i, want := range []string{"0.5", "0.2", "0"} {
+			budget, err := limiter.GetBudget(ctx, key, now)
+			if err != nil {
+				t.Fatalf("Check %d: %v", i, err)
+			}
+			if !budget.DailyRemaining.Equal(decimal.RequireFromString(want)) {
+				t.Fatalf("Check %d: daily remaining = %s, want %s", i, budget.DailyRemaining, want)
+			}
+			if exceeded := budget.ExceededPeriod == SpendPeriodDaily; exceeded != (want == "0") {
+				t.Fatalf("Check %d: exceeded period %q with %s remaining", i, budget.ExceededPeriod, want)
+			}
+			if err := limiter.RecordSpend(ctx, key, 1, decimal.RequireFromString("0.3"), now); err == nil {
+				t.Fatal("Expected the Redis error to be returned")
+			}
+		}
+
+		// Counters are per period: the next day starts afresh
+		budget, err := limiter.GetBudget(ctx, key, now.Add(24*time.Hour))
+		if err != nil || budget.ExceededPeriod != "" {
+			t.Fatalf("Expected a fresh daily budget, got %+v, %v", budget, err)
+		}
+	})
+}
+
+// TestProperty_CircuitBreaker_ModelIsolation tests that a failing model trips
+// its own breaker without opening the provider for other models
+func TestProperty_CircuitBreaker_ModelIsolation(t *testing.T) {
+	cfg := &CircuitBreakerConfig{
+		MaxRequests:      2,
+		Interval:         60 * time.Second,
+		Timeout:          30 * time.Second,
+		FailureThreshold: 3,
+		SuccessThreshold: 2,
+	}
+	cbManager := NewCircuitBreakerManager(cfg)
+
+	rapid.Check(t, func(rt *rapid.T) {
+		provider := fmt.Sprintf("provider-%s", uuid.New().String()[:8])
+		defer cbManager.Reset(provider)
+		defer cbManager.Reset(ModelBreakerName(provider, "bad"))
+		defer cbManager.Reset(ModelBreakerName(provider, "good"))
+
+		ctx := context.Background()
+		failures := rapid.IntRange(int(cfg.FailureThreshold), 10).Draw(rt, "failures")
+		for i := 0; i < failures; i++ {
+			cbManager.ExecuteModel(ctx, provider, "bad", func() (interface{}, error) {
+				return nil, ErrUpstreamError
+			})
+			// Another model keeps succeeding in between
+			if _, err := cbManager.ExecuteModel(ctx, provider, "good", func() (interface{}, error) {
+				return "success", nil
+			}); err != nil {
+				t.Fatalf("PROPERTY VIOLATION: Healthy model rejected: %v", err)
+			}
+		}
+
+		if !cbManager.IsOpen(ModelBreakerName(provider, "bad")) {
+			t.Fatal("PROPERTY VIOLATION: Failing model's circuit should be open")
+		}
+		if cbManager.IsOpen(provider) {
+			t.Fatal("PROPERTY VIOLATION: Provider circuit should stay closed while other models succeed")
+		}
+	})
+}
+
+// TestCircuitBreaker_SharedState tests that breaker state in Redis is shared,
+// so failures seen by one replica open the breaker for another
+func TestCircuitBreaker_SharedState(t *testing.T) {
+	if testRedis == nil {
+		t.Skip("Test Redis not available")
+	}
+
+	cfg := &CircuitBreakerConfig{
+		MaxRequests:      1,
+		Interval:         60 * time.Second,
+		Timeout:          200 * time.Millisecond,
+		FailureThreshold: 3,
+		SuccessThreshold: 1,
+	}
+	replicaA := NewSharedCircuitBreakerManager(cfg, testRedis, NewRedisGuard("local", 1))
+	replicaB := NewSharedCircuitBreakerManager(cfg, testRedis, NewRedisGuard("local", 1))
+	ctx := context.Background()
+	provider := fmt.Sprintf("shared-%s", uuid.New().String()[:8])
+	defer replicaA.Reset(provider)
+
+	failing := func() (interface{}, error) { return nil, ErrUpstreamError }
+	succeeding := func() (interface{}, error) { return "success", nil }
+
+	// Failures split across replicas add up
+	replicaA.Execute(ctx, provider, failing)
+	replicaB.Execute(ctx, provider, failing)
+	replicaA.Execute(ctx, provider, failing)
+
+	if _, err := replicaB.Execute(ctx, provider, succeeding); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Replica B should see the breaker opened by shared failures, got %v", err)
+	}
+	if status := replicaB.GetStatus(provider); status == nil || status.State != CircuitBreakerStateOpen {
+		t.Fatalf("Replica B should report the shared open state, got %+v", status)
+	}
+
+	// After the timeout one probe is admitted and closes the breaker for everyone
+	time.Sleep(cfg.Timeout + 50*time.Millisecond)
+	if _, err := replicaA.Execute(ctx, provider, succeeding); err != nil {
+		t.Fatalf("Half-open probe should be admitted: %v", err)
+	}
+	if _, err := replicaB.Execute(ctx, provider, succeeding); err != nil {
+		t.Fatalf("Breaker should be closed for replica B after a successful probe: %v", err)
+	}
+}
+
+// TestCircuitBreaker_SharedForceState tests that a shared override applies to
+// every replica and a reset on any replica clears it everywhere
+func TestCircuitBreaker_SharedForceState(t *testing.T) {
+	if testRedis == nil {
+		t.Skip("Test Redis not available")
+	}
+
+	cfg := DefaultCircuitBreakerConfig()
+	replicaA := NewSharedCircuitBreakerManager(cfg, testRedis, NewRedisGuard("local", 1))
+	replicaB := NewSharedCircuitBreakerManager(cfg, testRedis, NewRedisGuard("local", 1))
+	ctx := context.Background()
+	provider := fmt.Sprintf("shared-forced-%s", uuid.New().String()[:8])
+	defer replicaA.Reset(provider)
+	succeeding := func() (interface{}, error) { return "success", nil }
+
+	if err := replicaA.ForceState(ctx, provider, CircuitBreakerStateOpen); err != nil {
+		t.Fatalf("Failed to force open: %v", err)
+	}
+	for name, replica := range map[string]*CircuitBreakerManager{"A": replicaA, "B": replicaB} {
+		if _, err := replica.Execute(ctx, provider, succeeding); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Replica %s should see the forced open breaker, got %v", name, err)
+		}
+	}
+
+	// A reset on the other replica lifts the override for the one that set it
+	replicaB.Reset(provider)
+	if _, err := replicaA.Execute(ctx, provider, succeeding); err != nil {
+		t.Fatalf("Override should be cleared for replica A after a reset on B: %v", err)
+	}
+	if status := replicaA.GetStatus(provider); status == nil || status.Forced {
+		t.Fatalf("Replica A should no longer report an override, got %+v", status)
+	}
+}
+
+// TestCircuitBreaker_ForceState tests that admin overrides ignore traffic until reset
+func TestCircuitBreaker_ForceState(t *testing.T) {
+	cfg := &CircuitBreakerConfig{
+		MaxRequests:      1,
+		Interval:         60 * time.Second,
+		Timeout:          30 * time.Second,
+		FailureThreshold: 2,
+		SuccessThreshold: 1,
+	}
+	cbManager := NewCircuitBreakerManager(cfg)
+	ctx := context.Background()
+	provider := fmt.Sprintf("forced-%s", uuid.New().String()[:8])
+	failing := func() (interface{}, error) { return nil, ErrUpstreamError }
+	succeeding := func() (interface{}, error) { return "success", nil }
+
+	if err := cbManager.ForceState(ctx, provider, CircuitBreakerStateHalfOpen); !errors.Is(err, ErrInvalidBreakerState) {
+		t.Fatalf("Forcing half-open should be rejected, got %v", err)
+	}
+
+	// Forced open rejects even healthy calls
+	if err := cbManager.ForceState(ctx, provider, CircuitBreakerStateOpen); err != nil {
+		t.Fatalf("Failed to force open: %v", err)
+	}
+	if _, err := cbManager.Execute(ctx, provider, succeeding); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Forced-open breaker should reject, got %v", err)
+	}
+	if status := cbManager.GetStatus(provider); status == nil || !status.Forced || status.State != CircuitBreakerStateOpen {
+		t.Fatalf("Status should report the forced open state, got %+v", status)
+	}
+
+	// Forced closed lets calls through however many fail
+	if err := cbManager.ForceState(ctx, provider, CircuitBreakerStateClosed); err != nil {
+		t.Fatalf("Failed to force closed: %v", err)
+	}
+	for i := uint32(0); i < cfg.FailureThreshold*3; i++ {
+		if _, err := cbManager.Execute(ctx, provider, failing); !errors.Is(err, ErrUpstreamError) {
+			t.Fatalf("Forced-closed breaker should pass calls through, got %v", err)
+		}
+	}
+	if cbManager.IsOpen(provider) {
+		t.Fatal("Forced-closed breaker should not open")
+	}
+
+	// Reset returns the breaker to normal behaviour
+	cbManager.Reset(provider)
+	for i := uint32(0); i < cfg.FailureThreshold; i++ {
+		cbManager.Execute(ctx, provider, failing)
+	}
+	if !cbManager.IsOpen(provider) {
+		t.Fatal("Breaker should trip normally after reset")
+	}
+	cbManager.Reset(provider)
+}
+
+// TestIdempotency_Fingerprint tests that fingerprints differ whenever the agent or body differs
+func TestIdempotency_Fingerprint(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		agentID := uuid.New()
+		body := rapid.SliceOf(rapid.Byte()).Draw(rt, "body")
+		other := rapid.SliceOf(rapid.Byte()).Draw(rt, "other")
+
+		if IdempotencyFingerprint(agentID, body) != IdempotencyFingerprint(agentID, body) {
+			t.Fatal("PROPERTY VIOLATION: fingerprint is not deterministic")
+		}
+		if string(body) != string(other) && IdempotencyFingerprint(agentID, body) == IdempotencyFingerprint(agentID, other) {
+			t.Fatal("PROPERTY VIOLATION: different bodies share a fingerprint")
+		}
+		if IdempotencyFingerprint(agentID, body) == IdempotencyFingerprint(uuid.New(), body) {
+			t.Fatal("PROPERTY VIOLATION: different agents share a fingerprint")
+		}
+	})
+}
+
+// TestIdempotency_Store tests that a key is claimed once, rejects concurrent
+// and mismatched reuse, replays the completed response and can be released
+func TestIdempotency_Store(t *testing.T) {
+	if testRedis == nil {
+		t.Skip("Test Redis not available")
+	}
+
+	ctx := context.Background()
+	store := NewIdempotencyStore(testRedis, NewRedisGuard("open", 1))
+
+	rapid.Check(t, func(rt *rapid.T) {
+		apiKeyID := uuid.New()
+		key := rapid.StringMatching(`[A-Za-z0-9-]{1,64}`).Draw(rt, "key")
+		fingerprint := IdempotencyFingerprint(uuid.New(), []byte(rapid.String().Draw(rt, "body")))
+		defer store.Release(ctx, apiKeyID, key)
+
+		if stored, err := store.Begin(ctx, apiKeyID, key, fingerprint); err != nil || stored != nil {
+			t.Fatalf("First use should claim the key, got %v %v", stored, err)
+		}
+		if _, err := store.Begin(ctx, apiKeyID, key, fingerprint); !errors.Is(err, ErrIdempotencyKeyInUse) {
+			t.Fatalf("PROPERTY VIOLATION: concurrent duplicate got %v", err)
+		}
+		if _, err := store.Begin(ctx, apiKeyID, key, "other"); !errors.Is(err, ErrIdempotencyKeyMismatch) {
+			t.Fatalf("PROPERTY VIOLATION: reuse with a different body got %v", err)
+		}
+
+		resp := &IdempotentResponse{StatusCode: 200, ContentType: "application/json", Body: []byte(`{"ok":true}`)}
+		if err := store.Complete(ctx, apiKeyID, key, fingerprint, resp); err != nil {
+			t.Fatalf("Failed to complete: %v", err)
+		}
+		stored, err := store.Begin(ctx, apiKeyID, key, fingerprint)
+		if err != nil || stored == nil || string(stored.Body) != string(resp.Body) || stored.StatusCode != 200 {
+			t.Fatalf("PROPERTY VIOLATION: replay returned %+v %v", stored, err)
+		}
+
+		// Keys are scoped per API key
+		if stored, err := store.Begin(ctx, uuid.New(), key, fingerprint); err != nil || stored != nil {
+			t.Fatalf("PROPERTY VIOLATION: key leaked across API keys: %v %v", stored, err)
+		}
+
+		if err := store.Release(ctx, apiKeyID, key); err != nil {
+			t.Fatalf("Failed to release: %v", err)
+		}
+		if stored, err := store.Begin(ctx, apiKeyID, key, fingerprint); err != nil || stored != nil {
+			t.Fatalf("Released key should be claimable again, got %v %v", stored, err)
+		}
+	})
+}
+
+// recordOpeningBalance gives a test user a ledger entry matching their initial quota
+func recordOpeningBalance(t *testing.T, ctx context.Context, userID uuid.UUID, quota int64) {
+	t.Helper()
+
+	err := ledger.Record(ctx, testDB, &models.QuotaLedgerEntry{
+		UserID:    userID,
+		EntryType: models.QuotaLedgerOpeningBalance,
+		Amount:    quota,
+	})
+	if err != nil {
+		t.Fatalf("Failed to record opening balance: %v", err)
+	}
+}
+
+// TestQuotaLedger_DebitAndRefund tests that every call debit and refund is
+// recorded in the ledger against its request, keeping the ledger balance
+// equal to the quotas table
+func TestQuotaLedger_DebitAndRefund(t *testing.T) {
+	if testDB == nil {
+		t.Skip("Test database not available")
+	}
+	if testRedis == nil {
+		t.Skip("Test Redis not available")
+	}
+
+	ctx := context.Background()
+	agentSvc, err := agent.NewService(testDB, &testCfg.Encryption)
+	if err != nil {
+		t.Fatalf("Failed to create agent service: %v", err)
+	}
+	proxySvc := NewService(testDB, testRedis, agentSvc, apikey.NewService(testDB), testCfg)
+
+	rapid.Check(t, func(rt *rapid.T) {
+		initialQuota := rapid.Int64Range(10, 100).Draw(rt, "initialQuota")
+		numCalls := rapid.IntRange(1, 10).Draw(rt, "numCalls")
+		numFailed := rapid.IntRange(0, numCalls).Draw(rt, "numFailed")
+
+		userID := createTestUser(t, ctx, initialQuota)
+		defer cleanupTestUser(t, ctx, userID)
+		recordOpeningBalance(t, ctx, userID, initialQuota)
+		if _, err := proxySvc.GetQuotaManager().EnsureQuotaInRedis(ctx, userID); err != nil {
+			t.Fatalf("Failed to ensure quota in Redis: %v", err)
+		}
+
+		for i := 0; i < numCalls; i++ {
+			requestID := uuid.New().String()
+			if _, err := proxySvc.DecrementQuotaForRequest(ctx, userID, requestID, 1); err != nil {
+				t.Fatalf("Failed to decrement quota: %v", err)
+			}
+			if i < numFailed {
+				// Let the async debit land before refunding it
+				time.Sleep(20 * time.Millisecond)
+				if err := proxySvc.RefundQuotaForRequest(ctx, userID, requestID, 1); err != nil {
+					t.Fatalf("Failed to refund quota: %v", err)
+				}
+			}
+		}
+
+		// Wait for async DB sync
+		time.Sleep(100 * time.Millisecond)
+
+		var debits, refunds int
+		var balance, quotaBalance int64
+		err := testDB.QueryRow(ctx, `
+			SELECT COUNT(*) FILTER (WHERE entry_type = 'call_debit' AND request_id IS NOT NULL),
+			       COUNT(*) FILTER (WHERE entry_type = 'call_refund' AND request_id IS NOT NULL),
+			       SUM(amount)
+			FROM quota_ledger WHERE user_id = $1
+		`, userID).Scan(&debits, &refunds, &balance)
+		if err != nil {
+			t.Fatalf("Failed to read ledger: %v", err)
+		}
+		err = testDB.QueryRow(ctx, `
+			SELECT total_quota + free_quota - used_quota FROM quotas WHERE user_id = $1
+		`, userID).Scan(&quotaBalance)
+		if err != nil {
+			t.Fatalf("Failed to get quota: %v", err)
+		}
+
+		if debits != numCalls || refunds != numFailed {
+			t.Fatalf("PROPERTY VIOLATION: expected %d debits and %d refunds, got %d and %d",
+				numCalls, numFailed, debits, refunds)
+		}
+		expected := initialQuota - int64(numCalls-numFailed)
+		if balance != expected || quotaBalance != expected {
+			t.Fatalf("PROPERTY VIOLATION: ledger %d, quotas %d, expected %d", balance, quotaBalance, expected)
+		}
+	})
+}
+
+// TestQuotaReconciler_RebuildsRedis tests that reconciliation replaces a
+// drifted cached balance with the ledger balance and reports it
+func TestQuotaReconciler_RebuildsRedis(t *testing.T) {
+	if testDB == nil {
+		t.Skip("Test database not available")
+	}
+	if testRedis == nil {
+		t.Skip("Test Redis not available")
+	}
+
+	ctx := context.Background()
+	reconciler := NewQuotaReconciler(ledger.NewService(testDB), testRedis, NewRedisGuard("quota", 1), testCfg)
+	reconciler.settle = 10 * time.Millisecond
+
+	userID := createTestUser(t, ctx, 50)
+	defer cleanupTestUser(t, ctx, userID)
+	recordOpeningBalance(t, ctx, userID, 50)
+
+	// A recently active user is reconciled too once the drift has settled
+	if err := testRedis.Client.Set(ctx, quotaKey(userID), 42, 0).Err(); err != nil {
+		t.Fatalf("Failed to cache quota: %v", err)
+	}
+
+	report, err := reconciler.Reconcile(ctx)
+	if err != nil {
+		t.Fatalf("Failed to reconcile: %v", err)
+	}
+	if report.RedisRebuilt < 1 || reconciler.LastReport() != report {
+		t.Fatalf("Expected a rebuilt balance in the latest report, got %+v", report)
+	}
+
+	cached, err := testRedis.Client.Get(ctx, quotaKey(userID)).Int64()
+	if err != nil {
+		t.Fatalf("Failed to get cached quota: %v", err)
+	}
+	if cached != 50 {
+		t.Fatalf("Expected cached balance rebuilt to 50, got %d", cached)
+	}
+}
+
+// TestUpstreamErrorType_ClassifiesFailures tests that provider failures get a
+// bounded error type label and status errors still match ErrUpstreamError
+func TestUpstreamErrorType_ClassifiesFailures(t *testing.T) {
+	statusErr := error(&upstreamStatusError{status: 503})
+	if !errors.Is(statusErr, ErrUpstreamError) || statusErr.Error() != "upstream service error: status 503" {
+		t.Fatalf("Unexpected status error: %v", statusErr)
+	}
+
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{statusErr, "status_503"},
+		{ErrUpstreamTimeout, "timeout"},
+		{fmt.Errorf("wrapped: %w", ErrCircuitOpen), "circuit_open"},
+		{context.Canceled, "canceled"},
+		{fmt.Errorf("%w: connection refused", ErrUpstreamError), "network"},
+		{errors.New("failed to decode response: EOF"), "decode"},
+	}
+	for _, tc := range cases {
+		if got := upstreamErrorType(tc.err); got != tc.want {
+			t.Errorf("Expected %q for %v, got %q", tc.want, tc.err, got)
+		}
+	}
+}
+
+// stubFlusher counts flushes
+type stubFlusher struct{ flushes int }
+
+func (f *stubFlusher) Flush() { f.flushes++ }
+
+// TestStreamResponse_RecordsFirstChunk tests that the first data chunk's
+// arrival is recorded for time-to-first-token, and not comments before it
+func TestStreamResponse_RecordsFirstChunk(t *testing.T) {
+	handler := NewStreamHandler(NewPromptInjector())
+	var out strings.Builder
+
+	before := time.Now()
+	body := ": keep-alive\n\ndata: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\ndata: [DONE]\n\n"
+	result, err := handler.StreamResponse(context.Background(), strings.NewReader(body), &out, &stubFlusher{}, DefaultStreamConfig("secret"))
+	if err != nil {
+		t.Fatalf("Failed to stream: %v", err)
+	}
+	if result.ChunksProcessed != 1 || result.FirstChunkAt.Before(before) {
+		t.Fatalf("Expected one chunk with its arrival time, got %+v", result)
+	}
+
+	result, err = handler.StreamResponse(context.Background(), strings.NewReader("data: [DONE]\n\n"), &out, &stubFlusher{}, DefaultStreamConfig("secret"))
+	if err != nil || !result.FirstChunkAt.IsZero() {
+		t.Fatalf("Expected no first chunk for an empty stream, got %+v, %v", result, err)
+	}
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/google/uuid"
@@ -95,7 +96,7 @@ func (qm *QuotaManager) AtomicDecrementQuota(ctx context.Context, userID uuid.UU
 	}
 
 	// Async sync to database
-	go qm.syncQuotaToDB(context.Background(), userID, amount)
+	go qm.syncQuotaToDB(context.Background(), userID, "", amount)
 
 	return result, nil
 }
@@ -149,7 +150,7 @@ func (qm *QuotaManager) CheckAndDecrementQuota(ctx context.Context, userID uuid.
 
 	// Async sync to database if successful
 	if allowed {
-		go qm.syncQuotaToDB(context.Background(), userID, amount)
+		go qm.syncQuotaToDB(context.Background(), userID, "", amount)
 	}
 
 	return quotaRemaining, rateCount, allowed, nil
@@ -176,14 +177,9 @@ func (qm *QuotaManager) syncQuotaFromDB(ctx context.Context, userID uuid.UUID, d
 			return remaining, ErrQuotaExhausted
 		}
 		
-		err = qm.service.db.QueryRow(ctx, `
-			UPDATE quotas 
-			SET used_quota = used_quota + $1, updated_at = NOW()
-			WHERE user_id = $2
-			RETURNING (total_quota + free_quota - used_quota)
-		`, decrementAmount, userID).Scan(&remaining)
+		remaining, err = qm.service.debitQuota(ctx, userID, "", decrementAmount, true)
 		if err != nil {
-			return 0, fmt.Errorf("failed to decrement quota in database: %w", err)
+			return 0, err
 		}
 	}
 
@@ -197,13 +193,11 @@ func (qm *QuotaManager) syncQuotaFromDB(ctx context.Context, userID uuid.UUID, d
 	return remaining, nil
 }
 
-// syncQuotaToDB syncs quota decrement to database
-func (qm *QuotaManager) syncQuotaToDB(ctx context.Context, userID uuid.UUID, amount int64) {
-	_, err := qm.service.db.Exec(ctx, `
-		UPDATE quotas 
-		SET used_quota = used_quota + $1, updated_at = NOW()
-		WHERE user_id = $2
-	`, amount, userID)
+// syncQuotaToDB syncs a quota decrement Redis already accepted to the
+// database and the quota ledger. Drift left by a failed sync is found by the
+// ledger reconciler.
+func (qm *QuotaManager) syncQuotaToDB(ctx context.Context, userID uuid.UUID, requestID string, amount int64) {
+	_, err := qm.service.debitQuota(ctx, userID, requestID, amount, false)
 	if err != nil {
 		log.Error().Err(err).
 			Str("user_id", userID.String()).
@@ -222,13 +216,8 @@ func (qm *QuotaManager) RefundQuotaAtomic(ctx context.Context, userID uuid.UUID,
 	}
 
 	// Update database
-	_, dbErr := qm.service.db.Exec(ctx, `
-		UPDATE quotas 
-		SET used_quota = GREATEST(0, used_quota - $1), updated_at = NOW()
-		WHERE user_id = $2
-	`, amount, userID)
-	if dbErr != nil {
-		return fmt.Errorf("failed to refund quota in database: %w", dbErr)
+	if dbErr := qm.service.refundQuotaDB(ctx, userID, "", amount); dbErr != nil && !errors.Is(dbErr, ErrQuotaNotFound) {
+		return dbErr
 	}
 
 	return nil
@@ -275,3 +264,11 @@ func (qm *QuotaManager) EnsureQuotaInRedis(ctx context.Context, userID uuid.UUID
 	// Not in Redis, sync from database
 	return qm.syncQuotaFromDB(ctx, userID, 0)
 }
+
+// optionalString returns nil for an empty string
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 type RateLimiter struct {
 	redis  *cache.Redis
 	config *config.RateLimitConfig
+	guard  *RedisGuard
 }
 
 // RateLimitResult contains the result of a rate limit check
@@ -31,6 +33,7 @@ func NewRateLimiter(redis *cache.Redis, cfg *config.RateLimitConfig) *RateLimite
 	return &RateLimiter{
 		redis:  redis,
 		config: cfg,
+		guard:  NewRedisGuard(cfg.RedisFailureMode, cfg.ExpectedReplicas),
 	}
 }
 
@@ -70,15 +73,16 @@ func (r *RateLimiter) checkSlidingWindow(ctx context.Context, userID string, lim
 	countCmd := pipe.ZCard(ctx, key)
 
 	// Execute pipeline
-	_, err := pipe.Exec(ctx)
+	err := r.guard.Do(func() error {
+		_, err := pipe.Exec(ctx)
+		return err
+	})
 	if err != nil {
-		log.Error().Err(err).Str("user_id", userID).Msg("Failed to check rate limit")
-		// On Redis error, allow the request (fail open)
-		return &RateLimitResult{
-			Allowed:   true,
-			Remaining: int64(limit),
-			Limit:     limit,
-		}, nil
+		if !errors.Is(err, ErrRedisUnavailable) {
+			log.Error().Err(err).Str("user_id", userID).Msg("Failed to check rate limit")
+		}
+		// On Redis error, decide according to the configured failure mode
+		return r.guard.fallbackTake(key, 1, limit, windowDuration), nil
 	}
 
 	currentCount := countCmd.Val()
@@ -111,10 +115,12 @@ func (r *RateLimiter) checkSlidingWindow(ctx context.Context, userID string, lim
 
 	// Add new entry
 	requestID := fmt.Sprintf("%d-%s", now.UnixNano(), userID)
-	err = r.redis.Client.ZAdd(ctx, key, redis.Z{
-		Score:  float64(now.UnixNano()),
-		Member: requestID,
-	}).Err()
+	err = r.guard.Do(func() error {
+		return r.redis.Client.ZAdd(ctx, key, redis.Z{
+			Score:  float64(now.UnixNano()),
+			Member: requestID,
+		}).Err()
+	})
 	if err != nil {
 		log.Warn().Err(err).Str("user_id", userID).Msg("Failed to add rate limit entry")
 	}
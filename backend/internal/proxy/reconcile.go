@@ -0,0 +1,308 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/cache"
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/ledger"
+	"github.com/aimerfeng/AgentLink/internal/monitoring"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// reconcileBatchSize is how many users are compared per query
+	reconcileBatchSize = 500
+	// reconcileSettle is how long a cached balance must keep disagreeing with
+	// the ledger by the same amount before it is rebuilt. Calls debit Redis
+	// before their database sync lands, so an active user's balances briefly
+	// disagree by the calls in flight.
+	reconcileSettle = 2 * time.Second
+	// maxReportedDiscrepancies bounds the discrepancies kept in a report
+	maxReportedDiscrepancies = 100
+)
+
+// Lua script for replacing a cached quota only if it still holds the value read
+// KEYS[1]: quota key
+// ARGV: expected value, new value
+// Returns: 1 if replaced, 0 if the balance changed meanwhile
+const luaRebuildQuota = `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+    redis.call('SET', KEYS[1], ARGV[2], 'KEEPTTL')
+    return 1
+end
+return 0
+`
+
+// QuotaDiscrepancy is a user whose cached or stored balance disagreed with the ledger
+type QuotaDiscrepancy struct {
+	UserID        uuid.UUID `json:"user_id"`
+	LedgerBalance int64     `json:"ledger_balance"`
+	QuotaBalance  int64     `json:"quota_balance"`
+	RedisBalance  *int64    `json:"redis_balance,omitempty"` // nil if not cached
+}
+
+// ReconcileReport summarizes one reconciliation pass
+type ReconcileReport struct {
+	StartedAt     time.Time          `json:"started_at"`
+	FinishedAt    time.Time          `json:"finished_at"`
+	UsersChecked  int                `json:"users_checked"`
+	DatabaseDrift int                `json:"database_drift"` // quotas table disagreed with the ledger
+	RedisRebuilt  int                `json:"redis_rebuilt"`  // cached balances replaced with the ledger balance
+	Discrepancies []QuotaDiscrepancy `json:"discrepancies"`  // first maxReportedDiscrepancies found
+	Error         string             `json:"error,omitempty"`
+}
+
+// QuotaReconciler periodically compares every user's quota balance in Redis
+// and the quotas table with the quota ledger. The ledger is authoritative:
+// cached balances are rebuilt from it, and drift in the quotas table is reported.
+type QuotaReconciler struct {
+	ledger   *ledger.Service
+	redis    *cache.Redis
+	guard    *RedisGuard
+	interval time.Duration
+	settle   time.Duration
+	last     *ReconcileReport
+	running  sync.Mutex
+	mu       sync.RWMutex
+}
+
+// NewQuotaReconciler creates a new quota reconciler
+func NewQuotaReconciler(ledgerService *ledger.Service, redis *cache.Redis, guard *RedisGuard, cfg *config.Config) *QuotaReconciler {
+	return &QuotaReconciler{
+		ledger:   ledgerService,
+		redis:    redis,
+		guard:    guard,
+		interval: cfg.Proxy.QuotaReconcileInterval,
+		settle:   reconcileSettle,
+	}
+}
+
+// Run reconciles every interval until the context is cancelled.
+// Does nothing if reconciliation is disabled.
+func (r *QuotaReconciler) Run(ctx context.Context) {
+	if r.interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Reconcile(ctx); err != nil {
+				log.Error().Err(err).Msg("Quota reconciliation failed")
+			}
+		}
+	}
+}
+
+// Reconcile runs one reconciliation pass over all users. Only one pass runs
+// at a time; a concurrent call waits for the running pass to finish first.
+func (r *QuotaReconciler) Reconcile(ctx context.Context) (*ReconcileReport, error) {
+	r.running.Lock()
+	defer r.running.Unlock()
+
+	report := &ReconcileReport{StartedAt: time.Now(), Discrepancies: []QuotaDiscrepancy{}}
+	err := r.reconcile(ctx, report)
+	report.FinishedAt = time.Now()
+	if err != nil {
+		report.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	r.last = report
+	r.mu.Unlock()
+
+	log.Info().
+		Int("users_checked", report.UsersChecked).
+		Int("database_drift", report.DatabaseDrift).
+		Int("redis_rebuilt", report.RedisRebuilt).
+		Dur("duration", report.FinishedAt.Sub(report.StartedAt)).
+		Msg("Quota reconciliation finished")
+	return report, err
+}
+
+// LastReport returns the report of the latest reconciliation pass, or nil if none has run
+func (r *QuotaReconciler) LastReport() *ReconcileReport {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.last
+}
+
+// reconcile compares users in batches, in user ID order
+func (r *QuotaReconciler) reconcile(ctx context.Context, report *ReconcileReport) error {
+	after := uuid.Nil
+	for {
+		balances, err := r.ledger.ListBalances(ctx, after, reconcileBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(balances) == 0 {
+			return nil
+		}
+
+		cached, err := r.cachedBalances(ctx, balances)
+		if err != nil {
+			return err
+		}
+		settled, err := r.settledDrift(ctx, balances, cached)
+		if err != nil {
+			return err
+		}
+
+		for i, b := range balances {
+			report.UsersChecked++
+			drifted := b.Drifted()
+			if drifted {
+				report.DatabaseDrift++
+				monitoring.RecordQuotaDrift("database")
+			}
+
+			redisBalance := cached[i]
+			if d, ok := settled[b.UserID]; ok {
+				drifted = true
+				redisBalance = &d.redis
+				rebuilt, err := r.rebuild(ctx, b.UserID, d.redis, d.ledger)
+				if err != nil {
+					return err
+				}
+				if rebuilt {
+					report.RedisRebuilt++
+					monitoring.RecordQuotaDrift("redis")
+				}
+			}
+
+			if drifted {
+				log.Warn().
+					Str("user_id", b.UserID.String()).
+					Int64("ledger_balance", b.LedgerBalance).
+					Int64("quota_balance", b.QuotaBalance).
+					Interface("redis_balance", redisBalance).
+					Msg("Quota balance disagrees with ledger")
+				if len(report.Discrepancies) < maxReportedDiscrepancies {
+					report.Discrepancies = append(report.Discrepancies, QuotaDiscrepancy{
+						UserID:        b.UserID,
+						LedgerBalance: b.LedgerBalance,
+						QuotaBalance:  b.QuotaBalance,
+						RedisBalance:  redisBalance,
+					})
+				}
+			}
+		}
+
+		after = balances[len(balances)-1].UserID
+		if len(balances) < reconcileBatchSize {
+			return nil
+		}
+	}
+}
+
+// settledBalance is a cached balance and the ledger balance it disagrees with
+type settledBalance struct {
+	redis  int64
+	ledger int64
+}
+
+// settledDrift waits for the settle period and reads the users whose cached
+// balance disagreed with the ledger again. Users still off by the same amount
+// are returned with their latest balances; a changed difference means calls
+// were in flight, and the user is left for the next pass.
+func (r *QuotaReconciler) settledDrift(ctx context.Context, balances []ledger.Balance, cached []*int64) (map[uuid.UUID]settledBalance, error) {
+	diffs := make(map[uuid.UUID]int64)
+	var userIDs []uuid.UUID
+	for i, b := range balances {
+		if cached[i] != nil && *cached[i] != b.LedgerBalance {
+			diffs[b.UserID] = *cached[i] - b.LedgerBalance
+			userIDs = append(userIDs, b.UserID)
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(r.settle):
+	}
+
+	latest, err := r.ledger.GetBalances(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	latestCached, err := r.cachedBalances(ctx, latest)
+	if err != nil {
+		return nil, err
+	}
+
+	settled := make(map[uuid.UUID]settledBalance)
+	for i, b := range latest {
+		if c := latestCached[i]; c != nil && *c-b.LedgerBalance == diffs[b.UserID] {
+			settled[b.UserID] = settledBalance{redis: *c, ledger: b.LedgerBalance}
+		}
+	}
+	return settled, nil
+}
+
+// cachedBalances returns each user's balance in Redis, or nil where none is cached
+func (r *QuotaReconciler) cachedBalances(ctx context.Context, balances []ledger.Balance) ([]*int64, error) {
+	keys := make([]string, len(balances))
+	for i, b := range balances {
+		keys[i] = quotaKey(b.UserID)
+	}
+
+	var values []interface{}
+	err := r.guard.Do(func() error {
+		var err error
+		values, err = r.redis.Client.MGet(ctx, keys...).Result()
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cached quotas: %w", err)
+	}
+
+	cached := make([]*int64, len(balances))
+	for i, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			cached[i] = &n
+		}
+	}
+	return cached, nil
+}
+
+// rebuild replaces a user's cached balance with the ledger balance, unless a
+// call changed it since it was read
+func (r *QuotaReconciler) rebuild(ctx context.Context, userID uuid.UUID, expected, balance int64) (bool, error) {
+	var replaced int64
+	err := r.guard.Do(func() error {
+		var err error
+		replaced, err = r.redis.Client.Eval(ctx, luaRebuildQuota, []string{quotaKey(userID)}, expected, balance).Int64()
+		if errors.Is(err, redis.Nil) {
+			err = nil
+		}
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to rebuild cached quota: %w", err)
+	}
+	return replaced == 1, nil
+}
+
+// quotaKey returns the Redis key caching a user's remaining quota
+func quotaKey(userID uuid.UUID) string {
+	return fmt.Sprintf("quota:%s", userID.String())
+}
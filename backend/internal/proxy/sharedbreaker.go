@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/cache"
+)
+
+// sharedBreakerTTL keeps idle breaker state from accumulating in Redis
+const sharedBreakerTTL = 24 * time.Hour
+
+// Shared breaker states as stored in Redis
+const (
+	sharedStateClosed   = 0
+	sharedStateOpen     = 1
+	sharedStateHalfOpen = 2
+)
+
+// Lua script for admitting a request through a shared breaker
+// KEYS[1]: breaker hash
+// ARGV: now (ms), open timeout (ms), max half-open requests, ttl (s)
+// Returns: {allowed, state, generation, changed}
+const luaBreakerAllow = `
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local timeout = tonumber(ARGV[2])
+local max_requests = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+-- An admin override ignores traffic; generation -1 makes outcomes unrecorded
+local forced = redis.call('HGET', key, 'forced')
+if forced == 'open' then
+    return {0, 1, -1, 0}
+elseif forced == 'closed' then
+    return {1, 0, -1, 0}
+end
+
+local state = tonumber(redis.call('HGET', key, 'state') or '0')
+local generation = tonumber(redis.call('HGET', key, 'generation') or '0')
+local changed_at = tonumber(redis.call('HGET', key, 'changed_at') or '0')
+local changed = 0
+
+if state == 1 then
+    if now - changed_at < timeout then
+        return {0, state, generation, 0}
+    end
+    state = 2
+    generation = generation + 1
+    changed = 1
+    redis.call('HSET', key, 'state', state, 'generation', generation, 'changed_at', now,
+        'failures', 0, 'successes', 0, 'half_open', 0,
+        'requests', 0, 'total_success', 0, 'total_failure', 0)
+end
+
+if state == 2 then
+    local admitted = tonumber(redis.call('HGET', key, 'half_open') or '0')
+    -- Probes from a replica that died never report back; admit new ones
+    -- after another timeout instead of staying half-open forever
+    if admitted >= max_requests and now - changed_at < timeout then
+        return {0, state, generation, changed}
+    end
+    if admitted >= max_requests then
+        redis.call('HSET', key, 'half_open', 0, 'changed_at', now)
+    end
+    redis.call('HINCRBY', key, 'half_open', 1)
+end
+
+redis.call('HINCRBY', key, 'requests', 1)
+redis.call('EXPIRE', key, ttl)
+return {1, state, generation, changed}
+`
+
+// Lua script for recording a request's outcome in a shared breaker
+// KEYS[1]: breaker hash
+// ARGV: success (0/1), now (ms), generation, failure threshold, success threshold, interval (ms), ttl (s)
+// Returns: {previous state, state}; outcomes from an earlier generation are ignored
+const luaBreakerRecord = `
+local key = KEYS[1]
+local success = tonumber(ARGV[1]) == 1
+local now = tonumber(ARGV[2])
+local generation = tonumber(ARGV[3])
+local failure_threshold = tonumber(ARGV[4])
+local success_threshold = tonumber(ARGV[5])
+local interval = tonumber(ARGV[6])
+local ttl = tonumber(ARGV[7])
+
+local current = tonumber(redis.call('HGET', key, 'generation') or '0')
+local state = tonumber(redis.call('HGET', key, 'state') or '0')
+if current ~= generation then
+    return {state, state}
+end
+
+local next_state = state
+if success then
+    redis.call('HINCRBY', key, 'total_success', 1)
+    if state == 2 then
+        if redis.call('HINCRBY', key, 'successes', 1) >= success_threshold then
+            next_state = 0
+        end
+    else
+        redis.call('HSET', key, 'failures', 0)
+    end
+else
+    redis.call('HINCRBY', key, 'total_failure', 1)
+    if state == 2 then
+        next_state = 1
+    elseif state == 0 then
+        local window_start = tonumber(redis.call('HGET', key, 'window_start') or '0')
+        if interval > 0 and now - window_start >= interval then
+            redis.call('HSET', key, 'failures', 0, 'window_start', now)
+        end
+        if redis.call('HINCRBY', key, 'failures', 1) >= failure_threshold then
+            next_state = 1
+        end
+    end
+end
+
+if next_state ~= state then
+    redis.call('HSET', key, 'state', next_state, 'generation', current + 1, 'changed_at', now,
+        'failures', 0, 'successes', 0, 'half_open', 0, 'window_start', now,
+        'requests', 0, 'total_success', 0, 'total_failure', 0)
+end
+redis.call('EXPIRE', key, ttl)
+return {state, next_state}
+`
+
+// sharedBreaker keeps circuit breaker state in Redis so every proxy replica
+// sees the same failure counts and open/half-open state
+type sharedBreaker struct {
+	redis  *cache.Redis
+	guard  *RedisGuard
+	config *CircuitBreakerConfig
+}
+
+// allow admits a request through the named breaker.
+// Returns whether it may proceed and the generation its outcome belongs to.
+func (b *sharedBreaker) allow(ctx context.Context, name string) (bool, int64, error) {
+	var values []int64
+	err := b.guard.Do(func() error {
+		var err error
+		values, err = b.redis.Client.Eval(ctx, luaBreakerAllow, []string{sharedBreakerKey(name)},
+			time.Now().UnixMilli(),
+			b.config.Timeout.Milliseconds(),
+			b.config.MaxRequests,
+			int(sharedBreakerTTL.Seconds()),
+		).Int64Slice()
+		return err
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	if len(values) != 4 {
+		return false, 0, fmt.Errorf("unexpected breaker result length: %d", len(values))
+	}
+
+	if values[3] == 1 {
+		logStateChange(name, CircuitBreakerStateOpen, CircuitBreakerStateHalfOpen, true)
+	}
+	return values[0] == 1, values[2], nil
+}
+
+// record reports a request's outcome to the named breaker
+func (b *sharedBreaker) record(ctx context.Context, name string, generation int64, success bool) error {
+	outcome := 0
+	if success {
+		outcome = 1
+	}
+	var values []int64
+	err := b.guard.Do(func() error {
+		var err error
+		values, err = b.redis.Client.Eval(ctx, luaBreakerRecord, []string{sharedBreakerKey(name)},
+			outcome,
+			time.Now().UnixMilli(),
+			generation,
+			b.config.FailureThreshold,
+			b.successThreshold(),
+			b.config.Interval.Milliseconds(),
+			int(sharedBreakerTTL.Seconds()),
+		).Int64Slice()
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if len(values) != 2 {
+		return fmt.Errorf("unexpected breaker result length: %d", len(values))
+	}
+
+	if values[0] != values[1] {
+		logStateChange(name, sharedStateName(int(values[0])), sharedStateName(int(values[1])), true)
+	}
+	return nil
+}
+
+// status returns the shared state of the named breaker.
+// Returns nil if no replica has used the breaker recently.
+func (b *sharedBreaker) status(ctx context.Context, name string) (*CircuitBreakerStatus, error) {
+	var fields map[string]string
+	err := b.guard.Do(func() error {
+		var err error
+		fields, err = b.redis.Client.HGetAll(ctx, sharedBreakerKey(name)).Result()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	field := func(key string) uint32 {
+		n, _ := strconv.ParseUint(fields[key], 10, 32)
+		return uint32(n)
+	}
+	state, _ := strconv.Atoi(fields["state"])
+	status := &CircuitBreakerStatus{
+		Name:         name,
+		State:        sharedStateName(state),
+		Requests:     field("requests"),
+		TotalSuccess: field("total_success"),
+		TotalFailure: field("total_failure"),
+	}
+	if forced := fields["forced"]; forced != "" {
+		status.State = CircuitBreakerState(forced)
+		status.Forced = true
+	}
+	return status, nil
+}
+
+// force holds the named breaker open or closed for every replica until reset
+func (b *sharedBreaker) force(ctx context.Context, name string, state CircuitBreakerState) error {
+	return b.guard.Do(func() error {
+		pipe := b.redis.Client.TxPipeline()
+		pipe.HSet(ctx, sharedBreakerKey(name), "forced", string(state))
+		// Overrides last until an admin resets them
+		pipe.Persist(ctx, sharedBreakerKey(name))
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+}
+
+// reset clears the named breaker's shared state, closing it
+func (b *sharedBreaker) reset(ctx context.Context, name string) error {
+	return b.guard.Do(func() error {
+		return b.redis.Client.Del(ctx, sharedBreakerKey(name)).Err()
+	})
+}
+
+// successThreshold returns the half-open successes needed to close. It never
+// exceeds MaxRequests, the number of requests admitted while half-open.
+func (b *sharedBreaker) successThreshold() uint32 {
+	if b.config.SuccessThreshold > 0 && b.config.SuccessThreshold < b.config.MaxRequests {
+		return b.config.SuccessThreshold
+	}
+	return b.config.MaxRequests
+}
+
+// sharedBreakerKey returns the Redis hash holding a breaker's state
+func sharedBreakerKey(name string) string {
+	return fmt.Sprintf("circuit:%s", name)
+}
+
+func sharedStateName(state int) CircuitBreakerState {
+	switch state {
+	case sharedStateOpen:
+		return CircuitBreakerStateOpen
+	case sharedStateHalfOpen:
+		return CircuitBreakerStateHalfOpen
+	default:
+		return CircuitBreakerStateClosed
+	}
+}
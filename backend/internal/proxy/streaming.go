@@ -97,15 +97,22 @@ func (sh *StreamHandler) StreamResponse(
 		}
 
 		// Parse and process the chunk
-		processedData, tokens, err := sh.processChunk(data, config)
+		processedData, tokens, usage, err := sh.processChunk(data, config)
 		if err != nil {
 			log.Warn().Err(err).Str("data", truncateString(data, 100)).Msg("Failed to process chunk")
 			// Forward original data on parse error
 			processedData = data
 		}
 
+		if result.ChunksProcessed == 0 {
+			result.FirstChunkAt = time.Now()
+		}
 		result.ChunksProcessed++
 		result.TotalTokens += tokens
+		if usage != nil {
+			// Provider-reported usage (sent on the final chunk) supersedes the estimate
+			result.Usage = usage
+		}
 
 		// Write the processed chunk
 		fmt.Fprintf(writer, "data: %s\n\n", processedData)
@@ -123,14 +130,16 @@ func (sh *StreamHandler) StreamResponse(
 type StreamResult struct {
 	ChunksProcessed int
 	TotalTokens     int
+	Usage           *ChatUsage // Set when the provider reports usage in the stream
+	FirstChunkAt    time.Time  // When the first data chunk arrived; zero if none did
 	Error           error
 }
 
 // processChunk processes a single SSE chunk
-func (sh *StreamHandler) processChunk(data string, config *StreamConfig) (string, int, error) {
+func (sh *StreamHandler) processChunk(data string, config *StreamConfig) (string, int, *ChatUsage, error) {
 	var chunk StreamChunk
 	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-		return data, 0, err
+		return data, 0, nil, err
 	}
 
 	tokens := 0
@@ -139,7 +148,7 @@ func (sh *StreamHandler) processChunk(data string, config *StreamConfig) (string
 	for i, choice := range chunk.Choices {
 		if choice.Delta != nil && choice.Delta.Content != "" {
 			// Rough token estimate (1 token ≈ 4 chars)
-			tokens += len(choice.Delta.Content) / 4
+			tokens += estimateTokens(choice.Delta.Content)
 
 			// Sanitize content if enabled
 			if config.SanitizeContent && config.SystemPrompt != "" {
@@ -154,10 +163,10 @@ func (sh *StreamHandler) processChunk(data string, config *StreamConfig) (string
 	// Re-serialize the chunk
 	processed, err := json.Marshal(chunk)
 	if err != nil {
-		return data, tokens, err
+		return data, tokens, chunk.Usage, err
 	}
 
-	return string(processed), tokens, nil
+	return string(processed), tokens, chunk.Usage, nil
 }
 
 // StreamError sends an error event to the client
@@ -0,0 +1,251 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tokenWindow is the tokens-per-minute window; usage is counted in one bucket
+// per minute and the previous bucket is weighted by how much of it still
+// overlaps the sliding window
+const tokenWindow = time.Minute
+
+// Token limit scopes
+const (
+	TokenScopeUser   = "user"
+	TokenScopeAPIKey = "apikey"
+	TokenScopeAgent  = "agent"
+)
+
+// Lua script for reserving tokens against several limits at once
+// KEYS: current and previous bucket for each scope
+// ARGV: tokens, previous bucket weight, bucket TTL, then one limit per scope
+// Returns: {allowed, rejected_scope_index (1-based, 0 if allowed), used per scope...}
+const luaReserveTokens = `
+local tokens = tonumber(ARGV[1])
+local weight = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[3])
+local scopes = #KEYS / 2
+
+local result = {1, 0}
+for i = 1, scopes do
+    local current = tonumber(redis.call('GET', KEYS[2 * i - 1]) or '0')
+    local previous = tonumber(redis.call('GET', KEYS[2 * i]) or '0')
+    local used = math.floor(current + previous * weight)
+    if used < 0 then
+        used = 0
+    end
+    result[i + 2] = used
+
+    -- A single request larger than the limit is let through on an idle
+    -- window; otherwise it could never be served
+    local limit = tonumber(ARGV[3 + i])
+    if used > 0 and used + tokens > limit and result[1] == 1 then
+        result[1] = 0
+        result[2] = i
+    end
+end
+
+if result[1] == 0 then
+    return result
+end
+
+for i = 1, scopes do
+    redis.call('INCRBY', KEYS[2 * i - 1], tokens)
+    redis.call('EXPIRE', KEYS[2 * i - 1], ttl)
+end
+return result
+`
+
+// TokenScope is one tokens-per-minute limit applied to a request
+type TokenScope struct {
+	Name  string // TokenScopeUser, TokenScopeAPIKey or TokenScopeAgent
+	ID    string
+	Limit int
+}
+
+// TokenReservation records the tokens counted for a request so they can be
+// corrected once actual usage is known
+type TokenReservation struct {
+	Keys   []string // Current-minute buckets the tokens were added to
+	Tokens int64
+	Local  bool // Counted in the local fallback buckets while Redis was unavailable
+}
+
+// TokenLimitResult contains the result of a tokens-per-minute check.
+// Limit, Remaining and Scope describe the tightest of the checked scopes.
+type TokenLimitResult struct {
+	Allowed     bool
+	Scope       string
+	Limit       int
+	Remaining   int64
+	RetryAfter  time.Duration
+	ResetAt     time.Time
+	Reservation *TokenReservation
+}
+
+// ReserveTokens counts an estimated number of tokens against every scope,
+// atomically, if all of them have room. Scopes with a limit of 0 are skipped.
+// Returns nil if no scope applies.
+func (r *RateLimiter) ReserveTokens(ctx context.Context, scopes []TokenScope, tokens int64) (*TokenLimitResult, error) {
+	active := make([]TokenScope, 0, len(scopes))
+	for _, scope := range scopes {
+		if scope.Limit > 0 {
+			active = append(active, scope)
+		}
+	}
+	if len(active) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	bucket := now.Truncate(tokenWindow)
+	keys := make([]string, 0, len(active)*2)
+	args := []interface{}{tokens, previousWindowWeight(now), int((2 * tokenWindow).Seconds())}
+	for _, scope := range active {
+		keys = append(keys, tokenBucketKey(scope, bucket), tokenBucketKey(scope, bucket.Add(-tokenWindow)))
+		args = append(args, scope.Limit)
+	}
+
+	result := &TokenLimitResult{ResetAt: bucket.Add(tokenWindow)}
+	var values []int64
+	err := r.guard.Do(func() error {
+		var err error
+		values, err = r.redis.Client.Eval(ctx, luaReserveTokens, keys, args...).Int64Slice()
+		return err
+	})
+	if err != nil || len(values) != len(active)+2 {
+		if !errors.Is(err, ErrRedisUnavailable) {
+			log.Error().Err(err).Msg("Failed to check token rate limit")
+		}
+		// On Redis error, decide according to the configured failure mode
+		return r.reserveTokensFallback(active, tokens), nil
+	}
+
+	used := values[2:]
+	result.Allowed = values[0] == 1
+	index := tightestScope(active, used)
+	if !result.Allowed {
+		index = int(values[1]) - 1
+		// Usage only drops once the current bucket becomes the weighted one
+		result.RetryAfter = result.ResetAt.Sub(now)
+		if result.RetryAfter < time.Second {
+			result.RetryAfter = time.Second
+		}
+	} else {
+		used[index] += tokens
+		currentKeys := make([]string, len(active))
+		for i := range active {
+			currentKeys[i] = keys[2*i]
+		}
+		result.Reservation = &TokenReservation{Keys: currentKeys, Tokens: tokens}
+	}
+
+	result.Scope = active[index].Name
+	result.Limit = active[index].Limit
+	result.Remaining = int64(active[index].Limit) - used[index]
+	if result.Remaining < 0 {
+		result.Remaining = 0
+	}
+
+	return result, nil
+}
+
+// ReconcileTokens replaces a reservation's estimate with the actual number of
+// tokens used. The difference goes to the buckets the estimate was added to.
+func (r *RateLimiter) ReconcileTokens(ctx context.Context, reservation *TokenReservation, actual int64) error {
+	if reservation == nil {
+		return nil
+	}
+	delta := actual - reservation.Tokens
+	if delta == 0 {
+		return nil
+	}
+
+	if reservation.Local {
+		for _, key := range reservation.Keys {
+			r.guard.local.adjust(key, delta)
+		}
+		return nil
+	}
+
+	err := r.guard.Do(func() error {
+		pipe := r.redis.Client.Pipeline()
+		for _, key := range reservation.Keys {
+			pipe.IncrBy(ctx, key, delta)
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reconcile token usage: %w", err)
+	}
+	return nil
+}
+
+// reserveTokensFallback reserves tokens without Redis. In local mode each
+// scope has a per-instance bucket; a rejection returns tokens already taken.
+func (r *RateLimiter) reserveTokensFallback(scopes []TokenScope, tokens int64) *TokenLimitResult {
+	result := &TokenLimitResult{Allowed: true, ResetAt: time.Now().Add(tokenWindow)}
+	taken := make([]string, 0, len(scopes))
+	var tightest *RateLimitResult
+	for _, scope := range scopes {
+		key := fmt.Sprintf("ratelimit:tpm:%s:%s", scope.Name, scope.ID)
+		scopeResult := r.guard.fallbackTake(key, tokens, scope.Limit, tokenWindow)
+		if !scopeResult.Allowed {
+			for _, k := range taken {
+				r.guard.local.adjust(k, -tokens)
+			}
+			result.Allowed = false
+			result.Scope = scope.Name
+			result.Limit = scope.Limit
+			result.RetryAfter = scopeResult.RetryAfter
+			return result
+		}
+		taken = append(taken, key)
+		if tightest == nil || scopeResult.Remaining < tightest.Remaining {
+			tightest = scopeResult
+			result.Scope = scope.Name
+		}
+	}
+
+	result.Limit = tightest.Limit
+	result.Remaining = tightest.Remaining
+	if r.guard.Mode() == FailureModeLocal {
+		result.Reservation = &TokenReservation{Keys: taken, Tokens: tokens, Local: true}
+	}
+	return result
+}
+
+// previousWindowWeight returns how much of the previous minute bucket still
+// falls inside the sliding window ending at now
+func previousWindowWeight(now time.Time) float64 {
+	elapsed := now.Sub(now.Truncate(tokenWindow))
+	return 1 - float64(elapsed)/float64(tokenWindow)
+}
+
+// tightestScope returns the index of the scope with the least room left.
+// With no usage known, the lowest limit is the tightest.
+func tightestScope(scopes []TokenScope, used []int64) int {
+	tightest := 0
+	var least int64
+	for i, scope := range scopes {
+		remaining := int64(scope.Limit)
+		if used != nil {
+			remaining -= used[i]
+		}
+		if i == 0 || remaining < least {
+			tightest, least = i, remaining
+		}
+	}
+	return tightest
+}
+
+// tokenBucketKey returns the Redis counter for a scope's tokens in the minute starting at bucket
+func tokenBucketKey(scope TokenScope, bucket time.Time) string {
+	return fmt.Sprintf("ratelimit:tpm:%s:%s:%d", scope.Name, scope.ID, bucket.Unix()/60)
+}
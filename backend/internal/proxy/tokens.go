@@ -0,0 +1,22 @@
+package proxy
+
+// Token estimation helpers used when a provider does not report usage.
+// The heuristic (1 token ≈ 4 characters) matches the streaming estimate.
+
+// estimateTokens returns a rough token count for a piece of text
+func estimateTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	return (len(text) + 3) / 4
+}
+
+// estimateMessageTokens returns a rough prompt token count for a system
+// prompt plus conversation messages
+func estimateMessageTokens(systemPrompt string, messages []ChatMessage) int {
+	tokens := estimateTokens(systemPrompt)
+	for _, msg := range messages {
+		tokens += estimateTokens(msg.Content)
+	}
+	return tokens
+}
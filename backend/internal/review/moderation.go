@@ -0,0 +1,126 @@
+package review
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+)
+
+// QueueItem is a review awaiting moderation with the name of its agent
+type QueueItem struct {
+	models.Review
+	AgentName string `json:"agent_name"`
+}
+
+// QueueResponse represents a page of the moderation queue
+type QueueResponse struct {
+	Reviews    []QueueItem `json:"reviews"`
+	Total      int64       `json:"total"`
+	Page       int         `json:"page"`
+	PageSize   int         `json:"page_size"`
+	TotalPages int         `json:"total_pages"`
+}
+
+// Queue returns a page of reviews awaiting moderation, longest waiting first
+func (s *Service) Queue(ctx context.Context, page, pageSize int) (*QueueResponse, error) {
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	resp := &QueueResponse{Page: page, PageSize: pageSize}
+	err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM reviews WHERE status = 'pending'`).Scan(&resp.Total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count pending reviews: %w", err)
+	}
+	resp.TotalPages = int((resp.Total + int64(pageSize) - 1) / int64(pageSize))
+
+	rows, err := s.db.Query(ctx, `
+		SELECT `+reviewColumns+`, (SELECT name FROM agents a WHERE a.id = reviews.agent_id)
+		FROM reviews
+		WHERE status = 'pending'
+		ORDER BY COALESCE(updated_at, created_at), id
+		LIMIT $1 OFFSET $2
+	`, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending reviews: %w", err)
+	}
+	defer rows.Close()
+
+	resp.Reviews = []QueueItem{}
+	for rows.Next() {
+		var item QueueItem
+		r := &item.Review
+		err := rows.Scan(
+			&r.ID, &r.AgentID, &r.UserID, &r.Rating, &r.Content, &r.Status, &r.RejectionReason, &r.ModeratedAt,
+			&r.Reply, &r.RepliedAt, &r.CreatedAt, &r.UpdatedAt, &item.AgentName,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan review: %w", err)
+		}
+		resp.Reviews = append(resp.Reviews, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list pending reviews: %w", err)
+	}
+	return resp, nil
+}
+
+// Approve publishes a review and adds it to its agent's rating
+func (s *Service) Approve(ctx context.Context, adminID, reviewID uuid.UUID) (*models.Review, error) {
+	return s.moderate(ctx, adminID, reviewID, models.ReviewStatusApproved, nil)
+}
+
+// Reject hides a review with a reason shown to its author. Rejecting an
+// approved review removes it from its agent's rating.
+func (s *Service) Reject(ctx context.Context, adminID, reviewID uuid.UUID, reason string) (*models.Review, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrReasonRequired
+	}
+	return s.moderate(ctx, adminID, reviewID, models.ReviewStatusRejected, &reason)
+}
+
+// moderate sets a review's status and, if that changes whether it is
+// approved, its agent's rating, in one transaction
+func (s *Service) moderate(ctx context.Context, adminID, reviewID uuid.UUID, status models.ReviewStatus, reason *string) (*models.Review, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	agentID, previous, err := lockReview(ctx, tx, reviewID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if previous == status {
+		return nil, ErrAlreadyModerated
+	}
+
+	review, err := scanReview(tx.QueryRow(ctx, `
+		UPDATE reviews
+		SET status = $2, rejection_reason = $3, moderated_by = $4, moderated_at = NOW()
+		WHERE id = $1
+		RETURNING `+reviewColumns,
+		reviewID, status, reason, adminID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to moderate review: %w", err)
+	}
+
+	if status == models.ReviewStatusApproved || previous == models.ReviewStatusApproved {
+		if err := refreshAgentRating(ctx, tx, agentID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return review, nil
+}
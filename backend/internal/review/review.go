@@ -0,0 +1,365 @@
+package review
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// maxTextLength bounds review content and creator replies, in characters
+const maxTextLength = 2000
+
+// Review errors
+var (
+	ErrAgentNotFound    = errors.New("agent not found")
+	ErrReviewNotFound   = errors.New("review not found")
+	ErrNotVerified      = errors.New("only users with a successful call to the agent can review it")
+	ErrOwnAgent         = errors.New("creators cannot review their own agents")
+	ErrAlreadyReviewed  = errors.New("agent has already been reviewed by this user")
+	ErrInvalidRating    = errors.New("rating must be between 1 and 5")
+	ErrTextTooLong      = errors.New("review text cannot exceed 2000 characters")
+	ErrInvalidSort      = errors.New("sort must be newest, oldest, highest or lowest")
+	ErrAlreadyModerated = errors.New("review already has this status")
+	ErrReasonRequired   = errors.New("a reason is required to reject a review")
+)
+
+// sortOrders are the ORDER BY clauses of each listing sort
+var sortOrders = map[string]string{
+	"newest":  "created_at DESC, id",
+	"oldest":  "created_at ASC, id",
+	"highest": "rating DESC, created_at DESC, id",
+	"lowest":  "rating ASC, created_at DESC, id",
+}
+
+// reviewColumns are the columns scanned by scanReview
+const reviewColumns = `id, agent_id, user_id, rating, content, status, rejection_reason, moderated_at,
+	reply, replied_at, created_at, COALESCE(updated_at, created_at)`
+
+// Service handles agent reviews and their moderation
+type Service struct {
+	db *pgxpool.Pool
+}
+
+// NewService creates a new review service
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{
+		db: db,
+	}
+}
+
+// ReviewRequest represents a request to submit or edit a review
+type ReviewRequest struct {
+	Rating  int     `json:"rating" binding:"required"`
+	Content *string `json:"content"`
+}
+
+// ListResponse represents a page of an agent's approved reviews
+type ListResponse struct {
+	Reviews       []models.Review `json:"reviews"`
+	AverageRating decimal.Decimal `json:"average_rating"`
+	ReviewCount   int             `json:"review_count"`
+	Total         int64           `json:"total"`
+	Page          int             `json:"page"`
+	PageSize      int             `json:"page_size"`
+	TotalPages    int             `json:"total_pages"`
+}
+
+// Submit creates a review of an agent, pending moderation. Only users with
+// at least one successful call to the agent may review it, once.
+func (s *Service) Submit(ctx context.Context, userID, agentID uuid.UUID, req *ReviewRequest) (*models.Review, error) {
+	content, err := validate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var creatorID uuid.UUID
+	err = s.db.QueryRow(ctx, `
+		SELECT creator_id FROM agents WHERE id = $1 AND status = 'active'
+	`, agentID).Scan(&creatorID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAgentNotFound
+		}
+		return nil, fmt.Errorf("failed to get agent: %w", err)
+	}
+	if creatorID == userID {
+		return nil, ErrOwnAgent
+	}
+
+	var verified bool
+	err = s.db.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM call_logs WHERE user_id = $1 AND agent_id = $2 AND status = 'success'
+		)
+	`, userID, agentID).Scan(&verified)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check agent usage: %w", err)
+	}
+	if !verified {
+		return nil, ErrNotVerified
+	}
+
+	review, err := scanReview(s.db.QueryRow(ctx, `
+		INSERT INTO reviews (agent_id, user_id, rating, content, status)
+		VALUES ($1, $2, $3, $4, 'pending')
+		ON CONFLICT (agent_id, user_id) DO NOTHING
+		RETURNING `+reviewColumns,
+		agentID, userID, req.Rating, content))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAlreadyReviewed
+		}
+		return nil, fmt.Errorf("failed to create review: %w", err)
+	}
+	return review, nil
+}
+
+// Update edits a user's own review. The edited review goes back to
+// moderation, so an approved review stops counting towards the agent's
+// rating until it is approved again.
+func (s *Service) Update(ctx context.Context, userID, reviewID uuid.UUID, req *ReviewRequest) (*models.Review, error) {
+	content, err := validate(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	agentID, status, err := lockReview(ctx, tx, reviewID, &userID)
+	if err != nil {
+		return nil, err
+	}
+
+	review, err := scanReview(tx.QueryRow(ctx, `
+		UPDATE reviews
+		SET rating = $2, content = $3, status = 'pending', rejection_reason = NULL,
+			moderated_by = NULL, moderated_at = NULL, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+reviewColumns,
+		reviewID, req.Rating, content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to update review: %w", err)
+	}
+
+	if status == models.ReviewStatusApproved {
+		if err := refreshAgentRating(ctx, tx, agentID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return review, nil
+}
+
+// Delete removes a user's own review
+func (s *Service) Delete(ctx context.Context, userID, reviewID uuid.UUID) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	agentID, status, err := lockReview(ctx, tx, reviewID, &userID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM reviews WHERE id = $1`, reviewID); err != nil {
+		return fmt.Errorf("failed to delete review: %w", err)
+	}
+
+	if status == models.ReviewStatusApproved {
+		if err := refreshAgentRating(ctx, tx, agentID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Reply sets the creator's public reply to a review of one of their agents.
+// An empty reply removes it.
+func (s *Service) Reply(ctx context.Context, creatorID, reviewID uuid.UUID, reply string) (*models.Review, error) {
+	reply = strings.TrimSpace(reply)
+	if utf8.RuneCountInString(reply) > maxTextLength {
+		return nil, ErrTextTooLong
+	}
+	var replyPtr *string
+	if reply != "" {
+		replyPtr = &reply
+	}
+
+	review, err := scanReview(s.db.QueryRow(ctx, `
+		UPDATE reviews
+		SET reply = $3, replied_at = CASE WHEN $3::text IS NULL THEN NULL ELSE NOW() END
+		WHERE id = $1 AND agent_id IN (SELECT id FROM agents WHERE creator_id = $2)
+		RETURNING `+reviewColumns,
+		reviewID, creatorID, replyPtr))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrReviewNotFound
+		}
+		return nil, fmt.Errorf("failed to reply to review: %w", err)
+	}
+	return review, nil
+}
+
+// List returns a page of an agent's approved reviews with its rating
+func (s *Service) List(ctx context.Context, agentID uuid.UUID, sort string, page, pageSize int) (*ListResponse, error) {
+	if sort == "" {
+		sort = "newest"
+	}
+	order, ok := sortOrders[sort]
+	if !ok {
+		return nil, ErrInvalidSort
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	resp := &ListResponse{Page: page, PageSize: pageSize}
+	err := s.db.QueryRow(ctx, `
+		SELECT COALESCE(average_rating, 0), COALESCE(review_count, 0)
+		FROM agents WHERE id = $1 AND status = 'active'
+	`, agentID).Scan(&resp.AverageRating, &resp.ReviewCount)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAgentNotFound
+		}
+		return nil, fmt.Errorf("failed to get agent: %w", err)
+	}
+
+	err = s.db.QueryRow(ctx, `
+		SELECT COUNT(*) FROM reviews WHERE agent_id = $1 AND status = 'approved'
+	`, agentID).Scan(&resp.Total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count reviews: %w", err)
+	}
+	resp.TotalPages = int((resp.Total + int64(pageSize) - 1) / int64(pageSize))
+
+	resp.Reviews, err = s.query(ctx, `
+		SELECT `+reviewColumns+` FROM reviews
+		WHERE agent_id = $1 AND status = 'approved'
+		ORDER BY `+order+`
+		LIMIT $2 OFFSET $3
+	`, agentID, pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// query returns the reviews selected by a query over reviewColumns
+func (s *Service) query(ctx context.Context, sql string, args ...any) ([]models.Review, error) {
+	rows, err := s.db.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list reviews: %w", err)
+	}
+	defer rows.Close()
+
+	reviews := []models.Review{}
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan review: %w", err)
+		}
+		reviews = append(reviews, *review)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list reviews: %w", err)
+	}
+	return reviews, nil
+}
+
+// validate checks a review's rating and content, returning the trimmed
+// content or nil if there is none
+func validate(req *ReviewRequest) (*string, error) {
+	if req.Rating < 1 || req.Rating > 5 {
+		return nil, ErrInvalidRating
+	}
+	if req.Content == nil {
+		return nil, nil
+	}
+	content := strings.TrimSpace(*req.Content)
+	if content == "" {
+		return nil, nil
+	}
+	if utf8.RuneCountInString(content) > maxTextLength {
+		return nil, ErrTextTooLong
+	}
+	return &content, nil
+}
+
+// lockReview locks a review for update and returns its agent and status.
+// With a user ID, only that user's review is found.
+func lockReview(ctx context.Context, tx pgx.Tx, reviewID uuid.UUID, userID *uuid.UUID) (uuid.UUID, models.ReviewStatus, error) {
+	var agentID uuid.UUID
+	var status models.ReviewStatus
+	err := tx.QueryRow(ctx, `
+		SELECT agent_id, status FROM reviews
+		WHERE id = $1 AND ($2::uuid IS NULL OR user_id = $2)
+		FOR UPDATE
+	`, reviewID, userID).Scan(&agentID, &status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, "", ErrReviewNotFound
+		}
+		return uuid.Nil, "", fmt.Errorf("failed to get review: %w", err)
+	}
+	return agentID, status, nil
+}
+
+// refreshAgentRating recomputes an agent's rating from its approved reviews.
+// The agent row is locked first so that concurrent moderation of the same
+// agent's reviews applies one at a time, each seeing the others' changes.
+func refreshAgentRating(ctx context.Context, tx pgx.Tx, agentID uuid.UUID) error {
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM agents WHERE id = $1 FOR UPDATE`, agentID); err != nil {
+		return fmt.Errorf("failed to lock agent: %w", err)
+	}
+	_, err := tx.Exec(ctx, `
+		UPDATE agents a
+		SET average_rating = COALESCE(r.average, 0), review_count = r.count
+		FROM (
+			SELECT ROUND(AVG(rating), 2) AS average, COUNT(*) AS count
+			FROM reviews WHERE agent_id = $1 AND status = 'approved'
+		) r
+		WHERE a.id = $1
+	`, agentID)
+	if err != nil {
+		return fmt.Errorf("failed to update agent rating: %w", err)
+	}
+	return nil
+}
+
+// scanReview scans a row of reviewColumns
+func scanReview(row pgx.Row) (*models.Review, error) {
+	var r models.Review
+	err := row.Scan(
+		&r.ID, &r.AgentID, &r.UserID, &r.Rating, &r.Content, &r.Status, &r.RejectionReason, &r.ModeratedAt,
+		&r.Reply, &r.RepliedAt, &r.CreatedAt, &r.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
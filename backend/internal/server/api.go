@@ -1,25 +1,44 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/aimerfeng/AgentLink/internal/admin"
 	"github.com/aimerfeng/AgentLink/internal/agent"
+	"github.com/aimerfeng/AgentLink/internal/alerts"
 	"github.com/aimerfeng/AgentLink/internal/apikey"
 	"github.com/aimerfeng/AgentLink/internal/auth"
+	"github.com/aimerfeng/AgentLink/internal/capture"
 	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/email"
 	apierrors "github.com/aimerfeng/AgentLink/internal/errors"
+	"github.com/aimerfeng/AgentLink/internal/export"
+	"github.com/aimerfeng/AgentLink/internal/ledger"
 	"github.com/aimerfeng/AgentLink/internal/logging"
+	"github.com/aimerfeng/AgentLink/internal/marketplace"
 	"github.com/aimerfeng/AgentLink/internal/middleware"
+	"github.com/aimerfeng/AgentLink/internal/models"
 	"github.com/aimerfeng/AgentLink/internal/monitoring"
+	"github.com/aimerfeng/AgentLink/internal/partition"
 	"github.com/aimerfeng/AgentLink/internal/payment"
+	"github.com/aimerfeng/AgentLink/internal/review"
+	"github.com/aimerfeng/AgentLink/internal/tracing"
 	"github.com/aimerfeng/AgentLink/internal/trial"
+	"github.com/aimerfeng/AgentLink/internal/usage"
+	"github.com/aimerfeng/AgentLink/internal/webhook"
 	"github.com/aimerfeng/AgentLink/internal/withdrawal"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
 )
 
 // APIServer represents the main API server
@@ -33,6 +52,16 @@ type APIServer struct {
 	paymentService     *payment.Service
 	trialService       *trial.Service
 	withdrawalService  *withdrawal.Service
+	ledgerService      *ledger.Service
+	webhookService     *webhook.Service
+	alertService       *alerts.Service
+	usageService       *usage.Service
+	exportService      *export.Service
+	partitionService   *partition.Service
+	captureService     *capture.Service // nil without an agent service, whose key encrypts captures
+	adminService       *admin.Service
+	reviewService      *review.Service
+	marketplaceService *marketplace.Service
 	jwtAuthenticator   *middleware.JWTAuthenticator
 }
 
@@ -47,6 +76,7 @@ func NewAPIServer(cfg *config.Config, db *pgxpool.Pool) *APIServer {
 	// Add middleware in order
 	router.Use(gin.Recovery())
 	router.Use(middleware.RequestID())
+	router.Use(tracing.Middleware())
 	router.Use(middleware.CORS(cfg.CORS.AllowedOrigins))
 	router.Use(monitoring.MetricsMiddleware())
 	router.Use(logging.RequestLogger())
@@ -73,8 +103,32 @@ func NewAPIServer(cfg *config.Config, db *pgxpool.Pool) *APIServer {
 	// Create withdrawal service
 	withdrawalService := withdrawal.NewService(db, nil) // Uses default config
 
+	// Create quota ledger service
+	ledgerService := ledger.NewService(db)
+
+	// Create webhook service; production endpoints must use https
+	webhookService := webhook.NewService(db, &cfg.Webhook, cfg.Server.Env == "production")
+
+	// Create quota alert service
+	alertService := alerts.NewService(db, &cfg.Quota, email.NewMailer(&cfg.SMTP))
+
+	// Create usage service
+	usageService := usage.NewService(db, &cfg.Usage)
+
+	// Create export service
+	exportService := export.NewService(db, &cfg.Export)
+
+	// Create call log partition maintenance service
+	partitionService := partition.NewService(db, &cfg.Partitions)
+
+	// Create payload capture service; captures are encrypted with the agent config key
+	var captureService *capture.Service
+	if agentService != nil {
+		captureService = capture.NewService(db, agentService, &cfg.Capture)
+	}
+
 	// Create JWT authenticator for middleware
-	jwtAuthenticator := middleware.NewJWTAuthenticator(&cfg.JWT)
+	jwtAuthenticator := middleware.NewJWTAuthenticator(&cfg.JWT).WithAccountChecker(authService)
 
 	srv := &APIServer{
 		config:             cfg,
@@ -86,6 +140,16 @@ func NewAPIServer(cfg *config.Config, db *pgxpool.Pool) *APIServer {
 		paymentService:     paymentService,
 		trialService:       trialService,
 		withdrawalService:  withdrawalService,
+		ledgerService:      ledgerService,
+		webhookService:     webhookService,
+		alertService:       alertService,
+		usageService:       usageService,
+		exportService:      exportService,
+		partitionService:   partitionService,
+		captureService:     captureService,
+		adminService:       admin.NewService(db),
+		reviewService:      review.NewService(db),
+		marketplaceService: marketplace.NewService(db, &cfg.Marketplace),
 		jwtAuthenticator:   jwtAuthenticator,
 	}
 
@@ -98,6 +162,31 @@ func (s *APIServer) Router() http.Handler {
 	return s.router
 }
 
+// StartBackground starts the API server's background workers (webhook
+// delivery, quota alert emails, usage rollups, export jobs, call log
+// partition maintenance, payload capture cleanup) and backfills the searchable
+// model info of agents saved before it was stored
+func (s *APIServer) StartBackground(ctx context.Context) {
+	go s.webhookService.RunWorker(ctx)
+	go s.alertService.RunMailer(ctx)
+	go s.usageService.RunRollup(ctx)
+	go s.exportService.RunWorker(ctx)
+	go s.partitionService.RunMaintenance(ctx)
+	if s.captureService != nil {
+		go s.captureService.RunCleanup(ctx)
+	}
+	if s.agentService != nil {
+		go func() {
+			updated, err := s.agentService.BackfillModelInfo(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("Failed to backfill agent model info")
+			} else if updated > 0 {
+				log.Info().Int("agents", updated).Msg("Backfilled agent model info")
+			}
+		}()
+	}
+}
+
 // setupRoutes configures all API routes
 func (s *APIServer) setupRoutes() {
 	// Health check
@@ -138,6 +227,7 @@ func (s *APIServer) setupRoutes() {
 			agents.POST("/:id/unpublish", s.handleUnpublishAgent)
 			agents.GET("/:id/versions", s.handleListAgentVersions)
 			agents.GET("/:id/versions/:version", s.handleGetAgentVersion)
+			agents.GET("/:id/analytics", s.handleGetAgentAnalytics)
 			agents.POST("/:id/knowledge", s.handleUploadKnowledge)
 			// D5.4: Creator can disable trial for their agents
 			agents.PUT("/:id/trial", s.handleSetAgentTrial)
@@ -171,8 +261,12 @@ func (s *APIServer) setupRoutes() {
 			developers.GET("/me", s.handleGetDeveloper)
 			developers.GET("/keys", s.handleListAPIKeys)
 			developers.POST("/keys", s.handleCreateAPIKey)
+			developers.PUT("/keys/:id", s.handleUpdateAPIKey)
+			developers.POST("/keys/:id/rotate", s.handleRotateAPIKey)
+			developers.GET("/keys/:id/audit", s.handleListAPIKeyAuditLogs)
 			developers.DELETE("/keys/:id", s.handleDeleteAPIKey)
 			developers.GET("/usage", s.handleGetUsage)
+			developers.GET("/captures/:requestId", s.handleGetCapture)
 		}
 
 		// Payment routes (protected - requires any authenticated user)
@@ -185,6 +279,11 @@ func (s *APIServer) setupRoutes() {
 			payments.GET("/history", s.jwtAuthenticator.JWTAuth(), s.handlePaymentHistory)
 			payments.GET("/packages", s.handleGetPackages)
 			payments.GET("/quota", s.jwtAuthenticator.JWTAuth(), s.handleGetQuota)
+			payments.GET("/quota/ledger", s.jwtAuthenticator.JWTAuth(), s.handleGetQuotaLedger)
+			payments.GET("/quota/alerts", s.jwtAuthenticator.JWTAuth(), s.handleListQuotaAlerts)
+			payments.GET("/quota/alert-thresholds", s.jwtAuthenticator.JWTAuth(), s.handleListQuotaAlertThresholds)
+			payments.POST("/quota/alert-thresholds", s.jwtAuthenticator.JWTAuth(), s.handleCreateQuotaAlertThreshold)
+			payments.DELETE("/quota/alert-thresholds/:id", s.jwtAuthenticator.JWTAuth(), s.handleDeleteQuotaAlertThreshold)
 		}
 
 		// Review routes (mixed - some public, some protected)
@@ -192,6 +291,9 @@ func (s *APIServer) setupRoutes() {
 		{
 			reviews.POST("/agents/:id", s.jwtAuthenticator.JWTAuth(), s.handleSubmitReview)
 			reviews.GET("/agents/:id", s.handleGetReviews)
+			reviews.PUT("/:id", s.jwtAuthenticator.JWTAuth(), s.handleUpdateReview)
+			reviews.DELETE("/:id", s.jwtAuthenticator.JWTAuth(), s.handleDeleteReview)
+			reviews.PUT("/:id/reply", s.jwtAuthenticator.JWTAuth(), middleware.RequireCreator(), s.handleReplyReview)
 		}
 
 		// Webhook routes (protected - requires developer role)
@@ -201,7 +303,25 @@ func (s *APIServer) setupRoutes() {
 		{
 			webhooks.GET("/", s.handleListWebhooks)
 			webhooks.POST("/", s.handleCreateWebhook)
+			webhooks.GET("/:id", s.handleGetWebhook)
+			webhooks.PUT("/:id", s.handleUpdateWebhook)
 			webhooks.DELETE("/:id", s.handleDeleteWebhook)
+			webhooks.POST("/:id/test", s.handleTestWebhook)
+			webhooks.GET("/:id/deliveries", s.handleListWebhookDeliveries)
+			webhooks.POST("/:id/deliveries/:deliveryId/redeliver", s.handleRedeliverWebhook)
+		}
+
+		// Export routes (protected - requires creator or developer role)
+		exports := v1.Group("/exports")
+		exports.Use(s.jwtAuthenticator.JWTAuth())
+		exports.Use(middleware.RequireCreatorOrDeveloper())
+		{
+			exports.GET("/datasets", s.handleListExportDatasets)
+			exports.GET("/stream/:dataset", s.handleStreamExport)
+			exports.POST("/jobs", s.handleCreateExportJob)
+			exports.GET("/jobs", s.handleListExportJobs)
+			exports.GET("/jobs/:id", s.handleGetExportJob)
+			exports.GET("/jobs/:id/download", s.handleDownloadExportJob)
 		}
 
 		// Admin routes (protected - requires admin role)
@@ -213,6 +333,7 @@ func (s *APIServer) setupRoutes() {
 			admin.GET("/users", s.handleAdminListUsers)
 			admin.GET("/users/:id", s.handleAdminGetUser)
 			admin.PUT("/users/:id/status", s.handleAdminUpdateUserStatus)
+			admin.POST("/users/:id/quota-adjustments", s.handleAdminAdjustQuota)
 			admin.GET("/moderation/queue", s.handleAdminModerationQueue)
 			admin.POST("/moderation/reviews/:id/approve", s.handleAdminApproveReview)
 			admin.POST("/moderation/reviews/:id/reject", s.handleAdminRejectReview)
@@ -279,9 +400,14 @@ func (s *APIServer) handleLogin(c *gin.Context) {
 
 	resp, err := s.authService.Login(c.Request.Context(), &req)
 	if err != nil {
-		if err == auth.ErrInvalidCredentials {
+		switch err {
+		case auth.ErrInvalidCredentials:
 			respondError(c, apierrors.ErrInvalidCredentialsError)
-		} else {
+		case auth.ErrAccountSuspended:
+			respondError(c, apierrors.ErrAccountSuspendedError)
+		case auth.ErrAccountBanned:
+			respondError(c, apierrors.ErrAccountBannedError)
+		default:
 			respondError(c, apierrors.ErrInternalServerError)
 		}
 		return
@@ -314,6 +440,10 @@ func (s *APIServer) handleRefresh(c *gin.Context) {
 			respondError(c, apierrors.ErrTokenExpiredError)
 		case auth.ErrUserNotFound:
 			respondError(c, apierrors.ErrUserNotFoundError)
+		case auth.ErrAccountSuspended:
+			respondError(c, apierrors.ErrAccountSuspendedError)
+		case auth.ErrAccountBanned:
+			respondError(c, apierrors.ErrAccountBannedError)
 		default:
 			respondError(c, apierrors.ErrInternalServerError)
 		}
@@ -340,6 +470,7 @@ func respondError(c *gin.Context, err *apierrors.APIError) {
 		c.Request.URL.Path,
 		c.Request.Method,
 	)
+	response.TraceID = c.GetString("trace_id")
 
 	c.JSON(err.HTTPStatus, response)
 }
@@ -422,8 +553,14 @@ func (s *APIServer) handleCreateAgent(c *gin.Context) {
 		switch err {
 		case agent.ErrInvalidPrice:
 			respondError(c, apierrors.NewValidationError("Price must be between $0.001 and $100 per call"))
+		case agent.ErrInvalidPricingModel:
+			respondError(c, apierrors.NewValidationError("Pricing model must be per_call, per_token or hybrid"))
+		case agent.ErrInvalidTokenPrice:
+			respondError(c, apierrors.NewValidationError("Token prices must be between $0 and $10 per 1K tokens, with at least one non-zero"))
 		default:
-			if err.Error() != "" && err.Error()[:len("invalid agent configuration")] == "invalid agent configuration" {
+			if errors.Is(err, agent.ErrInvalidAgentLimits) {
+				respondError(c, apierrors.NewValidationError(err.Error()))
+			} else if err.Error() != "" && err.Error()[:len("invalid agent configuration")] == "invalid agent configuration" {
 				respondError(c, apierrors.NewValidationError(err.Error()))
 			} else {
 				respondError(c, apierrors.ErrInternalServerError)
@@ -567,8 +704,16 @@ func (s *APIServer) handleUpdateAgent(c *gin.Context) {
 			})
 		case agent.ErrInvalidPrice:
 			respondError(c, apierrors.NewValidationError("Price must be between $0.001 and $100 per call"))
+		case agent.ErrInvalidPricingModel:
+			respondError(c, apierrors.NewValidationError("Pricing model must be per_call, per_token or hybrid"))
+		case agent.ErrInvalidTokenPrice:
+			respondError(c, apierrors.NewValidationError("Token prices must be between $0 and $10 per 1K tokens, with at least one non-zero"))
 		default:
-			respondError(c, apierrors.ErrInternalServerError)
+			if errors.Is(err, agent.ErrInvalidAgentLimits) {
+				respondError(c, apierrors.NewValidationError(err.Error()))
+			} else {
+				respondError(c, apierrors.ErrInternalServerError)
+			}
 		}
 		return
 	}
@@ -725,6 +870,64 @@ func (s *APIServer) handleListAgentVersions(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 
+// handleGetAgentAnalytics handles getting call and limit rejection statistics for an agent
+// GET /api/v1/agents/:id/analytics?days=7
+func (s *APIServer) handleGetAgentAnalytics(c *gin.Context) {
+	if s.agentService == nil {
+		respondError(c, apierrors.NewInvalidRequestError("Agent service not available"))
+		return
+	}
+
+	// Get user ID from context
+	userIDStr := middleware.GetUserIDFromContext(c)
+	if userIDStr == "" {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	// Parse agent ID
+	agentIDStr := c.Param("id")
+	agentID, err := uuid.Parse(agentIDStr)
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid agent ID"))
+		return
+	}
+
+	days := agent.DefaultAnalyticsDays
+	if daysStr := c.Query("days"); daysStr != "" {
+		days, err = strconv.Atoi(daysStr)
+		if err != nil || days < 1 || days > agent.MaxAnalyticsDays {
+			respondError(c, apierrors.NewValidationError(fmt.Sprintf("days must be between 1 and %d", agent.MaxAnalyticsDays)))
+			return
+		}
+	}
+
+	resp, err := s.agentService.GetAnalytics(c.Request.Context(), agentID, userID, days)
+	if err != nil {
+		switch err {
+		case agent.ErrAgentNotFound:
+			respondError(c, apierrors.ErrAgentNotFoundError)
+		case agent.ErrAgentNotOwned:
+			respondError(c, &apierrors.APIError{
+				Code:       apierrors.ErrAgentNotOwned,
+				Message:    "You do not own this agent",
+				HTTPStatus: http.StatusForbidden,
+			})
+		default:
+			respondError(c, apierrors.ErrInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
 // handleGetAgentVersion handles getting a specific version of an agent
 func (s *APIServer) handleGetAgentVersion(c *gin.Context) {
 	if s.agentService == nil {
@@ -787,10 +990,6 @@ func (s *APIServer) handleGetAgentVersion(c *gin.Context) {
 	c.JSON(http.StatusOK, resp)
 }
 func (s *APIServer) handleUploadKnowledge(c *gin.Context) { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleSearchAgents(c *gin.Context)    { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleGetPublicAgent(c *gin.Context)  { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleGetCategories(c *gin.Context)   { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleGetFeatured(c *gin.Context)     { c.JSON(501, gin.H{"error": "not implemented"}) }
 func (s *APIServer) handleGetDeveloper(c *gin.Context)    { c.JSON(501, gin.H{"error": "not implemented"}) }
 
 // handleListAPIKeys handles listing all API keys for a developer
@@ -843,9 +1042,18 @@ func (s *APIServer) handleCreateAPIKey(c *gin.Context) {
 	// Create API key
 	resp, err := s.apiKeyService.Create(c.Request.Context(), userID, &req)
 	if err != nil {
-		switch err {
-		case apikey.ErrMaxKeysReached:
+		switch {
+		case errors.Is(err, apikey.ErrMaxKeysReached):
 			respondError(c, apierrors.NewInvalidRequestError("Maximum number of API keys reached (10)"))
+		case errors.Is(err, apikey.ErrInvalidPermission):
+			respondError(c, apierrors.NewValidationError(err.Error()))
+		case errors.Is(err, apikey.ErrInvalidAgentScope):
+			respondError(c, apierrors.NewValidationError("allowed_agent_ids contains an unknown agent"))
+		case errors.Is(err, apikey.ErrInvalidCIDR), errors.Is(err, apikey.ErrInvalidOrigin),
+			errors.Is(err, apikey.ErrInvalidKeyLimits), errors.Is(err, apikey.ErrInvalidCapture):
+			respondError(c, apierrors.NewValidationError(err.Error()))
+		case errors.Is(err, apikey.ErrInvalidExpiry):
+			respondError(c, apierrors.NewValidationError("expires_at must be in the future"))
 		default:
 			respondError(c, apierrors.ErrInternalServerError)
 		}
@@ -855,6 +1063,69 @@ func (s *APIServer) handleCreateAPIKey(c *gin.Context) {
 	c.JSON(http.StatusCreated, resp)
 }
 
+// handleUpdateAPIKey handles updating an API key's name, permissions and agent allowlist
+func (s *APIServer) handleUpdateAPIKey(c *gin.Context) {
+	// Get user ID from context
+	userIDStr := middleware.GetUserIDFromContext(c)
+	if userIDStr == "" {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	// Parse key ID from URL
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid API key ID"))
+		return
+	}
+
+	// Parse request body
+	var req apikey.UpdateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	// Update API key
+	resp, err := s.apiKeyService.Update(c.Request.Context(), keyID, userID, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, apikey.ErrAPIKeyNotFound):
+			respondError(c, &apierrors.APIError{
+				Code:       apierrors.ErrInvalidRequest,
+				Message:    "API key not found",
+				HTTPStatus: http.StatusNotFound,
+			})
+		case errors.Is(err, apikey.ErrAPIKeyNotOwned):
+			respondError(c, apierrors.ErrForbiddenError)
+		case errors.Is(err, apikey.ErrAPIKeyRevoked):
+			respondError(c, apierrors.NewInvalidRequestError("API key is revoked"))
+		case errors.Is(err, apikey.ErrInvalidPermission):
+			respondError(c, apierrors.NewValidationError(err.Error()))
+		case errors.Is(err, apikey.ErrInvalidAgentScope):
+			respondError(c, apierrors.NewValidationError("allowed_agent_ids contains an unknown agent"))
+		case errors.Is(err, apikey.ErrInvalidCIDR), errors.Is(err, apikey.ErrInvalidOrigin),
+			errors.Is(err, apikey.ErrInvalidKeyLimits), errors.Is(err, apikey.ErrInvalidCapture):
+			respondError(c, apierrors.NewValidationError(err.Error()))
+		case errors.Is(err, apikey.ErrInvalidExpiry):
+			respondError(c, apierrors.NewValidationError("expires_at must be in the future"))
+		case errors.Is(err, apikey.ErrExpiryLocked):
+			respondError(c, apierrors.NewInvalidRequestError("Expiry of a rotated or expired API key cannot be changed"))
+		default:
+			respondError(c, apierrors.ErrInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
 // handleDeleteAPIKey handles revoking/deleting an API key
 func (s *APIServer) handleDeleteAPIKey(c *gin.Context) {
 	// Get user ID from context
@@ -900,7 +1171,210 @@ func (s *APIServer) handleDeleteAPIKey(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
 }
-func (s *APIServer) handleGetUsage(c *gin.Context)        { c.JSON(501, gin.H{"error": "not implemented"}) }
+
+// handleRotateAPIKey handles issuing a replacement API key with a grace period for the old one
+func (s *APIServer) handleRotateAPIKey(c *gin.Context) {
+	// Get user ID from context
+	userIDStr := middleware.GetUserIDFromContext(c)
+	if userIDStr == "" {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	// Parse key ID from URL
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid API key ID"))
+		return
+	}
+
+	// Parse request body
+	var req apikey.RotateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		// An empty body uses the default grace period
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	// Rotate API key
+	resp, err := s.apiKeyService.Rotate(c.Request.Context(), keyID, userID, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, apikey.ErrAPIKeyNotFound):
+			respondError(c, &apierrors.APIError{
+				Code:       apierrors.ErrInvalidRequest,
+				Message:    "API key not found",
+				HTTPStatus: http.StatusNotFound,
+			})
+		case errors.Is(err, apikey.ErrAPIKeyNotOwned):
+			respondError(c, apierrors.ErrForbiddenError)
+		case errors.Is(err, apikey.ErrAPIKeyRevoked):
+			respondError(c, apierrors.NewInvalidRequestError("API key is revoked"))
+		case errors.Is(err, apikey.ErrAPIKeyExpired):
+			respondError(c, apierrors.NewInvalidRequestError("API key has expired"))
+		case errors.Is(err, apikey.ErrAlreadyRotated):
+			respondError(c, apierrors.NewInvalidRequestError("API key has already been rotated"))
+		case errors.Is(err, apikey.ErrMaxKeysReached):
+			respondError(c, apierrors.NewInvalidRequestError("Maximum number of API keys reached (10)"))
+		case errors.Is(err, apikey.ErrInvalidGracePeriod):
+			respondError(c, apierrors.NewValidationError("grace_period_seconds must be between 0 and 604800"))
+		case errors.Is(err, apikey.ErrInvalidExpiry):
+			respondError(c, apierrors.NewValidationError("expires_at must be in the future"))
+		default:
+			respondError(c, apierrors.ErrInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// handleListAPIKeyAuditLogs handles listing the audit trail of an API key
+func (s *APIServer) handleListAPIKeyAuditLogs(c *gin.Context) {
+	// Get user ID from context
+	userIDStr := middleware.GetUserIDFromContext(c)
+	if userIDStr == "" {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	// Parse key ID from URL
+	keyID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid API key ID"))
+		return
+	}
+
+	// Parse pagination parameters
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	resp, err := s.apiKeyService.ListAuditLogs(c.Request.Context(), keyID, userID, page, pageSize)
+	if err != nil {
+		switch {
+		case errors.Is(err, apikey.ErrAPIKeyNotFound):
+			respondError(c, &apierrors.APIError{
+				Code:       apierrors.ErrInvalidRequest,
+				Message:    "API key not found",
+				HTTPStatus: http.StatusNotFound,
+			})
+		case errors.Is(err, apikey.ErrAPIKeyNotOwned):
+			respondError(c, apierrors.ErrForbiddenError)
+		default:
+			respondError(c, apierrors.ErrInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleGetUsage handles the developer's usage report: a time series, totals
+// and an optional breakdown by agent, API key or status
+func (s *APIServer) handleGetUsage(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	q := usage.Query{
+		Granularity: usage.Granularity(c.Query("granularity")),
+		GroupBy:     usage.GroupBy(c.Query("group_by")),
+		Status:      c.Query("status"),
+	}
+	if q.From, err = parseUsageTime(c.Query("from")); err != nil {
+		respondError(c, apierrors.NewValidationError("from must be an RFC 3339 time or YYYY-MM-DD date"))
+		return
+	}
+	if q.To, err = parseUsageTime(c.Query("to")); err != nil {
+		respondError(c, apierrors.NewValidationError("to must be an RFC 3339 time or YYYY-MM-DD date"))
+		return
+	}
+	if v := c.Query("agent_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			respondError(c, apierrors.NewValidationError("Invalid agent ID"))
+			return
+		}
+		q.AgentID = &id
+	}
+	if v := c.Query("api_key_id"); v != "" {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			respondError(c, apierrors.NewValidationError("Invalid API key ID"))
+			return
+		}
+		q.APIKeyID = &id
+	}
+	q.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	q.PageSize, _ = strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	resp, err := s.usageService.Get(c.Request.Context(), userID, &q)
+	if err != nil {
+		switch {
+		case errors.Is(err, usage.ErrInvalidRange), errors.Is(err, usage.ErrRangeTooLarge),
+			errors.Is(err, usage.ErrInvalidGranularity), errors.Is(err, usage.ErrInvalidGroupBy),
+			errors.Is(err, usage.ErrInvalidStatus):
+			respondError(c, apierrors.NewValidationError(err.Error()))
+		default:
+			respondError(c, apierrors.ErrInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleGetCapture returns the captured request and response of one of the
+// developer's calls, by request ID
+func (s *APIServer) handleGetCapture(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+	if s.captureService == nil {
+		respondError(c, apierrors.NewInvalidRequestError("Payload capture not available"))
+		return
+	}
+
+	exchange, err := s.captureService.Get(c.Request.Context(), userID, c.Param("requestId"))
+	if err != nil {
+		if errors.Is(err, capture.ErrCaptureNotFound) {
+			respondError(c, apierrors.NewNotFoundError("Capture"))
+			return
+		}
+		logging.LogError(err, c.GetString("request_id"), "capture", "get")
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, exchange)
+}
+
+// parseUsageTime parses an RFC 3339 time or a YYYY-MM-DD date (UTC); empty is the zero time
+func parseUsageTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
 
 // handleCheckout handles Stripe checkout session creation
 func (s *APIServer) handleCheckout(c *gin.Context) {
@@ -1137,27 +1611,591 @@ func (s *APIServer) handleGetQuota(c *gin.Context) {
 
 	c.JSON(http.StatusOK, resp)
 }
-func (s *APIServer) handleSubmitReview(c *gin.Context)    { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleGetReviews(c *gin.Context)      { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleListWebhooks(c *gin.Context)    { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleCreateWebhook(c *gin.Context)   { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleDeleteWebhook(c *gin.Context)   { c.JSON(501, gin.H{"error": "not implemented"}) }
-
-// Admin handlers (placeholders)
-func (s *APIServer) handleAdminStats(c *gin.Context)            { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleAdminListUsers(c *gin.Context)        { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleAdminGetUser(c *gin.Context)          { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleAdminUpdateUserStatus(c *gin.Context) { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleAdminModerationQueue(c *gin.Context)  { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleAdminApproveReview(c *gin.Context)    { c.JSON(501, gin.H{"error": "not implemented"}) }
-func (s *APIServer) handleAdminRejectReview(c *gin.Context)     { c.JSON(501, gin.H{"error": "not implemented"}) }
 
+// handleGetQuotaLedger handles paging through the user's quota ledger, newest first
+func (s *APIServer) handleGetQuotaLedger(c *gin.Context) {
+	// Get user ID from context
+	userIDStr := middleware.GetUserIDFromContext(c)
+	if userIDStr == "" {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
 
-// ============================================
-// Trial Handlers
-// ============================================
+	userID, err := uuid.Parse(userIDStr)
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
 
-// SetAgentTrialRequest represents a request to set agent trial status
+	// Parse pagination params
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	resp, err := s.ledgerService.ListEntries(c.Request.Context(), userID, page, pageSize)
+	if err != nil {
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleListQuotaAlerts handles listing the user's recently fired quota alerts
+func (s *APIServer) handleListQuotaAlerts(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	quotaAlerts, err := s.alertService.ListAlerts(c.Request.Context(), userID, limit)
+	if err != nil {
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"alerts": quotaAlerts})
+}
+
+// handleListQuotaAlertThresholds handles listing the user's quota alert thresholds
+func (s *APIServer) handleListQuotaAlertThresholds(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	thresholds, err := s.alertService.ListThresholds(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"thresholds": thresholds})
+}
+
+// handleCreateQuotaAlertThreshold handles adding a quota alert threshold
+func (s *APIServer) handleCreateQuotaAlertThreshold(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	var req alerts.CreateThresholdRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	threshold, err := s.alertService.CreateThreshold(c.Request.Context(), userID, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, alerts.ErrInvalidThreshold), errors.Is(err, alerts.ErrInvalidThresholdType):
+			respondError(c, apierrors.NewValidationError(err.Error()))
+		case errors.Is(err, alerts.ErrTooManyThresholds):
+			respondError(c, apierrors.NewInvalidRequestError(
+				fmt.Sprintf("A maximum of %d alert thresholds can be configured", alerts.MaxThresholdsPerUser)))
+		default:
+			respondError(c, apierrors.ErrInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, threshold)
+}
+
+// handleDeleteQuotaAlertThreshold handles removing a quota alert threshold
+func (s *APIServer) handleDeleteQuotaAlertThreshold(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	thresholdID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid threshold ID"))
+		return
+	}
+
+	if err := s.alertService.DeleteThreshold(c.Request.Context(), userID, thresholdID); err != nil {
+		if errors.Is(err, alerts.ErrThresholdNotFound) {
+			respondError(c, apierrors.NewNotFoundError("Alert threshold"))
+			return
+		}
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Alert threshold deleted successfully"})
+}
+
+// handleSubmitReview handles reviewing an agent the user has called
+func (s *APIServer) handleSubmitReview(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	agentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid agent ID"))
+		return
+	}
+
+	var req review.ReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	resp, err := s.reviewService.Submit(c.Request.Context(), userID, agentID, &req)
+	if err != nil {
+		respondReviewError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// handleGetReviews handles listing an agent's approved reviews, sorted by
+// newest, oldest, highest or lowest rating
+func (s *APIServer) handleGetReviews(c *gin.Context) {
+	agentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid agent ID"))
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	resp, err := s.reviewService.List(c.Request.Context(), agentID, c.Query("sort"), page, pageSize)
+	if err != nil {
+		respondReviewError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleUpdateReview handles editing the user's own review, which returns it
+// to moderation
+func (s *APIServer) handleUpdateReview(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	reviewID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid review ID"))
+		return
+	}
+
+	var req review.ReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	resp, err := s.reviewService.Update(c.Request.Context(), userID, reviewID, &req)
+	if err != nil {
+		respondReviewError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleDeleteReview handles deleting the user's own review
+func (s *APIServer) handleDeleteReview(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	reviewID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid review ID"))
+		return
+	}
+
+	if err := s.reviewService.Delete(c.Request.Context(), userID, reviewID); err != nil {
+		respondReviewError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Review deleted successfully"})
+}
+
+// ReplyReviewRequest represents a creator's reply to a review
+type ReplyReviewRequest struct {
+	Reply string `json:"reply"` // Empty removes the reply
+}
+
+// handleReplyReview handles the creator's public reply to a review of one of
+// their agents
+func (s *APIServer) handleReplyReview(c *gin.Context) {
+	creatorID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	reviewID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid review ID"))
+		return
+	}
+
+	var req ReplyReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	resp, err := s.reviewService.Reply(c.Request.Context(), creatorID, reviewID, req.Reply)
+	if err != nil {
+		respondReviewError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// respondReviewError maps review service errors to API errors
+func respondReviewError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, review.ErrAgentNotFound):
+		respondError(c, apierrors.ErrAgentNotFoundError)
+	case errors.Is(err, review.ErrReviewNotFound):
+		respondError(c, apierrors.ErrReviewNotFoundError)
+	case errors.Is(err, review.ErrNotVerified):
+		respondError(c, apierrors.ErrReviewNotAllowedError)
+	case errors.Is(err, review.ErrOwnAgent):
+		respondError(c, apierrors.NewInvalidRequestError("Creators cannot review their own agents"))
+	case errors.Is(err, review.ErrAlreadyReviewed):
+		respondError(c, apierrors.ErrReviewExistsError)
+	case errors.Is(err, review.ErrAlreadyModerated):
+		respondError(c, apierrors.NewInvalidRequestError("Review already has this status"))
+	case errors.Is(err, review.ErrInvalidRating), errors.Is(err, review.ErrTextTooLong),
+		errors.Is(err, review.ErrInvalidSort), errors.Is(err, review.ErrReasonRequired):
+		respondError(c, apierrors.NewValidationError(err.Error()))
+	default:
+		respondError(c, apierrors.ErrInternalServerError)
+	}
+}
+
+// handleAdminStats handles platform statistics over a named range (24h, 7d,
+// 30d or 90d) or a from/to period (admin)
+func (s *APIServer) handleAdminStats(c *gin.Context) {
+	q := admin.StatsQuery{Range: c.Query("range")}
+	var err error
+	if q.From, err = parseUsageTime(c.Query("from")); err != nil {
+		respondError(c, apierrors.NewValidationError("from must be an RFC 3339 time or YYYY-MM-DD date"))
+		return
+	}
+	if q.To, err = parseUsageTime(c.Query("to")); err != nil {
+		respondError(c, apierrors.NewValidationError("to must be an RFC 3339 time or YYYY-MM-DD date"))
+		return
+	}
+
+	stats, err := s.adminService.Stats(c.Request.Context(), &q)
+	if err != nil {
+		switch {
+		case errors.Is(err, admin.ErrInvalidPreset), errors.Is(err, admin.ErrInvalidRange),
+			errors.Is(err, admin.ErrRangeTooLarge):
+			respondError(c, apierrors.NewValidationError(err.Error()))
+		default:
+			logging.LogError(err, c.GetString("request_id"), "admin", "stats")
+			respondError(c, apierrors.ErrInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// handleAdminListUsers handles searching and filtering users (admin)
+func (s *APIServer) handleAdminListUsers(c *gin.Context) {
+	f := admin.UserFilter{
+		Query:    c.Query("q"),
+		UserType: models.UserType(c.Query("user_type")),
+		Status:   models.AccountStatus(c.Query("status")),
+	}
+	f.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	f.PageSize, _ = strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	resp, err := s.adminService.ListUsers(c.Request.Context(), &f)
+	if err != nil {
+		switch {
+		case errors.Is(err, admin.ErrInvalidUserType), errors.Is(err, admin.ErrInvalidStatus):
+			respondError(c, apierrors.NewValidationError(err.Error()))
+		default:
+			respondError(c, apierrors.ErrInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleAdminGetUser handles a user's detail view with their quota, API keys,
+// recent payments, agents and status history (admin)
+func (s *APIServer) handleAdminGetUser(c *gin.Context) {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid user ID"))
+		return
+	}
+
+	user, err := s.adminService.GetUser(c.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, admin.ErrUserNotFound) {
+			respondError(c, apierrors.ErrUserNotFoundError)
+			return
+		}
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// AdminUpdateUserStatusRequest represents a request to change a user's account status
+type AdminUpdateUserStatusRequest struct {
+	Status models.AccountStatus `json:"status" binding:"required"`
+	Reason string               `json:"reason" binding:"max=500"`
+}
+
+// handleAdminUpdateUserStatus handles suspending, banning or reactivating a
+// user (admin). The change applies to the user's sessions and API keys immediately.
+func (s *APIServer) handleAdminUpdateUserStatus(c *gin.Context) {
+	adminID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid user ID"))
+		return
+	}
+
+	var req AdminUpdateUserStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	user, err := s.adminService.UpdateUserStatus(c.Request.Context(), adminID, userID, req.Status, req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, admin.ErrUserNotFound):
+			respondError(c, apierrors.ErrUserNotFoundError)
+		case errors.Is(err, admin.ErrInvalidStatus), errors.Is(err, admin.ErrReasonRequired):
+			respondError(c, apierrors.NewValidationError(err.Error()))
+		case errors.Is(err, admin.ErrCannotChangeAdmin):
+			respondError(c, apierrors.NewInvalidRequestError("Admin accounts cannot be suspended or banned"))
+		default:
+			respondError(c, apierrors.ErrInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// handleAdminModerationQueue handles listing reviews awaiting moderation (admin)
+func (s *APIServer) handleAdminModerationQueue(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	resp, err := s.reviewService.Queue(c.Request.Context(), page, pageSize)
+	if err != nil {
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleAdminApproveReview handles publishing a review (admin)
+func (s *APIServer) handleAdminApproveReview(c *gin.Context) {
+	adminID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	reviewID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid review ID"))
+		return
+	}
+
+	resp, err := s.reviewService.Approve(c.Request.Context(), adminID, reviewID)
+	if err != nil {
+		respondReviewError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// AdminRejectReviewRequest represents a request to reject a review
+type AdminRejectReviewRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// handleAdminRejectReview handles rejecting a review (admin)
+func (s *APIServer) handleAdminRejectReview(c *gin.Context) {
+	adminID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	reviewID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid review ID"))
+		return
+	}
+
+	var req AdminRejectReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	resp, err := s.reviewService.Reject(c.Request.Context(), adminID, reviewID, req.Reason)
+	if err != nil {
+		respondReviewError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+
+// ============================================
+// Marketplace Handlers
+// ============================================
+
+// handleSearchAgents handles searching active agents with full-text search,
+// filters, sorting and cursor pagination
+func (s *APIServer) handleSearchAgents(c *gin.Context) {
+	q := marketplace.SearchQuery{
+		Query:       c.Query("q"),
+		Category:    c.Query("category"),
+		Provider:    c.Query("provider"),
+		ModelFamily: c.Query("model_family"),
+		Sort:        c.Query("sort"),
+		Cursor:      c.Query("cursor"),
+	}
+	q.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	for param, dest := range map[string]**decimal.Decimal{
+		"min_price":  &q.MinPrice,
+		"max_price":  &q.MaxPrice,
+		"min_rating": &q.MinRating,
+	} {
+		if v := c.Query(param); v != "" {
+			d, err := decimal.NewFromString(v)
+			if err != nil {
+				respondError(c, apierrors.NewValidationError("Invalid "+param))
+				return
+			}
+			*dest = &d
+		}
+	}
+	if v := c.Query("trial"); v != "" {
+		trial, err := strconv.ParseBool(v)
+		if err != nil {
+			respondError(c, apierrors.NewValidationError("Invalid trial"))
+			return
+		}
+		q.Trial = &trial
+	}
+
+	resp, err := s.marketplaceService.Search(c.Request.Context(), &q)
+	if err != nil {
+		switch {
+		case errors.Is(err, marketplace.ErrInvalidSort), errors.Is(err, marketplace.ErrInvalidCursor),
+			errors.Is(err, marketplace.ErrInvalidPriceRange), errors.Is(err, marketplace.ErrInvalidRating),
+			errors.Is(err, marketplace.ErrRelevanceNeedsText):
+			respondError(c, apierrors.NewValidationError(err.Error()))
+		default:
+			respondError(c, apierrors.ErrInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleGetPublicAgent handles an active agent's public profile with its
+// creator, pricing, rating distribution, call statistics and version history
+func (s *APIServer) handleGetPublicAgent(c *gin.Context) {
+	agentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid agent ID"))
+		return
+	}
+
+	resp, err := s.marketplaceService.GetAgent(c.Request.Context(), agentID)
+	if err != nil {
+		if errors.Is(err, marketplace.ErrAgentNotFound) {
+			respondError(c, apierrors.ErrAgentNotFoundError)
+			return
+		}
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+	if resp.TrialEnabled {
+		resp.TrialCalls = s.trialService.GetTrialCallsPerAgent()
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleGetCategories handles listing the categories of active agents
+func (s *APIServer) handleGetCategories(c *gin.Context) {
+	categories, err := s.marketplaceService.Categories(c.Request.Context())
+	if err != nil {
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": categories})
+}
+
+// handleGetFeatured handles listing trending agents, ranked by recent call
+// volume and rating
+func (s *APIServer) handleGetFeatured(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	resp, err := s.marketplaceService.Featured(c.Request.Context(), limit)
+	if err != nil {
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// ============================================
+// Trial Handlers
+// ============================================
+
+// SetAgentTrialRequest represents a request to set agent trial status
 type SetAgentTrialRequest struct {
 	TrialEnabled bool `json:"trial_enabled"`
 }
@@ -1644,3 +2682,428 @@ func (s *APIServer) handleAdminFailWithdrawal(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "Withdrawal failed and funds restored"})
 }
+
+// AdminAdjustQuotaRequest represents a request to credit or debit a user's quota
+type AdminAdjustQuotaRequest struct {
+	Amount int64  `json:"amount" binding:"required"` // Positive credits, negative debits
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// handleAdminAdjustQuota handles crediting or debiting a user's quota (admin)
+func (s *APIServer) handleAdminAdjustQuota(c *gin.Context) {
+	adminID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	// Parse user ID
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid user ID"))
+		return
+	}
+
+	// Parse request body
+	var req AdminAdjustQuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	entry, err := s.ledgerService.Adjust(c.Request.Context(), userID, adminID, req.Amount, req.Reason)
+	if err != nil {
+		switch {
+		case errors.Is(err, ledger.ErrQuotaNotFound):
+			respondError(c, apierrors.ErrUserNotFoundError)
+		case errors.Is(err, ledger.ErrInvalidAdjustment), errors.Is(err, ledger.ErrReasonRequired):
+			respondError(c, apierrors.NewValidationError(err.Error()))
+		case errors.Is(err, ledger.ErrInsufficientBalance):
+			respondError(c, apierrors.NewInvalidRequestError("Adjustment would make the user's balance negative"))
+		default:
+			respondError(c, apierrors.ErrInternalServerError)
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, entry)
+}
+
+// handleListWebhooks handles listing the user's webhook endpoints
+func (s *APIServer) handleListWebhooks(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	webhooks, err := s.webhookService.List(c.Request.Context(), userID)
+	if err != nil {
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhooks": webhooks})
+}
+
+// handleCreateWebhook handles registering a webhook endpoint
+func (s *APIServer) handleCreateWebhook(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	var req webhook.CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	resp, err := s.webhookService.Create(c.Request.Context(), userID, &req)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, resp)
+}
+
+// handleGetWebhook handles getting a webhook endpoint
+func (s *APIServer) handleGetWebhook(c *gin.Context) {
+	userID, webhookID, ok := parseWebhookParams(c)
+	if !ok {
+		return
+	}
+
+	wh, err := s.webhookService.Get(c.Request.Context(), userID, webhookID)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, wh)
+}
+
+// handleUpdateWebhook handles changing a webhook endpoint
+func (s *APIServer) handleUpdateWebhook(c *gin.Context) {
+	userID, webhookID, ok := parseWebhookParams(c)
+	if !ok {
+		return
+	}
+
+	var req webhook.UpdateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	wh, err := s.webhookService.Update(c.Request.Context(), userID, webhookID, &req)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, wh)
+}
+
+// handleDeleteWebhook handles deleting a webhook endpoint and its delivery log
+func (s *APIServer) handleDeleteWebhook(c *gin.Context) {
+	userID, webhookID, ok := parseWebhookParams(c)
+	if !ok {
+		return
+	}
+
+	if err := s.webhookService.Delete(c.Request.Context(), userID, webhookID); err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// handleTestWebhook handles sending a test event to a webhook endpoint
+func (s *APIServer) handleTestWebhook(c *gin.Context) {
+	userID, webhookID, ok := parseWebhookParams(c)
+	if !ok {
+		return
+	}
+
+	delivery, err := s.webhookService.SendTestEvent(c.Request.Context(), userID, webhookID)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
+
+// handleListWebhookDeliveries handles paging through a webhook endpoint's delivery log
+func (s *APIServer) handleListWebhookDeliveries(c *gin.Context) {
+	userID, webhookID, ok := parseWebhookParams(c)
+	if !ok {
+		return
+	}
+
+	// Parse pagination params
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+
+	resp, err := s.webhookService.ListDeliveries(c.Request.Context(), userID, webhookID, page, pageSize)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// handleRedeliverWebhook handles queueing a past delivery to be sent again
+func (s *APIServer) handleRedeliverWebhook(c *gin.Context) {
+	userID, webhookID, ok := parseWebhookParams(c)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := uuid.Parse(c.Param("deliveryId"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid delivery ID"))
+		return
+	}
+
+	delivery, err := s.webhookService.Redeliver(c.Request.Context(), userID, webhookID, deliveryID)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, delivery)
+}
+
+// parseWebhookParams parses the caller and webhook ID, responding with an error if either is invalid
+func parseWebhookParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	webhookID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid webhook ID"))
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, webhookID, true
+}
+
+// respondWebhookError maps webhook service errors to API errors
+func respondWebhookError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, webhook.ErrWebhookNotFound):
+		respondError(c, apierrors.NewNotFoundError("Webhook"))
+	case errors.Is(err, webhook.ErrDeliveryNotFound):
+		respondError(c, apierrors.NewNotFoundError("Webhook delivery"))
+	case errors.Is(err, webhook.ErrInvalidURL), errors.Is(err, webhook.ErrInsecureURL),
+		errors.Is(err, webhook.ErrInvalidEvent):
+		respondError(c, apierrors.NewValidationError(err.Error()))
+	case errors.Is(err, webhook.ErrTooManyWebhooks):
+		respondError(c, apierrors.NewInvalidRequestError(
+			fmt.Sprintf("A maximum of %d webhooks can be registered", webhook.MaxWebhooksPerUser)))
+	default:
+		respondError(c, apierrors.ErrInternalServerError)
+	}
+}
+
+// handleListExportDatasets handles listing the datasets the user can export
+func (s *APIServer) handleListExportDatasets(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"datasets":        export.Datasets(middleware.GetUserTypeFromContext(c)),
+		"formats":         []export.Format{export.FormatCSV, export.FormatJSONL},
+		"max_stream_rows": s.config.Export.MaxStreamRows,
+	})
+}
+
+// handleStreamExport handles downloading an export directly. Exports with
+// more rows than the configured limit must be requested as jobs instead.
+func (s *APIServer) handleStreamExport(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	spec := export.Spec{
+		Dataset: c.Param("dataset"),
+		Format:  export.Format(c.Query("format")),
+	}
+	if v := c.Query("columns"); v != "" {
+		spec.Columns = strings.Split(v, ",")
+	}
+	if spec.From, err = parseUsageTime(c.Query("from")); err != nil {
+		respondError(c, apierrors.NewValidationError("from must be an RFC 3339 time or YYYY-MM-DD date"))
+		return
+	}
+	if spec.To, err = parseUsageTime(c.Query("to")); err != nil {
+		respondError(c, apierrors.NewValidationError("to must be an RFC 3339 time or YYYY-MM-DD date"))
+		return
+	}
+
+	started := false
+	err = s.exportService.Stream(c.Request.Context(), userID, middleware.GetUserTypeFromContext(c), &spec, c.Writer,
+		func(format export.Format) {
+			started = true
+			setExportHeaders(c, spec.Dataset, format)
+			c.Status(http.StatusOK)
+		})
+	if err != nil {
+		if started {
+			// Too late to report an error; the client sees a truncated file
+			logging.LogError(err, c.GetString("request_id"), "export", "stream")
+			c.Abort()
+			return
+		}
+		respondExportError(c, err)
+	}
+}
+
+// handleCreateExportJob handles queueing an export to run in the background
+func (s *APIServer) handleCreateExportJob(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	var spec export.Spec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		respondError(c, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	job, err := s.exportService.CreateJob(c.Request.Context(), userID, middleware.GetUserTypeFromContext(c), &spec)
+	if err != nil {
+		respondExportError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// handleListExportJobs handles listing the user's recent export jobs
+func (s *APIServer) handleListExportJobs(c *gin.Context) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	jobs, err := s.exportService.ListJobs(c.Request.Context(), userID, limit)
+	if err != nil {
+		respondError(c, apierrors.ErrInternalServerError)
+		return
+	}
+	for _, job := range jobs {
+		setExportDownloadURL(job)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// handleGetExportJob handles getting an export job's status, with a
+// download link once it has completed
+func (s *APIServer) handleGetExportJob(c *gin.Context) {
+	userID, jobID, ok := parseExportJobParams(c)
+	if !ok {
+		return
+	}
+
+	job, err := s.exportService.GetJob(c.Request.Context(), userID, jobID)
+	if err != nil {
+		respondExportError(c, err)
+		return
+	}
+	setExportDownloadURL(job)
+
+	c.JSON(http.StatusOK, job)
+}
+
+// handleDownloadExportJob handles downloading a completed export job's file
+func (s *APIServer) handleDownloadExportJob(c *gin.Context) {
+	userID, jobID, ok := parseExportJobParams(c)
+	if !ok {
+		return
+	}
+
+	started := false
+	err := s.exportService.Download(c.Request.Context(), userID, jobID, c.Writer, func(job *export.Job) {
+		started = true
+		setExportHeaders(c, job.Dataset, job.Format)
+		if job.SizeBytes != nil {
+			c.Header("Content-Length", strconv.FormatInt(*job.SizeBytes, 10))
+		}
+		c.Status(http.StatusOK)
+	})
+	if err != nil {
+		if started {
+			logging.LogError(err, c.GetString("request_id"), "export", "download")
+			c.Abort()
+			return
+		}
+		respondExportError(c, err)
+	}
+}
+
+// setExportHeaders sets the headers of an export file download
+func setExportHeaders(c *gin.Context, dataset string, format export.Format) {
+	c.Header("Content-Type", format.ContentType())
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="agentlink-%s-%s.%s"`,
+		dataset, time.Now().UTC().Format("20060102"), format))
+	c.Header("Cache-Control", "no-store")
+}
+
+// setExportDownloadURL links a completed export job to its file
+func setExportDownloadURL(job *export.Job) {
+	if job.Status == export.JobCompleted {
+		job.DownloadURL = fmt.Sprintf("/api/v1/exports/jobs/%s/download", job.ID)
+	}
+}
+
+// parseExportJobParams extracts the user ID and export job ID from the request
+func parseExportJobParams(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := uuid.Parse(middleware.GetUserIDFromContext(c))
+	if err != nil {
+		respondError(c, apierrors.ErrInvalidCredentialsError)
+		return uuid.Nil, uuid.Nil, false
+	}
+	jobID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		respondError(c, apierrors.NewValidationError("Invalid export job ID"))
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, jobID, true
+}
+
+// respondExportError maps export service errors to API errors
+func respondExportError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, export.ErrJobNotFound):
+		respondError(c, apierrors.NewNotFoundError("Export job"))
+	case errors.Is(err, export.ErrUnknownDataset), errors.Is(err, export.ErrInvalidFormat),
+		errors.Is(err, export.ErrUnknownColumn), errors.Is(err, export.ErrInvalidRange),
+		errors.Is(err, export.ErrRangeTooLarge):
+		respondError(c, apierrors.NewValidationError(err.Error()))
+	case errors.Is(err, export.ErrTooManyRows):
+		respondError(c, apierrors.NewInvalidRequestError(
+			"Export is too large to stream; create an export job with POST /api/v1/exports/jobs instead"))
+	case errors.Is(err, export.ErrTooManyJobs):
+		respondError(c, apierrors.NewInvalidRequestError(
+			fmt.Sprintf("A maximum of %d export jobs can be in progress", export.MaxActiveJobs)))
+	case errors.Is(err, export.ErrJobNotReady):
+		respondError(c, apierrors.NewInvalidRequestError("Export job has not completed"))
+	case errors.Is(err, export.ErrJobExpired):
+		respondError(c, apierrors.NewInvalidRequestError("Export job has expired"))
+	default:
+		respondError(c, apierrors.ErrInternalServerError)
+	}
+}
@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -10,8 +11,10 @@ import (
 
 	"github.com/aimerfeng/AgentLink/internal/config"
 	"github.com/aimerfeng/AgentLink/internal/middleware"
+	"github.com/aimerfeng/AgentLink/internal/models"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 func init() {
@@ -429,3 +432,49 @@ func TestTokenValidation_Checkpoint(t *testing.T) {
 		}
 	})
 }
+
+// stubAccounts reports the same account status for every user
+type stubAccounts struct {
+	status models.AccountStatus
+}
+
+func (s stubAccounts) AccountStatus(ctx context.Context, userID uuid.UUID) (models.AccountStatus, error) {
+	return s.status, nil
+}
+
+// TestProxyAdmin_AccountStatus tests that the proxy's admin routes reject
+// tokens of suspended and banned admins, like the API server
+func TestProxyAdmin_AccountStatus(t *testing.T) {
+	secret := "test-secret-key-for-jwt-testing-32chars"
+	cfg := &config.Config{JWT: config.JWTConfig{
+		Secret:             secret,
+		AccessTokenExpiry:  15 * time.Minute,
+		RefreshTokenExpiry: 7 * 24 * time.Hour,
+		Issuer:             "agentlink",
+	}}
+	token := createTestJWTToken(secret, uuid.New().String(), "admin", "admin@example.com", "access", 15*time.Minute)
+
+	tests := []struct {
+		status models.AccountStatus
+		want   int
+	}{
+		// Without a proxy service, an admin who gets through sees a 500
+		{models.AccountStatusActive, http.StatusInternalServerError},
+		{models.AccountStatusSuspended, http.StatusForbidden},
+		{models.AccountStatusBanned, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		srv := &ProxyServer{config: cfg, router: gin.New(), accounts: stubAccounts{tt.status}}
+		srv.router.Use(middleware.RequestID())
+		srv.setupRoutes()
+
+		req := httptest.NewRequest(http.MethodGet, "/proxy/v1/admin/circuit-breakers", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		srv.router.ServeHTTP(w, req)
+
+		if w.Code != tt.want {
+			t.Errorf("Status %q: expected %d, got %d", tt.status, tt.want, w.Code)
+		}
+	}
+}
@@ -1,21 +1,28 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"sort"
 	"time"
 
 	"github.com/aimerfeng/AgentLink/internal/agent"
 	"github.com/aimerfeng/AgentLink/internal/apikey"
+	"github.com/aimerfeng/AgentLink/internal/auth"
 	"github.com/aimerfeng/AgentLink/internal/cache"
+	"github.com/aimerfeng/AgentLink/internal/capture"
 	"github.com/aimerfeng/AgentLink/internal/config"
 	apierrors "github.com/aimerfeng/AgentLink/internal/errors"
 	"github.com/aimerfeng/AgentLink/internal/logging"
 	"github.com/aimerfeng/AgentLink/internal/middleware"
+	"github.com/aimerfeng/AgentLink/internal/models"
 	"github.com/aimerfeng/AgentLink/internal/monitoring"
 	"github.com/aimerfeng/AgentLink/internal/proxy"
+	"github.com/aimerfeng/AgentLink/internal/tracing"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -29,6 +36,10 @@ type ProxyServer struct {
 	db           *pgxpool.Pool
 	redis        *cache.Redis
 	proxyService *proxy.Service
+	// captureService stores payloads of keys capturing them; nil without an agent service
+	captureService *capture.Service
+	// accounts rejects admin tokens of suspended, banned or deleted accounts; nil without a database
+	accounts middleware.AccountChecker
 }
 
 // NewProxyServer creates a new proxy server instance
@@ -39,10 +50,16 @@ func NewProxyServer(cfg *config.Config) *ProxyServer {
 
 	router := gin.New()
 
+	// Only honor X-Forwarded-For from configured proxies; API key IP allowlists depend on it
+	if err := router.SetTrustedProxies(cfg.Proxy.TrustedProxies); err != nil {
+		log.Fatal().Err(err).Msg("Invalid trusted proxy configuration")
+	}
+
 	// Add middleware in order
 	router.Use(gin.Recovery())
 	router.Use(middleware.RequestID())
 	router.Use(middleware.CorrelationID())
+	router.Use(tracing.Middleware())
 	router.Use(middleware.CORS(cfg.CORS.AllowedOrigins))
 	router.Use(monitoring.MetricsMiddleware())
 	router.Use(logging.RequestLogger())
@@ -64,10 +81,16 @@ func NewProxyServerWithDeps(cfg *config.Config, db *pgxpool.Pool, redis *cache.R
 
 	router := gin.New()
 
+	// Only honor X-Forwarded-For from configured proxies; API key IP allowlists depend on it
+	if err := router.SetTrustedProxies(cfg.Proxy.TrustedProxies); err != nil {
+		log.Fatal().Err(err).Msg("Invalid trusted proxy configuration")
+	}
+
 	// Add middleware in order
 	router.Use(gin.Recovery())
 	router.Use(middleware.RequestID())
 	router.Use(middleware.CorrelationID())
+	router.Use(tracing.Middleware())
 	router.Use(middleware.CORS(cfg.CORS.AllowedOrigins))
 	router.Use(monitoring.MetricsMiddleware())
 	router.Use(logging.RequestLogger())
@@ -78,6 +101,13 @@ func NewProxyServerWithDeps(cfg *config.Config, db *pgxpool.Pool, redis *cache.R
 		db:           db,
 		redis:        redis,
 		proxyService: proxy.NewService(db, redis, agentSvc, apiKeySvc, cfg),
+		accounts:     auth.NewService(db, &cfg.JWT, &cfg.Quota),
+	}
+	if redis != nil && redis.Client != nil {
+		redis.Client.AddHook(tracing.RedisHook{})
+	}
+	if agentSvc != nil {
+		srv.captureService = capture.NewService(db, agentSvc, &cfg.Capture)
 	}
 
 	srv.setupRoutes()
@@ -90,6 +120,15 @@ func (s *ProxyServer) Router() http.Handler {
 	return s.router
 }
 
+// StartBackground starts the proxy's background workers, which stop when the
+// context is cancelled
+func (s *ProxyServer) StartBackground(ctx context.Context) {
+	if s.proxyService == nil {
+		return
+	}
+	s.proxyService.RunBackground(ctx)
+}
+
 // setupRoutes configures proxy routes
 func (s *ProxyServer) setupRoutes() {
 	// Health check
@@ -100,6 +139,22 @@ func (s *ProxyServer) setupRoutes() {
 	{
 		v1.POST("/agents/:agentId/chat", s.handleChat)
 	}
+
+	// Admin routes for this proxy's upstream circuit breakers and quota reconciliation
+	admin := v1.Group("/admin")
+	authenticator := middleware.NewJWTAuthenticator(&s.config.JWT)
+	if s.accounts != nil {
+		authenticator = authenticator.WithAccountChecker(s.accounts)
+	}
+	admin.Use(authenticator.JWTAuth())
+	admin.Use(middleware.RequireAdmin())
+	{
+		admin.GET("/circuit-breakers", s.handleListCircuitBreakers)
+		admin.POST("/circuit-breakers/force", s.handleForceCircuitBreaker)
+		admin.POST("/circuit-breakers/reset", s.handleResetCircuitBreaker)
+		admin.GET("/quota/reconcile", s.handleGetQuotaReconcileReport)
+		admin.POST("/quota/reconcile", s.handleReconcileQuotas)
+	}
 }
 
 // Health check handler
@@ -110,6 +165,116 @@ func (s *ProxyServer) healthCheck(c *gin.Context) {
 	})
 }
 
+// ForceCircuitBreakerRequest represents a request to hold a breaker open or closed
+type ForceCircuitBreakerRequest struct {
+	Name  string `json:"name" binding:"required"`  // Provider, or provider:model
+	State string `json:"state" binding:"required"` // open or closed
+}
+
+// ResetCircuitBreakerRequest represents a request to reset a breaker
+type ResetCircuitBreakerRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+// handleListCircuitBreakers lists provider and model circuit breakers with the
+// latest upstream health probe results (admin)
+func (s *ProxyServer) handleListCircuitBreakers(c *gin.Context) {
+	requestID := c.GetString("request_id")
+	if s.proxyService == nil {
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+		return
+	}
+
+	breakers := s.proxyService.GetCircuitBreakerManager().GetAllStatus()
+	sort.Slice(breakers, func(i, j int) bool { return breakers[i].Name < breakers[j].Name })
+	c.JSON(http.StatusOK, gin.H{
+		"breakers":  breakers,
+		"upstreams": s.proxyService.GetHealthProber().Results(),
+	})
+}
+
+// handleForceCircuitBreaker holds a circuit breaker open or closed until reset (admin)
+func (s *ProxyServer) handleForceCircuitBreaker(c *gin.Context) {
+	requestID := c.GetString("request_id")
+	if s.proxyService == nil {
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+		return
+	}
+
+	var req ForceCircuitBreakerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		s.sendError(c, requestID, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	manager := s.proxyService.GetCircuitBreakerManager()
+	err := manager.ForceState(c.Request.Context(), req.Name, proxy.CircuitBreakerState(req.State))
+	if errors.Is(err, proxy.ErrInvalidBreakerState) {
+		s.sendError(c, requestID, apierrors.NewValidationError("state must be open or closed"))
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("circuit_breaker", req.Name).Msg("Failed to force circuit breaker")
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, manager.GetStatus(req.Name))
+}
+
+// handleResetCircuitBreaker closes a circuit breaker and clears its counts and any override (admin)
+func (s *ProxyServer) handleResetCircuitBreaker(c *gin.Context) {
+	requestID := c.GetString("request_id")
+	if s.proxyService == nil {
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+		return
+	}
+
+	var req ResetCircuitBreakerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		s.sendError(c, requestID, apierrors.NewValidationError(err.Error()))
+		return
+	}
+
+	s.proxyService.GetCircuitBreakerManager().Reset(req.Name)
+	log.Info().Str("circuit_breaker", req.Name).Msg("Circuit breaker reset by admin")
+	c.JSON(http.StatusOK, gin.H{"message": "Circuit breaker reset"})
+}
+
+// handleGetQuotaReconcileReport returns the latest quota reconciliation report (admin)
+func (s *ProxyServer) handleGetQuotaReconcileReport(c *gin.Context) {
+	requestID := c.GetString("request_id")
+	if s.proxyService == nil {
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+		return
+	}
+
+	report := s.proxyService.GetQuotaReconciler().LastReport()
+	if report == nil {
+		s.sendError(c, requestID, apierrors.NewNotFoundError("Quota reconciliation report"))
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// handleReconcileQuotas rebuilds cached quota balances from the ledger now
+// and reports discrepancies (admin)
+func (s *ProxyServer) handleReconcileQuotas(c *gin.Context) {
+	requestID := c.GetString("request_id")
+	if s.proxyService == nil {
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+		return
+	}
+
+	report, err := s.proxyService.GetQuotaReconciler().Reconcile(c.Request.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("Quota reconciliation failed")
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
 // handleChat handles AI chat requests through the proxy
 func (s *ProxyServer) handleChat(c *gin.Context) {
 	requestID := c.GetString("request_id")
@@ -142,19 +307,72 @@ func (s *ProxyServer) handleChat(c *gin.Context) {
 	}
 
 	// Validate API key
-	apiKeyModel, err := s.proxyService.ValidateAPIKey(c.Request.Context(), apiKeyHeader)
+	ctx, span := tracing.Start(c.Request.Context(), "proxy.validate_key")
+	apiKeyModel, err := s.proxyService.ValidateAPIKey(ctx, apiKeyHeader)
+	span.RecordError(err)
+	span.End()
 	if err != nil {
 		if errors.Is(err, proxy.ErrInvalidAPIKey) {
 			s.sendError(c, requestID, apierrors.ErrInvalidAPIKeyError)
 			return
 		}
+		if errors.Is(err, proxy.ErrAPIKeyExpired) {
+			s.sendError(c, requestID, apierrors.ErrAPIKeyExpiredError)
+			return
+		}
+		if errors.Is(err, proxy.ErrAccountSuspended) {
+			s.sendError(c, requestID, apierrors.ErrAccountSuspendedError)
+			return
+		}
+		if errors.Is(err, proxy.ErrAccountBanned) {
+			s.sendError(c, requestID, apierrors.ErrAccountBannedError)
+			return
+		}
 		log.Error().Err(err).Str("correlation_id", correlationID).Msg("Failed to validate API key")
 		s.sendError(c, requestID, apierrors.ErrInternalServerError)
 		return
 	}
 
+	// Enforce IP and origin restrictions before anything else touches the key.
+	// ClientIP only honors X-Forwarded-For from configured trusted proxies.
+	clientIP := c.ClientIP()
+	if err := s.proxyService.CheckKeyNetwork(apiKeyModel, clientIP, c.GetHeader("Origin")); err != nil {
+		s.rejectKeySource(c, requestID, apiKeyModel, clientIP, err)
+		return
+	}
+
+	// Record a new client IP for the key audit trail (async, don't block on this)
+	if !s.proxyService.KeyIPKnown(apiKeyModel, clientIP) {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.proxyService.RecordKeyUse(ctx, apiKeyModel, clientIP); err != nil {
+				log.Warn().Err(err).Str("api_key_id", apiKeyModel.ID.String()).Msg("Failed to record API key use")
+			}
+		}()
+	}
+
+	// Enforce key scopes before touching the agent so out-of-scope keys learn nothing about it
+	if err := s.proxyService.CheckKeyScope(apiKeyModel, agentID, models.PermissionChat); err != nil {
+		s.sendKeyScopeError(c, requestID, err, models.PermissionChat)
+		return
+	}
+
+	// Replay or claim the Idempotency-Key before anything is limited or charged
+	idempotent, handled := s.beginIdempotentRequest(c, requestID, apiKeyModel, agentID)
+	if handled {
+		return
+	}
+	var succeeded bool
+	if idempotent != nil {
+		defer func() { s.finishIdempotentRequest(c, idempotent, succeeded) }()
+	}
+
 	// Get agent and validate it's active
-	agentModel, agentConfig, err := s.proxyService.GetAgent(c.Request.Context(), agentID)
+	ctx, span = tracing.Start(c.Request.Context(), "proxy.agent_lookup", tracing.WithAttributes(tracing.String("agent_id", agentID.String())))
+	agentModel, agentConfig, err := s.proxyService.GetAgent(ctx, agentID)
+	span.RecordError(err)
+	span.End()
 	if err != nil {
 		if errors.Is(err, proxy.ErrAgentNotFound) {
 			s.sendError(c, requestID, apierrors.ErrAgentNotFoundError)
@@ -175,9 +393,16 @@ func (s *ProxyServer) handleChat(c *gin.Context) {
 		log.Warn().Err(err).Str("correlation_id", correlationID).Msg("Failed to check paid status, assuming free user")
 		isPaidUser = false
 	}
+	tier := proxy.TierLabel(isPaidUser)
 
 	// Check rate limit with detailed result
-	rateLimitResult, err := s.proxyService.CheckRateLimitWithResult(c.Request.Context(), apiKeyModel.UserID, isPaidUser)
+	ctx, span = tracing.Start(c.Request.Context(), "proxy.rate_limit")
+	rateLimitResult, err := s.proxyService.CheckRateLimitWithResult(ctx, apiKeyModel.UserID, isPaidUser)
+	span.RecordError(err)
+	if err == nil {
+		span.SetAttributes(tracing.Bool("allowed", rateLimitResult.Allowed))
+	}
+	span.End()
 	if err != nil {
 		log.Error().Err(err).Str("correlation_id", correlationID).Msg("Failed to check rate limit")
 		s.sendError(c, requestID, apierrors.ErrInternalServerError)
@@ -186,22 +411,62 @@ func (s *ProxyServer) handleChat(c *gin.Context) {
 	c.Header("X-RateLimit-Remaining", fmt.Sprintf("%d", rateLimitResult.Remaining))
 	c.Header("X-RateLimit-Limit", fmt.Sprintf("%d", rateLimitResult.Limit))
 	if !rateLimitResult.Allowed {
+		monitoring.RecordRateLimitHit("user", tier)
 		retryAfterSeconds := int64(rateLimitResult.RetryAfter.Seconds())
 		if retryAfterSeconds < 1 {
 			retryAfterSeconds = 1
 		}
-		s.sendRateLimitError(c, requestID, retryAfterSeconds)
+		s.sendRateLimitError(c, requestID, apierrors.NewRateLimitError(retryAfterSeconds), retryAfterSeconds)
+		return
+	}
+
+	// Enforce the key's own limits alongside the user-level ones
+	keyStatus, err := s.proxyService.EnforceKeyLimits(c.Request.Context(), apiKeyModel, requestID)
+	setKeyLimitHeaders(c, keyStatus)
+	if err != nil {
+		if errors.Is(err, proxy.ErrKeyRateLimited) {
+			monitoring.RecordRateLimitHit("key", tier)
+		}
+		s.sendKeyLimitError(c, requestID, err, keyStatus)
+		return
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.proxyService.ReleaseKeySlot(ctx, apiKeyModel, requestID); err != nil {
+			log.Warn().Err(err).Str("api_key_id", apiKeyModel.ID.String()).Msg("Failed to release key concurrency slot")
+		}
+	}()
+
+	// Enforce the creator's limits on the agent
+	agentStatus, err := s.proxyService.EnforceAgentLimits(c.Request.Context(), agentModel, apiKeyModel.UserID, requestID)
+	if err != nil {
+		if errors.Is(err, proxy.ErrAgentRateLimited) {
+			monitoring.RecordRateLimitHit("agent", tier)
+		}
+		s.sendAgentLimitError(c, requestID, agentModel, err, agentStatus)
 		return
 	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.proxyService.ReleaseAgentSlot(ctx, agentModel, requestID); err != nil {
+			log.Warn().Err(err).Str("agent_id", agentModel.ID.String()).Msg("Failed to release agent concurrency slot")
+		}
+	}()
 
 	// Check quota
-	quotaRemaining, err := s.proxyService.CheckQuota(c.Request.Context(), apiKeyModel.UserID)
+	ctx, span = tracing.Start(c.Request.Context(), "proxy.quota")
+	quotaRemaining, err := s.proxyService.CheckQuota(ctx, apiKeyModel.UserID)
+	span.RecordError(err)
+	span.End()
 	if err != nil {
 		log.Error().Err(err).Str("correlation_id", correlationID).Msg("Failed to check quota")
 		s.sendError(c, requestID, apierrors.ErrInternalServerError)
 		return
 	}
 	if quotaRemaining <= 0 {
+		monitoring.RecordQuotaExhausted(tier)
 		s.sendError(c, requestID, apierrors.ErrQuotaExhaustedError)
 		return
 	}
@@ -219,6 +484,44 @@ func (s *ProxyServer) handleChat(c *gin.Context) {
 		return
 	}
 
+	// Streaming requires its own permission
+	if req.Stream {
+		if err := s.proxyService.CheckKeyScope(apiKeyModel, agentID, models.PermissionStream); err != nil {
+			s.sendKeyScopeError(c, requestID, err, models.PermissionStream)
+			return
+		}
+	}
+
+	// Reserve estimated prompt tokens against the tokens-per-minute limits;
+	// the reservation is corrected with actual usage once the call finishes
+	tokenResult, err := s.proxyService.ReserveTokens(c.Request.Context(), apiKeyModel, agentID, isPaidUser,
+		s.proxyService.EstimatePromptTokens(agentConfig, req.Messages))
+	setTokenLimitHeaders(c, tokenResult)
+	if err != nil {
+		if errors.Is(err, proxy.ErrTokenRateLimited) {
+			monitoring.RecordRateLimitHit("tokens", tier)
+			retryAfterSeconds := int64(tokenResult.RetryAfter.Seconds())
+			if retryAfterSeconds < 1 {
+				retryAfterSeconds = 1
+			}
+			s.sendRateLimitError(c, requestID, apierrors.NewTokenRateLimitError(tokenResult.Scope, retryAfterSeconds), retryAfterSeconds)
+			return
+		}
+		log.Error().Err(err).Str("correlation_id", correlationID).Msg("Failed to check token rate limit")
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+		return
+	}
+	var actualTokens int64
+	defer func() {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.proxyService.ReconcileTokens(ctx, tokenResult, actualTokens); err != nil {
+				log.Warn().Err(err).Str("correlation_id", correlationID).Msg("Failed to reconcile token usage")
+			}
+		}()
+	}()
+
 	// Create call context with correlation ID
 	callCtx := &proxy.CallContext{
 		RequestID:     requestID,
@@ -230,17 +533,41 @@ func (s *ProxyServer) handleChat(c *gin.Context) {
 		AgentConfig:   agentConfig,
 		StartTime:     startTime,
 		IsPaidUser:    isPaidUser,
+		TraceID:       c.GetString("trace_id"),
 	}
 
 	// Decrement quota before making the call
-	_, err = s.proxyService.DecrementQuota(c.Request.Context(), apiKeyModel.UserID, 1)
+	ctx, span = tracing.Start(c.Request.Context(), "proxy.quota_decrement")
+	_, err = s.proxyService.DecrementQuotaForRequest(ctx, apiKeyModel.UserID, requestID, 1)
+	if !errors.Is(err, proxy.ErrQuotaExhausted) {
+		span.RecordError(err)
+	}
+	span.End()
+	if errors.Is(err, proxy.ErrQuotaExhausted) {
+		monitoring.RecordQuotaExhausted(tier)
+		s.sendError(c, requestID, apierrors.ErrQuotaExhaustedError)
+		return
+	}
 	if err != nil {
 		log.Error().Err(err).Str("correlation_id", correlationID).Msg("Failed to decrement quota")
 		s.sendError(c, requestID, apierrors.ErrInternalServerError)
 		return
 	}
 
+	// Capture the exchange if the key opted in; the capture sees only what the caller does
+	var writer io.Writer = c.Writer
+	var captured *capture.Buffer
+	if apiKeyModel.Capture.Enabled && s.captureService != nil {
+		captured = capture.NewBuffer(s.config.Capture.MaxBytes)
+		writer = io.MultiWriter(c.Writer, captured)
+	}
+
 	// Process the chat request
+	upstreamCtx, upstreamSpan := tracing.Start(c.Request.Context(), "proxy.upstream", tracing.WithAttributes(
+		tracing.String("ai.provider", agentConfig.Provider),
+		tracing.String("ai.model", agentConfig.Model),
+		tracing.Bool("stream", req.Stream),
+	))
 	var result *proxy.CallResult
 	if req.Stream {
 		// Set up SSE headers
@@ -252,26 +579,33 @@ func (s *ProxyServer) handleChat(c *gin.Context) {
 		// Get the flusher
 		flusher, ok := c.Writer.(http.Flusher)
 		if !ok {
-			s.proxyService.RefundQuota(c.Request.Context(), apiKeyModel.UserID, 1)
+			upstreamSpan.End()
+			s.proxyService.RefundQuotaForRequest(c.Request.Context(), apiKeyModel.UserID, requestID, 1)
 			s.sendError(c, requestID, apierrors.NewInvalidRequestError("streaming not supported"))
 			return
 		}
 
 		// Process streaming chat
-		result, err = s.proxyService.ProcessChat(c.Request.Context(), callCtx, &req, c.Writer, flusher)
+		result, err = s.proxyService.ProcessChat(upstreamCtx, callCtx, &req, writer, flusher)
 	} else {
 		// Set response headers
 		c.Header("Content-Type", "application/json")
 		c.Header("X-Request-ID", requestID)
 
 		// Process non-streaming chat
-		result, err = s.proxyService.ProcessChat(c.Request.Context(), callCtx, &req, c.Writer, nil)
+		result, err = s.proxyService.ProcessChat(upstreamCtx, callCtx, &req, writer, nil)
+	}
+	upstreamSpan.RecordError(err)
+	upstreamSpan.End()
+
+	if result != nil {
+		actualTokens = int64(result.InputTokens + result.OutputTokens)
 	}
 
 	// Handle errors
 	if err != nil {
 		// Refund quota on failure - failed calls don't cost quota (Requirement A6.5)
-		refundErr := s.proxyService.RefundQuota(c.Request.Context(), apiKeyModel.UserID, 1)
+		refundErr := s.proxyService.RefundQuotaForRequest(c.Request.Context(), apiKeyModel.UserID, requestID, 1)
 		if refundErr != nil {
 			log.Error().Err(refundErr).Str("correlation_id", correlationID).Msg("Failed to refund quota")
 		}
@@ -301,28 +635,331 @@ func (s *ProxyServer) handleChat(c *gin.Context) {
 			log.Error().Err(err).Str("correlation_id", correlationID).Msg("Failed to process chat")
 			s.sendError(c, requestID, apierrors.ErrInternalServerError)
 		}
+		monitoring.RecordAPICall(agentID.String(), result.ErrorCode)
 
 		// Log the failed call asynchronously
+		reqCtx := c.Request.Context()
 		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			ctx, cancel := context.WithTimeout(context.WithoutCancel(reqCtx), 5*time.Second)
 			defer cancel()
+			ctx, span := tracing.Start(ctx, "proxy.log_call")
+			defer span.End()
 			if logErr := s.proxyService.LogCall(ctx, callCtx, result); logErr != nil {
+				span.RecordError(logErr)
 				log.Error().Err(logErr).Str("correlation_id", correlationID).Msg("Failed to log failed call")
 			}
+			s.storeCapture(ctx, apiKeyModel, callCtx, &req, result, captured)
 		}()
 		return
 	}
 
-	// Log the call asynchronously
+	succeeded = true
+	monitoring.RecordAPICall(agentID.String(), "success")
+	monitoring.RecordQuotaUsage(tier, agentID.String(), 1)
+
+	// Log the call and count it against the key's spend caps asynchronously,
+	// keeping the request's trace
+	reqCtx := c.Request.Context()
 	go func() {
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(reqCtx), 5*time.Second)
 		defer cancel()
+		ctx, span := tracing.Start(ctx, "proxy.log_call")
+		defer span.End()
 		if err := s.proxyService.LogCall(ctx, callCtx, result); err != nil {
+			span.RecordError(err)
 			log.Error().Err(err).Msg("Failed to log call")
 		}
+		if err := s.proxyService.RecordKeySpend(ctx, apiKeyModel, 1, result.Cost); err != nil {
+			log.Warn().Err(err).Str("api_key_id", apiKeyModel.ID.String()).Msg("Failed to record key spend")
+		}
+		s.storeCapture(ctx, apiKeyModel, callCtx, &req, result, captured)
 	}()
 }
 
+// storeCapture stores a call's exchange if its key captures payloads. The
+// system prompt is redacted from it. Failures are only logged.
+func (s *ProxyServer) storeCapture(ctx context.Context, apiKey *models.APIKey, callCtx *proxy.CallContext, req *proxy.ChatRequest, result *proxy.CallResult, response *capture.Buffer) {
+	if response == nil {
+		return
+	}
+
+	messages := make([]capture.Message, len(req.Messages))
+	for i, m := range req.Messages {
+		messages[i] = capture.Message{Role: m.Role, Content: m.Content}
+	}
+	var systemPrompt string
+	if callCtx.AgentConfig != nil {
+		systemPrompt = callCtx.AgentConfig.SystemPrompt
+	}
+
+	err := s.captureService.Store(ctx, &capture.Record{
+		UserID:       apiKey.UserID,
+		APIKeyID:     apiKey.ID,
+		AgentID:      callCtx.AgentID,
+		RequestID:    callCtx.RequestID,
+		Success:      result.Success,
+		ErrorCode:    result.ErrorCode,
+		TTL:          time.Duration(apiKey.Capture.TTLHours) * time.Hour,
+		SystemPrompt: systemPrompt,
+		Messages:     messages,
+		Stream:       req.Stream,
+		Response:     response,
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("api_key_id", apiKey.ID.String()).Msg("Failed to store payload capture")
+	}
+}
+
+// idempotentRequest is a chat request running under a claimed Idempotency-Key
+type idempotentRequest struct {
+	apiKeyID    uuid.UUID
+	key         string
+	fingerprint string
+	recorder    *responseRecorder
+}
+
+// beginIdempotentRequest handles the Idempotency-Key header. A completed
+// request is replayed and a conflicting one rejected; handled reports that a
+// response was sent. Otherwise the key is claimed and the response recorded.
+// Returns nil without a header, or when Redis cannot be reached.
+func (s *ProxyServer) beginIdempotentRequest(c *gin.Context, requestID string, apiKey *models.APIKey, agentID uuid.UUID) (*idempotentRequest, bool) {
+	key := c.GetHeader("Idempotency-Key")
+	if key == "" {
+		return nil, false
+	}
+	if len(key) > proxy.MaxIdempotencyKeyLength {
+		s.sendError(c, requestID, apierrors.NewInvalidRequestError(
+			fmt.Sprintf("Idempotency-Key must be at most %d characters", proxy.MaxIdempotencyKeyLength)))
+		return nil, true
+	}
+
+	// The body is read here for the fingerprint and restored for binding later
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, proxy.MaxIdempotentRequestSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			s.sendError(c, requestID, &apierrors.APIError{
+				Code:       apierrors.ErrInvalidRequest,
+				Message:    fmt.Sprintf("Request body must be at most %d bytes", proxy.MaxIdempotentRequestSize),
+				HTTPStatus: http.StatusRequestEntityTooLarge,
+			})
+			return nil, true
+		}
+		s.sendError(c, requestID, apierrors.NewInvalidRequestError("failed to read request body"))
+		return nil, true
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	fingerprint := proxy.IdempotencyFingerprint(agentID, body)
+
+	stored, err := s.proxyService.GetIdempotencyStore().Begin(c.Request.Context(), apiKey.ID, key, fingerprint)
+	switch {
+	case errors.Is(err, proxy.ErrIdempotencyKeyMismatch):
+		s.sendError(c, requestID, apierrors.ErrIdempotencyKeyMismatchError)
+		return nil, true
+	case errors.Is(err, proxy.ErrIdempotencyKeyInUse):
+		s.sendError(c, requestID, apierrors.ErrIdempotencyKeyInUseError)
+		return nil, true
+	case err != nil:
+		log.Warn().Err(err).Str("api_key_id", apiKey.ID.String()).Msg("Idempotency store unavailable, processing request without it")
+		return nil, false
+	case stored != nil:
+		if stored.Body == nil {
+			s.sendError(c, requestID, apierrors.ErrIdempotencyReplayTooLargeError)
+			return nil, true
+		}
+		c.Header("Idempotent-Replayed", "true")
+		c.Data(stored.StatusCode, stored.ContentType, stored.Body)
+		return nil, true
+	}
+
+	recorder := &responseRecorder{ResponseWriter: c.Writer, limit: proxy.MaxIdempotentResponseSize}
+	c.Writer = recorder
+	return &idempotentRequest{apiKeyID: apiKey.ID, key: key, fingerprint: fingerprint, recorder: recorder}, false
+}
+
+// finishIdempotentRequest stores a successful response for replay, or frees
+// the key after a failure so the client can retry; failed calls are refunded
+func (s *ProxyServer) finishIdempotentRequest(c *gin.Context, req *idempotentRequest, succeeded bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store := s.proxyService.GetIdempotencyStore()
+	if !succeeded {
+		if err := store.Release(ctx, req.apiKeyID, req.key); err != nil {
+			log.Warn().Err(err).Str("api_key_id", req.apiKeyID.String()).Msg("Failed to release idempotency key")
+		}
+		return
+	}
+
+	resp := &proxy.IdempotentResponse{
+		StatusCode:  req.recorder.Status(),
+		ContentType: req.recorder.Header().Get("Content-Type"),
+	}
+	if !req.recorder.overflow {
+		resp.Body = req.recorder.body.Bytes()
+	}
+	if err := store.Complete(ctx, req.apiKeyID, req.key, req.fingerprint, resp); err != nil {
+		log.Warn().Err(err).Str("api_key_id", req.apiKeyID.String()).Msg("Failed to store idempotent response")
+	}
+}
+
+// responseRecorder copies the response written to the client, up to a limit
+type responseRecorder struct {
+	gin.ResponseWriter
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.record(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.record([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *responseRecorder) record(b []byte) {
+	if w.overflow {
+		return
+	}
+	if w.body.Len()+len(b) > w.limit {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+	w.body.Write(b)
+}
+
+// setTokenLimitHeaders reports the tightest tokens-per-minute limit
+func setTokenLimitHeaders(c *gin.Context, result *proxy.TokenLimitResult) {
+	if result == nil {
+		return
+	}
+	c.Header("X-RateLimit-Tokens-Limit", fmt.Sprintf("%d", result.Limit))
+	c.Header("X-RateLimit-Tokens-Remaining", fmt.Sprintf("%d", result.Remaining))
+	c.Header("X-RateLimit-Tokens-Reset", fmt.Sprintf("%d", result.ResetAt.Unix()))
+}
+
+// setKeyLimitHeaders reports the API key's remaining limits and budget
+func setKeyLimitHeaders(c *gin.Context, status *proxy.KeyLimitStatus) {
+	if status == nil {
+		return
+	}
+	if status.RateLimit != nil {
+		c.Header("X-AgentLink-Key-RateLimit-Limit", fmt.Sprintf("%d", status.RateLimit.Limit))
+		c.Header("X-AgentLink-Key-RateLimit-Remaining", fmt.Sprintf("%d", status.RateLimit.Remaining))
+	}
+	if status.ConcurrencyLimit > 0 {
+		c.Header("X-AgentLink-Key-Concurrency-Limit", fmt.Sprintf("%d", status.ConcurrencyLimit))
+		c.Header("X-AgentLink-Key-Concurrency-InFlight", fmt.Sprintf("%d", status.InFlight))
+	}
+	if budget := status.Budget; budget != nil {
+		c.Header("X-AgentLink-Key-Budget-Unit", string(budget.Unit))
+		if budget.DailyRemaining != nil {
+			c.Header("X-AgentLink-Key-Budget-Daily-Remaining", budget.DailyRemaining.String())
+		}
+		if budget.MonthlyRemaining != nil {
+			c.Header("X-AgentLink-Key-Budget-Monthly-Remaining", budget.MonthlyRemaining.String())
+		}
+	}
+}
+
+// sendKeyLimitError maps an API key limit rejection to a 429 response
+func (s *ProxyServer) sendKeyLimitError(c *gin.Context, requestID string, err error, status *proxy.KeyLimitStatus) {
+	switch {
+	case errors.Is(err, proxy.ErrKeySpendCapExceeded):
+		s.sendError(c, requestID, apierrors.NewKeySpendCapError(status.Budget.ExceededPeriod, string(status.Budget.Unit)))
+	case errors.Is(err, proxy.ErrKeyRateLimited):
+		retryAfterSeconds := int64(status.RateLimit.RetryAfter.Seconds())
+		if retryAfterSeconds < 1 {
+			retryAfterSeconds = 1
+		}
+		s.sendRateLimitError(c, requestID, apierrors.NewKeyRateLimitError(retryAfterSeconds), retryAfterSeconds)
+	case errors.Is(err, proxy.ErrKeyConcurrencyLimit):
+		s.sendError(c, requestID, apierrors.NewKeyConcurrencyLimitError(status.ConcurrencyLimit))
+	case errors.Is(err, proxy.ErrKeyLimitsUnavailable):
+		s.sendError(c, requestID, apierrors.ErrLimitsUnavailableError)
+	default:
+		log.Error().Err(err).Str("request_id", requestID).Msg("Failed to check key limits")
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+	}
+}
+
+// sendAgentLimitError maps an agent limit rejection to a 429 response and
+// counts it for the creator's analytics
+func (s *ProxyServer) sendAgentLimitError(c *gin.Context, requestID string, agentModel *models.Agent, err error, status *proxy.AgentLimitStatus) {
+	if status.RejectionReason != "" {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.proxyService.RecordAgentRejection(ctx, agentModel.ID, status.RejectionReason); err != nil {
+				log.Warn().Err(err).Str("agent_id", agentModel.ID.String()).Msg("Failed to record agent limit rejection")
+			}
+		}()
+	}
+
+	switch {
+	case errors.Is(err, proxy.ErrAgentRateLimited):
+		scope, result := "agent", status.RateLimit
+		if status.RejectionReason == models.AgentRejectionCallerRateLimited {
+			scope, result = "caller", status.CallerRateLimit
+		}
+		retryAfterSeconds := int64(result.RetryAfter.Seconds())
+		if retryAfterSeconds < 1 {
+			retryAfterSeconds = 1
+		}
+		s.sendRateLimitError(c, requestID, apierrors.NewAgentRateLimitError(scope, retryAfterSeconds), retryAfterSeconds)
+	case errors.Is(err, proxy.ErrAgentConcurrencyLimit):
+		s.sendError(c, requestID, apierrors.NewAgentConcurrencyLimitError(status.ConcurrencyLimit))
+	default:
+		log.Error().Err(err).Str("request_id", requestID).Msg("Failed to check agent limits")
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+	}
+}
+
+// sendKeyScopeError maps an API key scope violation to a 403 response
+func (s *ProxyServer) sendKeyScopeError(c *gin.Context, requestID string, err error, permission string) {
+	switch {
+	case errors.Is(err, proxy.ErrKeyAgentNotAllowed):
+		s.sendError(c, requestID, apierrors.NewKeyScopeDeniedError("agent_not_allowed", ""))
+	case errors.Is(err, proxy.ErrKeyPermissionDenied):
+		s.sendError(c, requestID, apierrors.NewKeyScopeDeniedError("missing_permission", permission))
+	default:
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+	}
+}
+
+// rejectKeySource logs and counts a request blocked by the key's network
+// restrictions, then sends a 403 response
+func (s *ProxyServer) rejectKeySource(c *gin.Context, requestID string, apiKey *models.APIKey, clientIP string, err error) {
+	var reason, eventType string
+	switch {
+	case errors.Is(err, proxy.ErrKeyIPNotAllowed):
+		reason, eventType = models.RejectionIPNotAllowed, "api_key_ip_rejected"
+	case errors.Is(err, proxy.ErrKeyOriginNotAllowed):
+		reason, eventType = models.RejectionOriginNotAllowed, "api_key_origin_rejected"
+	default:
+		s.sendError(c, requestID, apierrors.ErrInternalServerError)
+		return
+	}
+
+	logging.LogSecurityEvent(eventType, apiKey.UserID.String(), clientIP,
+		fmt.Sprintf("api_key_id=%s origin=%q", apiKey.ID, c.GetHeader("Origin")))
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.proxyService.RecordKeyRejection(ctx, apiKey, reason); err != nil {
+			log.Warn().Err(err).Str("api_key_id", apiKey.ID.String()).Msg("Failed to record API key rejection")
+		}
+	}()
+
+	s.sendError(c, requestID, apierrors.NewRequestSourceDeniedError(reason))
+}
+
 // sendError sends a standardized error response with correlation ID
 func (s *ProxyServer) sendError(c *gin.Context, requestID string, apiErr *apierrors.APIError) {
 	correlationID := c.GetString("correlation_id")
@@ -337,6 +974,7 @@ func (s *ProxyServer) sendError(c *gin.Context, requestID string, apiErr *apierr
 		c.Request.URL.Path,
 		c.Request.Method,
 	)
+	response.TraceID = c.GetString("trace_id")
 
 	// Set correlation ID header for tracing
 	c.Header("X-Correlation-ID", correlationID)
@@ -345,13 +983,12 @@ func (s *ProxyServer) sendError(c *gin.Context, requestID string, apiErr *apierr
 }
 
 // sendRateLimitError sends a rate limit error with Retry-After header
-func (s *ProxyServer) sendRateLimitError(c *gin.Context, requestID string, retryAfter int64) {
+func (s *ProxyServer) sendRateLimitError(c *gin.Context, requestID string, apiErr *apierrors.APIError, retryAfter int64) {
 	correlationID := c.GetString("correlation_id")
 	if correlationID == "" {
 		correlationID = requestID
 	}
 
-	apiErr := apierrors.NewRateLimitError(retryAfter)
 	response := &apierrors.RateLimitErrorResponse{
 		ErrorResponse: *apierrors.NewErrorResponse(
 			apiErr,
@@ -362,6 +999,7 @@ func (s *ProxyServer) sendRateLimitError(c *gin.Context, requestID string, retry
 		),
 		RetryAfter: retryAfter,
 	}
+	response.TraceID = c.GetString("trace_id")
 
 	// Set standard Retry-After header
 	c.Header("Retry-After", fmt.Sprintf("%d", retryAfter))
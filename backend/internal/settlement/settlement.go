@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/aimerfeng/AgentLink/internal/monitoring"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -80,10 +81,13 @@ type SettlementCalculation struct {
 
 // AgentEarnings represents earnings for a specific agent
 type AgentEarnings struct {
-	AgentID     uuid.UUID       `json:"agent_id"`
-	AgentName   string          `json:"agent_name"`
-	TotalCalls  int64           `json:"total_calls"`
-	Revenue     decimal.Decimal `json:"revenue"`
+	AgentID      uuid.UUID           `json:"agent_id"`
+	AgentName    string              `json:"agent_name"`
+	TotalCalls   int64               `json:"total_calls"`
+	Revenue      decimal.Decimal     `json:"revenue"`
+	PricingModel models.PricingModel `json:"pricing_model"`
+	InputTokens  int64               `json:"input_tokens"`  // Billed input tokens (token-priced agents)
+	OutputTokens int64               `json:"output_tokens"` // Billed output tokens (token-priced agents)
 }
 
 
@@ -147,15 +151,18 @@ func (s *Service) CalculateSettlement(ctx context.Context, creatorID uuid.UUID,
 		SELECT 
 			a.id,
 			a.name,
+			COALESCE(a.pricing_model, 'per_call'),
 			COUNT(cl.id) as total_calls,
-			COALESCE(SUM(cl.cost_usd), 0) as revenue
+			COALESCE(SUM(cl.cost_usd), 0) as revenue,
+			COALESCE(SUM(cl.input_tokens), 0) as input_tokens,
+			COALESCE(SUM(cl.output_tokens), 0) as output_tokens
 		FROM agents a
 		LEFT JOIN call_logs cl ON cl.agent_id = a.id 
 			AND cl.created_at >= $2 
 			AND cl.created_at < $3
 			AND cl.status = 'success'
 		WHERE a.creator_id = $1
-		GROUP BY a.id, a.name
+		GROUP BY a.id, a.name, a.pricing_model
 		HAVING COUNT(cl.id) > 0
 		ORDER BY revenue DESC
 	`, creatorID, periodStart, periodEnd)
@@ -171,7 +178,8 @@ func (s *Service) CalculateSettlement(ctx context.Context, creatorID uuid.UUID,
 	for rows.Next() {
 		var ae AgentEarnings
 		var revenue decimal.Decimal
-		err := rows.Scan(&ae.AgentID, &ae.AgentName, &ae.TotalCalls, &revenue)
+		err := rows.Scan(&ae.AgentID, &ae.AgentName, &ae.PricingModel, &ae.TotalCalls, &revenue,
+			&ae.InputTokens, &ae.OutputTokens)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan agent earnings: %w", err)
 		}
@@ -263,6 +271,9 @@ func (s *Service) CreateSettlement(ctx context.Context, creatorID uuid.UUID, per
 	if err := tx.Commit(ctx); err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	monitoring.RecordSettlement(string(models.SettlementStatusPending))
+	monitoring.RecordSettlementVolume(calc.NetAmount.InexactFloat64())
+	monitoring.RecordRevenue("platform_fee", calc.PlatformFee.InexactFloat64())
 
 	// Fetch and return the created settlement
 	return s.GetSettlementByID(ctx, settlementID)
@@ -356,6 +367,7 @@ func (s *Service) CompleteSettlement(ctx context.Context, settlementID uuid.UUID
 	if result.RowsAffected() == 0 {
 		return ErrSettlementAlreadyDone
 	}
+	monitoring.RecordSettlement(string(models.SettlementStatusCompleted))
 	return nil
 }
 
@@ -403,6 +415,7 @@ func (s *Service) FailSettlement(ctx context.Context, settlementID uuid.UUID) er
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	monitoring.RecordSettlement(string(models.SettlementStatusFailed))
 	return nil
 }
 
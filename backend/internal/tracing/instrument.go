@@ -0,0 +1,198 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxStatementLength is the longest SQL statement recorded on a span
+const maxStatementLength = 2048
+
+// Middleware starts a server span for each request. Callers are untrusted,
+// so each request starts a new trace sampled by our own ratio; a caller's
+// traceparent is only linked from the server span, never continued. The
+// trace ID is stored in the gin context as "trace_id" and returned in the
+// X-Trace-ID header.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched route"
+		}
+
+		opts := []Option{
+			trace.WithNewRoot(),
+			WithKind(SpanKindServer),
+			WithAttributes(
+				String("http.request.method", c.Request.Method),
+				String("http.route", route),
+				String("url.path", c.Request.URL.Path),
+				String("request_id", c.GetString("request_id")),
+			),
+		}
+		if remote := Extract(c.Request.Header); remote.IsValid() {
+			opts = append(opts, trace.WithLinks(trace.Link{SpanContext: remote}))
+		}
+		ctx, span := Start(c.Request.Context(), c.Request.Method+" "+route, opts...)
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Set("trace_id", span.TraceID())
+		c.Header("X-Trace-ID", span.TraceID())
+
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(Int("http.response.status_code", status))
+		if status >= http.StatusInternalServerError {
+			span.SetError(http.StatusText(status))
+		}
+	}
+}
+
+// Transport is an http.RoundTripper that records a client span for each
+// request and propagates the trace in the traceparent header
+type Transport struct {
+	Base http.RoundTripper
+}
+
+// NewTransport wraps base, or http.DefaultTransport if base is nil
+func NewTransport(base http.RoundTripper) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base}
+}
+
+// RoundTrip sends the request inside a client span. The span ends when the
+// response body is closed, so it covers streamed responses.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Start(req.Context(), "HTTP "+req.Method,
+		WithKind(SpanKindClient),
+		WithAttributes(
+			String("http.request.method", req.Method),
+			String("server.address", req.URL.Host),
+		))
+	req = req.Clone(ctx)
+	Inject(ctx, req.Header)
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		span.End()
+		return nil, err
+	}
+	span.SetAttributes(Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		span.SetError(http.StatusText(resp.StatusCode))
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// spanBody ends its span when closed
+type spanBody struct {
+	io.ReadCloser
+	span *Span
+}
+
+func (b *spanBody) Close() error {
+	defer b.span.End()
+	return b.ReadCloser.Close()
+}
+
+// PgxTracer records a client span for each query run inside a sampled trace.
+// Set it as the pool's ConnConfig.Tracer.
+type PgxTracer struct{}
+
+// TraceQueryStart implements pgx.QueryTracer
+func (PgxTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !trace.SpanFromContext(ctx).IsRecording() {
+		return ctx
+	}
+	statement := data.SQL
+	if len(statement) > maxStatementLength {
+		statement = statement[:maxStatementLength]
+	}
+	operation := "query"
+	if fields := strings.Fields(statement); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+	ctx, _ = Start(ctx, "postgres "+operation,
+		WithKind(SpanKindClient),
+		WithAttributes(
+			String("db.system", "postgresql"),
+			String("db.operation", operation),
+			String("db.statement", strings.TrimSpace(statement)),
+		))
+	return context.WithValue(ctx, pgxSpanKey{}, true)
+}
+
+// TraceQueryEnd implements pgx.QueryTracer
+func (PgxTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	if started, _ := ctx.Value(pgxSpanKey{}).(bool); !started {
+		return
+	}
+	span := SpanFromContext(ctx)
+	if !errors.Is(data.Err, pgx.ErrNoRows) {
+		span.RecordError(data.Err)
+	}
+	span.SetAttributes(Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	span.End()
+}
+
+// pgxSpanKey marks a context holding a query span started by PgxTracer
+type pgxSpanKey struct{}
+
+// RedisHook records a client span for each command run inside a sampled trace. Add
+// it with Client.AddHook.
+type RedisHook struct{}
+
+// DialHook implements redis.Hook
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+// ProcessHook implements redis.Hook
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if !trace.SpanFromContext(ctx).IsRecording() {
+			return next(ctx, cmd)
+		}
+		ctx, span := Start(ctx, "redis "+cmd.Name(),
+			WithKind(SpanKindClient),
+			WithAttributes(String("db.system", "redis"), String("db.operation", cmd.Name())))
+		err := next(ctx, cmd)
+		if !errors.Is(err, redis.Nil) {
+			span.RecordError(err)
+		}
+		span.End()
+		return err
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if !trace.SpanFromContext(ctx).IsRecording() {
+			return next(ctx, cmds)
+		}
+		ctx, span := Start(ctx, "redis pipeline",
+			WithKind(SpanKindClient),
+			WithAttributes(String("db.system", "redis"), Int("db.redis.commands", len(cmds))))
+		err := next(ctx, cmds)
+		if !errors.Is(err, redis.Nil) {
+			span.RecordError(err)
+		}
+		span.End()
+		return err
+	}
+}
@@ -0,0 +1,27 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceparentHeader carries W3C trace context
+const TraceparentHeader = "traceparent"
+
+// propagator reads and writes W3C trace context
+var propagator = propagation.TraceContext{}
+
+// Inject sets the traceparent header from the span in ctx
+func Inject(ctx context.Context, h http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(h))
+}
+
+// Extract returns the caller's span context from the traceparent header. It
+// is invalid if the header is missing or malformed.
+func Extract(h http.Header) trace.SpanContext {
+	ctx := propagator.Extract(context.Background(), propagation.HeaderCarrier(h))
+	return trace.SpanContextFromContext(ctx)
+}
@@ -0,0 +1,175 @@
+// Package tracing records OpenTelemetry spans and exports them over OTLP/HTTP
+// or to stdout. Trace context is propagated in the W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// scopeName is the instrumentation scope of spans recorded by this package
+const scopeName = "github.com/aimerfeng/AgentLink/internal/tracing"
+
+// SpanKind is the OpenTelemetry span kind
+type SpanKind = trace.SpanKind
+
+const (
+	SpanKindInternal = trace.SpanKindInternal
+	SpanKindServer   = trace.SpanKindServer
+	SpanKindClient   = trace.SpanKindClient
+)
+
+// Attribute is a span attribute
+type Attribute = attribute.KeyValue
+
+// String returns a string attribute
+func String(key, value string) Attribute {
+	return attribute.String(key, value)
+}
+
+// Int returns an integer attribute
+func Int(key string, value int) Attribute {
+	return attribute.Int(key, value)
+}
+
+// Int64 returns an integer attribute
+func Int64(key string, value int64) Attribute {
+	return attribute.Int64(key, value)
+}
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute {
+	return attribute.Bool(key, value)
+}
+
+// Span is one timed operation in a trace
+type Span struct {
+	span trace.Span
+}
+
+// SpanContext returns the span's propagated context
+func (s *Span) SpanContext() trace.SpanContext {
+	return s.span.SpanContext()
+}
+
+// TraceID returns the span's trace ID in hex, or "" if it has none
+func (s *Span) TraceID() string {
+	sc := s.span.SpanContext()
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	s.span.SetAttributes(attrs...)
+}
+
+// RecordError marks the span failed with err. A nil error is ignored.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// SetError marks the span failed without an error value, e.g. for a 5xx status
+func (s *Span) SetError(message string) {
+	s.span.SetStatus(codes.Error, message)
+}
+
+// End ends the span. Only the first call has an effect.
+func (s *Span) End() {
+	s.span.End()
+}
+
+// Option configures a span when it starts
+type Option = trace.SpanStartOption
+
+// WithKind sets the span kind; spans are internal by default
+func WithKind(kind SpanKind) Option {
+	return trace.WithSpanKind(kind)
+}
+
+// WithAttributes sets attributes on the span
+func WithAttributes(attrs ...Attribute) Option {
+	return trace.WithAttributes(attrs...)
+}
+
+// Start starts a span as a child of the span in ctx, or as a new trace
+func Start(ctx context.Context, name string, opts ...Option) (context.Context, *Span) {
+	ctx, span := otel.GetTracerProvider().Tracer(scopeName).Start(ctx, name, opts...)
+	return ctx, &Span{span: span}
+}
+
+// SpanFromContext returns the current span. It records nothing if ctx holds
+// no span.
+func SpanFromContext(ctx context.Context) *Span {
+	return &Span{span: trace.SpanFromContext(ctx)}
+}
+
+// TraceIDFromContext returns the current trace ID in hex, or ""
+func TraceIDFromContext(ctx context.Context) string {
+	return SpanFromContext(ctx).TraceID()
+}
+
+// Setup installs the process's tracer provider, naming the process service
+// unless the configuration overrides it. New traces are sampled at the
+// configured ratio and child spans follow their parent. Without an exporter
+// nothing is sampled, but spans still get IDs so trace IDs can be logged.
+// The returned function flushes queued spans and stops exporting.
+func Setup(cfg *config.TracingConfig, service string) (func(context.Context) error, error) {
+	if cfg.ServiceName != "" {
+		service = cfg.ServiceName
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "", "none":
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	case "otlp":
+		headers := map[string]string{}
+		for _, h := range cfg.OTLPHeaders {
+			key, value, _ := strings.Cut(h, "=")
+			headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		exporter, err = otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpointURL(strings.TrimSuffix(cfg.OTLPEndpoint, "/")+"/v1/traces"),
+			otlptracehttp.WithHeaders(headers))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+		sdktrace.WithSampler(sdktrace.NeverSample()),
+	}
+	if exporter != nil {
+		opts = append(opts,
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))))
+		log.Info().Str("exporter", cfg.Exporter).Str("service", service).Msg("Tracing enabled")
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,205 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"pgregory.net/rapid"
+)
+
+// withRecorder installs a tracer provider sampling new traces at ratio for
+// the test, and returns the recorder of its ended spans
+func withRecorder(t testing.TB, ratio float64) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+	return recorder
+}
+
+// TestProperty_SamplingFollowsRatio tests that new traces are sampled at
+// the configured ratio, and children always follow their parent
+func TestProperty_SamplingFollowsRatio(t *testing.T) {
+	rapid.Check(t, func(rt *rapid.T) {
+		ratio := rapid.SampledFrom([]float64{0, 0.25, 0.5, 1}).Draw(rt, "ratio")
+		withRecorder(t, ratio)
+
+		sampled := 0
+		const n = 2000
+		for i := 0; i < n; i++ {
+			ctx, root := Start(context.Background(), "root")
+			_, child := Start(ctx, "child")
+			rootSC, childSC := root.SpanContext(), child.SpanContext()
+			if childSC.IsSampled() != rootSC.IsSampled() || childSC.TraceID() != rootSC.TraceID() || !childSC.IsValid() {
+				rt.Fatalf("PROPERTY VIOLATION: child %+v does not continue root %+v", childSC, rootSC)
+			}
+			if rootSC.IsSampled() {
+				sampled++
+			}
+		}
+		if got := float64(sampled) / n; got < ratio-0.05 || got > ratio+0.05 {
+			rt.Fatalf("PROPERTY VIOLATION: sampled %.3f of traces at ratio %.2f", got, ratio)
+		}
+	})
+}
+
+// TestSpan_RecordErrorSetsStatus tests that recorded errors fail the span
+// and nil errors are ignored
+func TestSpan_RecordErrorSetsStatus(t *testing.T) {
+	recorder := withRecorder(t, 1)
+	_, ok := Start(context.Background(), "ok", WithAttributes(Int("count", 3)))
+	ok.RecordError(nil)
+	ok.End()
+	_, failed := Start(context.Background(), "failed")
+	failed.RecordError(errors.New("boom"))
+	failed.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Status().Code != codes.Unset || spans[0].Attributes()[0] != Int("count", 3) {
+		t.Fatalf("Unexpected span: %+v %+v", spans[0].Status(), spans[0].Attributes())
+	}
+	if spans[1].Status().Code != codes.Error || spans[1].Status().Description != "boom" || len(spans[1].Events()) != 1 {
+		t.Fatalf("Expected a failed span, got %+v", spans[1].Status())
+	}
+}
+
+// TestSetup_OTLPExporterPostsTraces tests that spans are posted to the
+// collector's traces path with the configured headers
+func TestSetup_OTLPExporterPostsTraces(t *testing.T) {
+	var path, auth string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, auth = r.URL.Path, r.Header.Get("Authorization")
+	}))
+	defer collector.Close()
+
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	shutdown, err := Setup(&config.TracingConfig{
+		Exporter:     "otlp",
+		OTLPEndpoint: collector.URL + "/",
+		OTLPHeaders:  []string{"Authorization=Bearer token"},
+		SampleRatio:  1,
+	}, "test")
+	if err != nil {
+		t.Fatalf("Failed to set up tracing: %v", err)
+	}
+	_, span := Start(context.Background(), "span")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Failed to flush spans: %v", err)
+	}
+
+	if path != "/v1/traces" || auth != "Bearer token" {
+		t.Fatalf("Unexpected export: path %q, auth %q", path, auth)
+	}
+}
+
+// TestSetup_NoExporterStillAssignsTraceIDs tests that trace IDs are
+// available for logs when tracing is off
+func TestSetup_NoExporterStillAssignsTraceIDs(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	defer otel.SetTracerProvider(previous)
+	shutdown, err := Setup(&config.TracingConfig{Exporter: "none", SampleRatio: 1}, "test")
+	if err != nil {
+		t.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdown(context.Background())
+
+	ctx, span := Start(context.Background(), "span")
+	defer span.End()
+	if span.SpanContext().IsSampled() || len(TraceIDFromContext(ctx)) != 32 {
+		t.Fatalf("Expected an unsampled span with a trace ID, got %+v", span.SpanContext())
+	}
+	if _, err := Setup(&config.TracingConfig{Exporter: "zipkin"}, "test"); err == nil {
+		t.Fatalf("Expected an unknown exporter to be rejected")
+	}
+}
+
+// TestMiddleware_DoesNotTrustCallerTrace tests that a request starts a new
+// trace linked to the caller's, ignoring the caller's sampling flag, and
+// that the trace is propagated to upstream calls made through Transport
+func TestMiddleware_DoesNotTrustCallerTrace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const callerTrace = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const incoming = "00-" + callerTrace + "-00f067aa0ba902b7-01"
+
+	var upstreamParent http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamParent = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	client := &http.Client{Transport: NewTransport(nil)}
+
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/chat/:id", func(c *gin.Context) {
+		req, _ := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, upstream.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			c.Status(http.StatusBadGateway)
+			return
+		}
+		resp.Body.Close()
+		c.Status(http.StatusInternalServerError)
+	})
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/chat/1", nil)
+		req.Header.Set(TraceparentHeader, incoming)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The caller sampled its trace, but nothing is recorded at a ratio of 0
+	recorder := withRecorder(t, 0)
+	w := serve()
+	if got := w.Header().Get("X-Trace-ID"); got == "" || got == callerTrace {
+		t.Fatalf("Expected a new trace ID, got %q", got)
+	}
+	if len(recorder.Ended()) != 0 {
+		t.Fatalf("Expected the caller's sampling flag to be ignored, got %d spans", len(recorder.Ended()))
+	}
+
+	recorder = withRecorder(t, 1)
+	w = serve()
+	traceID := w.Header().Get("X-Trace-ID")
+	sc := Extract(upstreamParent)
+	if !sc.IsValid() || sc.TraceID().String() != traceID || traceID == callerTrace {
+		t.Fatalf("Trace not propagated upstream: %q", upstreamParent.Get(TraceparentHeader))
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected client and server spans, got %d", len(spans))
+	}
+	clientSpan, server := spans[0], spans[1]
+	if server.Name() != "GET /chat/:id" || server.Parent().IsValid() || server.Status().Code != codes.Error {
+		t.Fatalf("Unexpected server span: %s parent %+v status %+v", server.Name(), server.Parent(), server.Status())
+	}
+	if links := server.Links(); len(links) != 1 || links[0].SpanContext.TraceID().String() != callerTrace {
+		t.Fatalf("Expected a link to the caller's span, got %+v", links)
+	}
+	if clientSpan.SpanKind() != trace.SpanKindClient || clientSpan.Parent().SpanID() != server.SpanContext().SpanID() ||
+		clientSpan.SpanContext().SpanID() != sc.SpanID() {
+		t.Fatalf("Unexpected client span: %+v", clientSpan.SpanContext())
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/ledger"
+	"github.com/aimerfeng/AgentLink/internal/models"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -146,9 +148,15 @@ func (s *Service) UseTrialCall(ctx context.Context, userID, agentID uuid.UUID) (
 		return nil, ErrTrialExhausted
 	}
 
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
 	// Use upsert to atomically increment trial usage
 	var usage TrialUsage
-	err = s.db.QueryRow(ctx, `
+	err = tx.QueryRow(ctx, `
 		INSERT INTO trial_usage (user_id, agent_id, used_trials, max_trials)
 		VALUES ($1, $2, 1, $3)
 		ON CONFLICT (user_id, agent_id) 
@@ -170,6 +178,20 @@ func (s *Service) UseTrialCall(ctx context.Context, userID, agentID uuid.UUID) (
 		return nil, fmt.Errorf("failed to use trial call: %w", err)
 	}
 
+	// Trial calls are free, but are recorded so the ledger explains every call
+	err = ledger.Record(ctx, tx, &models.QuotaLedgerEntry{
+		UserID:    userID,
+		EntryType: models.QuotaLedgerTrial,
+		AgentID:   &agentID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	remaining := usage.MaxTrials - usage.UsedTrials
 	if remaining < 0 {
 		remaining = 0
@@ -0,0 +1,135 @@
+package usage
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// rollupLag keeps the current hour, and the first minutes after it, out of
+	// the rollups so calls still being logged for it are not missed
+	rollupLag = 5 * time.Minute
+	// rollupChunk bounds how much of the call log one rollup transaction reads
+	rollupChunk = 24 * time.Hour
+)
+
+// execer is implemented by both the connection pool and a transaction
+type execer interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+}
+
+// RunRollup rolls up finished hours every interval until the context is
+// cancelled. Does nothing if rolling up is disabled.
+func (s *Service) RunRollup(ctx context.Context) {
+	if s.config.RollupInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.RollupInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.Rollup(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to roll up call usage")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Rollup rolls up every finished hour not yet in the rollups. Concurrent calls
+// from other replicas wait for each other rather than duplicating work.
+func (s *Service) Rollup(ctx context.Context) error {
+	horizon := time.Now().UTC().Add(-rollupLag).Truncate(time.Hour)
+	for {
+		done, err := s.rollupChunk(ctx, horizon)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// rollupChunk rolls up the next chunk of hours before the horizon and
+// advances the watermark. Reports whether the rollups have caught up.
+func (s *Service) rollupChunk(ctx context.Context, horizon time.Time) (bool, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var watermark *time.Time
+	err = tx.QueryRow(ctx, `SELECT rolled_up_to FROM usage_rollup_state WHERE id FOR UPDATE`).Scan(&watermark)
+	if err != nil {
+		return false, fmt.Errorf("failed to get rollup state: %w", err)
+	}
+	if watermark == nil {
+		// First rollup: start from the oldest call log
+		var oldest *time.Time
+		if err := tx.QueryRow(ctx, `SELECT MIN(created_at) FROM call_logs`).Scan(&oldest); err != nil {
+			return false, fmt.Errorf("failed to get oldest call log: %w", err)
+		}
+		start := horizon
+		if oldest != nil && oldest.Before(horizon) {
+			start = oldest.UTC().Truncate(time.Hour)
+		}
+		watermark = &start
+	}
+
+	start := watermark.UTC()
+	if !start.Before(horizon) {
+		return true, tx.Commit(ctx)
+	}
+	end := start.Add(rollupChunk)
+	if end.After(horizon) {
+		end = horizon
+	}
+
+	if err := rollupRange(ctx, tx, start, end); err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(ctx, `UPDATE usage_rollup_state SET rolled_up_to = $1, updated_at = NOW() WHERE id`, end)
+	if err != nil {
+		return false, fmt.Errorf("failed to advance rollup state: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Debug().Time("from", start).Time("to", end).Msg("Rolled up call usage")
+	return !end.Before(horizon), nil
+}
+
+// rollupRange replaces the rollups of the whole hours in [start, end)
+func rollupRange(ctx context.Context, q execer, start, end time.Time) error {
+	_, err := q.Exec(ctx, `
+		INSERT INTO call_usage_hourly (
+			bucket_start, user_id, agent_id, api_key_id, status, calls, input_tokens, output_tokens,
+			cost_usd, latency_count, latency_sum_ms, latency_histogram
+		)
+		SELECT `+hourExpr+`, user_id, agent_id, api_key_id, COALESCE(status, 'unknown'), COUNT(*),
+		       COALESCE(SUM(input_tokens), 0), COALESCE(SUM(output_tokens), 0), COALESCE(SUM(cost_usd), 0),
+		       COUNT(latency_ms), COALESCE(SUM(latency_ms), 0), `+LatencyHistogramExpr+`
+		FROM call_logs
+		WHERE created_at >= $1 AND created_at < $2
+		GROUP BY 1, 2, 3, 4, 5
+		ON CONFLICT (user_id, bucket_start, agent_id, api_key_id, status) DO UPDATE SET
+			calls = EXCLUDED.calls,
+			input_tokens = EXCLUDED.input_tokens,
+			output_tokens = EXCLUDED.output_tokens,
+			cost_usd = EXCLUDED.cost_usd,
+			latency_count = EXCLUDED.latency_count,
+			latency_sum_ms = EXCLUDED.latency_sum_ms,
+			latency_histogram = EXCLUDED.latency_histogram
+	`, start, end)
+	if err != nil {
+		return fmt.Errorf("failed to roll up call usage: %w", err)
+	}
+	return nil
+}
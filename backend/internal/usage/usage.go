@@ -0,0 +1,532 @@
+package usage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+)
+
+// Granularity is the width of a time series bucket
+type Granularity string
+
+const (
+	GranularityHour  Granularity = "hour"
+	GranularityDay   Granularity = "day"
+	GranularityMonth Granularity = "month"
+)
+
+// maxRange bounds the queried period per granularity, to bound the series length
+var maxRange = map[Granularity]time.Duration{
+	GranularityHour:  31 * 24 * time.Hour,
+	GranularityDay:   366 * 24 * time.Hour,
+	GranularityMonth: 5 * 366 * 24 * time.Hour,
+}
+
+// GroupBy is the dimension usage is broken down by
+type GroupBy string
+
+const (
+	GroupByNone   GroupBy = ""
+	GroupByAgent  GroupBy = "agent"
+	GroupByAPIKey GroupBy = "api_key"
+	GroupByStatus GroupBy = "status"
+)
+
+// groupKeys are the SQL expressions over the source rows for each breakdown
+var groupKeys = map[GroupBy]string{
+	GroupByNone:   `''`,
+	GroupByAgent:  `agent_id::text`,
+	GroupByAPIKey: `api_key_id::text`,
+	GroupByStatus: `status`,
+}
+
+// validStatuses are the call statuses usage can be filtered by
+var validStatuses = map[string]bool{"success": true, "error": true, "timeout": true}
+
+// Query errors
+var (
+	ErrInvalidRange       = errors.New("from must be before to")
+	ErrRangeTooLarge      = errors.New("date range is too large for the granularity")
+	ErrInvalidGranularity = errors.New("granularity must be hour, day or month")
+	ErrInvalidGroupBy     = errors.New("group_by must be agent, api_key or status")
+	ErrInvalidStatus      = errors.New("status must be success, error or timeout")
+)
+
+// LatencyBounds are the upper bounds in milliseconds of the latency histogram
+// buckets kept in the rollups; a final bucket holds everything slower.
+// Changing them invalidates existing rollups.
+var LatencyBounds = []int64{25, 50, 100, 200, 300, 500, 750, 1000, 1500, 2000, 3000, 5000, 7500, 10000, 15000, 30000, 60000}
+
+// Service answers usage queries from hourly rollups of call_logs, plus the
+// not yet rolled up recent call logs
+type Service struct {
+	db     *pgxpool.Pool
+	config *config.UsageConfig
+}
+
+// NewService creates a new usage service
+func NewService(db *pgxpool.Pool, cfg *config.UsageConfig) *Service {
+	return &Service{
+		db:     db,
+		config: cfg,
+	}
+}
+
+// Query selects the calls to report on. From is rounded down and To rounded up
+// to the granularity, in UTC.
+type Query struct {
+	From        time.Time
+	To          time.Time
+	Granularity Granularity
+	GroupBy     GroupBy
+	AgentID     *uuid.UUID
+	APIKeyID    *uuid.UUID
+	Status      string
+	Page        int
+	PageSize    int
+}
+
+// Stats summarizes a set of calls. Percentiles are estimated from the latency
+// histogram, so are accurate to within a histogram bucket.
+type Stats struct {
+	Calls           int64           `json:"calls"`
+	SuccessfulCalls int64           `json:"successful_calls"`
+	FailedCalls     int64           `json:"failed_calls"`
+	InputTokens     int64           `json:"input_tokens"`
+	OutputTokens    int64           `json:"output_tokens"`
+	CostUSD         decimal.Decimal `json:"cost_usd"`
+	AvgLatencyMs    int64           `json:"avg_latency_ms"`
+	P50LatencyMs    int64           `json:"p50_latency_ms"`
+	P95LatencyMs    int64           `json:"p95_latency_ms"`
+	P99LatencyMs    int64           `json:"p99_latency_ms"`
+}
+
+// SeriesPoint is one time bucket of usage
+type SeriesPoint struct {
+	Period       time.Time       `json:"period"`
+	Calls        int64           `json:"calls"`
+	FailedCalls  int64           `json:"failed_calls"`
+	InputTokens  int64           `json:"input_tokens"`
+	OutputTokens int64           `json:"output_tokens"`
+	CostUSD      decimal.Decimal `json:"cost_usd"`
+	AvgLatencyMs int64           `json:"avg_latency_ms"`
+}
+
+// Breakdown is the usage of one agent, API key or status
+type Breakdown struct {
+	Key  string  `json:"key"`
+	Name *string `json:"name,omitempty"` // Agent or API key name
+	Stats
+}
+
+// Response represents a usage report
+type Response struct {
+	From        time.Time     `json:"from"`
+	To          time.Time     `json:"to"`
+	Granularity Granularity   `json:"granularity"`
+	GroupBy     GroupBy       `json:"group_by,omitempty"`
+	Totals      Stats         `json:"totals"`
+	Series      []SeriesPoint `json:"series"`
+	Breakdown   []Breakdown   `json:"breakdown,omitempty"` // Busiest first, paginated
+	Total       int           `json:"total,omitempty"`     // Breakdown entries across pages
+	Page        int           `json:"page,omitempty"`
+	PageSize    int           `json:"page_size,omitempty"`
+}
+
+// aggregate is the sums of a group of source rows
+type aggregate struct {
+	calls, successful, inputTokens, outputTokens int64
+	cost                                         decimal.Decimal
+	latencyCount, latencySum                     int64
+}
+
+func (a *aggregate) avgLatency() int64 {
+	if a.latencyCount == 0 {
+		return 0
+	}
+	return a.latencySum / a.latencyCount
+}
+
+// aggregateColumns sums source rows; scanned by scanAggregate
+const aggregateColumns = `SUM(calls)::BIGINT, (SUM(calls) FILTER (WHERE status = 'success'))::BIGINT,
+	SUM(input_tokens)::BIGINT, SUM(output_tokens)::BIGINT, SUM(cost_usd),
+	SUM(latency_count)::BIGINT, SUM(latency_sum_ms)::BIGINT`
+
+func scanAggregate(rows pgx.Rows, dest ...any) (*aggregate, error) {
+	var a aggregate
+	var successful *int64
+	dest = append(dest, &a.calls, &successful, &a.inputTokens, &a.outputTokens, &a.cost, &a.latencyCount, &a.latencySum)
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if successful != nil {
+		a.successful = *successful
+	}
+	return &a, nil
+}
+
+// Get returns a user's usage report
+func (s *Service) Get(ctx context.Context, userID uuid.UUID, q *Query) (*Response, error) {
+	var watermark *time.Time
+	err := s.db.QueryRow(ctx, `SELECT rolled_up_to FROM usage_rollup_state`).Scan(&watermark)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get rollup state: %w", err)
+	}
+	return s.get(ctx, userID, q, watermark)
+}
+
+// get builds the report from rollups before the watermark and raw call logs
+// after it. A nil watermark reads only raw call logs.
+func (s *Service) get(ctx context.Context, userID uuid.UUID, q *Query, watermark *time.Time) (*Response, error) {
+	if err := normalize(q); err != nil {
+		return nil, err
+	}
+	groupKey := groupKeys[q.GroupBy]
+	period := fmt.Sprintf(`date_trunc('%s', bucket_start AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'`, q.Granularity)
+
+	var status *string
+	if q.Status != "" {
+		status = &q.Status
+	}
+	args := []any{userID, q.From, q.To, watermark, q.AgentID, q.APIKeyID, status}
+	src := `
+		WITH src AS (
+			SELECT bucket_start, agent_id, api_key_id, status, calls, input_tokens, output_tokens,
+			       cost_usd, latency_count, latency_sum_ms, latency_histogram
+			FROM call_usage_hourly
+			WHERE user_id = $1 AND bucket_start >= $2
+			  AND $4::timestamptz IS NOT NULL AND bucket_start < LEAST($3, $4::timestamptz)
+			  AND ($5::uuid IS NULL OR agent_id = $5) AND ($6::uuid IS NULL OR api_key_id = $6)
+			  AND ($7::text IS NULL OR status = $7)
+			UNION ALL
+			SELECT ` + hourExpr + `, agent_id, api_key_id, COALESCE(status, 'unknown'), COUNT(*),
+			       COALESCE(SUM(input_tokens), 0), COALESCE(SUM(output_tokens), 0), COALESCE(SUM(cost_usd), 0),
+			       COUNT(latency_ms), COALESCE(SUM(latency_ms), 0), ` + LatencyHistogramExpr + `
+			FROM call_logs
+			WHERE user_id = $1 AND created_at >= GREATEST($2, $4::timestamptz) AND created_at < $3
+			  AND ($5::uuid IS NULL OR agent_id = $5) AND ($6::uuid IS NULL OR api_key_id = $6)
+			  AND ($7::text IS NULL OR COALESCE(status, 'unknown') = $7)
+			GROUP BY 1, 2, 3, 4
+		)`
+
+	resp := &Response{
+		From:        q.From,
+		To:          q.To,
+		Granularity: q.Granularity,
+		GroupBy:     q.GroupBy,
+		Series:      emptySeries(q),
+	}
+
+	// Time series
+	rows, err := s.db.Query(ctx, src+`
+		SELECT `+period+`, `+aggregateColumns+` FROM src GROUP BY 1
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage series: %w", err)
+	}
+	points := make(map[int64]*SeriesPoint, len(resp.Series))
+	for i := range resp.Series {
+		points[resp.Series[i].Period.Unix()] = &resp.Series[i]
+	}
+	for rows.Next() {
+		var start time.Time
+		a, err := scanAggregate(rows, &start)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan usage series: %w", err)
+		}
+		if p, ok := points[start.Unix()]; ok {
+			p.Calls, p.FailedCalls = a.calls, a.calls-a.successful
+			p.InputTokens, p.OutputTokens = a.inputTokens, a.outputTokens
+			p.CostUSD, p.AvgLatencyMs = a.cost, a.avgLatency()
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query usage series: %w", err)
+	}
+
+	// Per group sums and latency histograms; the totals are their sum
+	rows, err = s.db.Query(ctx, src+`
+		SELECT `+groupKey+`, `+aggregateColumns+` FROM src GROUP BY 1
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usage breakdown: %w", err)
+	}
+	groups := make(map[string]*aggregate)
+	total := &aggregate{}
+	for rows.Next() {
+		var key string
+		a, err := scanAggregate(rows, &key)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan usage breakdown: %w", err)
+		}
+		groups[key] = a
+		total.add(a)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query usage breakdown: %w", err)
+	}
+
+	histograms, err := s.histograms(ctx, src, groupKey, args)
+	if err != nil {
+		return nil, err
+	}
+	totalHistogram := make([]int64, len(LatencyBounds)+1)
+	for _, h := range histograms {
+		for i, n := range h {
+			totalHistogram[i] += n
+		}
+	}
+	resp.Totals = stats(total, totalHistogram)
+
+	if q.GroupBy == GroupByNone {
+		return resp, nil
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if groups[keys[i]].calls != groups[keys[j]].calls {
+			return groups[keys[i]].calls > groups[keys[j]].calls
+		}
+		return keys[i] < keys[j]
+	})
+	resp.Total, resp.Page, resp.PageSize = len(keys), q.Page, q.PageSize
+	start := min((q.Page-1)*q.PageSize, len(keys))
+	keys = keys[start:min(start+q.PageSize, len(keys))]
+
+	names, err := s.names(ctx, q.GroupBy, keys)
+	if err != nil {
+		return nil, err
+	}
+	resp.Breakdown = make([]Breakdown, 0, len(keys))
+	for _, key := range keys {
+		b := Breakdown{Key: key, Stats: stats(groups[key], histograms[key])}
+		if name, ok := names[key]; ok {
+			b.Name = &name
+		}
+		resp.Breakdown = append(resp.Breakdown, b)
+	}
+	return resp, nil
+}
+
+// histograms returns each group's latency histogram
+func (s *Service) histograms(ctx context.Context, src, groupKey string, args []any) (map[string][]int64, error) {
+	rows, err := s.db.Query(ctx, src+`
+		SELECT `+groupKey+`, h.i, SUM(h.n)::BIGINT
+		FROM src, unnest(src.latency_histogram) WITH ORDINALITY AS h(n, i)
+		GROUP BY 1, 2
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latency histograms: %w", err)
+	}
+	defer rows.Close()
+
+	histograms := make(map[string][]int64)
+	for rows.Next() {
+		var key string
+		var i, n int64
+		if err := rows.Scan(&key, &i, &n); err != nil {
+			return nil, fmt.Errorf("failed to scan latency histogram: %w", err)
+		}
+		h, ok := histograms[key]
+		if !ok {
+			h = make([]int64, len(LatencyBounds)+1)
+			histograms[key] = h
+		}
+		if i >= 1 && int(i) <= len(h) {
+			h[i-1] += n
+		}
+	}
+	return histograms, rows.Err()
+}
+
+// names returns the names of the agents or API keys in a breakdown
+func (s *Service) names(ctx context.Context, groupBy GroupBy, keys []string) (map[string]string, error) {
+	var query string
+	switch groupBy {
+	case GroupByAgent:
+		query = `SELECT id::text, name FROM agents WHERE id::text = ANY($1)`
+	case GroupByAPIKey:
+		query = `SELECT id::text, COALESCE(name, key_prefix) FROM api_keys WHERE id::text = ANY($1)`
+	default:
+		return nil, nil
+	}
+
+	rows, err := s.db.Query(ctx, query, keys)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get breakdown names: %w", err)
+	}
+	defer rows.Close()
+	names := make(map[string]string, len(keys))
+	for rows.Next() {
+		var key, name string
+		if err := rows.Scan(&key, &name); err != nil {
+			return nil, fmt.Errorf("failed to scan breakdown name: %w", err)
+		}
+		names[key] = name
+	}
+	return names, rows.Err()
+}
+
+func (a *aggregate) add(b *aggregate) {
+	a.calls += b.calls
+	a.successful += b.successful
+	a.inputTokens += b.inputTokens
+	a.outputTokens += b.outputTokens
+	a.cost = a.cost.Add(b.cost)
+	a.latencyCount += b.latencyCount
+	a.latencySum += b.latencySum
+}
+
+func stats(a *aggregate, histogram []int64) Stats {
+	return Stats{
+		Calls:           a.calls,
+		SuccessfulCalls: a.successful,
+		FailedCalls:     a.calls - a.successful,
+		InputTokens:     a.inputTokens,
+		OutputTokens:    a.outputTokens,
+		CostUSD:         a.cost,
+		AvgLatencyMs:    a.avgLatency(),
+		P50LatencyMs:    Percentile(histogram, 0.50),
+		P95LatencyMs:    Percentile(histogram, 0.95),
+		P99LatencyMs:    Percentile(histogram, 0.99),
+	}
+}
+
+// Percentile estimates a latency percentile (0 < p <= 1) from a histogram over
+// LatencyBounds, interpolating linearly within the bucket it falls in
+func Percentile(histogram []int64, p float64) int64 {
+	var total int64
+	for _, n := range histogram {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := p * float64(total)
+	var seen int64
+	for i, n := range histogram {
+		if n == 0 || float64(seen+n) < rank {
+			seen += n
+			continue
+		}
+		var lower, upper int64
+		if i > 0 {
+			lower = LatencyBounds[i-1]
+		}
+		if i >= len(LatencyBounds) {
+			return lower // Slower than the last bound; report the bound
+		}
+		upper = LatencyBounds[i]
+		fraction := (rank - float64(seen)) / float64(n)
+		return lower + int64(fraction*float64(upper-lower))
+	}
+	return LatencyBounds[len(LatencyBounds)-1]
+}
+
+// normalize validates a query, applies defaults and aligns its range
+func normalize(q *Query) error {
+	if q.Granularity == "" {
+		q.Granularity = GranularityDay
+	}
+	limit, ok := maxRange[q.Granularity]
+	if !ok {
+		return ErrInvalidGranularity
+	}
+	if _, ok := groupKeys[q.GroupBy]; !ok {
+		return ErrInvalidGroupBy
+	}
+	if q.Status != "" && !validStatuses[q.Status] {
+		return ErrInvalidStatus
+	}
+
+	if q.To.IsZero() {
+		q.To = time.Now()
+	}
+	if q.From.IsZero() {
+		q.From = q.To.AddDate(0, 0, -30)
+	}
+	q.From = truncate(q.From.UTC(), q.Granularity)
+	if aligned := truncate(q.To.UTC(), q.Granularity); !aligned.Equal(q.To) {
+		q.To = advance(aligned, q.Granularity)
+	}
+	if !q.From.Before(q.To) {
+		return ErrInvalidRange
+	}
+	if q.To.Sub(q.From) > limit {
+		return ErrRangeTooLarge
+	}
+
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PageSize < 1 || q.PageSize > 100 {
+		q.PageSize = 20
+	}
+	return nil
+}
+
+// emptySeries returns a zero point for every bucket of the range, so gaps show as zeros
+func emptySeries(q *Query) []SeriesPoint {
+	var series []SeriesPoint
+	for t := q.From; t.Before(q.To); t = advance(t, q.Granularity) {
+		series = append(series, SeriesPoint{Period: t, CostUSD: decimal.Zero})
+	}
+	return series
+}
+
+// truncate rounds a UTC time down to the start of its bucket
+func truncate(t time.Time, g Granularity) time.Time {
+	switch g {
+	case GranularityHour:
+		return t.Truncate(time.Hour)
+	case GranularityMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// advance returns the start of the next bucket
+func advance(t time.Time, g Granularity) time.Time {
+	switch g {
+	case GranularityHour:
+		return t.Add(time.Hour)
+	case GranularityMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.AddDate(0, 0, 1)
+	}
+}
+
+// hourExpr is the UTC hour of a call log
+const hourExpr = `date_trunc('hour', created_at AT TIME ZONE 'UTC') AT TIME ZONE 'UTC'`
+
+// LatencyHistogramExpr is an SQL aggregate counting a group's call logs into
+// the LatencyBounds buckets, in the form of the rollups' latency_histogram
+var LatencyHistogramExpr = func() string {
+	buckets := make([]string, 0, len(LatencyBounds)+1)
+	lower := "latency_ms >= 0"
+	for _, bound := range LatencyBounds {
+		buckets = append(buckets, fmt.Sprintf("COUNT(*) FILTER (WHERE %s AND latency_ms < %d)", lower, bound))
+		lower = fmt.Sprintf("latency_ms >= %d", bound)
+	}
+	buckets = append(buckets, fmt.Sprintf("COUNT(*) FILTER (WHERE %s)", lower))
+	return "ARRAY[" + strings.Join(buckets, ", ") + "]::BIGINT[]"
+}()
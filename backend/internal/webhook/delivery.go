@@ -0,0 +1,356 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-AgentLink-Event"
+	HeaderDelivery  = "X-AgentLink-Delivery"
+	HeaderTimestamp = "X-AgentLink-Timestamp"
+	// HeaderSignature is "sha256=" followed by the hex HMAC-SHA256 of
+	// "<timestamp>.<body>" keyed with the endpoint's secret
+	HeaderSignature = "X-AgentLink-Signature"
+)
+
+const (
+	// deliveryBatchSize is how many due deliveries one worker claims at a time
+	deliveryBatchSize = 50
+	// deliveryConcurrency bounds the requests one worker has in flight
+	deliveryConcurrency = 10
+	// baseBackoff and maxBackoff bound the wait between attempts
+	baseBackoff = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+)
+
+// Envelope is the JSON body POSTed to an endpoint
+type Envelope struct {
+	ID        uuid.UUID           `json:"id"` // Event ID; the same across redeliveries
+	Type      models.WebhookEvent `json:"type"`
+	CreatedAt time.Time           `json:"created_at"`
+	Data      json.RawMessage     `json:"data"`
+}
+
+// claimedDelivery is a delivery claimed for an attempt, with its endpoint
+type claimedDelivery struct {
+	ID        uuid.UUID
+	WebhookID uuid.UUID
+	EventID   uuid.UUID
+	EventType models.WebhookEvent
+	Payload   json.RawMessage
+	Attempts  int
+	CreatedAt time.Time
+	URL       string
+	Secret    string
+	test      bool
+}
+
+// Sign returns the signature header value for a delivery body
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff returns how long to wait before the attempt after the given one:
+// 30s doubling each attempt, at most 6h
+func Backoff(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	if attempt > 20 {
+		return maxBackoff
+	}
+	backoff := baseBackoff << (attempt - 1)
+	if backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+// RunWorker delivers due events every interval until the context is cancelled.
+// Any number of workers can run; each claims different deliveries.
+// Does nothing if the worker is disabled.
+func (s *Service) RunWorker(ctx context.Context) {
+	if s.config.WorkerInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.WorkerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.importPaymentNotifications(ctx); err != nil {
+			log.Error().Err(err).Msg("Failed to enqueue payment notifications")
+		}
+		for {
+			n, err := s.ProcessDue(ctx)
+			if err != nil {
+				log.Error().Err(err).Msg("Failed to process webhook deliveries")
+				break
+			}
+			if n < deliveryBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// ProcessDue claims a batch of due deliveries to active endpoints and attempts
+// each. Returns how many were attempted.
+func (s *Service) ProcessDue(ctx context.Context) (int, error) {
+	// A claim pushes next_attempt_at past the attempt, so a worker that dies
+	// mid-attempt leaves the delivery to be retried rather than lost
+	lease := s.config.Timeout + time.Minute
+	rows, err := s.db.Query(ctx, `
+		UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1, last_attempt_at = NOW(),
+		    next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT d2.id FROM webhook_deliveries d2
+			JOIN webhooks w2 ON w2.id = d2.webhook_id
+			WHERE d2.status = 'pending' AND d2.next_attempt_at <= NOW() AND w2.active = TRUE
+			ORDER BY d2.next_attempt_at
+			LIMIT $1
+			FOR UPDATE OF d2 SKIP LOCKED
+		)
+		RETURNING d.id, d.webhook_id, d.event_id, d.event_type, d.payload, d.attempts, d.created_at, w.url, w.secret
+	`, deliveryBatchSize, lease.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim deliveries: %w", err)
+	}
+
+	var claimed []*claimedDelivery
+	for rows.Next() {
+		var d claimedDelivery
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload,
+			&d.Attempts, &d.CreatedAt, &d.URL, &d.Secret)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan delivery: %w", err)
+		}
+		claimed = append(claimed, &d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to claim deliveries: %w", err)
+	}
+
+	sem := make(chan struct{}, deliveryConcurrency)
+	var wg sync.WaitGroup
+	for _, d := range claimed {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(d *claimedDelivery) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			s.deliver(ctx, d)
+		}(d)
+	}
+	wg.Wait()
+	return len(claimed), nil
+}
+
+// deliver POSTs a claimed delivery to its endpoint and records the outcome
+func (s *Service) deliver(ctx context.Context, d *claimedDelivery) {
+	body, err := json.Marshal(Envelope{
+		ID:        d.EventID,
+		Type:      d.EventType,
+		CreatedAt: d.CreatedAt,
+		Data:      d.Payload,
+	})
+	if err != nil {
+		s.recordFailure(ctx, d, nil, fmt.Sprintf("failed to encode event: %v", err), 0)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		s.recordFailure(ctx, d, nil, fmt.Sprintf("invalid request: %v", err), 0)
+		return
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "AgentLink-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, string(d.EventType))
+	req.Header.Set(HeaderDelivery, d.ID.String())
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(d.Secret, timestamp, body))
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	duration := time.Since(start)
+	if err != nil {
+		s.recordFailure(ctx, d, nil, err.Error(), duration)
+		return
+	}
+	// Only the status is kept: response bodies are never logged or shown back
+	// to the endpoint's owner
+	resp.Body.Close()
+
+	status := resp.StatusCode
+	if status < 200 || status >= 300 {
+		s.recordFailure(ctx, d, &status, fmt.Sprintf("endpoint returned status %d", status), duration)
+		return
+	}
+	s.recordSuccess(ctx, d, status, duration)
+}
+
+// recordSuccess marks a delivery succeeded and clears the endpoint's failure streak
+func (s *Service) recordSuccess(ctx context.Context, d *claimedDelivery, status int, duration time.Duration) {
+	// Record the outcome even if the caller's context was cancelled mid-attempt
+	ctx = context.WithoutCancel(ctx)
+	_, err := s.db.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = 'succeeded', delivered_at = NOW(), next_attempt_at = NULL,
+		    response_status = $2, last_error = NULL, duration_ms = $3
+		WHERE id = $1
+	`, d.ID, status, duration.Milliseconds())
+	if err != nil {
+		log.Error().Err(err).Str("delivery_id", d.ID.String()).Msg("Failed to record webhook delivery")
+		return
+	}
+	if d.test {
+		return
+	}
+	_, err = s.db.Exec(ctx, `UPDATE webhooks SET consecutive_failures = 0 WHERE id = $1 AND consecutive_failures > 0`, d.WebhookID)
+	if err != nil {
+		log.Error().Err(err).Str("webhook_id", d.WebhookID.String()).Msg("Failed to reset webhook failures")
+	}
+}
+
+// recordFailure schedules a retry with backoff, or marks the delivery failed
+// once attempts run out, and disables an endpoint that keeps failing
+func (s *Service) recordFailure(ctx context.Context, d *claimedDelivery, status *int, reason string, duration time.Duration) {
+	ctx = context.WithoutCancel(ctx)
+
+	exhausted := d.test || d.Attempts >= s.config.MaxAttempts
+	_, err := s.db.Exec(ctx, `
+		UPDATE webhook_deliveries
+		SET status = CASE WHEN $2 THEN 'failed' ELSE 'pending' END,
+		    next_attempt_at = CASE WHEN $2 THEN NULL ELSE NOW() + make_interval(secs => $3) END,
+		    response_status = $4, last_error = $5, duration_ms = $6
+		WHERE id = $1
+	`, d.ID, exhausted, Backoff(d.Attempts).Seconds(), status, reason, duration.Milliseconds())
+	if err != nil {
+		log.Error().Err(err).Str("delivery_id", d.ID.String()).Msg("Failed to record webhook delivery")
+		return
+	}
+	if exhausted && !d.test {
+		log.Warn().
+			Str("delivery_id", d.ID.String()).
+			Str("webhook_id", d.WebhookID.String()).
+			Int("attempts", d.Attempts).
+			Str("error", reason).
+			Msg("Webhook delivery failed permanently")
+	}
+	if d.test {
+		return
+	}
+
+	var disabled bool
+	err = s.db.QueryRow(ctx, `
+		UPDATE webhooks
+		SET consecutive_failures = consecutive_failures + 1,
+		    active = active AND consecutive_failures + 1 < $2,
+		    disabled_at = CASE WHEN active AND consecutive_failures + 1 >= $2 THEN NOW() ELSE disabled_at END,
+		    disabled_reason = CASE WHEN active AND consecutive_failures + 1 >= $2 THEN $3 ELSE disabled_reason END,
+		    updated_at = NOW()
+		WHERE id = $1
+		RETURNING NOT active AND disabled_at = NOW()
+	`, d.WebhookID, s.config.DisableAfterFailures,
+		fmt.Sprintf("Disabled after %d consecutive failed deliveries", s.config.DisableAfterFailures),
+	).Scan(&disabled)
+	if err != nil {
+		log.Error().Err(err).Str("webhook_id", d.WebhookID.String()).Msg("Failed to record webhook failure")
+		return
+	}
+	if disabled {
+		log.Warn().Str("webhook_id", d.WebhookID.String()).Msg("Webhook endpoint disabled after repeated failures")
+	}
+}
+
+// importPaymentNotifications moves payment notifications written by the
+// payment service into the webhook outbox, marking them delivered to it
+func (s *Service) importPaymentNotifications(ctx context.Context) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, user_id, payment_id, event_type, payload
+		FROM payment_notifications
+		WHERE delivered = FALSE
+		ORDER BY created_at
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, deliveryBatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to query payment notifications: %w", err)
+	}
+
+	type notification struct {
+		id, userID, paymentID uuid.UUID
+		event                 models.WebhookEvent
+		data                  map[string]any
+	}
+	var notifications []notification
+	for rows.Next() {
+		var n notification
+		if err := rows.Scan(&n.id, &n.userID, &n.paymentID, &n.event, &n.data); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan payment notification: %w", err)
+		}
+		notifications = append(notifications, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to iterate payment notifications: %w", err)
+	}
+
+	for _, n := range notifications {
+		if n.data == nil {
+			n.data = map[string]any{}
+		}
+		n.data["payment_id"] = n.paymentID
+		if err := Enqueue(ctx, tx, n.userID, n.event, n.data); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `
+			UPDATE payment_notifications SET delivered = TRUE, delivered_at = NOW() WHERE id = $1
+		`, n.id)
+		if err != nil {
+			return fmt.Errorf("failed to mark payment notification: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
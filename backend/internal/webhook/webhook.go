@@ -0,0 +1,492 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/aimerfeng/AgentLink/internal/config"
+	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// MaxWebhooksPerUser bounds how many endpoints a user can register
+const MaxWebhooksPerUser = 10
+
+// Service errors
+var (
+	ErrWebhookNotFound  = errors.New("webhook not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrInvalidURL       = errors.New("webhook URL must be an absolute http(s) URL")
+	ErrInsecureURL      = errors.New("webhook URL must use https")
+	ErrInvalidEvent     = errors.New("unknown webhook event")
+	ErrTooManyWebhooks  = errors.New("webhook limit reached")
+	ErrBlockedAddress   = errors.New("webhook endpoint resolves to a non-public address")
+)
+
+// subscribableEvents are the events an endpoint can subscribe to
+var subscribableEvents = map[models.WebhookEvent]bool{
+	models.WebhookEventQuotaLow:       true,
+	models.WebhookEventQuotaExhausted: true,
+	models.WebhookEventCallCompleted:  true,
+	models.WebhookEventPaymentDone:    true,
+	models.WebhookEventPaymentFailed:  true,
+}
+
+// Querier is implemented by both the connection pool and a transaction, so
+// events can be enqueued in the same transaction as the change they describe
+type Querier interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// Service manages webhook endpoints and delivers events to them
+type Service struct {
+	db           *pgxpool.Pool
+	config       *config.WebhookConfig
+	client       *http.Client
+	requireHTTPS bool
+	// allowPrivate lets endpoints on non-public addresses through; tests only
+	allowPrivate bool
+}
+
+// NewService creates a new webhook service. With requireHTTPS set, endpoints
+// must use https. Endpoints on non-public addresses are always refused.
+func NewService(db *pgxpool.Pool, cfg *config.WebhookConfig, requireHTTPS bool) *Service {
+	s := &Service{
+		db:           db,
+		config:       cfg,
+		requireHTTPS: requireHTTPS,
+	}
+
+	// Endpoints are dialed directly, without a proxy, so the address check
+	// sees the address actually connected to
+	dialer := &net.Dialer{Control: s.checkDialAddress}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	s.client = &http.Client{
+		Timeout:   cfg.Timeout,
+		Transport: transport,
+		// A redirect is treated as a failed delivery rather than followed
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return s
+}
+
+// CreateWebhookRequest represents a request to register a webhook endpoint
+type CreateWebhookRequest struct {
+	URL         string                `json:"url" binding:"required,max=500"`
+	Events      []models.WebhookEvent `json:"events" binding:"required,min=1"`
+	Description string                `json:"description" binding:"max=255"`
+}
+
+// UpdateWebhookRequest represents a request to change a webhook endpoint.
+// Setting active to true re-enables an endpoint that was disabled for failing.
+type UpdateWebhookRequest struct {
+	URL         *string               `json:"url" binding:"omitempty,max=500"`
+	Events      []models.WebhookEvent `json:"events" binding:"omitempty,min=1"`
+	Description *string               `json:"description" binding:"omitempty,max=255"`
+	Active      *bool                 `json:"active"`
+}
+
+// CreateWebhookResponse includes the signing secret, which is only returned on creation
+type CreateWebhookResponse struct {
+	models.Webhook
+	Secret string `json:"secret"`
+}
+
+// DeliveriesResponse represents a page of an endpoint's delivery log, newest first
+type DeliveriesResponse struct {
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+	Total      int                      `json:"total"`
+	Page       int                      `json:"page"`
+	PageSize   int                      `json:"page_size"`
+}
+
+const webhookColumns = `id, user_id, url, description, events, secret, active, consecutive_failures,
+	disabled_at, disabled_reason, created_at, updated_at`
+
+const deliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at,
+	last_attempt_at, response_status, last_error, duration_ms, created_at, delivered_at`
+
+// Create registers a webhook endpoint with a new signing secret
+func (s *Service) Create(ctx context.Context, userID uuid.UUID, req *CreateWebhookRequest) (*CreateWebhookResponse, error) {
+	if err := s.validateURL(req.URL); err != nil {
+		return nil, err
+	}
+	events, err := normalizeEvents(req.Events)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM webhooks WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to count webhooks: %w", err)
+	}
+	if count >= MaxWebhooksPerUser {
+		return nil, ErrTooManyWebhooks
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	var description *string
+	if req.Description != "" {
+		description = &req.Description
+	}
+
+	row := s.db.QueryRow(ctx, `
+		INSERT INTO webhooks (user_id, url, description, events, secret, active)
+		VALUES ($1, $2, $3, $4, $5, TRUE)
+		RETURNING `+webhookColumns,
+		userID, req.URL, description, events, secret)
+	webhook, err := scanWebhook(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	return &CreateWebhookResponse{Webhook: *webhook, Secret: secret}, nil
+}
+
+// List returns a user's webhook endpoints, oldest first
+func (s *Service) List(ctx context.Context, userID uuid.UUID) ([]models.Webhook, error) {
+	rows, err := s.db.Query(ctx, `
+		SELECT `+webhookColumns+`
+		FROM webhooks WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	webhooks := []models.Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, *webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// Get returns one of a user's webhook endpoints
+func (s *Service) Get(ctx context.Context, userID, webhookID uuid.UUID) (*models.Webhook, error) {
+	row := s.db.QueryRow(ctx, `
+		SELECT `+webhookColumns+` FROM webhooks WHERE id = $1 AND user_id = $2
+	`, webhookID, userID)
+	webhook, err := scanWebhook(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// Update changes one of a user's webhook endpoints
+func (s *Service) Update(ctx context.Context, userID, webhookID uuid.UUID, req *UpdateWebhookRequest) (*models.Webhook, error) {
+	if req.URL != nil {
+		if err := s.validateURL(*req.URL); err != nil {
+			return nil, err
+		}
+	}
+	var events []models.WebhookEvent
+	if req.Events != nil {
+		var err error
+		if events, err = normalizeEvents(req.Events); err != nil {
+			return nil, err
+		}
+	}
+
+	// Re-enabling clears the failure streak that disabled the endpoint
+	row := s.db.QueryRow(ctx, `
+		UPDATE webhooks SET
+			url = COALESCE($3, url),
+			events = COALESCE($4, events),
+			description = COALESCE($5, description),
+			active = COALESCE($6, active),
+			consecutive_failures = CASE WHEN $6 THEN 0 ELSE consecutive_failures END,
+			disabled_at = CASE WHEN $6 THEN NULL ELSE disabled_at END,
+			disabled_reason = CASE WHEN $6 THEN NULL ELSE disabled_reason END,
+			updated_at = NOW()
+		WHERE id = $1 AND user_id = $2
+		RETURNING `+webhookColumns,
+		webhookID, userID, req.URL, events, req.Description, req.Active)
+	webhook, err := scanWebhook(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// Delete removes one of a user's webhook endpoints and its delivery log
+func (s *Service) Delete(ctx context.Context, userID, webhookID uuid.UUID) error {
+	result, err := s.db.Exec(ctx, `DELETE FROM webhooks WHERE id = $1 AND user_id = $2`, webhookID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// ListDeliveries returns a page of an endpoint's delivery log, newest first
+func (s *Service) ListDeliveries(ctx context.Context, userID, webhookID uuid.UUID, page, pageSize int) (*DeliveriesResponse, error) {
+	if _, err := s.Get(ctx, userID, webhookID); err != nil {
+		return nil, err
+	}
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	offset := (page - 1) * pageSize
+
+	var total int
+	err := s.db.QueryRow(ctx, `SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = $1`, webhookID).Scan(&total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count deliveries: %w", err)
+	}
+
+	rows, err := s.db.Query(ctx, `
+		SELECT `+deliveryColumns+`
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2 OFFSET $3
+	`, webhookID, pageSize, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]models.WebhookDelivery, 0, pageSize)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan delivery: %w", err)
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate deliveries: %w", err)
+	}
+
+	return &DeliveriesResponse{
+		Deliveries: deliveries,
+		Total:      total,
+		Page:       page,
+		PageSize:   pageSize,
+	}, nil
+}
+
+// Redeliver queues a new delivery of an earlier delivery's event to the same
+// endpoint. The original delivery stays in the log unchanged.
+func (s *Service) Redeliver(ctx context.Context, userID, webhookID, deliveryID uuid.UUID) (*models.WebhookDelivery, error) {
+	row := s.db.QueryRow(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+		SELECT d.webhook_id, d.event_id, d.event_type, d.payload
+		FROM webhook_deliveries d
+		JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.id = $1 AND d.webhook_id = $2 AND w.user_id = $3
+		RETURNING `+deliveryColumns,
+		deliveryID, webhookID, userID)
+	delivery, err := scanDelivery(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrDeliveryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to redeliver: %w", err)
+	}
+	return delivery, nil
+}
+
+// SendTestEvent sends a webhook.test event to an endpoint right away, even if
+// it is disabled, and returns the logged delivery. Test events are not retried
+// and do not count towards disabling the endpoint.
+func (s *Service) SendTestEvent(ctx context.Context, userID, webhookID uuid.UUID) (*models.WebhookDelivery, error) {
+	webhook, err := s.Get(ctx, userID, webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(map[string]any{
+		"webhook_id": webhook.ID,
+		"message":    "This is a test event from AgentLink",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode test event: %w", err)
+	}
+
+	// Inserted already claimed, so the worker never picks it up
+	var d claimedDelivery
+	err = s.db.QueryRow(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, attempts, last_attempt_at, next_attempt_at)
+		VALUES ($1, $2, $3, $4, 1, NOW(), NULL)
+		RETURNING id, event_id, created_at
+	`, webhook.ID, uuid.New(), models.WebhookEventTest, payload).Scan(&d.ID, &d.EventID, &d.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create test delivery: %w", err)
+	}
+	d.WebhookID = webhook.ID
+	d.EventType = models.WebhookEventTest
+	d.Payload = payload
+	d.Attempts = 1
+	d.URL = webhook.URL
+	d.Secret = webhook.Secret
+	d.test = true
+
+	s.deliver(ctx, &d)
+
+	row := s.db.QueryRow(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = $1`, d.ID)
+	delivery, err := scanDelivery(row)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get test delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+// Enqueue adds an event to the outbox of every active endpoint of the user
+// subscribed to it. Call it in the transaction that makes the change the event
+// describes, so the event is only sent if the change commits.
+func Enqueue(ctx context.Context, q Querier, userID uuid.UUID, event models.WebhookEvent, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook event: %w", err)
+	}
+
+	_, err = q.Exec(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload)
+		SELECT id, $3, $2, $4
+		FROM webhooks
+		WHERE user_id = $1 AND active = TRUE AND events @> jsonb_build_array($2::text)
+	`, userID, string(event), uuid.New(), payload)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue webhook event: %w", err)
+	}
+	return nil
+}
+
+// validateURL checks that a webhook URL is absolute, uses https where
+// required, and does not name a non-public host. Hostnames are checked
+// again when dialed, once resolved.
+func (s *Service) validateURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return ErrInvalidURL
+	}
+	if s.requireHTTPS && u.Scheme != "https" {
+		return ErrInsecureURL
+	}
+	if s.allowPrivate {
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return ErrInvalidURL
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return ErrInvalidURL
+	}
+	return nil
+}
+
+// checkDialAddress is the delivery dialer's Control hook. It runs after DNS
+// resolution, so a hostname resolving to a non-public address is refused too.
+func (s *Service) checkDialAddress(network, address string, _ syscall.RawConn) error {
+	if s.allowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+// isPublicIP reports whether an address is not loopback, private, link-local,
+// unspecified or multicast
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsUnspecified() && !ip.IsMulticast()
+}
+
+// normalizeEvents validates event names and removes duplicates
+func normalizeEvents(events []models.WebhookEvent) ([]models.WebhookEvent, error) {
+	seen := make(map[models.WebhookEvent]bool, len(events))
+	normalized := make([]models.WebhookEvent, 0, len(events))
+	for _, event := range events {
+		if !subscribableEvents[event] {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidEvent, event)
+		}
+		if !seen[event] {
+			seen[event] = true
+			normalized = append(normalized, event)
+		}
+	}
+	return normalized, nil
+}
+
+// generateSecret creates a webhook signing secret
+func generateSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
+
+func scanWebhook(row pgx.Row) (*models.Webhook, error) {
+	var w models.Webhook
+	err := row.Scan(
+		&w.ID, &w.UserID, &w.URL, &w.Description, &w.Events, &w.Secret, &w.Active,
+		&w.ConsecutiveFailures, &w.DisabledAt, &w.DisabledReason, &w.CreatedAt, &w.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func scanDelivery(row pgx.Row) (*models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := row.Scan(
+		&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastAttemptAt, &d.ResponseStatus, &d.LastError,
+		&d.DurationMs, &d.CreatedAt, &d.DeliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/aimerfeng/AgentLink/internal/models"
+	"github.com/aimerfeng/AgentLink/internal/monitoring"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -293,6 +294,7 @@ func (s *Service) CreateWithdrawal(ctx context.Context, creatorID uuid.UUID, req
 	if err := tx.Commit(ctx); err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	monitoring.RecordWithdrawal(string(req.WithdrawalMethod), string(models.WithdrawalStatusPending))
 
 	// Fetch the created withdrawal
 	withdrawal, err := s.GetWithdrawalByID(ctx, withdrawalID)
@@ -389,18 +391,25 @@ func (s *Service) GetWithdrawalHistory(ctx context.Context, creatorID uuid.UUID,
 // CompleteWithdrawal marks a withdrawal as completed
 func (s *Service) CompleteWithdrawal(ctx context.Context, withdrawalID uuid.UUID, externalTxID string) error {
 	now := time.Now()
-	result, err := s.db.Exec(ctx, `
+	var method models.WithdrawalMethod
+	var netAmount, platformFee decimal.Decimal
+	err := s.db.QueryRow(ctx, `
 		UPDATE withdrawals 
 		SET status = $1, external_tx_id = $2, completed_at = $3, processed_at = COALESCE(processed_at, $3)
 		WHERE id = $4 AND status IN ($5, $6)
+		RETURNING withdrawal_method, net_amount, platform_fee
 	`, models.WithdrawalStatusCompleted, externalTxID, now, withdrawalID,
-		models.WithdrawalStatusPending, models.WithdrawalStatusProcessing)
+		models.WithdrawalStatusPending, models.WithdrawalStatusProcessing).Scan(&method, &netAmount, &platformFee)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrWithdrawalAlreadyDone
+		}
 		return fmt.Errorf("failed to complete withdrawal: %w", err)
 	}
-	if result.RowsAffected() == 0 {
-		return ErrWithdrawalAlreadyDone
-	}
+
+	monitoring.RecordWithdrawal(string(method), string(models.WithdrawalStatusCompleted))
+	monitoring.RecordWithdrawalVolume(string(method), netAmount.InexactFloat64())
+	monitoring.RecordRevenue("withdrawal_fee", platformFee.InexactFloat64())
 	return nil
 }
 
@@ -456,9 +465,10 @@ func (s *Service) FailWithdrawalWithOptions(ctx context.Context, withdrawalID uu
 	var creatorID uuid.UUID
 	var amount decimal.Decimal
 	var status models.WithdrawalStatus
+	var method models.WithdrawalMethod
 	err = tx.QueryRow(ctx, `
-		SELECT creator_id, amount, status FROM withdrawals WHERE id = $1 FOR UPDATE
-	`, withdrawalID).Scan(&creatorID, &amount, &status)
+		SELECT creator_id, amount, status, withdrawal_method FROM withdrawals WHERE id = $1 FOR UPDATE
+	`, withdrawalID).Scan(&creatorID, &amount, &status, &method)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return ErrWithdrawalNotFound
@@ -502,6 +512,7 @@ func (s *Service) FailWithdrawalWithOptions(ctx context.Context, withdrawalID uu
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	monitoring.RecordWithdrawal(string(method), string(models.WithdrawalStatusFailed))
 	return nil
 }
 
@@ -616,17 +627,20 @@ func (s *Service) GetFailedWithdrawals(ctx context.Context, creatorID uuid.UUID,
 // SetWithdrawalProcessing marks a withdrawal as processing
 func (s *Service) SetWithdrawalProcessing(ctx context.Context, withdrawalID uuid.UUID) error {
 	now := time.Now()
-	result, err := s.db.Exec(ctx, `
+	var method models.WithdrawalMethod
+	err := s.db.QueryRow(ctx, `
 		UPDATE withdrawals 
 		SET status = $1, processed_at = $2
 		WHERE id = $3 AND status = $4
-	`, models.WithdrawalStatusProcessing, now, withdrawalID, models.WithdrawalStatusPending)
+		RETURNING withdrawal_method
+	`, models.WithdrawalStatusProcessing, now, withdrawalID, models.WithdrawalStatusPending).Scan(&method)
 	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrWithdrawalNotPending
+		}
 		return fmt.Errorf("failed to set withdrawal processing: %w", err)
 	}
-	if result.RowsAffected() == 0 {
-		return ErrWithdrawalNotPending
-	}
+	monitoring.RecordWithdrawal(string(method), string(models.WithdrawalStatusProcessing))
 	return nil
 }
 
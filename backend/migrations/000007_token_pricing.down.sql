@@ -0,0 +1,5 @@
+-- Rollback Token Pricing Migration
+
+ALTER TABLE agents DROP COLUMN IF EXISTS price_per_1k_output_tokens;
+ALTER TABLE agents DROP COLUMN IF EXISTS price_per_1k_input_tokens;
+ALTER TABLE agents DROP COLUMN IF EXISTS pricing_model;
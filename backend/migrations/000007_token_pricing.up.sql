@@ -0,0 +1,15 @@
+-- Token Pricing Migration
+-- Adds token-metered and hybrid pricing models for agents
+
+-- pricing_model selects how a call is billed:
+--   per_call  - flat price_per_call per request (default, existing behaviour)
+--   per_token - price per 1K input tokens + price per 1K output tokens
+--   hybrid    - price_per_call base fee + per-token charges
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS pricing_model VARCHAR(20) DEFAULT 'per_call'
+    CHECK (pricing_model IN ('per_call', 'per_token', 'hybrid'));
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS price_per_1k_input_tokens DECIMAL(10, 6) DEFAULT 0;
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS price_per_1k_output_tokens DECIMAL(10, 6) DEFAULT 0;
+
+COMMENT ON COLUMN agents.pricing_model IS 'Billing model: per_call, per_token or hybrid';
+COMMENT ON COLUMN agents.price_per_1k_input_tokens IS 'USD charged per 1,000 input (prompt) tokens';
+COMMENT ON COLUMN agents.price_per_1k_output_tokens IS 'USD charged per 1,000 output (completion) tokens';
@@ -0,0 +1,6 @@
+-- Rollback API Key Scopes Migration
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS allowed_agent_ids;
+
+UPDATE api_keys
+SET permissions = permissions - 'chat' - 'stream' - 'batch' - 'sessions';
@@ -0,0 +1,13 @@
+-- API Key Scopes Migration
+-- Restricts API keys to specific agents and call types
+
+-- Agent allowlist: NULL or empty means the key may call any agent
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_agent_ids UUID[];
+
+COMMENT ON COLUMN api_keys.allowed_agent_ids IS 'Agents this key may call; NULL or empty allows all agents';
+
+-- Permissions were not enforced before this migration, so existing keys keep
+-- full access to every call type
+UPDATE api_keys
+SET permissions = COALESCE(permissions, '{}'::jsonb)
+    || '{"chat": true, "stream": true, "batch": true, "sessions": true}'::jsonb;
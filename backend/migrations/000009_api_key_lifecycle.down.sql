@@ -0,0 +1,8 @@
+-- Rollback API Key Lifecycle Migration
+
+DROP TABLE IF EXISTS api_key_known_ips;
+DROP TABLE IF EXISTS api_key_audit_logs;
+
+DROP INDEX IF EXISTS idx_api_keys_expires;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS replaced_by;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,39 @@
+-- API Key Lifecycle Migration
+-- Adds key expiry, rotation with a grace period, and a per-key audit trail
+
+-- Optional expiry; NULL means the key never expires
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP WITH TIME ZONE;
+-- Set on the old key when it is rotated; the old key stays valid until expires_at
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS replaced_by UUID REFERENCES api_keys(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_expires ON api_keys(expires_at) WHERE expires_at IS NOT NULL;
+
+COMMENT ON COLUMN api_keys.expires_at IS 'Key is rejected after this time; NULL never expires';
+COMMENT ON COLUMN api_keys.replaced_by IS 'Key issued when this key was rotated';
+
+-- ============================================
+-- API Key Audit Logs Table
+-- ============================================
+CREATE TABLE IF NOT EXISTS api_key_audit_logs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event_type VARCHAR(30) NOT NULL CHECK (event_type IN ('created', 'updated', 'rotated', 'revoked', 'new_ip')),
+    ip_address VARCHAR(45),
+    details JSONB DEFAULT '{}',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_key_audit_logs_key ON api_key_audit_logs(api_key_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_api_key_audit_logs_user ON api_key_audit_logs(user_id, created_at DESC);
+
+-- ============================================
+-- API Key Known IPs Table
+-- Tracks client IPs already seen per key so first use from a new IP is audited once
+-- ============================================
+CREATE TABLE IF NOT EXISTS api_key_known_ips (
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    ip_address VARCHAR(45) NOT NULL,
+    first_seen_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (api_key_id, ip_address)
+);
@@ -0,0 +1,6 @@
+-- Rollback API Key Network Restrictions Migration
+
+DROP TABLE IF EXISTS api_key_rejections;
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS allowed_origins;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS allowed_cidrs;
@@ -0,0 +1,22 @@
+-- API Key Network Restrictions Migration
+-- Restricts API keys to known client networks and browser origins
+
+-- CIDR allowlist (e.g. 203.0.113.0/24); NULL or empty allows any IP
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_cidrs TEXT[];
+-- Origin allowlist (e.g. https://app.example.com, https://*.example.com); NULL or empty allows any origin
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS allowed_origins TEXT[];
+
+COMMENT ON COLUMN api_keys.allowed_cidrs IS 'Client networks allowed to use this key; NULL or empty allows all';
+COMMENT ON COLUMN api_keys.allowed_origins IS 'Browser origins allowed to use this key; NULL or empty allows all';
+
+-- ============================================
+-- API Key Rejections Table
+-- Hourly counters of requests rejected by a key's network restrictions
+-- ============================================
+CREATE TABLE IF NOT EXISTS api_key_rejections (
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    bucket_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    reason VARCHAR(30) NOT NULL CHECK (reason IN ('ip_not_allowed', 'origin_not_allowed')),
+    count INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (api_key_id, bucket_start, reason)
+);
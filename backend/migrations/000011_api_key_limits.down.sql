@@ -0,0 +1,7 @@
+-- Rollback API Key Limits Migration
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS spend_cap_unit;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS monthly_spend_cap;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS daily_spend_cap;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS max_concurrent_requests;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS rate_limit_rpm;
@@ -0,0 +1,16 @@
+-- API Key Limits Migration
+-- Optional per-key request rate, concurrency, and spend caps enforced by the proxy
+
+-- NULL means the limit is not set for the key
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS rate_limit_rpm INT CHECK (rate_limit_rpm > 0);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS max_concurrent_requests INT CHECK (max_concurrent_requests > 0);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS daily_spend_cap DECIMAL(14,6) CHECK (daily_spend_cap > 0);
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS monthly_spend_cap DECIMAL(14,6) CHECK (monthly_spend_cap > 0);
+-- Unit of the spend caps: quota units (one per call) or USD of agent cost
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS spend_cap_unit VARCHAR(10) NOT NULL DEFAULT 'quota'
+    CHECK (spend_cap_unit IN ('quota', 'usd'));
+
+COMMENT ON COLUMN api_keys.rate_limit_rpm IS 'Maximum requests per minute for this key; NULL for no key-level limit';
+COMMENT ON COLUMN api_keys.max_concurrent_requests IS 'Maximum in-flight requests for this key; NULL for no limit';
+COMMENT ON COLUMN api_keys.daily_spend_cap IS 'Maximum spend per UTC day in spend_cap_unit; NULL for no cap';
+COMMENT ON COLUMN api_keys.monthly_spend_cap IS 'Maximum spend per UTC month in spend_cap_unit; NULL for no cap';
@@ -0,0 +1,7 @@
+-- Rollback Cache Invalidation Migration
+
+DROP TRIGGER IF EXISTS agents_cache_invalidation ON agents;
+DROP TRIGGER IF EXISTS api_keys_cache_invalidation ON api_keys;
+
+DROP FUNCTION IF EXISTS notify_agent_cache_invalidation();
+DROP FUNCTION IF EXISTS notify_api_key_cache_invalidation();
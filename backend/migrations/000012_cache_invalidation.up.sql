@@ -0,0 +1,46 @@
+-- Cache Invalidation Migration
+-- Notifies proxies over LISTEN/NOTIFY when cached API keys or agents change,
+-- so revocations, agent updates and unpublishing take effect immediately.
+-- Payloads are 'apikey:<key_hash>' or 'agent:<id>' on channel 'cache_invalidation'.
+
+CREATE OR REPLACE FUNCTION notify_api_key_cache_invalidation()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('cache_invalidation', 'apikey:' || OLD.key_hash);
+        RETURN OLD;
+    END IF;
+    -- last_used_at is written in batches on the hot path and is not cached
+    IF (to_jsonb(OLD) - 'last_used_at') IS DISTINCT FROM (to_jsonb(NEW) - 'last_used_at') THEN
+        PERFORM pg_notify('cache_invalidation', 'apikey:' || NEW.key_hash);
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE OR REPLACE FUNCTION notify_agent_cache_invalidation()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('cache_invalidation', 'agent:' || OLD.id);
+        RETURN OLD;
+    END IF;
+    -- Call statistics change on every call and do not affect proxying
+    IF (to_jsonb(OLD) - ARRAY['total_calls', 'total_revenue', 'average_rating', 'review_count', 'updated_at'])
+        IS DISTINCT FROM
+       (to_jsonb(NEW) - ARRAY['total_calls', 'total_revenue', 'average_rating', 'review_count', 'updated_at']) THEN
+        PERFORM pg_notify('cache_invalidation', 'agent:' || NEW.id);
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER api_keys_cache_invalidation
+    AFTER UPDATE OR DELETE ON api_keys
+    FOR EACH ROW
+    EXECUTE FUNCTION notify_api_key_cache_invalidation();
+
+CREATE TRIGGER agents_cache_invalidation
+    AFTER UPDATE OR DELETE ON agents
+    FOR EACH ROW
+    EXECUTE FUNCTION notify_agent_cache_invalidation();
@@ -0,0 +1,3 @@
+-- Rollback API Key Token Limit Migration
+
+ALTER TABLE api_keys DROP COLUMN IF EXISTS tokens_per_minute;
@@ -0,0 +1,6 @@
+-- API Key Token Limit Migration
+-- Optional per-key tokens-per-minute limit (prompt + completion tokens)
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS tokens_per_minute INT CHECK (tokens_per_minute > 0);
+
+COMMENT ON COLUMN api_keys.tokens_per_minute IS 'Maximum prompt + completion tokens per minute for this key; NULL for no key-level limit';
@@ -0,0 +1,7 @@
+-- Rollback Agent Limits Migration
+
+DROP TABLE IF EXISTS agent_limit_rejections;
+
+ALTER TABLE agents DROP COLUMN IF EXISTS max_concurrent_requests;
+ALTER TABLE agents DROP COLUMN IF EXISTS per_caller_rpm;
+ALTER TABLE agents DROP COLUMN IF EXISTS rate_limit_rpm;
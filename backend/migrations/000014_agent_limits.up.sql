@@ -0,0 +1,21 @@
+-- Agent Limits Migration
+-- Creator-defined rate and concurrency limits per agent
+
+-- Requests per minute across all callers; NULL for no limit
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS rate_limit_rpm INT CHECK (rate_limit_rpm > 0);
+-- Requests per minute for each calling user; NULL for no limit
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS per_caller_rpm INT CHECK (per_caller_rpm > 0);
+-- Maximum requests in flight across all callers; NULL for no limit
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS max_concurrent_requests INT CHECK (max_concurrent_requests > 0);
+
+-- ============================================
+-- Agent Limit Rejections Table
+-- Hourly counters of requests rejected by an agent's limits
+-- ============================================
+CREATE TABLE IF NOT EXISTS agent_limit_rejections (
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    bucket_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    reason VARCHAR(30) NOT NULL CHECK (reason IN ('agent_rate_limited', 'caller_rate_limited', 'concurrency_limited')),
+    count INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (agent_id, bucket_start, reason)
+);
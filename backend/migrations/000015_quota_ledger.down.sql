@@ -0,0 +1,3 @@
+-- Rollback Quota Ledger Migration
+
+DROP TABLE IF EXISTS quota_ledger;
@@ -0,0 +1,36 @@
+-- Quota Ledger Migration
+-- Append-only record of every change to a user's quota balance
+
+-- ============================================
+-- Quota Ledger Table
+-- The sum of a user's amounts is their balance (total + free - used)
+-- ============================================
+CREATE TABLE IF NOT EXISTS quota_ledger (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    entry_type VARCHAR(20) NOT NULL CHECK (entry_type IN (
+        'opening_balance', 'signup_grant', 'purchase', 'call_debit',
+        'call_refund', 'trial', 'admin_adjustment'
+    )),
+    amount BIGINT NOT NULL,  -- Signed change to the balance
+    balance_after BIGINT,  -- Database balance after the entry
+    payment_id UUID REFERENCES payments(id) ON DELETE SET NULL,
+    request_id TEXT,  -- X-Request-ID of the call, which clients may set
+    agent_id UUID REFERENCES agents(id) ON DELETE SET NULL,
+    note TEXT,
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,  -- Admin who made an adjustment
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_ledger_user ON quota_ledger(user_id, id DESC);
+-- A payment is credited at most once
+CREATE UNIQUE INDEX IF NOT EXISTS idx_quota_ledger_payment ON quota_ledger(entry_type, payment_id) WHERE payment_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_quota_ledger_request ON quota_ledger(request_id) WHERE request_id IS NOT NULL;
+
+-- Open the ledger with each existing user's current balance
+INSERT INTO quota_ledger (user_id, entry_type, amount, balance_after, note)
+SELECT user_id, 'opening_balance',
+       COALESCE(total_quota, 0) + COALESCE(free_quota, 0) - COALESCE(used_quota, 0),
+       COALESCE(total_quota, 0) + COALESCE(free_quota, 0) - COALESCE(used_quota, 0),
+       'Balance when the ledger was introduced'
+FROM quotas;
@@ -0,0 +1,9 @@
+-- Rollback Webhook Deliveries Migration
+
+DROP TABLE IF EXISTS webhook_deliveries;
+
+ALTER TABLE webhooks DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS disabled_reason;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS disabled_at;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS consecutive_failures;
+ALTER TABLE webhooks DROP COLUMN IF EXISTS description;
@@ -0,0 +1,5 @@
+-- Rollback Quota Alerts Migration
+
+ALTER TABLE quotas DROP COLUMN IF EXISTS exhausted_alerted_at;
+DROP TABLE IF EXISTS quota_alerts;
+DROP TABLE IF EXISTS quota_alert_thresholds;
@@ -0,0 +1,42 @@
+-- Quota Alerts Migration
+-- Developer-configured low-quota thresholds and the alerts they fire
+
+-- ============================================
+-- Quota Alert Thresholds Table
+-- A threshold fires once when the balance drops to it, then stays fired
+-- until a top-up raises the balance above it again
+-- ============================================
+CREATE TABLE IF NOT EXISTS quota_alert_thresholds (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    threshold_type VARCHAR(20) NOT NULL CHECK (threshold_type IN ('absolute', 'percentage')),
+    -- Quota units for absolute thresholds; percent of the last purchase for percentage thresholds
+    value BIGINT NOT NULL CHECK (value > 0),
+    notify_email BOOLEAN NOT NULL DEFAULT TRUE,
+    fired_at TIMESTAMP WITH TIME ZONE,  -- NULL while armed
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_alert_thresholds_user ON quota_alert_thresholds(user_id);
+
+-- ============================================
+-- Quota Alerts Table
+-- History of fired alerts; rows with email_pending are the email outbox
+-- ============================================
+CREATE TABLE IF NOT EXISTS quota_alerts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    threshold_id UUID REFERENCES quota_alert_thresholds(id) ON DELETE SET NULL,
+    event_type VARCHAR(50) NOT NULL,  -- quota.low or quota.exhausted
+    balance BIGINT NOT NULL,
+    threshold_quota BIGINT,  -- The threshold in quota units when it fired
+    email_pending BOOLEAN NOT NULL DEFAULT FALSE,
+    emailed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_quota_alerts_user ON quota_alerts(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_quota_alerts_email_pending ON quota_alerts(created_at) WHERE email_pending = TRUE;
+
+-- Set when quota.exhausted fires; cleared by a top-up
+ALTER TABLE quotas ADD COLUMN IF NOT EXISTS exhausted_alerted_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,4 @@
+-- Rollback Call Usage Rollups Migration
+
+DROP TABLE IF EXISTS usage_rollup_state;
+DROP TABLE IF EXISTS call_usage_hourly;
@@ -0,0 +1,35 @@
+-- Call Usage Rollups Migration
+-- Hourly per-user aggregates of call_logs backing the developer usage API
+
+-- ============================================
+-- Call Usage Hourly Table
+-- One row per user, agent, API key and status for each UTC hour
+-- ============================================
+CREATE TABLE IF NOT EXISTS call_usage_hourly (
+    bucket_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    user_id UUID NOT NULL,
+    agent_id UUID NOT NULL,
+    api_key_id UUID NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    calls BIGINT NOT NULL DEFAULT 0,
+    input_tokens BIGINT NOT NULL DEFAULT 0,
+    output_tokens BIGINT NOT NULL DEFAULT 0,
+    cost_usd DECIMAL(18, 6) NOT NULL DEFAULT 0,
+    latency_count BIGINT NOT NULL DEFAULT 0,  -- Calls with a recorded latency
+    latency_sum_ms BIGINT NOT NULL DEFAULT 0,
+    -- Call counts per latency bucket; the bucket bounds are defined by the usage package
+    latency_histogram BIGINT[] NOT NULL,
+    PRIMARY KEY (user_id, bucket_start, agent_id, api_key_id, status)
+);
+
+-- ============================================
+-- Usage Rollup State Table
+-- Single row; hours before rolled_up_to are in call_usage_hourly
+-- ============================================
+CREATE TABLE IF NOT EXISTS usage_rollup_state (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    rolled_up_to TIMESTAMP WITH TIME ZONE,  -- NULL until the first rollup
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+INSERT INTO usage_rollup_state (id) VALUES (TRUE) ON CONFLICT DO NOTHING;
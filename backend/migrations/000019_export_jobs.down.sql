@@ -0,0 +1,4 @@
+-- Rollback Export Jobs Migration
+
+DROP TABLE IF EXISTS export_job_chunks;
+DROP TABLE IF EXISTS export_jobs;
@@ -0,0 +1,37 @@
+-- Export Jobs Migration
+-- Background CSV/JSONL exports of usage and billing data
+
+-- ============================================
+-- Export Jobs Table
+-- ============================================
+CREATE TABLE IF NOT EXISTS export_jobs (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    dataset VARCHAR(30) NOT NULL,
+    format VARCHAR(10) NOT NULL CHECK (format IN ('csv', 'jsonl')),
+    columns JSONB NOT NULL,
+    from_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    to_time TIMESTAMP WITH TIME ZONE NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'completed', 'failed')),
+    row_count BIGINT,
+    size_bytes BIGINT,
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    started_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE  -- Completed exports are deleted after this
+);
+
+CREATE INDEX IF NOT EXISTS idx_export_jobs_user ON export_jobs(user_id, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_export_jobs_pending ON export_jobs(created_at) WHERE status IN ('pending', 'running');
+
+-- ============================================
+-- Export Job Chunks Table
+-- An export's file, in order; stored in the database so any replica can serve it
+-- ============================================
+CREATE TABLE IF NOT EXISTS export_job_chunks (
+    job_id UUID NOT NULL REFERENCES export_jobs(id) ON DELETE CASCADE,
+    seq INT NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (job_id, seq)
+);
@@ -0,0 +1,21 @@
+-- Rollback Call Logs Partitions Migration
+-- Drops the default partition and every monthly partition after the initial
+-- ones, with the calls they hold
+
+DROP TABLE IF EXISTS call_logs_default;
+
+DO $$
+DECLARE
+    partition_name TEXT;
+BEGIN
+    FOR partition_name IN
+        SELECT c.relname FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        WHERE i.inhparent = 'call_logs'::regclass
+          AND c.relname ~ '^call_logs_[0-9]{4}_[0-9]{2}$'
+          AND c.relname > 'call_logs_2025_04'
+    LOOP
+        EXECUTE format('DROP TABLE IF EXISTS %I', partition_name);
+    END LOOP;
+END
+$$;
@@ -0,0 +1,22 @@
+-- Call Logs Partitions Migration
+-- Monthly partitions from the last initial one through three months ahead,
+-- plus a default partition so inserts never fail for lack of one. Months
+-- are in UTC, like those created by the partition maintenance job.
+
+DO $$
+DECLARE
+    month DATE := DATE '2025-05-01';
+BEGIN
+    WHILE month <= date_trunc('month', NOW() + INTERVAL '3 months') LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF call_logs FOR VALUES FROM (%L) TO (%L)',
+            'call_logs_' || to_char(month, 'YYYY_MM'),
+            month::timestamp AT TIME ZONE 'UTC', (month + INTERVAL '1 month') AT TIME ZONE 'UTC'
+        );
+        month := month + INTERVAL '1 month';
+    END LOOP;
+END
+$$;
+
+-- Catches calls outside every monthly partition; maintenance moves them out
+CREATE TABLE IF NOT EXISTS call_logs_default PARTITION OF call_logs DEFAULT;
@@ -0,0 +1,5 @@
+-- Rollback Payload Capture Migration
+
+DROP TABLE IF EXISTS call_payloads;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS capture_ttl_hours;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS capture_payloads;
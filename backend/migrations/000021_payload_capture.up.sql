@@ -0,0 +1,28 @@
+-- Payload Capture Migration
+-- Opt-in, per API key capture of request and response payloads for debugging
+
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS capture_payloads BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE api_keys ADD COLUMN IF NOT EXISTS capture_ttl_hours INT NOT NULL DEFAULT 24
+    CHECK (capture_ttl_hours BETWEEN 1 AND 720);
+
+-- ============================================
+-- Call Payloads Table
+-- The captured exchange is encrypted with the agent config key (AES-256-GCM)
+-- and never includes the agent's system prompt
+-- ============================================
+CREATE TABLE IF NOT EXISTS call_payloads (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    api_key_id UUID NOT NULL REFERENCES api_keys(id) ON DELETE CASCADE,
+    agent_id UUID REFERENCES agents(id) ON DELETE SET NULL,
+    request_id VARCHAR(128) NOT NULL,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('success', 'error')),
+    error_code VARCHAR(50),
+    payload_encrypted BYTEA NOT NULL,
+    payload_iv BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_call_payloads_request ON call_payloads(user_id, request_id);
+CREATE INDEX IF NOT EXISTS idx_call_payloads_expires ON call_payloads(expires_at);
@@ -0,0 +1,4 @@
+-- Rollback Call Logs Trace ID Migration
+
+DROP INDEX IF EXISTS idx_call_logs_trace;
+ALTER TABLE call_logs DROP COLUMN IF EXISTS trace_id;
@@ -0,0 +1,7 @@
+-- Call Logs Trace ID Migration
+-- Links each call to its OpenTelemetry trace. Calls logged without tracing
+-- fall back to the correlation or request ID.
+
+ALTER TABLE call_logs ADD COLUMN IF NOT EXISTS trace_id VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_call_logs_trace ON call_logs(trace_id);
@@ -0,0 +1,11 @@
+-- Rollback Account Status Migration
+
+DROP TRIGGER IF EXISTS users_status_cache_invalidation ON users;
+DROP FUNCTION IF EXISTS notify_user_status_cache_invalidation();
+
+DROP TABLE IF EXISTS user_status_changes;
+
+DROP INDEX IF EXISTS idx_users_status;
+ALTER TABLE users DROP COLUMN IF EXISTS status_changed_at;
+ALTER TABLE users DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE users DROP COLUMN IF EXISTS status;
@@ -0,0 +1,38 @@
+-- Account Status Migration
+-- Lets admins suspend or ban accounts. Changing a user's status notifies the
+-- proxies to drop the user's cached API keys so it takes effect immediately.
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'suspended', 'banned'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_reason TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_users_status ON users(status) WHERE status <> 'active';
+
+-- History of status changes, newest first per user
+CREATE TABLE IF NOT EXISTS user_status_changes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL CHECK (status IN ('active', 'suspended', 'banned')),
+    reason TEXT,
+    changed_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_status_changes_user ON user_status_changes(user_id, created_at DESC);
+
+CREATE OR REPLACE FUNCTION notify_user_status_cache_invalidation()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF OLD.status IS DISTINCT FROM NEW.status THEN
+        PERFORM pg_notify('cache_invalidation', 'apikey:' || key_hash)
+        FROM api_keys WHERE user_id = NEW.id;
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER users_status_cache_invalidation
+    AFTER UPDATE OF status ON users
+    FOR EACH ROW
+    EXECUTE FUNCTION notify_user_status_cache_invalidation();
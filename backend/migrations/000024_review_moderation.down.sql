@@ -0,0 +1,11 @@
+-- Rollback Review Moderation Migration
+
+DROP INDEX IF EXISTS idx_reviews_pending;
+DROP INDEX IF EXISTS idx_reviews_agent_status;
+
+ALTER TABLE reviews DROP COLUMN IF EXISTS replied_at;
+ALTER TABLE reviews DROP COLUMN IF EXISTS reply;
+ALTER TABLE reviews DROP COLUMN IF EXISTS rejection_reason;
+ALTER TABLE reviews DROP COLUMN IF EXISTS moderated_at;
+ALTER TABLE reviews DROP COLUMN IF EXISTS moderated_by;
+ALTER TABLE reviews DROP COLUMN IF EXISTS updated_at;
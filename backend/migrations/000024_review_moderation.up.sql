@@ -0,0 +1,14 @@
+-- Review Moderation Migration
+-- Tracks edits, moderation decisions and creator replies on reviews. Only
+-- approved reviews count towards agents.average_rating and review_count.
+
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS moderated_by UUID REFERENCES users(id) ON DELETE SET NULL;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS moderated_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS rejection_reason TEXT;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS reply TEXT;
+ALTER TABLE reviews ADD COLUMN IF NOT EXISTS replied_at TIMESTAMP WITH TIME ZONE;
+
+-- Public listing of an agent's approved reviews and the moderation queue
+CREATE INDEX IF NOT EXISTS idx_reviews_agent_status ON reviews(agent_id, status, created_at DESC);
+CREATE INDEX IF NOT EXISTS idx_reviews_pending ON reviews(updated_at) WHERE status = 'pending';
@@ -0,0 +1,34 @@
+-- Rollback Marketplace Search Migration
+
+DROP INDEX IF EXISTS idx_agents_provider;
+DROP INDEX IF EXISTS idx_agents_active_published;
+DROP INDEX IF EXISTS idx_agents_active_rating;
+DROP INDEX IF EXISTS idx_agents_active_calls;
+DROP INDEX IF EXISTS idx_agents_search_vector;
+
+CREATE OR REPLACE FUNCTION notify_agent_cache_invalidation()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('cache_invalidation', 'agent:' || OLD.id);
+        RETURN OLD;
+    END IF;
+    -- Call statistics change on every call and do not affect proxying
+    IF (to_jsonb(OLD) - ARRAY['total_calls', 'total_revenue', 'average_rating', 'review_count', 'updated_at'])
+        IS DISTINCT FROM
+       (to_jsonb(NEW) - ARRAY['total_calls', 'total_revenue', 'average_rating', 'review_count', 'updated_at']) THEN
+        PERFORM pg_notify('cache_invalidation', 'agent:' || NEW.id);
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS creator_profiles_search_vector ON creator_profiles;
+DROP FUNCTION IF EXISTS update_creator_agents_search_vector();
+DROP TRIGGER IF EXISTS agents_search_vector ON agents;
+DROP FUNCTION IF EXISTS update_agent_search_vector();
+DROP FUNCTION IF EXISTS agent_search_vector(TEXT, TEXT, TEXT, UUID);
+
+ALTER TABLE agents DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE agents DROP COLUMN IF EXISTS model_family;
+ALTER TABLE agents DROP COLUMN IF EXISTS provider;
@@ -0,0 +1,79 @@
+-- Marketplace Search Migration
+-- Full-text search over agents by name, category, creator display name and
+-- description, plus the provider and model family filters. The provider and
+-- model family are copied out of the encrypted config when an agent is saved.
+
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS provider VARCHAR(50);
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS model_family VARCHAR(100);
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS search_vector TSVECTOR;
+
+-- Weighted search document for an agent: name first, then category and
+-- creator, then description
+CREATE OR REPLACE FUNCTION agent_search_vector(p_name TEXT, p_category TEXT, p_description TEXT, p_creator_id UUID)
+RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('english', COALESCE(p_name, '')), 'A')
+        || setweight(to_tsvector('english', COALESCE(p_category, '')), 'B')
+        || setweight(to_tsvector('english', COALESCE(
+            (SELECT display_name FROM creator_profiles WHERE user_id = p_creator_id), '')), 'B')
+        || setweight(to_tsvector('english', COALESCE(p_description, '')), 'C');
+$$ LANGUAGE sql STABLE;
+
+CREATE OR REPLACE FUNCTION update_agent_search_vector()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector := agent_search_vector(NEW.name, NEW.category, NEW.description, NEW.creator_id);
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER agents_search_vector
+    BEFORE INSERT OR UPDATE OF name, category, description, creator_id ON agents
+    FOR EACH ROW
+    EXECUTE FUNCTION update_agent_search_vector();
+
+-- Renaming a creator changes the search documents of all their agents
+CREATE OR REPLACE FUNCTION update_creator_agents_search_vector()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' OR OLD.display_name IS DISTINCT FROM NEW.display_name THEN
+        UPDATE agents
+        SET search_vector = agent_search_vector(name, category, description, creator_id)
+        WHERE creator_id = NEW.user_id;
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER creator_profiles_search_vector
+    AFTER INSERT OR UPDATE OF display_name ON creator_profiles
+    FOR EACH ROW
+    EXECUTE FUNCTION update_creator_agents_search_vector();
+
+-- Search columns do not affect proxying
+CREATE OR REPLACE FUNCTION notify_agent_cache_invalidation()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        PERFORM pg_notify('cache_invalidation', 'agent:' || OLD.id);
+        RETURN OLD;
+    END IF;
+    -- Call statistics change on every call and do not affect proxying
+    IF (to_jsonb(OLD) - ARRAY['total_calls', 'total_revenue', 'average_rating', 'review_count', 'updated_at',
+                              'search_vector', 'provider', 'model_family'])
+        IS DISTINCT FROM
+       (to_jsonb(NEW) - ARRAY['total_calls', 'total_revenue', 'average_rating', 'review_count', 'updated_at',
+                              'search_vector', 'provider', 'model_family']) THEN
+        PERFORM pg_notify('cache_invalidation', 'agent:' || NEW.id);
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+UPDATE agents SET search_vector = agent_search_vector(name, category, description, creator_id);
+
+CREATE INDEX IF NOT EXISTS idx_agents_search_vector ON agents USING GIN (search_vector);
+-- Filters and sorts of the marketplace, over active agents only
+CREATE INDEX IF NOT EXISTS idx_agents_active_calls ON agents(total_calls DESC, id DESC) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_agents_active_rating ON agents(average_rating DESC, id DESC) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_agents_active_published ON agents(published_at DESC, id DESC) WHERE status = 'active';
+CREATE INDEX IF NOT EXISTS idx_agents_provider ON agents(provider, model_family) WHERE status = 'active';
@@ -0,0 +1,3 @@
+-- Rollback Call Logs Agent Created Migration
+
+DROP INDEX IF EXISTS idx_call_logs_agent_created;
@@ -0,0 +1,4 @@
+-- Call Logs Agent Created Migration
+-- Public agent pages compute rolling call statistics per agent from call_logs
+
+CREATE INDEX IF NOT EXISTS idx_call_logs_agent_created ON call_logs(agent_id, created_at);
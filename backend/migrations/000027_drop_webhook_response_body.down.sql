@@ -0,0 +1,3 @@
+-- Rollback Drop Webhook Response Body Migration
+
+ALTER TABLE webhook_deliveries ADD COLUMN IF NOT EXISTS response_body TEXT;
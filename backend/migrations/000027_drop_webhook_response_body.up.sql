@@ -0,0 +1,4 @@
+-- Drop Webhook Response Body Migration
+-- Endpoint response bodies are no longer stored or shown in the delivery log
+
+ALTER TABLE webhook_deliveries DROP COLUMN IF EXISTS response_body;
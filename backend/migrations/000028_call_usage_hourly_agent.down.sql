@@ -0,0 +1,3 @@
+-- Rollback Call Usage Hourly Agent Migration
+
+DROP INDEX IF EXISTS idx_call_usage_hourly_agent_bucket;
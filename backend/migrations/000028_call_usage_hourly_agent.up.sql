@@ -0,0 +1,4 @@
+-- Call Usage Hourly Agent Migration
+-- Public agent pages compute rolling call statistics per agent from the hourly rollups
+
+CREATE INDEX IF NOT EXISTS idx_call_usage_hourly_agent_bucket ON call_usage_hourly(agent_id, bucket_start);